@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// # EMBEDDING
+// An agent for other Go services to run in-process, instead of shelling out to the CLI or calling the REST server
+//   - New takes options for the model, tools, system prompt, HTTP client and session store, anything not given
+//     falling back to the process's settings: SetModel, SetSystemPrompt and the provider installed by SetProvider,
+//     or a client on ANTHROPIC_API_KEY and ANTHROPIC_BASE_URL if there is none
+//   - Each Agent is one session; Run sends a message and returns once Claude is done calling tools.
+//     Runs on the same Agent take turns, separate Agents run independently
+//   - With a store, the session is loaded before each run and saved after it, sealed like the server's sessions,
+//     so a service with several replicas can continue a session named with WithSession on any of them
+//   - Tools come from the registry, as LoadToolsFromDirectory, LoadPostalTools and friends fill it, or from
+//     WithTool for a Go function, which only that Agent can run and which goes before a registered tool of the same name
+//   - Usage and cost are the Agent's own, apart from the CLI's session totals, and WithBudget caps them
+//   - Cancelling ctx abandons the turn, leaving the session as it was before the run
+//   - Compacting an oversized conversation goes through the Agent's provider, counts toward its usage and is logged rather than printed;
+//     a spawn_agent sub-agent goes through the installed provider
+type Agent struct {
+	model      anthropic.Model
+	system     string
+	tools      []anthropic.Tool
+	own        map[string]registeredTool // the tools added with WithTool
+	provider   anthropic.Provider
+	httpClient *http.Client
+	store      Store
+	budget     Budget
+	session    *Session
+
+	// usage and cost total the Agent's runs, guarded by the session's lock
+	usage anthropic.Usage
+	cost  float64
+}
+
+// Option configures an Agent made by New
+type Option func(*Agent) error
+
+// Result is the outcome of a run
+type Result struct {
+	SessionID    string
+	Text         string
+	ToolCalls    []ToolCall
+	StopReason   anthropic.StopReason
+	Model        anthropic.Model
+	FallbackFrom anthropic.Model // the model asked for, if a fallback answered
+	Limit        string          // the loop limit that cut the tool calls short, if one did
+	Usage        anthropic.Usage // of this run
+	Cost         float64         // of this run in USD
+}
+
+// WithModel sets the model, a model id or opus, sonnet or haiku
+func WithModel(name string) Option {
+	return func(a *Agent) error {
+		if name == "" {
+			return fmt.Errorf("the model must not be empty")
+		}
+		a.model = anthropic.ParseModel(name)
+		return nil
+	}
+}
+
+// WithTools offers Claude these tools, which must be in the registry already
+func WithTools(tools ...anthropic.Tool) Option {
+	return func(a *Agent) error {
+		for _, tool := range tools {
+			if _, ok := findTool(context.Background(), tool.Name); !ok {
+				return fmt.Errorf("tool '%s' is not registered", tool.Name)
+			}
+		}
+		a.tools = append(a.tools, tools...)
+		return nil
+	}
+}
+
+// WithTool offers Claude the tool, run by fn for this Agent only. fn's result is a tool_result, with IsError set on failure.
+func WithTool(tool anthropic.Tool, fn func(ctx context.Context, input map[string]any) anthropic.Content) Option {
+	return func(a *Agent) error {
+		if tool.Name == "" {
+			return fmt.Errorf("tools must have a name")
+		}
+		if _, ok := a.own[tool.Name]; ok {
+			return fmt.Errorf("tool '%s' is given twice", tool.Name)
+		}
+		schema := tool.InputSchema
+		a.own[tool.Name] = registeredTool{fn: fn, schema: &schema}
+		a.tools = append(a.tools, tool)
+		return nil
+	}
+}
+
+// WithSystemPrompt sets the system prompt
+func WithSystemPrompt(prompt string) Option {
+	return func(a *Agent) error {
+		a.system = prompt
+		return nil
+	}
+}
+
+// WithHTTPClient sends the agent's requests with client, on a copy of the provider
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *Agent) error {
+		a.httpClient = client
+		return nil
+	}
+}
+
+// WithProvider sends the agent's requests with provider instead of the installed one
+func WithProvider(provider anthropic.Provider) Option {
+	return func(a *Agent) error {
+		a.provider = provider
+		return nil
+	}
+}
+
+// WithStore keeps the session in store, e.g. one from OpenStore
+func WithStore(store Store) Option {
+	return func(a *Agent) error {
+		a.store = store
+		return nil
+	}
+}
+
+// WithBudget caps the usage and cost of the Agent's runs, Run refusing to start once it is exceeded if b.Stop is set
+func WithBudget(b Budget) Option {
+	return func(a *Agent) error {
+		a.budget = b
+		return nil
+	}
+}
+
+// WithSession continues the session with this id, from the store if it was saved there, rather than starting a new one
+func WithSession(id string) Option {
+	return func(a *Agent) error {
+		if !sessionNamePattern.MatchString(id) {
+			return fmt.Errorf("invalid session id '%s', use letters, digits, '.', '_' and '-'", id)
+		}
+		a.session.ID = id
+		return nil
+	}
+}
+
+// New makes an agent with the given options
+func New(opts ...Option) (*Agent, error) {
+	a := &Agent{model: model, system: systemPrompt, own: map[string]registeredTool{}, session: &Session{Messages: Conversation{}}}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.provider == nil {
+		a.provider = anthropic.GetProvider()
+	}
+	if a.provider == nil {
+		client := anthropic.NewClient(os.Getenv("ANTHROPIC_API_KEY"))
+		if url := os.Getenv("ANTHROPIC_BASE_URL"); url != "" {
+			client.BaseURL = url
+		}
+		if client.APIKey == "" {
+			return nil, fmt.Errorf("no provider is installed and ANTHROPIC_API_KEY is not set")
+		}
+		a.provider = client
+	}
+	if a.httpClient != nil {
+		switch p := a.provider.(type) {
+		case *anthropic.Client:
+			c := *p
+			c.HTTPClient = a.httpClient
+			a.provider = &c
+		case *anthropic.Bedrock:
+			b := *p
+			b.HTTPClient = a.httpClient
+			a.provider = &b
+		case *anthropic.Vertex:
+			v := *p
+			v.HTTPClient = a.httpClient
+			a.provider = &v
+		default:
+			return nil, fmt.Errorf("an HTTP client can't be set on a provider of type %T", a.provider)
+		}
+	}
+
+	if a.session.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return nil, err
+		}
+		a.session.ID = id
+	} else if err := loadSession(context.Background(), a.store, a.session); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SessionID is the id of the agent's session, for WithSession to continue it later
+func (a *Agent) SessionID() string {
+	return a.session.ID
+}
+
+// Usage totals the usage and cost of the Agent's runs so far
+func (a *Agent) Usage() (anthropic.Usage, float64) {
+	a.session.mu.Lock()
+	defer a.session.mu.Unlock()
+	return a.usage, a.cost
+}
+
+// Run sends input as the next user message of the session and returns Claude's final answer.
+// On error the result holds whatever was completed.
+func (a *Agent) Run(ctx context.Context, input string) (Result, error) {
+	session := a.session
+	if strings.TrimSpace(input) == "" {
+		return Result{SessionID: session.ID}, fmt.Errorf("input must not be empty")
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if err := loadSession(ctx, a.store, session); err != nil {
+		return Result{SessionID: session.ID}, err
+	}
+	if msg := a.budget.exceeded(a.usage, a.cost); msg != "" && a.budget.Stop {
+		return Result{SessionID: session.ID}, fmt.Errorf("budget exceeded: %s", msg)
+	}
+
+	ctx, span := startTurnSpan(ctx, attribute.String("session.id", session.ID))
+	defer span.End()
+	ctx = withAgentTools(withAuditSession(ctx, "embed:"+session.ID, ""), a.own)
+
+	convo := &session.Messages
+	compacting := compaction{provider: a.provider, addUsage: func(usage anthropic.Usage, model anthropic.Model) {
+		a.usage.Add(usage)
+		a.cost += usage.Cost(model)
+	}}
+	convo.maybeCompact(ctx, compacting)
+	convo.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(input)})
+	req := newRequest(*convo, a.tools)
+	req.Model, req.MaxTokens, req.System, req.Provider = a.model, requestMaxTokens(a.model), a.system, a.provider
+	err := convo.fitContext(ctx, req, compacting)
+	start := len(*convo) - 1 // the message is still last, even if older turns were compacted to fit
+	if err != nil {
+		convo.rollback(start)
+		return Result{SessionID: session.ID}, err
+	}
+	turn, err := convo.exchange(ctx, req, nil)
+	cost := turn.Usage.Cost(turn.Model)
+	a.usage.Add(turn.Usage)
+	a.cost += cost
+	if msg := a.budget.exceeded(a.usage, a.cost); msg != "" {
+		slog.Warn("budget exceeded", "session", session.ID, "detail", msg)
+	}
+	if err != nil || ctx.Err() != nil {
+		convo.rollback(start)
+	} else {
+		session.Usage.Add(turn.Usage)
+		if saveErr := saveSession(context.WithoutCancel(ctx), a.store, session); saveErr != nil {
+			slog.Error("could not save session", "session", session.ID, "error", saveErr)
+		}
+	}
+
+	return Result{
+		SessionID:    session.ID,
+		Text:         turn.Text,
+		ToolCalls:    turn.ToolCalls,
+		StopReason:   turn.StopReason,
+		Model:        turn.Model,
+		FallbackFrom: turn.FallbackFrom,
+		Limit:        turn.Limit,
+		Usage:        turn.Usage,
+		Cost:         cost,
+	}, err
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+type Handler struct {
+	Tools *[]anthropic.Tool
+}
+
+func (h *Handler) ConverseHttp(w http.ResponseWriter, r *http.Request) {
+	_, role, ok := apiCaller(r)
+	if !ok {
+		http.Error(w, "ERROR: "+errAPIKey, http.StatusUnauthorized)
+		return
+	}
+	convo := Conversation{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "ERROR: Invalid data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = json.Unmarshal(body, &convo)
+	if err != nil {
+		http.Error(w, "ERROR: Invalid values, must be of type Conversation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Converse
+	req := newRequest(convo, roleTools(role, *h.Tools))
+	convo.talkHttp(withRole(r.Context(), role), req, w)
+}
+
+func (convo *Conversation) talkHttp(ctx context.Context, req *anthropic.Request, w http.ResponseWriter) {
+	resp, err := req.Post(ctx)
+	if err != nil {
+		errMsg := utils.Csprintf("red", "Error making request: %s", err.Error())
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+	recordUsage(resp)
+
+	var responseMsg string
+	var toolUses []anthropic.Content
+	for _, cont := range resp.Content {
+		if cont.Type == anthropic.MessageResp || cont.Type == anthropic.Text {
+			thoughts, message := parseThoughts(cont.Text)
+			if thoughts != "" {
+				responseMsg += utils.Csprintf(claudeThoughtsColor, "\n*Thinking* %s\n", thoughts)
+			}
+			if message != "" {
+				responseMsg += utils.Csprintf(claudeColor, "Claude:\n")
+				responseMsg += utils.Csprintf(claudeResponseColor, "%s\n", message)
+			}
+		} else if cont.Type == anthropic.ToolUse {
+			toolUses = append(toolUses, cont)
+		} else if cont.Type == anthropic.Thinking {
+			if showThinking {
+				responseMsg += utils.Csprintf(thinkingColor, "\n*Thinking* %s\n", strings.TrimSpace(cont.Thinking))
+			}
+		} else if cont.Type != anthropic.RedactedThinking {
+			errMsg := utils.Csprintf("red", "Error: Unknown response type %s", cont.Type)
+			http.Error(w, errMsg, http.StatusInternalServerError)
+			return
+		}
+	}
+	convo.appendAssistant(resp)
+
+	if len(toolUses) > 0 {
+		convo.useToolsHttp(withAuditTurn(ctx, resp.ID), toolUses, &responseMsg)
+		w.Write([]byte(responseMsg))
+		req.Messages = *convo
+		// Forced tool use only applies to the first request of a turn, or it would loop forever
+		req.ToolChoice = nil
+		convo.talkHttp(ctx, req, w) // Recursively call talk to handle the next step
+		return
+	}
+	if resp.StopReason == anthropic.MaxTokens {
+		responseMsg += utils.Csprintf("yellow", "Warning: reply was cut off at max_tokens\n")
+	}
+	w.Write([]byte(responseMsg))
+}
+
+func (convo *Conversation) useToolsHttp(ctx context.Context, uses []anthropic.Content, responseMsg *string) {
+	for _, use := range uses {
+		*responseMsg += utils.Csprintf(toolRequestColor, "Claude wants to use tool: '%s' with inputs: %v\n", use.Name, use.Input)
+	}
+	results := runTools(ctx, uses)
+	for i, use := range uses {
+		*responseMsg += utils.Csprintf(toolResponseColor, "Used tool '%s' and got response: %v\n", use.Name, results[i].Content)
+	}
+	convo.appendToolResults(uses, results)
+}
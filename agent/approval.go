@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # APPROVAL POLICY
+// Deciding, before a tool runs, whether it may run on its own, needs someone's approval, or may not run at all
+//   - Each tool has a policy, falling back to the default, which is auto unless configured
+//   - Rules match tool names with globs and inputs with regular expressions, e.g. confirm anything containing DELETE
+//   - The strictest of the tool's policy and every matching rule applies, so a rule can tighten a policy but never loosen it
+//   - The CLI asks at the prompt, the REST API queues the call until it is approved or declined; with nobody to ask, it is refused
+type ToolPolicy string
+
+const (
+	PolicyAuto    ToolPolicy = "auto"
+	PolicyConfirm ToolPolicy = "confirm"
+	PolicyDeny    ToolPolicy = "deny"
+)
+
+// ApprovalRule applies a policy to the calls of the tools matching Tool whose input matches Match
+type ApprovalRule struct {
+	Tool   string     // glob of tool names, e.g. delete_*, empty for any tool
+	Match  string     // regular expression matched against the input as JSON, empty for any input
+	Policy ToolPolicy // what to do with matching calls
+
+	match *regexp.Regexp
+}
+
+type ApprovalPolicy struct {
+	Default ToolPolicy
+	Tools   map[string]ToolPolicy
+	Rules   []ApprovalRule
+}
+
+// approver asks someone whether a tool call may run, returning an error if nobody can answer
+type approver func(ctx context.Context, use anthropic.Content, reason string) (bool, error)
+
+type approverKey struct{}
+
+var approvalPolicy = ApprovalPolicy{Default: PolicyAuto}
+
+// SetApprovalPolicy checks the policy and applies it to every tool call from now on
+func SetApprovalPolicy(p ApprovalPolicy) error {
+	if p.Default == "" {
+		p.Default = PolicyAuto
+	}
+	if err := p.Default.check(); err != nil {
+		return fmt.Errorf("invalid default tool policy: %v", err)
+	}
+	for name, policy := range p.Tools {
+		if err := policy.check(); err != nil {
+			return fmt.Errorf("invalid policy for tool %s: %v", name, err)
+		}
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if err := rule.Policy.check(); err != nil {
+			return fmt.Errorf("invalid policy in tool policy rule %d: %v", i+1, err)
+		}
+		if _, err := path.Match(rule.Tool, ""); err != nil {
+			return fmt.Errorf("invalid tool pattern in tool policy rule %d: %v", i+1, err)
+		}
+		if rule.Match != "" {
+			match, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return fmt.Errorf("invalid match in tool policy rule %d: %v", i+1, err)
+			}
+			rule.match = match
+		}
+	}
+	approvalPolicy = p
+	return nil
+}
+
+func (p ToolPolicy) check() error {
+	switch p {
+	case PolicyAuto, PolicyConfirm, PolicyDeny:
+		return nil
+	}
+	return fmt.Errorf("unknown policy '%s', expected auto, confirm or deny", p)
+}
+
+// strictness orders policies from auto to deny
+func (p ToolPolicy) strictness() int {
+	switch p {
+	case PolicyConfirm:
+		return 1
+	case PolicyDeny:
+		return 2
+	}
+	return 0
+}
+
+// decide returns the policy for a tool call, and why it applies
+func (p ApprovalPolicy) decide(use anthropic.Content) (ToolPolicy, string) {
+	policy, reason := p.Default, "the default policy"
+	if toolPolicy, ok := p.Tools[use.Name]; ok {
+		policy, reason = toolPolicy, "the policy for "+use.Name
+	}
+	input := formatToolInput(use.Input)
+	for _, rule := range p.Rules {
+		if ok, _ := path.Match(rule.Tool, use.Name); rule.Tool != "" && !ok {
+			continue
+		}
+		if rule.match != nil && !rule.match.MatchString(input) {
+			continue
+		}
+		if rule.Policy.strictness() > policy.strictness() {
+			policy, reason = rule.Policy, "the rule for "+rule.Tool
+			if rule.Match != "" {
+				reason = fmt.Sprintf("the rule matching `%s`", rule.Match)
+			}
+		}
+	}
+	return policy, reason
+}
+
+// withApprover has confirmations for tool calls under ctx answered by ask, instead of at the prompt
+func withApprover(ctx context.Context, ask approver) context.Context {
+	return context.WithValue(ctx, approverKey{}, ask)
+}
+
+// approveTool applies the approval policy to a tool call, returning the error result to send back if it may not run
+func approveTool(ctx context.Context, use anthropic.Content) (anthropic.Content, bool) {
+	policy, reason := approvalPolicy.decide(use)
+	switch policy {
+	case PolicyAuto:
+		return anthropic.Content{}, true
+	case PolicyDeny:
+		return toolError(fmt.Sprintf("calling %s is not allowed by %s", use.Name, reason)), false
+	}
+
+	ask, ok := ctx.Value(approverKey{}).(approver)
+	if !ok {
+		ask = confirmAtPrompt
+	}
+	approved, err := ask(ctx, use, reason)
+	if err != nil {
+		return toolError(fmt.Sprintf("calling %s needs approval because of %s, but %v", use.Name, reason, err)), false
+	}
+	if !approved {
+		return toolError("the user declined this call to " + use.Name), false
+	}
+	return anthropic.Content{}, true
+}
+
+// confirmAtPrompt asks the user at the prompt of the interactive CLI
+func confirmAtPrompt(ctx context.Context, use anthropic.Content, reason string) (bool, error) {
+	if confirmFunc == nil {
+		return false, fmt.Errorf("nobody can approve it in this mode")
+	}
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+	return confirmFunc(fmt.Sprintf("Allow %s %s (needs approval because of %s)?", use.Name, formatToolInput(use.Input), reason)), nil
+}
+
+// # PENDING APPROVALS
+// Calls waiting for approval through the REST API, while the chat request that made them waits too
+//   - GET /v1/approvals lists them, POST /v1/approvals/{id} with {"approve": true} or false decides one
+//   - With API keys, only keys of an approver role may list and decide them, and never a call their own chat made
+//   - A call nobody decides on within the approval timeout is declined
+const approvalTimeout = 10 * time.Minute
+
+var (
+	errApprovalNotFound = errors.New("approval not found")
+	errOwnApproval      = errors.New("a call can't be decided with the API key whose chat made it")
+)
+
+type PendingApproval struct {
+	ID        string         `json:"id"`
+	SessionID string         `json:"session_id"`
+	ToolUseID string         `json:"tool_use_id"`
+	Tool      string         `json:"tool"`
+	Input     map[string]any `json:"input"`
+	Reason    string         `json:"reason"`
+	Caller    string         `json:"caller,omitempty"` // the API key whose chat made the call
+	Created   time.Time      `json:"created"`
+
+	decision chan bool
+}
+
+type approvalQueue struct {
+	mu      sync.Mutex
+	pending map[string]*PendingApproval
+}
+
+// approver returns the approver that queues the calls of a session's chat by caller until they are decided
+func (q *approvalQueue) approver(sessionID, caller string) approver {
+	return func(ctx context.Context, use anthropic.Content, reason string) (bool, error) {
+		id, err := newSessionID()
+		if err != nil {
+			return false, err
+		}
+		pending := &PendingApproval{
+			ID: id, SessionID: sessionID, ToolUseID: use.Id, Tool: use.Name, Input: use.Input,
+			Reason: reason, Caller: caller, Created: time.Now().UTC(), decision: make(chan bool, 1),
+		}
+		q.mu.Lock()
+		q.pending[id] = pending
+		q.mu.Unlock()
+		defer func() {
+			q.mu.Lock()
+			delete(q.pending, id)
+			q.mu.Unlock()
+		}()
+
+		timer := time.NewTimer(approvalTimeout)
+		defer timer.Stop()
+		select {
+		case approved := <-pending.decision:
+			return approved, nil
+		case <-timer.C:
+			return false, fmt.Errorf("nobody approved it within %s", approvalTimeout)
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// list returns the pending approvals, oldest first
+func (q *approvalQueue) list() []*PendingApproval {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	list := make([]*PendingApproval, 0, len(q.pending))
+	for _, pending := range q.pending {
+		list = append(list, pending)
+	}
+	slices.SortFunc(list, func(a, b *PendingApproval) int { return a.Created.Compare(b.Created) })
+	return list
+}
+
+// decide approves or declines a pending call for caller, who must not be the one that made it
+func (q *approvalQueue) decide(id string, approved bool, caller string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending, ok := q.pending[id]
+	if !ok {
+		return errApprovalNotFound
+	}
+	if caller != "" && caller == pending.Caller {
+		return errOwnApproval
+	}
+	delete(q.pending, id)
+	pending.decision <- approved
+	return nil
+}
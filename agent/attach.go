@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # ATTACH
+// A terminal on a daemon session, see daemon.go
+//   - Attaching shows the last few messages, then it works like the REPL without slash commands
+//   - Turns other terminals took in the meantime are shown before each reply
+//   - Ctrl+C cancels the running turn, and a second one detaches; the daemon keeps running
+const attachHistory = 6 // messages shown on attaching
+
+type daemonClient struct {
+	http *http.Client
+}
+
+func newDaemonClient(socket string) *daemonClient {
+	transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}}
+	return &daemonClient{http: &http.Client{Transport: transport}}
+}
+
+// do sends a request to the daemon and decodes its JSON response into out
+func (c *daemonClient) do(ctx context.Context, method, path string, in any, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://daemon"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach the daemon, is `super-claude daemon` running? %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s", apiErr.Error)
+		}
+		return fmt.Errorf("daemon returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// ListSessions prints the daemon's sessions, most recently used first
+func ListSessions(socket string) error {
+	var sessions []SessionInfo
+	if err := newDaemonClient(socket).do(context.Background(), "GET", "/sessions", nil, &sessions); err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		utils.Cprintln(commandColor, "No sessions, start one with `super-claude attach <name>`.")
+		return nil
+	}
+	for _, s := range sessions {
+		utils.Cprintf(commandColor, "%-24s %-26s %4d messages  last used %s\n", s.Name, s.Model, s.Length, s.Updated.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// Attach opens the named session on the daemon, creating it if needed, and chats in it until the user detaches
+func Attach(socket, name string, open OpenSessionRequest, in LineReader) error {
+	client := newDaemonClient(socket)
+	var info SessionInfo
+	if err := client.do(context.Background(), "PUT", "/sessions/"+name, open, &info); err != nil {
+		return err
+	}
+	if open.Model != "" && anthropic.ParseModel(open.Model) != info.Model {
+		utils.Eprintln("yellow", "Session", name, "already exists and keeps using", info.Model)
+	}
+	utils.Cprintf(commandColor, "Attached to session %s (%s, %d messages). Ctrl+C twice or `exit` detaches.\n", info.Name, info.Model, info.Length)
+	if info.Length > 0 {
+		if err := client.do(context.Background(), "GET", fmt.Sprintf("/sessions/%s?since=%d", name, max(0, info.Length-attachHistory)), nil, &info); err != nil {
+			return err
+		}
+		printMessages(info.Messages)
+	}
+	seen := info.Length
+
+	var mu sync.Mutex
+	var interrupts interrupter
+	stop := interrupts.trapInterrupts(&mu, func() { in.Close() })
+	defer stop()
+	for {
+		message, ok := handleUserInput(in, &interrupts)
+		if !ok {
+			return nil
+		}
+		if strings.TrimSpace(message) == "" {
+			continue
+		}
+
+		mu.Lock()
+		ctx := interrupts.startTurn()
+		var resp TurnResponse
+		err := client.do(ctx, "POST", "/sessions/"+name+"/turns", TurnRequest{Message: message, Since: seen}, &resp)
+		interrupted := ctx.Err() != nil
+		interrupts.endTurn()
+		mu.Unlock()
+		if interrupted {
+			continue // interrupted, already reported, and the daemon drops the turn
+		}
+		if err != nil {
+			utils.Eprintln("red", "Error: "+err.Error())
+			continue
+		}
+		if len(resp.Missed) > 0 {
+			utils.Cprintln(commandColor, "-- from another terminal --")
+			printMessages(resp.Missed)
+			utils.Cprintln(commandColor, "-- end --")
+		}
+		if resp.Result.FallbackFrom != "" {
+			utils.Eprintln("yellow", fmt.Sprintf("%s is unavailable, %s answered instead.", resp.Result.FallbackFrom, resp.Result.Model))
+		}
+		if resp.Result.Limit != "" {
+			utils.Eprintln("yellow", fmt.Sprintf("Stopped calling tools at %s, so the answer may be incomplete.", DescribeLimit(resp.Result.Limit)))
+		}
+		for _, call := range resp.Result.ToolCalls {
+			utils.Eprintln(toolRequestColor, "Claude used tool:", call.Name, formatToolInput(call.Input))
+		}
+		if resp.Result.Text != "" {
+			printReply(resp.Result.Text)
+		}
+		seen = resp.Length
+	}
+}
+
+// printMessages shows messages from the history the way the REPL shows them live
+func printMessages(messages []anthropic.Message) {
+	for _, msg := range messages {
+		for _, cont := range msg.Content {
+			switch {
+			case cont.Type == anthropic.Text && msg.Role == anthropic.User:
+				utils.Cprintln(userColor, "You:", cont.Text)
+			case cont.Type == anthropic.Text:
+				_, message := parseThoughts(cont.Text)
+				if message != "" {
+					printReply(message)
+				}
+			case cont.Type == anthropic.ToolUse:
+				utils.Eprintln(toolRequestColor, "Claude used tool:", cont.Name, formatToolInput(cont.Input))
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # AUDIT LOG
+// An append-only JSONL trail of every tool execution, for accountability once tools change production data
+//   - One line per call, written and synced to disk as soon as the call returns, whatever the result
+//   - Each line says who asked: the session, the user if known, and the model response that requested the call
+//   - The file is only ever appended to, never rewritten or rotated by the agent
+const maxAuditErrorBytes = 1024
+
+type AuditRecord struct {
+	Time       time.Time      `json:"time"`
+	Session    string         `json:"session"`        // cli:<id>, api:<id>, daemon:<name> or slack:<channel>/<thread>
+	User       string         `json:"user,omitempty"` // the OS user, or the Slack user
+	Turn       string         `json:"turn"`           // id of the model response that asked for the call
+	ToolUseID  string         `json:"tool_use_id"`
+	Tool       string         `json:"tool"`
+	Input      map[string]any `json:"input"`
+	Status     string         `json:"status"` // ok or error
+	Error      string         `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+var (
+	auditFile *os.File
+	auditMu   sync.Mutex
+
+	// cliSession identifies this process in the log when a turn doesn't belong to a named session
+	cliSession, cliUser string
+)
+
+type auditKey int
+
+const (
+	auditSessionKey auditKey = iota
+	auditUserKey
+	auditTurnKey
+)
+
+// SetAuditLog appends a record of every tool call to filename, "" turns the log off
+func SetAuditLog(filename string) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditFile != nil {
+		auditFile.Close()
+		auditFile = nil
+	}
+	if filename == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %v", err)
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	auditFile = file
+	return identifyCLI()
+}
+
+// identifyCLI names this process's session and user, for the audit and usage logs to share
+func identifyCLI() error {
+	if cliSession != "" {
+		return nil
+	}
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	cliSession = "cli:" + id[:12]
+	if u, err := user.Current(); err == nil {
+		cliUser = u.Username
+	}
+	return nil
+}
+
+// auditCaller is the session and user tool calls under ctx are made for, this process's if ctx doesn't say
+func auditCaller(ctx context.Context) (session, user string) {
+	if session, ok := ctx.Value(auditSessionKey).(string); ok {
+		return session, ctx.Value(auditUserKey).(string)
+	}
+	return cliSession, cliUser
+}
+
+// withAuditSession tags the tool calls made under ctx with a session and, if known, the user who started it
+func withAuditSession(ctx context.Context, session, user string) context.Context {
+	ctx = context.WithValue(ctx, auditSessionKey, session)
+	return context.WithValue(ctx, auditUserKey, user)
+}
+
+// withAuditTurn tags the tool calls made under ctx with the id of the response that asked for them
+func withAuditTurn(ctx context.Context, responseID string) context.Context {
+	return context.WithValue(ctx, auditTurnKey, responseID)
+}
+
+// auditTool records a finished tool call, a failure to write it is logged but doesn't fail the call
+func auditTool(ctx context.Context, use anthropic.Content, result anthropic.Content, duration time.Duration) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if auditFile == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:       time.Now().UTC(),
+		ToolUseID:  use.Id,
+		Tool:       use.Name,
+		Input:      use.Input,
+		Status:     "ok",
+		DurationMS: duration.Milliseconds(),
+	}
+	record.Session, record.User = auditCaller(ctx)
+	record.Turn, _ = ctx.Value(auditTurnKey).(string)
+	if result.IsError {
+		record.Status = "error"
+		record.Error = result.Content
+		if len(record.Error) > maxAuditErrorBytes {
+			record.Error = record.Error[:runeStart(record.Error, maxAuditErrorBytes)] + "..."
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = auditFile.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = auditFile.Sync()
+	}
+	if err != nil {
+		slog.Error("could not write audit record", "tool", use.Name, "tool_use_id", use.Id, "error", err)
+	}
+}
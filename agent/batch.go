@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # BATCHES
+// Running a JSONL file of prompts through the Message Batches API, for cheap offline regression runs
+//   - Each input line is {"custom_id": "...", "prompt": "..."}, custom_id defaults to prompt-<line>
+//   - Each prompt gets one reply with the current settings and tools, tools are not run
+//   - Results are written in input order as one JSON line each
+var batchPollInterval = 30 * time.Second
+
+// SetBatchPollInterval sets how often RunBatch checks whether the batch has ended
+func SetBatchPollInterval(d time.Duration) {
+	if d > 0 {
+		batchPollInterval = d
+	}
+}
+
+type batchPrompt struct {
+	CustomID string `json:"custom_id"`
+	Prompt   string `json:"prompt"`
+}
+
+// BatchResult is one line of the results file. ToolCalls are the tools Claude asked for, without results.
+type BatchResult struct {
+	CustomID   string               `json:"custom_id"`
+	Status     string               `json:"status"` // succeeded, errored, canceled or expired
+	Text       string               `json:"text"`
+	ToolCalls  []ToolCall           `json:"tool_calls"`
+	StopReason anthropic.StopReason `json:"stop_reason,omitempty"`
+	Model      anthropic.Model      `json:"model,omitempty"`
+	Usage      anthropic.Usage      `json:"usage"`
+	Error      string               `json:"error,omitempty"`
+}
+
+func readBatchPrompts(filename string) ([]batchPrompt, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var prompts []batchPrompt
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var p batchPrompt
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if p.Prompt == "" {
+			return nil, fmt.Errorf("line %d: prompt is required", line)
+		}
+		if p.CustomID == "" {
+			p.CustomID = fmt.Sprintf("prompt-%d", line)
+		}
+		if ids[p.CustomID] {
+			return nil, fmt.Errorf("line %d: duplicate custom_id '%s'", line, p.CustomID)
+		}
+		ids[p.CustomID] = true
+		prompts = append(prompts, p)
+	}
+	return prompts, scanner.Err()
+}
+
+// RunBatch submits the prompts in inFile as a batch, waits for it to end and writes the results to outFile
+func RunBatch(ctx context.Context, inFile string, outFile string, t []anthropic.Tool) error {
+	client := anthropic.GetClient()
+	if client == nil {
+		return fmt.Errorf("batch runs need the Anthropic API, the Batches API is not available through the configured provider")
+	}
+	prompts, err := readBatchPrompts(inFile)
+	if err != nil {
+		return fmt.Errorf("failed to read prompts: %v", err)
+	}
+	if len(prompts) == 0 {
+		return fmt.Errorf("no prompts in %s", inFile)
+	}
+
+	requests := make([]anthropic.BatchRequest, len(prompts))
+	for i, p := range prompts {
+		convo := Conversation{{Role: anthropic.User, Content: makeTextContent(p.Prompt)}}
+		requests[i] = anthropic.BatchRequest{CustomID: p.CustomID, Params: newRequest(convo, t)}
+	}
+	batch, err := client.CreateBatch(ctx, requests)
+	if err != nil {
+		return fmt.Errorf("failed to create batch: %v", err)
+	}
+	slog.Info("submitted batch", "batch", batch.ID, "requests", len(requests))
+
+	for batch.ProcessingStatus != "ended" {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for batch %s, which keeps running: %v", batch.ID, ctx.Err())
+		case <-time.After(batchPollInterval):
+		}
+		batch, err = client.GetBatch(ctx, batch.ID)
+		if err != nil {
+			return fmt.Errorf("failed to check batch: %v", err)
+		}
+		c := batch.RequestCounts
+		slog.Info("batch status", "batch", batch.ID, "status", batch.ProcessingStatus, "processing", c.Processing, "succeeded", c.Succeeded, "errored", c.Errored)
+	}
+
+	results, err := client.BatchResults(ctx, batch)
+	if err != nil {
+		return fmt.Errorf("failed to download batch results: %v", err)
+	}
+	byID := map[string]anthropic.BatchResult{}
+	for _, r := range results {
+		byID[r.CustomID] = r
+	}
+
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	encoder := json.NewEncoder(out)
+	for _, p := range prompts {
+		r, ok := byID[p.CustomID]
+		line := batchResultLine(p.CustomID, r, ok)
+		addUsage(line.Usage, line.Model)
+		record := UsageRecord{Model: line.Model, Usage: line.Usage, Cost: line.Usage.Cost(line.Model) / 2}
+		for _, call := range line.ToolCalls {
+			record.Tools = append(record.Tools, call.Name)
+		}
+		appendUsage(ctx, record)
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	_, cost := sessionTotals()
+	slog.Info("wrote batch results", "file", outFile, "succeeded", batch.RequestCounts.Succeeded, "errored", batch.RequestCounts.Errored,
+		"cost", fmt.Sprintf("$%.4f", cost/2)) // batches are billed at half price
+	return nil
+}
+
+func batchResultLine(customID string, r anthropic.BatchResult, found bool) BatchResult {
+	line := BatchResult{CustomID: customID, Status: r.Result.Type, ToolCalls: []ToolCall{}}
+	if !found {
+		line.Status, line.Error = "errored", "no result returned for this request"
+		return line
+	}
+	if r.Result.Error != nil {
+		line.Error = r.Result.Error.Error.Type + ": " + r.Result.Error.Error.Message
+	}
+	msg := r.Result.Message
+	if msg == nil {
+		return line
+	}
+	line.StopReason, line.Model, line.Usage = msg.StopReason, msg.Model, msg.Usage
+	var texts []string
+	for _, cont := range msg.Content {
+		switch cont.Type {
+		case anthropic.Text:
+			_, message := parseThoughts(cont.Text)
+			texts = append(texts, message)
+		case anthropic.ToolUse:
+			line.ToolCalls = append(line.ToolCalls, ToolCall{ID: cont.Id, Name: cont.Name, Input: cont.Input})
+		}
+	}
+	line.Text = strings.Join(texts, "\n\n")
+	return line
+}
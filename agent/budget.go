@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # BUDGET
+// Session usage totals and budget enforcement
+// Budget caps the spend of a session, a zero value disables that limit
+type Budget struct {
+	MaxCost   float64
+	MaxTokens int
+	Stop      bool // refuse further requests once exceeded instead of only warning
+}
+
+var (
+	sessionCost   float64
+	sessionBudget Budget
+	usageMu       sync.Mutex // guards the totals, which sub-agents and FanOut add to from several goroutines
+)
+
+func SetBudget(b Budget) {
+	sessionBudget = b
+}
+
+// recordUsage adds a response's usage to the session totals and warns if the budget is exceeded
+func recordUsage(resp *anthropic.Response) {
+	addUsage(resp.Usage, resp.Model)
+	if msg := budgetExceeded(); msg != "" {
+		utils.Eprintln("yellow", "Warning: "+msg)
+	}
+}
+
+// addUsage adds usage on a model to the session totals without any output
+func addUsage(usage anthropic.Usage, model anthropic.Model) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	sessionUsage.Add(usage)
+	sessionCost += usage.Cost(model)
+}
+
+// sessionTotals are the session's usage and cost so far
+func sessionTotals() (anthropic.Usage, float64) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	return sessionUsage, sessionCost
+}
+
+// budgetExceeded describes which limit of the session budget has been passed, if any
+func budgetExceeded() string {
+	return sessionBudget.exceeded(sessionTotals())
+}
+
+// exceeded describes which limit of the budget usage and cost have passed, if any
+func (b Budget) exceeded(usage anthropic.Usage, cost float64) string {
+	if b.MaxCost > 0 && cost >= b.MaxCost {
+		return fmt.Sprintf("session cost $%.4f has reached the budget of $%.4f", cost, b.MaxCost)
+	}
+	total := usage.InputTokens + usage.OutputTokens
+	if b.MaxTokens > 0 && total >= b.MaxTokens {
+		return fmt.Sprintf("session used %d tokens, reaching the budget of %d", total, b.MaxTokens)
+	}
+	return ""
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # TOOL RESULT CACHE
+// Successful results of idempotent tools are reused for repeated calls with the same input
+//   - Only tools given a TTL are cached, in their JSON file's cache_ttl or with SetToolCacheTTL
+//   - Entries are keyed by the tool name and the input as canonical JSON, with object keys sorted
+//   - Entries live in memory, and also in a directory if SetToolCacheDir is set, so they outlast the process;
+//     the files are sealed like saved conversations and only readable by the user
+type cachedResult struct {
+	Tool    string            `json:"tool"`
+	Input   map[string]any    `json:"input"`
+	Result  anthropic.Content `json:"result"`
+	Expires time.Time         `json:"expires"`
+}
+
+var (
+	toolCacheTTLs       = map[string]time.Duration{} // from the tools' files
+	configuredCacheTTLs = map[string]time.Duration{} // from SetToolCacheTTL, over the files'
+	toolCache           = map[string]cachedResult{}
+	toolCacheMu         sync.Mutex
+	toolCacheDir        string
+)
+
+// SetToolCacheTTL caches the tool's results for ttl whatever its file says, zero turns caching off for it
+func SetToolCacheTTL(tool string, ttl time.Duration) {
+	toolSettingsMu.Lock()
+	defer toolSettingsMu.Unlock()
+	configuredCacheTTLs[tool] = ttl
+}
+
+func toolCacheTTL(tool string) time.Duration {
+	toolSettingsMu.RLock()
+	defer toolSettingsMu.RUnlock()
+	if ttl, ok := configuredCacheTTLs[tool]; ok {
+		return ttl
+	}
+	return toolCacheTTLs[tool]
+}
+
+// SetToolCacheDir also keeps cached results as files in dir, "" keeps them in memory only
+func SetToolCacheDir(dir string) {
+	toolCacheDir = dir
+}
+
+// toolCacheKey is a hash of the tool name and canonical input, "" if the input can't be encoded
+func toolCacheKey(use anthropic.Content) string {
+	input, err := json.Marshal(use.Input) // maps are encoded with sorted keys
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(append([]byte(use.Name+"\x00"), input...))
+	return hex.EncodeToString(sum[:])
+}
+
+func cachedToolResult(use anthropic.Content) (anthropic.Content, bool) {
+	if toolCacheTTL(use.Name) <= 0 {
+		return anthropic.Content{}, false
+	}
+	key := toolCacheKey(use)
+	if key == "" {
+		return anthropic.Content{}, false
+	}
+	toolCacheMu.Lock()
+	defer toolCacheMu.Unlock()
+
+	entry, ok := toolCache[key]
+	if !ok && toolCacheDir != "" {
+		if data, err := os.ReadFile(filepath.Join(toolCacheDir, key+".json")); err == nil {
+			if data, err = openData(data); err == nil {
+				ok = json.Unmarshal(data, &entry) == nil
+			} else {
+				slog.Warn("could not read tool cache entry", "tool", use.Name, "error", err)
+			}
+		}
+	}
+	if !ok || time.Now().After(entry.Expires) {
+		delete(toolCache, key)
+		return anthropic.Content{}, false
+	}
+	toolCache[key] = entry
+	slog.Debug("tool cache hit", "tool", use.Name, "expires", entry.Expires)
+	return entry.Result, true
+}
+
+func cacheToolResult(use anthropic.Content, result anthropic.Content) {
+	ttl := toolCacheTTL(use.Name)
+	if ttl <= 0 {
+		return
+	}
+	key := toolCacheKey(use)
+	if key == "" {
+		return
+	}
+	entry := cachedResult{Tool: use.Name, Input: use.Input, Result: result, Expires: time.Now().Add(ttl)}
+	toolCacheMu.Lock()
+	defer toolCacheMu.Unlock()
+	toolCache[key] = entry
+
+	if toolCacheDir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(toolCacheDir, 0o700)
+	}
+	if err == nil {
+		err = writeSealed(filepath.Join(toolCacheDir, key+".json"), data)
+	}
+	if err != nil {
+		slog.Warn("could not write tool cache entry", "tool", use.Name, "error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+func TestToolCacheDirIsSealed(t *testing.T) {
+	key, err := NewStorageKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetStorageKey(key); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "cache")
+	SetToolCacheDir(dir)
+	SetToolCacheTTL("postal_codes", time.Hour)
+	t.Cleanup(func() {
+		storageCipher = nil
+		SetToolCacheDir("")
+		SetToolCacheTTL("postal_codes", 0)
+		toolCache = map[string]cachedResult{}
+	})
+
+	use := anthropic.Content{Type: anthropic.ToolUse, Name: "postal_codes", Input: map[string]any{"code": "30350"}}
+	result := anthropic.Content{Type: anthropic.ToolResult, Content: "Atlanta, 12 Peachtree St"}
+	cacheToolResult(use, result)
+
+	file := filepath.Join(dir, toolCacheKey(use)+".json")
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("cache file mode %o, want 600", mode)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, sealMagic) || bytes.Contains(data, []byte("Peachtree")) {
+		t.Errorf("cache file is not sealed: %q", data)
+	}
+
+	toolCache = map[string]cachedResult{} // as in a new process
+	got, ok := cachedToolResult(use)
+	if !ok || got.Content != result.Content {
+		t.Errorf("read back %+v, %v from the sealed file", got, ok)
+	}
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # CHECKPOINTS
+// Named snapshots of the conversation, to rewind to and explore a different line of questioning
+// They live for the session and survive /reset and /load
+type checkpoint struct {
+	messages Conversation
+}
+
+var checkpoints = map[string]checkpoint{}
+
+func (convo *Conversation) saveCheckpoint(name string) {
+	// Copied, so later appends can't write into the snapshot's backing array
+	checkpoints[name] = checkpoint{messages: append(Conversation(nil), *convo...)}
+}
+
+func (convo *Conversation) rewind(name string) bool {
+	cp, ok := checkpoints[name]
+	if !ok {
+		return false
+	}
+	*convo = append(Conversation(nil), cp.messages...)
+	pendingAttachments = nil
+	return true
+}
+
+func cmdCheckpoint(_ context.Context, convo *Conversation, args string, _ *[]anthropic.Tool) {
+	if args == "" {
+		if len(checkpoints) == 0 {
+			utils.Cprintln(commandColor, "No checkpoints yet, create one with /checkpoint <name>.")
+			return
+		}
+		names := make([]string, 0, len(checkpoints))
+		for name := range checkpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			utils.Cprintf(commandColor, "  %s (%d messages)\n", name, len(checkpoints[name].messages))
+		}
+		return
+	}
+	_, replaced := checkpoints[args]
+	convo.saveCheckpoint(args)
+	if replaced {
+		utils.Cprintln(commandColor, "Checkpoint", args, "replaced at", len(*convo), "messages.")
+		return
+	}
+	utils.Cprintln(commandColor, "Checkpoint", args, "saved at", len(*convo), "messages.")
+}
+
+func cmdRewind(_ context.Context, convo *Conversation, args string, _ *[]anthropic.Tool) {
+	if args == "" {
+		utils.Eprintln("red", "Usage: /rewind <name> (see /checkpoint for the list)")
+		return
+	}
+	if !convo.rewind(args) {
+		utils.Eprintln("red", "Unknown checkpoint: "+args+" (see /checkpoint)")
+		return
+	}
+	utils.Cprintln(commandColor, "Rewound to checkpoint", args, "with", len(*convo), "messages.")
+}
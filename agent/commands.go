@@ -0,0 +1,191 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # COMMANDS
+// Slash commands typed into the REPL are handled locally instead of being sent to Claude
+const commandColor = "pastel_cyan"
+
+type command struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, convo *Conversation, args string, t *[]anthropic.Tool)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":       {"/help", "List available commands", cmdHelp},
+		"reset":      {"/reset", "Clear the conversation history", cmdReset},
+		"save":       {"/save [file]", "Save the conversation to a JSON file", cmdSave},
+		"load":       {"/load [file]", "Load a conversation from a JSON file", cmdLoad},
+		"tools":      {"/tools", "List the loaded tools", cmdTools},
+		"tokens":     {"/tokens", "Show cumulative token usage for this session", cmdTokens},
+		"cost":       {"/cost", "Show the estimated cost of this session", cmdCost},
+		"stats":      {"/stats", "Show the last turn's latency and throughput, and the session's for each model", cmdStats},
+		"system":     {"/system [prompt]", "Show the system prompt, or replace it", cmdSystem},
+		"toolchoice": {"/toolchoice [auto|any|tool]", "Show or set whether Claude must use tools", cmdToolChoice},
+		"paste":      {"/paste", "Read multi-line input until a blank line", nil},
+		"export":     {"/export [--redact] <md|html> <path>", "Export the conversation as Markdown or HTML, --redact masks the redact_patterns", cmdExport},
+		"image":      {"/image <path> [message]", "Attach a PNG/JPEG image, sending it now if a message is given", cmdImage},
+		"attach":     {"/attach <path> [message]", "Attach a PDF or text document for Claude to cite, sending it now if a message is given", cmdAttach},
+		"retry":      {"/retry [model] [temperature]", "Regenerate the last reply, optionally with another model or temperature", cmdRetry},
+		"edit":       {"/edit <message>", "Replace your last message and send it again", cmdEdit},
+		"checkpoint": {"/checkpoint [name]", "Snapshot the conversation under a name, or list checkpoints", cmdCheckpoint},
+		"rewind":     {"/rewind <name>", "Roll the conversation back to a checkpoint", cmdRewind},
+		"compact":    {"/compact", "Summarize older turns to shrink the conversation", cmdCompact},
+		"persona":    {"/persona [name]", "List the personas, or switch to one", cmdPersona},
+	}
+}
+
+func (convo *Conversation) handleCommand(ctx context.Context, input string, t *[]anthropic.Tool) {
+	name, args, _ := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	cmd, ok := commands[strings.ToLower(name)]
+	if !ok {
+		utils.Eprintln("red", "Unknown command: /"+name+" (try /help)")
+		return
+	}
+	if cmd.run == nil {
+		return // handled while reading input
+	}
+	cmd.run(ctx, convo, strings.TrimSpace(args), t)
+}
+
+func cmdHelp(_ context.Context, _ *Conversation, _ string, _ *[]anthropic.Tool) {
+	for _, name := range []string{"help", "reset", "save", "load", "tools", "tokens", "cost", "stats", "system", "persona", "toolchoice", "image", "attach", "retry", "edit", "checkpoint", "rewind", "compact", "export", "paste"} {
+		utils.Cprintf(commandColor, "  %-28s %s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+func cmdReset(_ context.Context, convo *Conversation, _ string, _ *[]anthropic.Tool) {
+	*convo = (*convo)[:0]
+	pendingAttachments = nil
+	utils.Cprintln(commandColor, "Conversation cleared.")
+}
+
+func cmdSave(_ context.Context, convo *Conversation, args string, _ *[]anthropic.Tool) {
+	filename := defaultConvoFile
+	if args != "" {
+		filename = args
+	}
+	err := writeConvoToFile(*convo, filename)
+	if err != nil {
+		utils.Eprintln("red", "Error writing conversation to file: "+err.Error())
+	}
+}
+
+func cmdLoad(_ context.Context, convo *Conversation, args string, _ *[]anthropic.Tool) {
+	filename := defaultConvoFile
+	if args != "" {
+		filename = args
+	}
+	loaded, err := readConvoFromFile(filename)
+	if err != nil {
+		utils.Eprintln("red", "Error reading conversation from file: "+err.Error())
+		return
+	}
+	*convo = loaded
+	utils.Cprintln(commandColor, "Loaded", len(loaded), "messages from", filename)
+}
+
+func cmdTools(_ context.Context, _ *Conversation, _ string, t *[]anthropic.Tool) {
+	if len(*t) == 0 {
+		utils.Cprintln(commandColor, "No tools loaded.")
+		return
+	}
+	for _, tool := range *t {
+		utils.Cprintf(commandColor, "  %s: %s\n", tool.Name, tool.Description)
+	}
+}
+
+func cmdTokens(_ context.Context, _ *Conversation, _ string, _ *[]anthropic.Tool) {
+	usage, _ := sessionTotals()
+	utils.Cprintf(commandColor, "Input tokens: %d, output tokens: %d\n", usage.InputTokens, usage.OutputTokens)
+	if usage.CacheCreationInputTokens > 0 || usage.CacheReadInputTokens > 0 {
+		utils.Cprintf(commandColor, "Cache write tokens: %d, cache read tokens: %d\n", usage.CacheCreationInputTokens, usage.CacheReadInputTokens)
+	}
+}
+
+func cmdCost(_ context.Context, _ *Conversation, _ string, _ *[]anthropic.Tool) {
+	_, cost := sessionTotals()
+	utils.Cprintf(commandColor, "Session cost: $%.4f", cost)
+	if sessionBudget.MaxCost > 0 {
+		utils.Cprintf(commandColor, " of $%.4f budget", sessionBudget.MaxCost)
+	}
+	utils.Cprintln(commandColor)
+}
+
+func cmdSystem(_ context.Context, _ *Conversation, args string, _ *[]anthropic.Tool) {
+	if args == "" {
+		utils.Cprintln(commandColor, strings.TrimSpace(systemPrompt))
+		return
+	}
+	systemPrompt = args
+	utils.Cprintln(commandColor, "System prompt updated.")
+}
+
+func cmdImage(ctx context.Context, convo *Conversation, args string, t *[]anthropic.Tool) {
+	path, message, _ := strings.Cut(args, " ")
+	if path == "" {
+		utils.Eprintln("red", "Usage: /image <path> [message]")
+		return
+	}
+	image, err := anthropic.NewImageContentFromFile(path)
+	if err != nil {
+		utils.Eprintln("red", "Error attaching image: "+err.Error())
+		return
+	}
+	pendingAttachments = append(pendingAttachments, image)
+
+	message = strings.TrimSpace(message)
+	if message == "" {
+		utils.Cprintln(commandColor, "Attached", path, "to your next message.")
+		return
+	}
+	convo.send(ctx, makeTextContent(message), t)
+}
+
+func cmdToolChoice(_ context.Context, _ *Conversation, args string, t *[]anthropic.Tool) {
+	if args == "" {
+		utils.Cprintln(commandColor, "Tool choice:", toolChoice.String())
+		return
+	}
+	if args != "auto" && args != "any" && !hasTool(*t, args) {
+		utils.Eprintln("red", "Unknown tool: "+args+" (see /tools)")
+		return
+	}
+	SetToolChoice(args)
+	utils.Cprintln(commandColor, "Tool choice set to", toolChoice.String())
+}
+
+func hasTool(tools []anthropic.Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdExport(_ context.Context, convo *Conversation, args string, _ *[]anthropic.Tool) {
+	args, redacted := strings.CutPrefix(args, "--redact ")
+	format, path, _ := strings.Cut(strings.TrimSpace(args), " ")
+	path = strings.TrimSpace(path)
+	if format == "" || path == "" {
+		utils.Eprintln("red", "Usage: /export [--redact] <md|html> <path>")
+		return
+	}
+	err := exportConvo(*convo, format, path, redacted)
+	if err != nil {
+		utils.Eprintln("red", "Error exporting conversation: "+err.Error())
+		return
+	}
+	utils.Cprintln(commandColor, "Conversation exported to", path)
+}
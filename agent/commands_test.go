@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+func TestCommands(t *testing.T) {
+	tools := []anthropic.Tool{}
+	ctx := context.Background()
+	convo := Conversation{
+		{Role: anthropic.User, Content: makeTextContent("Hi")},
+		{Role: anthropic.Assistant, Content: makeTextContent("Hello.")},
+	}
+
+	file := filepath.Join(t.TempDir(), "saved.json")
+	convo.handleCommand(ctx, "/save "+file, &tools)
+	convo.handleCommand(ctx, "/RESET", &tools)
+	if len(convo) != 0 {
+		t.Fatalf("/reset left %d messages", len(convo))
+	}
+	convo.handleCommand(ctx, "/load "+file, &tools)
+	if len(convo) != 2 || convo[1].Content[0].Text != "Hello." {
+		t.Fatalf("/load didn't bring back the saved conversation: %+v", convo)
+	}
+
+	convo.handleCommand(ctx, "/nope", &tools)
+	if len(convo) != 2 {
+		t.Errorf("an unknown command changed the conversation to %d messages", len(convo))
+	}
+
+	defer func(prompt string) { systemPrompt = prompt }(systemPrompt)
+	convo.handleCommand(ctx, "/system  Answer in French.", &tools)
+	if systemPrompt != "Answer in French." {
+		t.Errorf("/system set the prompt to %q", systemPrompt)
+	}
+}
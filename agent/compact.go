@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # COMPACTION
+// Replaces the oldest turns of a long conversation with a summary written by a cheap model
+//   - The most recent turns are always kept as they are
+//   - The summary goes at the start of the first kept user message, so roles still alternate
+//   - The model is shown the kept turns too, so it keeps tool results they still refer to
+//   - The summary is written by the provider of whoever owns the conversation, and its usage counts toward their totals
+const (
+	compactModel     = anthropic.Haiku
+	compactKeepTurns = 2
+	compactMaxTokens = 1024
+	compactPrompt    = `You compact the history of a conversation between a user and an AI assistant that uses tools.
+Summarize the earlier part of the conversation you are given, so the assistant can carry on without it.
+- Keep the user's goals, decisions, constraints and any setup context they gave.
+- Keep verbatim any tool results, identifiers, codes, values and file contents that the later messages refer to or may still need.
+- Drop pleasantries and anything superseded.
+Reply with the summary only.`
+	summaryPrefix = "Summary of the earlier conversation, which was compacted:\n\n"
+)
+
+// compaction is who a conversation is compacted for: the provider writing the summary, nil for the installed one,
+// what the summary's usage is added to, and how the user hears of it, only logged if report is nil
+type compaction struct {
+	provider anthropic.Provider
+	addUsage func(usage anthropic.Usage, model anthropic.Model)
+	report   func(color, msg string)
+}
+
+// cliCompaction compacts for the CLI, counting toward its session totals and telling the user at the prompt
+var cliCompaction = compaction{addUsage: addUsage, report: func(color, msg string) { utils.Eprintln(color, msg) }}
+
+func (c compaction) info(msg string) {
+	if c.report != nil {
+		c.report("yellow", msg)
+		return
+	}
+	slog.Info(msg)
+}
+
+func (c compaction) warn(msg string) {
+	if c.report != nil {
+		c.report("yellow", "Warning: "+msg)
+		return
+	}
+	slog.Warn(msg)
+}
+
+// compactThreshold is the estimated size in tokens at which a conversation is compacted before the next message, 0 disables it
+var compactThreshold int
+
+func SetCompactThreshold(tokens int) {
+	compactThreshold = tokens
+}
+
+// estimateTokens roughly sizes a conversation, at about 4 characters per token
+func estimateTokens(convo Conversation) int {
+	chars := 0
+	for _, msg := range convo {
+		for _, cont := range msg.Content {
+			chars += len(cont.Text) + len(cont.Content)
+			if cont.Input != nil {
+				input, _ := json.Marshal(cont.Input)
+				chars += len(input)
+			}
+			if cont.Type == anthropic.Image {
+				chars += 1600 * 4 // images cost about 1600 tokens whatever their encoded size
+			}
+			if cont.Type == anthropic.Document && cont.Source != nil {
+				chars += len(cont.Source.Data)
+			}
+		}
+	}
+	return chars / 4
+}
+
+// maybeCompact compacts the conversation if it has grown past the threshold
+func (convo *Conversation) maybeCompact(ctx context.Context, c compaction) {
+	if compactThreshold <= 0 {
+		return
+	}
+	before := estimateTokens(*convo)
+	if before < compactThreshold {
+		return
+	}
+	n, err := convo.compact(ctx, c)
+	if err != nil {
+		c.warn("could not compact the conversation: " + err.Error())
+		return
+	}
+	if n > 0 {
+		c.info(fmt.Sprintf("Compacted %d messages into a summary (about %d tokens, now %d)", n, before, estimateTokens(*convo)))
+	}
+}
+
+// compactSplit is the index of the first message to keep, the start of the last compactKeepTurns turns, or 0 if there's nothing to compact
+func (convo Conversation) compactSplit() int {
+	var turnStarts []int
+	for i, msg := range convo {
+		if msg.Role == anthropic.User && !hasToolResult(msg) {
+			turnStarts = append(turnStarts, i)
+		}
+	}
+	if len(turnStarts) <= compactKeepTurns {
+		return 0
+	}
+	return turnStarts[len(turnStarts)-compactKeepTurns]
+}
+
+func hasToolResult(msg anthropic.Message) bool {
+	for _, cont := range msg.Content {
+		if cont.Type == anthropic.ToolResult {
+			return true
+		}
+	}
+	return false
+}
+
+// compact summarizes everything before the last few turns, returning how many messages were replaced
+func (convo *Conversation) compact(ctx context.Context, c compaction) (int, error) {
+	split := convo.compactSplit()
+	if split == 0 {
+		return 0, nil
+	}
+	old, kept := (*convo)[:split], (*convo)[split:]
+
+	prompt := "<earlier_conversation>\n" + renderTranscript(old) + "</earlier_conversation>\n\n" +
+		"<later_messages>\n" + renderTranscript(kept) + "</later_messages>\n\n" +
+		"Summarize the earlier conversation."
+	req := &anthropic.Request{
+		Model:     compactModel,
+		MaxTokens: compactMaxTokens,
+		System:    compactPrompt,
+		Messages:  []anthropic.Message{{Role: anthropic.User, Content: makeTextContent(prompt)}},
+		Provider:  c.provider,
+	}
+	resp, err := req.Post(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if c.addUsage != nil {
+		c.addUsage(resp.Usage, resp.Model)
+	}
+	logUsage(ctx, resp.Model, resp.Usage, nil)
+	var summary []string
+	for _, cont := range resp.Content {
+		if cont.Type == anthropic.Text {
+			summary = append(summary, cont.Text)
+		}
+	}
+	if len(summary) == 0 {
+		return 0, fmt.Errorf("the summary was empty")
+	}
+
+	first := kept[0]
+	first.Content = append([]anthropic.Content{{Type: anthropic.Text, Text: summaryPrefix + strings.Join(summary, "\n")}}, first.Content...)
+	*convo = append(Conversation{first}, kept[1:]...)
+	return split, nil
+}
+
+// renderTranscript writes messages as plain text for the summarizer
+func renderTranscript(convo Conversation) string {
+	var b strings.Builder
+	for _, msg := range convo {
+		for _, cont := range msg.Content {
+			switch cont.Type {
+			case anthropic.Text, anthropic.MessageResp:
+				fmt.Fprintf(&b, "%s: %s\n", msg.Role, cont.Text)
+			case anthropic.ToolUse:
+				input, _ := json.Marshal(cont.Input)
+				fmt.Fprintf(&b, "%s called tool %s (id %s) with %s\n", msg.Role, cont.Name, cont.Id, input)
+			case anthropic.ToolResult:
+				fmt.Fprintf(&b, "tool result for %s: %s\n", cont.ToolUseId, cont.Content)
+			case anthropic.Image:
+				fmt.Fprintf(&b, "%s: [image]\n", msg.Role)
+			case anthropic.Document:
+				fmt.Fprintf(&b, "%s: [document %s]\n", msg.Role, cont.Title)
+			}
+		}
+	}
+	return b.String()
+}
+
+func cmdCompact(ctx context.Context, convo *Conversation, _ string, _ *[]anthropic.Tool) {
+	before := estimateTokens(*convo)
+	n, err := convo.compact(ctx, cliCompaction)
+	if err != nil {
+		utils.Eprintln("red", "Error compacting conversation: "+err.Error())
+		return
+	}
+	if n == 0 {
+		utils.Cprintln(commandColor, "Nothing to compact, the conversation is only", len(*convo), "messages.")
+		return
+	}
+	utils.Cprintf(commandColor, "Compacted %d messages into a summary (about %d tokens, now %d)\n", n, before, estimateTokens(*convo))
+}
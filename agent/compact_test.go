@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+func TestAgentCompactsWithItsOwnProvider(t *testing.T) {
+	installed := anthropictest.NewServer()
+	defer installed.Close()
+	anthropic.SetClient(installed.Client())
+	SetCompactThreshold(1)
+	defer SetCompactThreshold(0)
+	before, beforeCost := sessionTotals()
+
+	own := anthropictest.NewServer(
+		anthropictest.Reply{Text: "one"},
+		anthropictest.Reply{Text: "two"},
+		anthropictest.Reply{Text: "three"},
+		anthropictest.Reply{Text: "The user counted to three.", Usage: &anthropic.Usage{InputTokens: 1000, OutputTokens: 10}},
+		anthropictest.Reply{Text: "four"},
+	)
+	defer own.Close()
+	a, err := New(WithProvider(own.Client()), WithModel("haiku"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs anthropic.Usage
+	for _, input := range []string{"count", "again", "again", "again"} {
+		result, err := a.Run(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs.Add(result.Usage)
+	}
+
+	if len(installed.Requests()) != 0 {
+		t.Errorf("the installed provider got %d requests", len(installed.Requests()))
+	}
+	if requests := own.Requests(); len(requests) != 5 || requests[3].System != compactPrompt {
+		t.Fatalf("the agent's provider got %d requests, want the summary as the fourth", len(requests))
+	}
+	if usage, _ := a.Usage(); usage.InputTokens != runs.InputTokens+1000 {
+		t.Errorf("the agent used %d input tokens, want %d from its runs and 1000 from the summary", usage.InputTokens, runs.InputTokens)
+	}
+	if after, afterCost := sessionTotals(); after != before || afterCost != beforeCost {
+		t.Errorf("the CLI's session totals went from %+v to %+v", before, after)
+	}
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # COMPARE
+// Running one scenario with two variants, e.g. two models or two system prompts, and reporting them side by side
+//   - The report is Markdown: totals first, then for each turn the replies, tool calls, tokens, cost and latency of both
+//   - The variants run one after the other, each in a conversation of its own, so their tool calls don't interleave
+
+// Compare runs the scenario with each variant in turn
+func Compare(ctx context.Context, s *Scenario, variants []Variant, tools []anthropic.Tool) ([]*ScenarioRun, error) {
+	var runs []*ScenarioRun
+	for _, v := range variants {
+		run, err := RunScenario(ctx, s, v, tools)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %v", v.Name, err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// ComparisonReport renders the runs of a scenario as a Markdown table per turn, one column per run
+func ComparisonReport(s *Scenario, runs []*ScenarioRun) string {
+	var b strings.Builder
+	name := s.Name
+	if name == "" {
+		name = "Scenario"
+	}
+	fmt.Fprintf(&b, "# %s\n\n", name)
+
+	header := func(first string) {
+		b.WriteString("| " + first + " |")
+		for _, run := range runs {
+			fmt.Fprintf(&b, " %s |", tableCell(fmt.Sprintf("%s (%s)", run.Variant.Name, run.Model)))
+		}
+		b.WriteString("\n|---|" + strings.Repeat("---|", len(runs)) + "\n")
+	}
+	row := func(label string, cell func(run *ScenarioRun) string) {
+		b.WriteString("| " + label + " |")
+		for _, run := range runs {
+			b.WriteString(" " + cell(run) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Totals\n\n")
+	header("")
+	row("Failed turns", func(run *ScenarioRun) string {
+		failed := 0
+		for _, turn := range run.Turns {
+			if turn.Error != "" {
+				failed++
+			}
+		}
+		return fmt.Sprintf("%d of %d", failed, len(run.Turns))
+	})
+	row("Tool calls", func(run *ScenarioRun) string {
+		calls := 0
+		for _, turn := range run.Turns {
+			if turn.Result != nil {
+				calls += len(turn.Result.ToolCalls)
+			}
+		}
+		return fmt.Sprint(calls)
+	})
+	row("Tokens", func(run *ScenarioRun) string { return usageCell(run.Usage()) })
+	row("Cost", func(run *ScenarioRun) string { return fmt.Sprintf("$%.4f", run.Usage().Cost(run.Model)) })
+	row("Latency", func(run *ScenarioRun) string { return run.Latency().Round(10 * time.Millisecond).String() })
+	row("Throughput", func(run *ScenarioRun) string {
+		var apiTime time.Duration
+		for _, turn := range run.Turns {
+			if turn.Result != nil {
+				apiTime += turn.Result.Stats.APITime
+			}
+		}
+		if apiTime == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.1f tokens/s", float64(run.Usage().OutputTokens)/apiTime.Seconds())
+	})
+
+	for i, turn := range s.Turns {
+		fmt.Fprintf(&b, "\n## Turn %d\n\n> %s\n\n", i+1, strings.ReplaceAll(turn.Message, "\n", "\n> "))
+		header("")
+		result := func(run *ScenarioRun) (ScenarioTurnResult, *TurnResult) {
+			if i >= len(run.Turns) {
+				return ScenarioTurnResult{Error: "not run"}, nil
+			}
+			return run.Turns[i], run.Turns[i].Result
+		}
+		row("Reply", func(run *ScenarioRun) string {
+			outcome, res := result(run)
+			if outcome.Error != "" {
+				return tableCell("**Error:** " + outcome.Error)
+			}
+			return tableCell(res.Text)
+		})
+		row("Tool calls", func(run *ScenarioRun) string {
+			_, res := result(run)
+			if res == nil || len(res.ToolCalls) == 0 {
+				return "none"
+			}
+			calls := make([]string, len(res.ToolCalls))
+			for j, call := range res.ToolCalls {
+				calls[j] = fmt.Sprintf("`%s %s`", call.Name, formatToolInput(call.Input))
+				if call.IsError {
+					calls[j] += " (failed)"
+				}
+			}
+			return tableCell(strings.Join(calls, "\n"))
+		})
+		row("Tokens", func(run *ScenarioRun) string {
+			_, res := result(run)
+			if res == nil {
+				return ""
+			}
+			return usageCell(res.Usage)
+		})
+		row("Cost", func(run *ScenarioRun) string {
+			_, res := result(run)
+			if res == nil {
+				return ""
+			}
+			return fmt.Sprintf("$%.4f", res.Usage.Cost(res.Model))
+		})
+		row("Latency", func(run *ScenarioRun) string {
+			outcome, _ := result(run)
+			return outcome.Latency.Round(10 * time.Millisecond).String()
+		})
+	}
+	return b.String()
+}
+
+func usageCell(u anthropic.Usage) string {
+	return fmt.Sprintf("%d in, %d out", u.InputTokens+u.CacheCreationInputTokens+u.CacheReadInputTokens, u.OutputTokens)
+}
+
+// tableCell fits text in a Markdown table cell, which can't hold pipes or line breaks
+func tableCell(text string) string {
+	text = strings.ReplaceAll(strings.TrimSpace(text), "|", `\|`)
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
@@ -0,0 +1,527 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # CONVERSATION
+// Functions and logic for managing the flow of conversation with Claude
+const SYS_PROMPT = `
+	You are Super Claude, an AI assistant designed to help employees and developers work with Super-Sod's backend microservices. 
+	We will start off by working with the 'go-postal' REST API. Use the tools provided to fulfil user requests.
+	Do your best to infer user intent and take actions on their behalf.
+	
+	Here are some of your current directives:
+	- If you need to take multiple actions, make one tool_use request, wait for the tool_results, then take the next.
+	- Use common sense. Users may not be used to typing out length and precise prompts; Do your best to put together a sequence of actions to meet their needs.
+	- Ask for clarification when needed.
+	- If the user asks you to modify a resource, and you do so successfully, ask the user if they would like you to get the resource to confirm the modification.
+	- Give brief responses - we are in dev mode and many conversations are for testing purposes.
+`
+
+const (
+	claudeResponseColor = "vintage_white"
+	claudeThoughtsColor = "indigo"
+	thinkingColor       = "gray"
+	toolRequestColor    = "pastel_gray"
+	toolResponseColor   = "black"
+	userColor           = "vintage_lime"
+	claudeColor         = "pastel_pink"
+)
+
+var (
+	systemPrompt  = SYS_PROMPT
+	model         = anthropic.Opus
+	maxTokens     int // 0 for the model's maximum
+	promptCaching bool
+	toolChoice    *anthropic.ToolChoice
+	sampling      Sampling
+	autoContinue  bool
+	outputJSON    bool
+	plainOutput   bool
+	sessionUsage  anthropic.Usage
+
+	// thinkingBudget turns on extended thinking with this many tokens, showThinking prints the reasoning
+	thinkingBudget int
+	showThinking   bool
+
+	// pendingAttachments are sent ahead of the next user message
+	pendingAttachments []anthropic.Content
+)
+
+const defaultConvoFile = "conversation.json"
+
+// Replies cut off by max_tokens are continued at most maxContinuations times per turn
+const (
+	maxContinuations = 3
+	continuePrompt   = "Your last reply was cut off. Continue exactly where you left off, without repeating anything."
+)
+
+type Conversation []anthropic.Message
+
+// SetSystemPrompt replaces the system prompt sent with each request, SYS_PROMPT is used if empty
+func SetSystemPrompt(prompt string) {
+	if strings.TrimSpace(prompt) == "" {
+		prompt = SYS_PROMPT
+	}
+	systemPrompt = prompt
+}
+
+// SetModel sets the model used for requests, a model id or opus, sonnet or haiku. Empty keeps the default.
+func SetModel(name string) {
+	if name != "" {
+		model = anthropic.ParseModel(name)
+	}
+}
+
+// SetMaxTokens sets the max_tokens of each request, values below 1 use the most the model can write
+func SetMaxTokens(n int) {
+	maxTokens = max(n, 0)
+}
+
+// SetPromptCaching caches the system prompt and tool definitions between requests
+func SetPromptCaching(enabled bool) {
+	promptCaching = enabled
+}
+
+// SetToolChoice forces tool use: "auto", "any", or the name of a tool
+func SetToolChoice(choice string) {
+	toolChoice = anthropic.ParseToolChoice(choice)
+}
+
+// SetAutoContinue continues replies cut off by max_tokens instead of only warning
+func SetAutoContinue(enabled bool) {
+	autoContinue = enabled
+}
+
+// SetPlainOutput prints replies as the raw text Claude wrote, without rendering their Markdown
+func SetPlainOutput(enabled bool) {
+	plainOutput = enabled
+}
+
+// SetThinking turns on extended thinking with a budget in tokens, 0 turns it off
+func SetThinking(budgetTokens int) {
+	thinkingBudget = budgetTokens
+}
+
+// SetShowThinking prints Claude's extended thinking, dimmed, before its answer
+func SetShowThinking(enabled bool) {
+	showThinking = enabled
+}
+
+// Sampling holds the optional sampling parameters applied to every request
+type Sampling struct {
+	Temperature   *float64
+	TopP          *float64
+	TopK          *int
+	StopSequences []string
+}
+
+func SetSampling(s Sampling) {
+	sampling = s
+}
+
+// newRequest builds a request for the conversation with the current settings
+func newRequest(convo Conversation, t []anthropic.Tool) *anthropic.Request {
+	req := &anthropic.Request{
+		Model:         model,
+		Messages:      convo,
+		MaxTokens:     requestMaxTokens(model),
+		System:        systemPrompt,
+		Tools:         t,
+		ToolChoice:    toolChoice,
+		Temperature:   sampling.Temperature,
+		TopP:          sampling.TopP,
+		TopK:          sampling.TopK,
+		StopSequences: sampling.StopSequences,
+		PromptCaching: promptCaching,
+		Thinking:      anthropic.EnableThinking(thinkingBudget),
+	}
+	if len(t) == 0 {
+		req.ToolChoice = nil // the API rejects tool_choice without tools
+	}
+	return req
+}
+
+// requestMaxTokens is the max_tokens for a request to the model, the configured value or the model's maximum
+func requestMaxTokens(m anthropic.Model) int {
+	n := maxTokens
+	if n <= 0 {
+		n = m.MaxOutputTokens()
+	}
+	if thinkingBudget > 0 && n <= thinkingBudget {
+		n += thinkingBudget // max_tokens includes the thinking, leave room for the answer
+	}
+	return n
+}
+
+func (convo *Conversation) Converse(in LineReader, t *[]anthropic.Tool) {
+	// Write conversation to JSON file on exit, keeping the recovery file if that fails
+	save := func() {
+		saved := true
+		err := writeConvoToFile(*convo, defaultConvoFile)
+		if err != nil {
+			utils.Eprintln("red", "Error writing conversation to file: "+err.Error())
+			saved = false
+		}
+		if err := saveHistory(*convo); err != nil {
+			utils.Eprintln("red", "Error saving the session to the history: "+err.Error())
+			saved = false
+		}
+		if saved {
+			clearRecovery()
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			saveRecovery(*convo)
+			utils.Eprintln("red", "The REPL crashed, the session was saved for recovery and will be offered when the REPL starts next")
+			panic(r)
+		}
+	}()
+	// mu is held while a turn or command uses the conversation, so an exit on Ctrl+C never saves it half-written
+	var mu sync.Mutex
+	var interrupts interrupter
+	setConfirmInput(in)
+	convo.offerRecovery()
+	stop := interrupts.trapInterrupts(&mu, func() {
+		save()
+		in.Close()
+		CloseMCPServers()
+	})
+	defer stop()
+	stopRecovery := convo.guardRecovery(&mu, &interrupts)
+	defer stopRecovery()
+
+	for {
+		// Get user input (or quit)
+		userInput, ok := handleUserInput(in, &interrupts)
+		if !ok {
+			save()
+			break
+		}
+		if strings.TrimSpace(userInput) == "" {
+			continue
+		}
+
+		mu.Lock()
+		reloadTools(t)
+		ctx := interrupts.startTurn()
+		if strings.HasPrefix(userInput, "/") {
+			// Slash commands are handled locally and never sent to Claude
+			convo.handleCommand(ctx, userInput, t)
+		} else {
+			// Converse
+			convo.send(ctx, makeTextContent(expandFileMentions(userInput)), t)
+			maybeTitle(*convo)
+		}
+		interrupts.endTurn()
+		saveRecovery(*convo)
+		mu.Unlock()
+	}
+}
+
+// Ask sends a single message and returns Claude's final answer once it is done
+// calling tools. Nothing is printed, which makes it suitable for scripting.
+// Cancelling ctx abandons the turn, leaving the conversation as it was before.
+func (convo *Conversation) Ask(ctx context.Context, message string, t []anthropic.Tool) (*TurnResult, error) {
+	return convo.ask(ctx, makeTextContent(message), t, nil)
+}
+
+func (convo *Conversation) ask(ctx context.Context, content []anthropic.Content, t []anthropic.Tool, hooks *turnHooks) (*TurnResult, error) {
+	if msg := budgetExceeded(); msg != "" && sessionBudget.Stop {
+		return nil, fmt.Errorf("budget exceeded: %s", msg)
+	}
+	ctx, span := startTurnSpan(ctx)
+	defer span.End()
+
+	quiet := compaction{addUsage: addUsage} // logged rather than printed
+	convo.maybeCompact(ctx, quiet)
+	convo.appendMsg(anthropic.Message{Role: anthropic.User, Content: content})
+	req := newRequest(*convo, t)
+	err := convo.fitContext(ctx, req, quiet)
+	start := len(*convo) - 1 // the message is still last, even if older turns were compacted to fit
+	if err != nil {
+		convo.rollback(start)
+		return nil, err
+	}
+	result, err := convo.exchange(ctx, req, hooks)
+	addUsage(result.Usage, result.Model)
+	if err != nil || ctx.Err() != nil {
+		convo.rollback(start)
+	}
+	if msg := budgetExceeded(); msg != "" {
+		slog.Warn("budget exceeded", "detail", msg)
+	}
+	return result, err
+}
+
+// send adds a user message, along with any pending attachments, and talks to Claude
+func (convo *Conversation) send(ctx context.Context, content []anthropic.Content, t *[]anthropic.Tool) {
+	if budgetStops() {
+		return
+	}
+	content = append(pendingAttachments, content...)
+	pendingAttachments = nil
+	convo.sendMessage(ctx, content, t)
+}
+
+// budgetStops reports whether the budget is exceeded and set to stop further messages, saying so if it is
+func budgetStops() bool {
+	if msg := budgetExceeded(); msg != "" && sessionBudget.Stop {
+		utils.Eprintln("red", "Budget exceeded, not sending: "+msg)
+		return true
+	}
+	return false
+}
+
+// sendMessage adds a user message with exactly the content given and talks to Claude
+func (convo *Conversation) sendMessage(ctx context.Context, content []anthropic.Content, t *[]anthropic.Tool) {
+	if outputJSON {
+		result, err := convo.ask(ctx, content, *t, nil)
+		PrintTurnJSON(result, err)
+		if err == nil {
+			recordTurnStats(result.Model, result.Stats)
+		}
+		return
+	}
+	ctx, span := startTurnSpan(ctx)
+	defer span.End()
+	convo.maybeCompact(ctx, cliCompaction)
+	*convo = append(*convo, anthropic.Message{Role: anthropic.User, Content: content})
+	req := newRequest(*convo, *t)
+	err := convo.fitContext(ctx, req, cliCompaction)
+	start := len(*convo) - 1 // the message is still last, even if older turns were compacted to fit
+	if err != nil {
+		utils.Eprintln("red", "Not sending: "+err.Error())
+		convo.rollback(start)
+		return
+	}
+	result, err := convo.talk(ctx, req)
+	if err != nil || ctx.Err() != nil {
+		convo.rollback(start)
+	} else if result.Stats.Requests > 0 {
+		recordTurnStats(result.Model, result.Stats)
+	}
+}
+
+// rollback drops the messages of an abandoned turn, so the conversation never ends
+// on an unanswered user message or a tool_use without its result
+func (convo *Conversation) rollback(length int) {
+	*convo = (*convo)[:length]
+}
+
+// talk runs the turn, printing each reply as it arrives and the tool calls as they are made, and any error
+func (convo *Conversation) talk(ctx context.Context, req *anthropic.Request) (*TurnResult, error) {
+	requested := req.Model
+	continued := 0
+	hooks := &turnHooks{
+		reply: func(resp *anthropic.Response) {
+			if req.Model != requested {
+				utils.Eprintln("yellow", fmt.Sprintf("%s is unavailable, %s is answering instead.", requested, req.Model))
+				requested = req.Model
+			}
+			recordUsage(resp)
+			toolUse := false
+			for _, cont := range withCitations(resp.Content) {
+				switch cont.Type {
+				case anthropic.MessageResp, anthropic.Text:
+					thoughts, message := parseThoughts(cont.Text)
+					if thoughts != "" {
+						utils.Cprintln(claudeThoughtsColor, "\n*Thinking* ", thoughts, "\n")
+					}
+					if message != "" {
+						printReply(message)
+					}
+				case anthropic.Thinking, anthropic.RedactedThinking:
+					printThinking(cont)
+				case anthropic.ToolUse:
+					toolUse = true
+				}
+			}
+			// exchange continues the reply on the same terms as continueTruncated
+			if resp.StopReason == anthropic.MaxTokens && !toolUse && autoContinue && continued < maxContinuations {
+				continued++
+				utils.Eprintln("yellow", "Reply was cut off at max_tokens, continuing...")
+			}
+		},
+		tools: convo.useTools,
+	}
+
+	result, err := convo.exchange(ctx, req, hooks)
+	if err != nil {
+		if ctx.Err() == nil { // an interruption is already reported
+			utils.Eprintln("red", "Error making request: "+err.Error())
+			if hint := ErrorHint(err); hint != "" {
+				utils.Eprintln("yellow", hint)
+			}
+		}
+		return result, err
+	}
+	if result.Limit != "" {
+		utils.Eprintln("yellow", fmt.Sprintf("Stopped calling tools at %s, so the answer may be incomplete.", DescribeLimit(result.Limit)))
+	}
+	if result.StopReason == anthropic.MaxTokens {
+		if autoContinue {
+			utils.Eprintln("yellow", fmt.Sprintf("Warning: reply was still cut off at max_tokens after %d continuations", maxContinuations))
+		} else {
+			utils.Eprintln("yellow", "Warning: reply was cut off at max_tokens (use --auto-continue to continue automatically)")
+		}
+	}
+	return result, nil
+}
+
+// continueTruncated asks Claude to pick up a reply cut off by max_tokens, if
+// auto-continue is on and the turn hasn't already been continued too many times
+func (convo *Conversation) continueTruncated(continued int) bool {
+	if !autoContinue || continued >= maxContinuations {
+		return false
+	}
+	convo.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(continuePrompt)})
+	return true
+}
+
+func (convo *Conversation) appendMsg(m anthropic.Message) { // append Message to Conversation receiver
+	*convo = append(*convo, m)
+}
+
+// appendAssistant records a response's content blocks as a single assistant message, along with its usage
+func (convo *Conversation) appendAssistant(resp *anthropic.Response) {
+	if len(resp.Content) == 0 {
+		return
+	}
+	usage := resp.Usage
+	convo.appendMsg(anthropic.Message{Role: anthropic.Assistant, Content: resp.Content, Usage: &usage})
+}
+
+// printReply shows an answer from Claude, with its Markdown rendered unless output is plain
+func printReply(message string) {
+	utils.Eprintln(claudeColor, "Claude:")
+	if plainOutput {
+		utils.Cprintln(claudeResponseColor, message, "\n")
+		return
+	}
+	utils.CprintMarkdown(claudeResponseColor, message+"\n")
+}
+
+// formatToolInput shows a tool's input as the JSON Claude sent
+func formatToolInput(input map[string]any) string {
+	data, err := json.Marshal(input)
+	if err != nil || input == nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// printThinking shows an extended thinking block if showThinking is on
+func printThinking(cont anthropic.Content) {
+	if !showThinking {
+		return
+	}
+	if cont.Type == anthropic.RedactedThinking {
+		utils.Cprintln(thinkingColor, "*Thinking* [redacted]\n")
+		return
+	}
+	utils.Cprintln(thinkingColor, "*Thinking*\n"+strings.TrimSpace(cont.Thinking), "\n")
+}
+
+func makeTextContent(s string) []anthropic.Content {
+	content := make([]anthropic.Content, 1)
+	content[0] = anthropic.Content{Type: anthropic.Text, Text: s}
+	return content
+}
+
+// useTools runs a round of tool calls for the REPL, previewing them if that is on and printing each call and its result
+func (convo *Conversation) useTools(ctx context.Context, uses []anthropic.Content) []anthropic.Content {
+	for _, use := range uses {
+		utils.Eprintln(toolRequestColor, "Claude wants to use tool:", use.Name, formatToolInput(use.Input))
+	}
+	results := runPreviewed(ctx, uses, convo.previewToolUses(uses))
+	if ctx.Err() != nil {
+		return results
+	}
+	for i, use := range uses {
+		utils.Eprintln(toolResponseColor, "Used tool", use.Name, "and got response", results[i].Content)
+	}
+	return results
+}
+
+// appendToolResults answers every tool_use block of the last assistant message in one user message
+func (convo *Conversation) appendToolResults(uses []anthropic.Content, results []anthropic.Content) {
+	content := make([]anthropic.Content, len(uses))
+	for i, use := range uses {
+		content[i] = anthropic.Content{Type: anthropic.ToolResult, ToolUseId: use.Id, Content: results[i].Content, IsError: results[i].IsError}
+	}
+	convo.appendMsg(anthropic.Message{Role: anthropic.User, Content: content})
+}
+
+func parseThoughts(input string) (string, string) {
+	// Regular expression pattern to match the content between <thinking> tags
+	pattern := `(?s)<thinking>(.*?)</thinking>`
+
+	// Compile the regular expression
+	re := regexp.MustCompile(pattern)
+
+	// Find the match in the input string
+	match := re.FindStringSubmatch(input)
+
+	// Extract the thoughts and result
+	thoughts := ""
+	result := ""
+	if len(match) > 1 {
+		thoughts = strings.TrimSpace(match[1])
+		result = strings.TrimSpace(strings.Replace(input, match[0], "", 1))
+	}
+
+	if result == "" && thoughts == "" { // Fallback to original string
+		result = input
+	}
+
+	return thoughts, result
+}
+
+func writeConvoToFile(convo Conversation, filename string) error {
+	data, err := json.MarshalIndent(convo, "", "  ")
+	if err != nil {
+		return err
+	}
+	data, err = sealData(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		return err
+	}
+
+	utils.Eprintln("green", "Conversation written to", filename)
+	return nil
+}
+
+func readConvoFromFile(filename string) (Conversation, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err = openData(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filename, err)
+	}
+
+	var convo Conversation
+	err = json.Unmarshal(data, &convo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal conversation: %v", err)
+	}
+
+	return convo, nil
+}
@@ -0,0 +1,361 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// # DAEMON
+// A long-running process holding named conversations, which terminals attach to over a Unix socket
+//   - Each session has its own history, model and tools, and is saved to the store after every turn;
+//     a tool_use naming a tool outside the session's is refused before it runs
+//   - Turns in a session are serialized, so any number of terminals can attach to the same one
+//   - A turn's response carries the messages other terminals added since this one last looked
+//
+// The API, over HTTP on the socket:
+//   - GET    /sessions               list the sessions
+//   - PUT    /sessions/{name}        open a session, creating it with {"model": "...", "tools": [...]} if needed
+//   - GET    /sessions/{name}        fetch a session, with its messages from ?since=N on
+//   - POST   /sessions/{name}/turns  send {"message": "...", "since": N}
+//   - DELETE /sessions/{name}        delete a session, in the store too
+var sessionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type DaemonSession struct {
+	Name     string          `json:"name"`
+	Model    anthropic.Model `json:"model"`
+	Tools    []string        `json:"tools,omitempty"` // empty for all of the daemon's tools
+	Messages Conversation    `json:"messages"`
+	Usage    anthropic.Usage `json:"usage"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+
+	mu sync.Mutex
+}
+
+// SessionInfo describes a session without its messages
+type SessionInfo struct {
+	Name     string          `json:"name"`
+	Model    anthropic.Model `json:"model"`
+	Tools    []string        `json:"tools,omitempty"`
+	Length   int             `json:"length"` // number of messages
+	Usage    anthropic.Usage `json:"usage"`
+	Updated  time.Time       `json:"updated"`
+	Messages Conversation    `json:"messages,omitempty"`
+}
+
+type OpenSessionRequest struct {
+	Model string   `json:"model,omitempty"`
+	Tools []string `json:"tools,omitempty"`
+}
+
+type TurnRequest struct {
+	Message string `json:"message"`
+	Since   int    `json:"since"` // how many messages the terminal has already seen
+}
+
+type TurnResponse struct {
+	Missed []anthropic.Message `json:"missed"` // messages added by other terminals since Since
+	Result *TurnResult         `json:"result"`
+	Length int                 `json:"length"`
+}
+
+type Daemon struct {
+	Store Store // where sessions are saved, a FileStore of the sessions directory by default
+	Tools []anthropic.Tool
+
+	mu       sync.Mutex
+	sessions map[string]*DaemonSession
+}
+
+// NewDaemon loads the sessions saved in store
+func NewDaemon(store Store, tools []anthropic.Tool) (*Daemon, error) {
+	ctx := context.Background()
+	d := &Daemon{Store: store, Tools: tools, sessions: map[string]*DaemonSession{}}
+	names, err := store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+	for _, name := range names {
+		data, err := store.Get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = openData(data); err != nil {
+			return nil, fmt.Errorf("failed to load session '%s': %v", name, err)
+		}
+		var session DaemonSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, fmt.Errorf("failed to load session '%s': %v", name, err)
+		}
+		d.sessions[session.Name] = &session
+	}
+	slog.Info("loaded sessions", "sessions", len(d.sessions))
+	sessionsOpened("daemon", len(d.sessions))
+	return d, nil
+}
+
+// Serve answers requests on the Unix socket until ctx is done
+func (d *Daemon) Serve(ctx context.Context, socket string) error {
+	if err := claimSocket(socket); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", socket, err)
+	}
+	defer os.Remove(socket)
+	if err := os.Chmod(socket, 0o600); err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: d.Routes()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx) // lets running turns finish and be saved
+	}()
+	slog.Info("daemon listening", "socket", socket)
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// claimSocket removes a socket left behind by a daemon that is no longer running
+func claimSocket(socket string) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+	if _, err := os.Stat(socket); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("a daemon is already listening on %s", socket)
+	}
+	return os.Remove(socket)
+}
+
+func (d *Daemon) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", d.handleList)
+	mux.HandleFunc("PUT /sessions/{name}", d.handleOpen)
+	mux.HandleFunc("GET /sessions/{name}", d.handleGet)
+	mux.HandleFunc("POST /sessions/{name}/turns", d.handleTurn)
+	mux.HandleFunc("DELETE /sessions/{name}", d.handleDelete)
+	return mux
+}
+
+func (d *Daemon) handleList(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	sessions := make([]*DaemonSession, 0, len(d.sessions))
+	for _, s := range d.sessions {
+		sessions = append(sessions, s)
+	}
+	d.mu.Unlock()
+
+	infos := make([]SessionInfo, len(sessions))
+	for i, s := range sessions {
+		s.mu.Lock()
+		infos[i] = s.info(-1)
+		s.mu.Unlock()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Updated.After(infos[j].Updated) })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (d *Daemon) handleOpen(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !sessionNamePattern.MatchString(name) {
+		writeJSONError(w, http.StatusBadRequest, "session names may only contain letters, digits, '.', '_' and '-'")
+		return
+	}
+	var open OpenSessionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&open); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	}
+	if _, err := d.tools(open.Tools); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	session, ok := d.sessions[name]
+	if !ok {
+		now := time.Now()
+		session = &DaemonSession{Name: name, Model: model, Tools: open.Tools, Messages: Conversation{}, Created: now, Updated: now}
+		if open.Model != "" {
+			session.Model = anthropic.ParseModel(open.Model)
+		}
+		d.sessions[name] = session
+		sessionsOpened("daemon", 1)
+	}
+	d.mu.Unlock()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !ok {
+		if err := d.save(r.Context(), session); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, session.info(-1))
+		return
+	}
+	writeJSON(w, http.StatusOK, session.info(-1))
+}
+
+func (d *Daemon) handleGet(w http.ResponseWriter, r *http.Request) {
+	session, ok := d.session(r.PathValue("name"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	writeJSON(w, http.StatusOK, session.info(since))
+}
+
+func (d *Daemon) handleTurn(w http.ResponseWriter, r *http.Request) {
+	session, ok := d.session(r.PathValue("name"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	var turn TurnRequest
+	if err := json.NewDecoder(r.Body).Decode(&turn); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(turn.Message) == "" {
+		writeJSONError(w, http.StatusBadRequest, "message must not be empty")
+		return
+	}
+
+	// Turns within a session are serialized, sessions run independently
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	tools, err := d.tools(session.Tools)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var missed []anthropic.Message
+	if turn.Since >= 0 && turn.Since < len(session.Messages) {
+		missed = append(missed, session.Messages[turn.Since:]...)
+	}
+
+	start := len(session.Messages)
+	session.Messages.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(turn.Message)})
+	req := newRequest(nil, tools)
+	req.Model, req.MaxTokens = session.Model, requestMaxTokens(session.Model)
+	ctx, span := startTurnSpan(r.Context(), attribute.String("session.name", session.Name))
+	defer span.End()
+	result, err := session.Messages.exchange(withToolSet(withAuditSession(ctx, "daemon:"+session.Name, ""), tools), req, nil)
+	session.Usage.Add(result.Usage)
+	if err != nil || ctx.Err() != nil {
+		// Failed, or the terminal went away mid-turn: drop the unfinished turn so the history stays valid
+		session.Messages = session.Messages[:start]
+	}
+	session.Updated = time.Now()
+	if saveErr := d.save(context.WithoutCancel(ctx), session); saveErr != nil {
+		slog.Error("could not save session", "session", session.Name, "error", saveErr)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "error making request: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, TurnResponse{Missed: missed, Result: result, Length: len(session.Messages)})
+}
+
+func (d *Daemon) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	d.mu.Lock()
+	session, ok := d.sessions[name]
+	delete(d.sessions, name)
+	d.mu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	sessionsOpened("daemon", -1)
+
+	session.mu.Lock() // wait for a running turn
+	defer session.mu.Unlock()
+	if err := d.Store.Delete(r.Context(), name); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *Daemon) session(name string) (*DaemonSession, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	session, ok := d.sessions[name]
+	return session, ok
+}
+
+// tools picks the named tools out of the daemon's, or all of them if names is empty
+func (d *Daemon) tools(names []string) ([]anthropic.Tool, error) {
+	if len(names) == 0 {
+		return d.Tools, nil
+	}
+	byName := map[string]anthropic.Tool{}
+	for _, t := range d.Tools {
+		byName[t.Name] = t
+	}
+	tools := make([]anthropic.Tool, 0, len(names))
+	for _, name := range names {
+		t, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool '%s'", name)
+		}
+		tools = append(tools, t)
+	}
+	return tools, nil
+}
+
+// save writes the session to the store. The caller holds the session's lock.
+func (d *Daemon) save(ctx context.Context, session *DaemonSession) error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = sealData(data); err != nil {
+		return err
+	}
+	return d.Store.Put(ctx, session.Name, data)
+}
+
+// info describes the session, with its messages from since on, or none if since is negative
+func (s *DaemonSession) info(since int) SessionInfo {
+	info := SessionInfo{Name: s.Name, Model: s.Model, Tools: s.Tools, Length: len(s.Messages), Usage: s.Usage, Updated: s.Updated}
+	if since >= 0 && since < len(s.Messages) {
+		info.Messages = s.Messages[since:]
+	}
+	return info
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+// countedTool registers a tool that counts its calls
+func countedTool(t *testing.T, name string) (anthropic.Tool, *atomic.Int32) {
+	t.Helper()
+	calls := &atomic.Int32{}
+	tool := anthropic.Tool{Name: name, Description: "A test tool", InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{}}}
+	registerTool(tool, func(context.Context, map[string]any) anthropic.Content {
+		calls.Add(1)
+		return anthropic.Content{Type: anthropic.ToolResult, Content: "done"}
+	})
+	t.Cleanup(func() { unregisterTool(name) })
+	return tool, calls
+}
+
+func TestDaemonRefusesToolsOutsideTheSession(t *testing.T) {
+	offered, offeredCalls := countedTool(t, "daemon_offered")
+	other, otherCalls := countedTool(t, "daemon_other")
+	_, unlistedCalls := countedTool(t, "daemon_unlisted") // registered, but not one of the daemon's tools
+	server := anthropictest.NewServer(
+		anthropictest.Reply{ToolUses: []anthropictest.ToolUse{
+			{Name: "daemon_offered", Input: map[string]any{}},
+			{Name: "daemon_other", Input: map[string]any{}},
+			{Name: "daemon_unlisted", Input: map[string]any{}},
+		}},
+		anthropictest.Reply{Text: "Only one of them ran."},
+	)
+	defer server.Close()
+	anthropic.SetClient(server.Client())
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDaemon(store, []anthropic.Tool{offered, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := d.Routes()
+	if rec := call(t, routes, "PUT", "/sessions/limited", "", `{"model": "haiku", "tools": ["daemon_offered"]}`); rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("open: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(t, routes, "POST", "/sessions/limited/turns", "", `{"message": "run them all"}`); rec.Code != http.StatusOK {
+		t.Fatalf("turn: %d %s", rec.Code, rec.Body)
+	}
+
+	if offeredCalls.Load() != 1 {
+		t.Errorf("the session's tool ran %d times, want once", offeredCalls.Load())
+	}
+	if otherCalls.Load() != 0 || unlistedCalls.Load() != 0 {
+		t.Errorf("tools outside the session ran: %d and %d times", otherCalls.Load(), unlistedCalls.Load())
+	}
+	if requests := server.Requests(); len(requests) != 2 || len(requests[0].Tools) != 1 {
+		t.Fatalf("got %d requests, want the session's one tool offered", len(requests))
+	}
+	results := server.Requests()[1].Messages
+	refused := 0
+	for _, cont := range results[len(results)-1].Content {
+		if cont.IsError && strings.Contains(cont.Content, "is not one of this session's tools") {
+			refused++
+		}
+	}
+	if refused != 2 {
+		t.Errorf("%d calls were refused, want 2: %+v", refused, results[len(results)-1].Content)
+	}
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+func TestSingleStatement(t *testing.T) {
+	tests := []struct {
+		driver string
+		query  string
+		want   string // "" if the query must be refused
+	}{
+		{"postgres", "SELECT 1;", "SELECT 1"},
+		{"postgres", "SELECT 1; -- done", "SELECT 1"},
+		{"postgres", "SELECT 1 /* ; */ FROM t", "SELECT 1   FROM t"},
+		{"postgres", "SELECT ';' FROM t", "SELECT ';' FROM t"},
+		{"postgres", "SELECT 'it''s; fine'", "SELECT 'it''s; fine'"},
+		{"postgres", "SELECT $$;$$, $1", "SELECT $$;$$, $1"},
+		{"postgres", "SELECT $body$ ; $$ ; $body$", "SELECT $body$ ; $$ ; $body$"},
+		{"postgres", "SELECT a$b FROM t", "SELECT a$b FROM t"},
+		{"postgres", "SELECT 1; DELETE FROM t", ""},
+		{"postgres", "SELECT $$'$$; COMMIT; DELETE FROM t; SELECT $$'$$", ""},
+		{"postgres", "SELECT $x$'$x$; COMMIT; DELETE FROM t; SELECT $x$'$x$", ""},
+		{"postgres", `SELECT E'\''; COMMIT; DELETE FROM t; SELECT E'\''`, ""},
+		{"postgres", `SELECT e'\\'; DELETE FROM t`, ""},
+		{"postgres", `SELECT 'a\'; DELETE FROM t; SELECT '\'`, ""}, // standard strings have no backslash escapes
+		{"postgres", "SELECT `;`; DELETE FROM t; SELECT `;`", ""},
+		{"postgres", "SELECT $$; DELETE FROM t", ""},
+		{"mysql", `SELECT '\''; DELETE FROM t; SELECT '\''`, ""},
+		{"mysql", `SELECT "\""; DELETE FROM t; SELECT "\""`, ""},
+		{"mysql", "SELECT 1 # '\n; DELETE FROM t; '", ""},
+		{"mysql", "SELECT `a;b` FROM t", "SELECT `a;b` FROM t"},
+		{"mysql", "SELECT $$; DELETE FROM t; SELECT $$", ""},
+		{"postgres", "", ""},
+		{"postgres", "SELECT 'unterminated", ""},
+		{"postgres", "SELECT 1 /* unterminated", ""},
+	}
+	for _, tt := range tests {
+		got, err := singleStatement(tt.query, tt.driver)
+		if tt.want == "" && err == nil {
+			t.Errorf("%s: accepted `%s` as `%s`", tt.driver, tt.query, got)
+		}
+		if tt.want != "" && (err != nil || got != tt.want) {
+			t.Errorf("%s: singleStatement(`%s`) = `%s`, %v, want `%s`", tt.driver, tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestDatabaseRefusals(t *testing.T) {
+	// The statements are refused before the database is reached, so there is none
+	readOnly := &database{driver: "postgres", maxRows: defaultMaxRows}
+	writable := &database{driver: "postgres", write: true, maxRows: defaultMaxRows}
+	tests := []struct {
+		db    *database
+		query string
+	}{
+		{readOnly, "DELETE FROM t"},
+		{readOnly, "/* SELECT */ UPDATE t SET a = 1"},
+		{readOnly, "COMMIT"},
+		{writable, "COMMIT"},
+		{writable, "end"},
+		{writable, "ROLLBACK"},
+		{writable, "SET default_transaction_read_only = off"},
+		{writable, "SET SESSION CHARACTERISTICS AS TRANSACTION READ WRITE"},
+		{writable, "BEGIN"},
+		{writable, "RESET ALL"},
+	}
+	for _, tt := range tests {
+		result := tt.db.query(context.Background(), map[string]any{"query": tt.query})
+		if result.Type != anthropic.ToolResult || !result.IsError {
+			t.Errorf("write %v: ran `%s`: %+v", tt.db.write, tt.query, result)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package agent
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # DOCS INDEX
+// Local docs such as runbooks and API docs, chunked and embedded by `super-claude ingest` into a vector index on disk,
+// and the built-in search_docs tool, enabled with --docs, retrieving the chunks closest to a question
+//   - Markdown, text, reStructuredText, AsciiDoc and HTML files are read, HTML reduced to text as fetch_url does
+//   - Chunks are paragraphs packed up to docsChunkChars, never across a Markdown heading, and each keeps the headings it is under
+//   - Chunks are embedded with Voyage AI when VOYAGE_API_KEY is set, or else locally by hashing their words and word pairs,
+//     weighted by how rare they are in the index; the index remembers which, and questions are embedded the same way
+//   - Ingesting again re-embeds only the files that changed, and drops the chunks of files gone from the directories given
+//   - The index is loaded into memory when the agent starts, and searched by cosine similarity
+const (
+	docsChunkChars       = 1500
+	defaultDocsResults   = 5
+	maxDocsResults       = 20
+	localEmbeddingModel  = "local"
+	localEmbeddingDims   = 1024
+	defaultVoyageModel   = "voyage-3.5"
+	defaultVoyageBaseURL = "https://api.voyageai.com/v1"
+	voyageBatchSize      = 64
+	voyageTimeout        = 60 * time.Second
+)
+
+var docExtensions = []string{".md", ".markdown", ".txt", ".rst", ".adoc", ".html", ".htm"}
+
+// DocsConfig says where the index is and how to embed into it
+type DocsConfig struct {
+	Index         string
+	Model         string // the Voyage model to ingest with, or local; local unless VoyageAPIKey is set
+	VoyageAPIKey  string
+	VoyageBaseURL string
+}
+
+// DocsIndex is the index file
+type DocsIndex struct {
+	Model  string            `json:"model"`
+	Files  map[string]string `json:"files"` // the sha256 of each file ingested, by absolute path
+	IDF    []float32         `json:"idf,omitempty"`
+	Chunks []DocChunk        `json:"chunks"`
+}
+
+// DocChunk is a passage of a file with its embedding
+type DocChunk struct {
+	Source  string    `json:"source"`
+	Heading string    `json:"heading,omitempty"` // the headings it is under, e.g. Runbook > Restarting
+	Text    string    `json:"text"`
+	Vector  []float32 `json:"vector"`
+}
+
+// embedText is what is embedded for a chunk, its headings giving the text context
+func (c DocChunk) embedText() string {
+	if c.Heading == "" {
+		return c.Text
+	}
+	return c.Heading + "\n\n" + c.Text
+}
+
+// IngestDocs chunks and embeds the docs under paths into the index, re-embedding only files that changed
+func IngestDocs(ctx context.Context, paths []string, cfg DocsConfig) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no files or directories to ingest")
+	}
+	index, err := readDocsIndex(cfg.Index)
+	if errors.Is(err, os.ErrNotExist) {
+		index = &DocsIndex{Model: cfg.Model, Files: map[string]string{}}
+		if index.Model == "" {
+			index.Model = localEmbeddingModel
+			if cfg.VoyageAPIKey != "" {
+				index.Model = defaultVoyageModel
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+	if cfg.Model != "" && cfg.Model != index.Model {
+		return fmt.Errorf("the index at %s was embedded with %s, not %s; ingest into a new --docs-index to switch", cfg.Index, index.Model, cfg.Model)
+	}
+	embed, err := newEmbedder(index, cfg)
+	if err != nil {
+		return err
+	}
+
+	var roots []string
+	seen := map[string]bool{}
+	changed := map[string][]DocChunk{}
+	unchanged := 0
+	for _, path := range paths {
+		root, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+		err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if file != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if file != root && !slices.Contains(docExtensions, strings.ToLower(filepath.Ext(file))) {
+				return nil
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", file, err)
+			}
+			seen[file] = true
+			sum := sha256.Sum256(data)
+			hash := hex.EncodeToString(sum[:])
+			if index.Files[file] == hash {
+				unchanged++
+				return nil
+			}
+			index.Files[file] = hash
+			changed[file] = chunkDoc(file, docText(file, data))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// keep the chunks of files untouched by this run, then add those of the files that changed
+	removed := 0
+	chunks := index.Chunks[:0]
+	for _, chunk := range index.Chunks {
+		if _, ok := changed[chunk.Source]; ok || gone(chunk.Source, roots, seen) {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	for file := range index.Files {
+		if gone(file, roots, seen) {
+			delete(index.Files, file)
+			removed++
+		}
+	}
+	files := make([]string, 0, len(changed))
+	for file := range changed {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+	var added []DocChunk
+	for _, file := range files {
+		added = append(added, changed[file]...)
+	}
+	if index.Model == localEmbeddingModel {
+		index.Chunks = append(chunks, added...)
+		index.IDF = localIDF(index.Chunks)
+		for i := range index.Chunks {
+			index.Chunks[i].Vector = localEmbedding(index.Chunks[i].embedText(), index.IDF)
+		}
+	} else {
+		texts := make([]string, len(added))
+		for i, chunk := range added {
+			texts[i] = chunk.embedText()
+		}
+		vectors, err := embed(ctx, texts, false)
+		if err != nil {
+			return err
+		}
+		for i := range added {
+			added[i].Vector = vectors[i]
+		}
+		index.Chunks = append(chunks, added...)
+	}
+
+	if err := writeDocsIndex(cfg.Index, index); err != nil {
+		return err
+	}
+	utils.Cprintf(commandColor, "Ingested %d changed files as %d chunks, %d unchanged, %d removed; the index at %s has %d chunks by %s\n",
+		len(changed), len(added), unchanged, removed, cfg.Index, len(index.Chunks), index.Model)
+	return nil
+}
+
+// gone reports whether file is under one of the roots ingested but wasn't found there this time
+func gone(file string, roots []string, seen map[string]bool) bool {
+	if seen[file] {
+		return false
+	}
+	for _, root := range roots {
+		if file == root || strings.HasPrefix(file, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// docText is a file's text, HTML reduced to Markdown-like text
+func docText(file string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".html", ".htm":
+		return htmlToText(data, &url.URL{Scheme: "file", Path: file})
+	}
+	return string(data)
+}
+
+// chunkDoc packs a file's paragraphs into chunks, starting a new chunk at each Markdown heading
+func chunkDoc(file, text string) []DocChunk {
+	var chunks []DocChunk
+	var headings []string
+	var b strings.Builder
+	flush := func() {
+		if t := strings.TrimSpace(b.String()); t != "" {
+			chunks = append(chunks, DocChunk{Source: file, Heading: strings.Join(headings, " > "), Text: t})
+		}
+		b.Reset()
+	}
+	add := func(paragraph string) {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			return
+		}
+		if b.Len() > 0 && b.Len()+len(paragraph)+2 > docsChunkChars {
+			flush()
+		}
+		for len(paragraph) > docsChunkChars {
+			cut := runeStart(paragraph, docsChunkChars)
+			if space := strings.LastIndexFunc(paragraph[:cut], unicode.IsSpace); space > docsChunkChars/2 {
+				cut = space
+			}
+			b.WriteString(paragraph[:cut])
+			flush()
+			paragraph = strings.TrimSpace(paragraph[cut:])
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(paragraph)
+	}
+
+	var paragraph strings.Builder
+	fenced := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			fenced = !fenced
+		}
+		if !fenced {
+			if level, title, ok := docHeading(trimmed); ok {
+				add(paragraph.String())
+				paragraph.Reset()
+				flush()
+				headings = append(headings[:min(len(headings), level-1)], title)
+				continue
+			}
+			if trimmed == "" {
+				add(paragraph.String())
+				paragraph.Reset()
+				continue
+			}
+		}
+		paragraph.WriteString(line + "\n")
+	}
+	add(paragraph.String())
+	flush()
+	return chunks
+}
+
+// docHeading reads a Markdown heading line such as "## Restarting", giving its level and title
+func docHeading(line string) (int, string, bool) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return 0, "", false
+	}
+	return level, strings.TrimSpace(strings.Trim(line[level:], "# ")), true
+}
+
+func readDocsIndex(filename string) (*DocsIndex, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read the docs index: %v", err)
+	}
+	var index DocsIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse the docs index %s: %v", filename, err)
+	}
+	if index.Files == nil {
+		index.Files = map[string]string{}
+	}
+	return &index, nil
+}
+
+func writeDocsIndex(filename string, index *DocsIndex) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("failed to create the docs index directory: %v", err)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write the docs index: %v", err)
+	}
+	return os.Rename(tmp, filename)
+}
+
+// embedFunc embeds texts, as questions to search with if query is set, else as passages to be found
+type embedFunc func(ctx context.Context, texts []string, query bool) ([][]float32, error)
+
+// newEmbedder embeds the way the index was built
+func newEmbedder(index *DocsIndex, cfg DocsConfig) (embedFunc, error) {
+	if index.Model == localEmbeddingModel {
+		return func(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+			vectors := make([][]float32, len(texts))
+			for i, text := range texts {
+				vectors[i] = localEmbedding(text, index.IDF)
+			}
+			return vectors, nil
+		}, nil
+	}
+	if cfg.VoyageAPIKey == "" {
+		return nil, fmt.Errorf("the docs index is embedded with Voyage AI's %s, which needs VOYAGE_API_KEY", index.Model)
+	}
+	v := &voyageClient{apiKey: cfg.VoyageAPIKey, baseURL: cfg.VoyageBaseURL, model: index.Model, client: &http.Client{Timeout: voyageTimeout}}
+	if v.baseURL == "" {
+		v.baseURL = defaultVoyageBaseURL
+	}
+	return v.embed, nil
+}
+
+type voyageClient struct {
+	apiKey, baseURL, model string
+	client                 *http.Client
+}
+
+// embed calls Voyage AI's embeddings API in batches of voyageBatchSize
+func (v *voyageClient) embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	inputType := "document"
+	if query {
+		inputType = "query"
+	}
+	var vectors [][]float32
+	for start := 0; start < len(texts); start += voyageBatchSize {
+		batch := texts[start:min(start+voyageBatchSize, len(texts))]
+		body, err := json.Marshal(map[string]any{"input": batch, "model": v.model, "input_type": inputType})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(v.baseURL, "/")+"/embeddings", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+v.apiKey)
+		resp, err := v.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("Voyage AI embeddings request failed: %v", err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Voyage AI's response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Voyage AI embeddings failed with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+		}
+		var out struct {
+			Data []struct {
+				Embedding []float32 `json:"embedding"`
+				Index     int       `json:"index"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, fmt.Errorf("failed to decode Voyage AI's response: %v", err)
+		}
+		if len(out.Data) != len(batch) {
+			return nil, fmt.Errorf("Voyage AI returned %d embeddings for %d texts", len(out.Data), len(batch))
+		}
+		embeddings := make([][]float32, len(batch))
+		for _, d := range out.Data {
+			if d.Index < 0 || d.Index >= len(batch) {
+				return nil, fmt.Errorf("Voyage AI returned an embedding for text %d of %d", d.Index, len(batch))
+			}
+			embeddings[d.Index] = normalize(d.Embedding)
+		}
+		vectors = append(vectors, embeddings...)
+	}
+	return vectors, nil
+}
+
+// localFeatures are the hashed dimensions of a text's words and word pairs, with their counts
+func localFeatures(text string) map[int]float64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	features := map[int]float64{}
+	hash := func(s string) int {
+		h := fnv.New32a()
+		h.Write([]byte(s))
+		return int(h.Sum32() % localEmbeddingDims)
+	}
+	for i, word := range words {
+		features[hash(word)]++
+		if i > 0 {
+			features[hash(words[i-1]+" "+word)]++
+		}
+	}
+	return features
+}
+
+// localIDF weights each dimension by how few chunks have it
+func localIDF(chunks []DocChunk) []float32 {
+	counts := make([]int, localEmbeddingDims)
+	for _, chunk := range chunks {
+		for dim := range localFeatures(chunk.embedText()) {
+			counts[dim]++
+		}
+	}
+	idf := make([]float32, localEmbeddingDims)
+	for dim, n := range counts {
+		idf[dim] = float32(math.Log(float64(1+len(chunks)) / float64(1+n)))
+	}
+	return idf
+}
+
+// localEmbedding is a text's word and word pair counts, damped and weighted by idf, as a unit vector
+func localEmbedding(text string, idf []float32) []float32 {
+	vector := make([]float32, localEmbeddingDims)
+	for dim, count := range localFeatures(text) {
+		weight := float32(1)
+		if len(idf) == localEmbeddingDims {
+			weight = idf[dim]
+		}
+		vector[dim] = float32(1+math.Log(count)) * weight
+	}
+	return normalize(vector)
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+	return v
+}
+
+type docsSearcher struct {
+	index *DocsIndex
+	embed embedFunc
+}
+
+// LoadDocsTool loads the docs index into memory, registers the search_docs tool on it and returns its definition
+func LoadDocsTool(cfg DocsConfig) (anthropic.Tool, error) {
+	index, err := readDocsIndex(cfg.Index)
+	if errors.Is(err, os.ErrNotExist) {
+		return anthropic.Tool{}, fmt.Errorf("no docs index at %s, build one with `super-claude ingest <dir>`", cfg.Index)
+	}
+	if err != nil {
+		return anthropic.Tool{}, err
+	}
+	embed, err := newEmbedder(index, cfg)
+	if err != nil {
+		return anthropic.Tool{}, err
+	}
+	s := &docsSearcher{index: index, embed: embed}
+
+	tool := anthropic.Tool{
+		Name: "search_docs",
+		Description: "Search our documentation, such as runbooks and API docs for the microservices, for the passages most relevant to a question, " +
+			"returning each passage's text with its source file, headings and a similarity score as JSON. " +
+			"Use it before answering questions about our services or how to operate them, ground the answer in the passages and name their sources. " +
+			"Rephrase the query and search again if nothing relevant comes back.",
+		InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+			"query": map[string]any{"type": "string", "description": "What to find, as a question or the words a passage would use, e.g. how to restart go-postal"},
+			"k":     map[string]any{"type": "integer", "description": fmt.Sprintf("Number of passages to return, %d unless given, at most %d", defaultDocsResults, maxDocsResults)},
+		}, Required: []string{"query"}},
+	}
+	registerTool(tool, s.search)
+	return tool, nil
+}
+
+func (s *docsSearcher) search(ctx context.Context, params map[string]any) anthropic.Content {
+	query, _ := params["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return toolError("query must not be empty")
+	}
+	k := defaultDocsResults
+	if v, ok := params["k"].(float64); ok && v > 0 {
+		k = min(int(v), maxDocsResults)
+	}
+	vectors, err := s.embed(ctx, []string{query}, true)
+	if err != nil {
+		return toolError(err.Error())
+	}
+
+	type result struct {
+		Source  string  `json:"source"`
+		Heading string  `json:"heading,omitempty"`
+		Score   float64 `json:"score"`
+		Text    string  `json:"text"`
+	}
+	results := make([]result, 0, len(s.index.Chunks))
+	for _, chunk := range s.index.Chunks {
+		if len(chunk.Vector) != len(vectors[0]) {
+			continue
+		}
+		var dot float64
+		for i, x := range chunk.Vector {
+			dot += float64(x) * float64(vectors[0][i])
+		}
+		if dot > 0 {
+			results = append(results, result{Source: chunk.Source, Heading: chunk.Heading, Score: math.Round(dot*1000) / 1000, Text: chunk.Text})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b result) int { return cmp.Compare(b.Score, a.Score) })
+
+	// encoded without escaping, so headings keep their >
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]any{"results": results[:min(k, len(results))]}); err != nil {
+		return toolError(err.Error())
+	}
+	data := bytes.TrimSpace(out.Bytes())
+	return anthropic.Content{Type: anthropic.ToolResult, Content: string(data)}
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # DOCUMENTS
+// Attaching PDFs and text files such as runbooks with /attach, and showing the passages Claude cites from them
+//   - A cited reply arrives as many text blocks, which are shown as one, each cited passage marked with a
+//     numbered reference to the sources listed after it
+//   - The conversation keeps the blocks as they came, so the citations go back to the API unchanged
+func cmdAttach(ctx context.Context, convo *Conversation, args string, t *[]anthropic.Tool) {
+	path, message, _ := strings.Cut(args, " ")
+	if path == "" {
+		utils.Eprintln("red", "Usage: /attach <file.pdf|file.txt> [message]")
+		return
+	}
+	document, err := anthropic.NewDocumentContentFromFile(path)
+	if err != nil {
+		utils.Eprintln("red", "Error attaching document: "+err.Error())
+		return
+	}
+	pendingAttachments = append(pendingAttachments, document)
+
+	message = strings.TrimSpace(message)
+	if message == "" {
+		utils.Cprintln(commandColor, "Attached", path, "to your next message.")
+		return
+	}
+	convo.send(ctx, makeTextContent(message), t)
+}
+
+// withCitations merges the text blocks of a cited reply into one, in place of the first,
+// with the citations rendered as references; a reply without citations is returned as it is
+func withCitations(content []anthropic.Content) []anthropic.Content {
+	cited := slices.ContainsFunc(content, func(cont anthropic.Content) bool {
+		return cont.Citations != nil && len(cont.Citations.List) > 0
+	})
+	if !cited {
+		return content
+	}
+
+	var text, sources strings.Builder
+	refs := map[string]int{}
+	for _, cont := range content {
+		if cont.Type != anthropic.Text {
+			continue
+		}
+		text.WriteString(cont.Text)
+		if cont.Citations == nil {
+			continue
+		}
+		for _, citation := range cont.Citations.List {
+			quote := strings.Join(strings.Fields(citation.CitedText), " ")
+			key := fmt.Sprintf("%d %s", citation.DocumentIndex, quote)
+			n, ok := refs[key]
+			if !ok {
+				n = len(refs) + 1
+				refs[key] = n
+				fmt.Fprintf(&sources, "%d. %s: \"%s\"\n", n, citationSource(citation), quote)
+			}
+			fmt.Fprintf(&text, "[%d]", n)
+		}
+	}
+	text.WriteString("\n\nSources:\n" + sources.String())
+
+	merged := make([]anthropic.Content, 0, len(content))
+	done := false
+	for _, cont := range content {
+		if cont.Type != anthropic.Text {
+			merged = append(merged, cont)
+		} else if !done {
+			merged = append(merged, anthropic.Content{Type: anthropic.Text, Text: text.String()})
+			done = true
+		}
+	}
+	return merged
+}
+
+// citationSource names the document a citation is from, with the pages for a PDF
+func citationSource(c anthropic.Citation) string {
+	source := c.DocumentTitle
+	if source == "" {
+		source = fmt.Sprintf("document %d", c.DocumentIndex+1)
+	}
+	if c.StartPageNumber == nil || c.EndPageNumber == nil {
+		return source
+	}
+	// end_page_number is exclusive
+	if last := *c.EndPageNumber - 1; last > *c.StartPageNumber {
+		return fmt.Sprintf("%s, pp. %d-%d", source, *c.StartPageNumber, last)
+	}
+	return fmt.Sprintf("%s, p. %d", source, *c.StartPageNumber)
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// # ENCRYPTION AT REST
+// Sealing saved conversations and sessions with AES-256-GCM, since they hold whatever the tools looked up, e.g. customers' addresses
+//   - Applies to conversation.json and /save, the tool cache directory, and the sessions of the REST API and daemon in any store
+//   - The key is 32 bytes in base64, from storage_key, STORAGE_KEY or the system keyring (`super-claude auth storage-key`)
+//   - Sealed data starts with sealMagic, so files saved before a key was set still load, and are sealed the next time they're saved
+//   - Without the key, sealed data doesn't load at all rather than loading as garbage
+var sealMagic = []byte("super-claude:aes-256-gcm:v1\n")
+
+var storageCipher cipher.AEAD
+
+// SetStorageKey seals saved conversations and sessions with key, 32 bytes in base64
+func SetStorageKey(key string) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return fmt.Errorf("storage key is not base64: %v", err)
+	}
+	if len(raw) != 32 {
+		return fmt.Errorf("storage key is %d bytes, it must be 32", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return err
+	}
+	storageCipher, err = cipher.NewGCM(block)
+	return err
+}
+
+// NewStorageKey makes a random key for SetStorageKey
+func NewStorageKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate a storage key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// sealData encrypts data for saving, or returns it as it is without a storage key
+func sealData(data []byte) ([]byte, error) {
+	if storageCipher == nil {
+		return data, nil
+	}
+	nonce := make([]byte, storageCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to encrypt: %v", err)
+	}
+	out := append(append([]byte{}, sealMagic...), nonce...)
+	return storageCipher.Seal(out, nonce, data, sealMagic), nil
+}
+
+// openData decrypts data sealed with sealData, and returns data that was saved unsealed as it is
+func openData(data []byte) ([]byte, error) {
+	sealed, ok := bytes.CutPrefix(data, sealMagic)
+	if !ok {
+		return data, nil
+	}
+	if storageCipher == nil {
+		return nil, fmt.Errorf("it is encrypted, set storage_key or STORAGE_KEY, or run `super-claude auth storage-key` on the machine that saved it")
+	}
+	if len(sealed) < storageCipher.NonceSize() {
+		return nil, fmt.Errorf("it is encrypted but cut off")
+	}
+	nonce, ciphertext := sealed[:storageCipher.NonceSize()], sealed[storageCipher.NonceSize():]
+	plain, err := storageCipher.Open(nil, nonce, ciphertext, sealMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt, the storage key is not the one it was saved with")
+	}
+	return plain, nil
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # ERROR HINTS
+// What the CLI user can do about an API error, shown under it, e.g. which setting to check
+//   - Only errors the user can act on get a hint, others are shown as they are
+
+// ErrorHint suggests how to fix the cause of err, or returns "" if there's nothing to suggest
+func ErrorHint(err error) string {
+	var (
+		auth       *anthropic.AuthenticationError
+		permission *anthropic.PermissionError
+		notFound   *anthropic.NotFoundError
+		invalid    *anthropic.InvalidRequestError
+		rateLimit  *anthropic.RateLimitError
+		overloaded *anthropic.OverloadedError
+		apiErr     *anthropic.APIError
+	)
+	switch {
+	case errors.As(err, &auth):
+		return "Check ANTHROPIC_API_KEY, or store a key with `super-claude auth login`."
+	case errors.As(err, &permission):
+		return "The API key isn't allowed to do this; check the model and features its workspace may use."
+	case errors.As(err, &notFound):
+		return "Check the model name, e.g. --model sonnet, and that the API key's organization has access to it."
+	case errors.As(err, &invalid):
+		msg := strings.ToLower(invalid.Message)
+		switch {
+		case strings.Contains(msg, "max_tokens"):
+			return "Reduce max_tokens with --max-tokens or MAX_TOKENS, or pick a model that can write more."
+		case strings.Contains(msg, "prompt is too long"):
+			return "The conversation is too long for the model; shrink it with /compact or start over with /reset."
+		case strings.Contains(msg, "credit balance"):
+			return "Add credits or a payment method in the Anthropic Console."
+		}
+	case errors.As(err, &rateLimit):
+		return "Wait a little, lower --rpm or --tpm to stay under the limits, or answer with other models via --fallback."
+	case errors.As(err, &overloaded):
+		return "The API is busy; try again shortly, or answer with other models via --fallback sonnet,haiku."
+	case errors.As(err, &apiErr) && apiErr.Type == "request_too_large":
+		return "Send less at once, e.g. smaller attachments or tool results (--tool-result-limit)."
+	}
+	return ""
+}
@@ -0,0 +1,231 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+// # EVAL
+// Checking that a scenario's turns do what its expectations say, as integration tests of the agent's tool use
+//   - tools are the calls a turn must make, in order, with other calls allowed in between unless only_tools is set
+//   - A tool's input is matched key by key: a plain value must be equal, {contains: ...} and {matches: regexp} test strings
+//   - contains and not_contains are substrings of the final answer, ignoring case
+//   - A scenario with replies runs against the fake Messages API, so it needs no credentials and gives the same result every time
+type Expectation struct {
+	Tools       []ToolExpectation `yaml:"tools"`
+	OnlyTools   bool              `yaml:"only_tools"` // no calls but the expected ones, so an empty tools means none at all
+	Contains    []string          `yaml:"contains"`
+	NotContains []string          `yaml:"not_contains"`
+	Error       bool              `yaml:"error"` // the turn should fail, e.g. the API rejecting it
+}
+
+type ToolExpectation struct {
+	Name  string             `yaml:"name"`
+	Input map[string]Matcher `yaml:"input"`
+	Error *bool              `yaml:"error"` // whether the call should fail, either if unset
+}
+
+// Matcher tests a value of a tool's input
+type Matcher struct {
+	Equals   any
+	Contains string
+	Matches  *regexp.Regexp
+}
+
+// UnmarshalYAML reads a plain value to compare with, or {contains: ...} or {matches: ...}
+func (m *Matcher) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var ops struct {
+			Contains *string `yaml:"contains"`
+			Matches  *string `yaml:"matches"`
+		}
+		if err := node.Decode(&ops); err == nil && (ops.Contains != nil || ops.Matches != nil) {
+			if ops.Contains != nil {
+				m.Contains = *ops.Contains
+			}
+			if ops.Matches != nil {
+				re, err := regexp.Compile(*ops.Matches)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid regexp: %v", node.Line, err)
+				}
+				m.Matches = re
+			}
+			return nil
+		}
+	}
+	return node.Decode(&m.Equals)
+}
+
+// matches tests a value from a tool's input, which was decoded from JSON
+func (m Matcher) matches(value any) bool {
+	if m.Contains != "" || m.Matches != nil {
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		return strings.Contains(s, m.Contains) && (m.Matches == nil || m.Matches.MatchString(s))
+	}
+	// Numbers are float64 in the input and int in the expectation, so compare them as JSON would print them
+	return jsonValue(m.Equals) == jsonValue(value)
+}
+
+func (m Matcher) String() string {
+	var conds []string
+	if m.Contains != "" {
+		conds = append(conds, fmt.Sprintf("containing %q", m.Contains))
+	}
+	if m.Matches != nil {
+		conds = append(conds, "matching /"+m.Matches.String()+"/")
+	}
+	if len(conds) == 0 {
+		return jsonValue(m.Equals)
+	}
+	return strings.Join(conds, " and ")
+}
+
+func jsonValue(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// EvalResult is a scenario's run and what in it didn't meet the expectations, by turn
+type EvalResult struct {
+	Run      *ScenarioRun
+	Failures [][]string
+}
+
+// Passed reports whether every turn met its expectations
+func (r *EvalResult) Passed() bool {
+	for _, failures := range r.Failures {
+		if len(failures) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Eval runs the scenario, with its variant if it has one and against the fake API if it scripts replies,
+// and checks each turn against its expectations
+func Eval(ctx context.Context, s *Scenario, tools []anthropic.Tool) (*EvalResult, error) {
+	v := Variant{Name: "eval"}
+	if len(s.Variants) == 1 {
+		v = s.Variants[0]
+	}
+	if s.Mocked() {
+		var replies []anthropictest.Reply
+		for _, turn := range s.Turns {
+			replies = append(replies, turn.Replies...)
+		}
+		server := anthropictest.NewServer(replies...)
+		defer server.Close()
+		previous := anthropic.GetProvider()
+		anthropic.SetClient(server.Client())
+		defer anthropic.SetProvider(previous)
+	}
+	run, err := RunScenario(ctx, s, v, tools)
+	if err != nil {
+		return nil, err
+	}
+	result := &EvalResult{Run: run, Failures: make([][]string, len(s.Turns))}
+	for i, turn := range run.Turns {
+		result.Failures[i] = s.Turns[i].Expect.check(turn)
+	}
+	return result, nil
+}
+
+// check lists how the turn fell short of the expectation
+func (e Expectation) check(turn ScenarioTurnResult) []string {
+	if turn.Error != "" {
+		if e.Error {
+			return nil
+		}
+		return []string{"the turn failed: " + strings.TrimSpace(turn.Error)}
+	}
+	var failures []string
+	if e.Error {
+		failures = append(failures, "the turn should have failed")
+	}
+	res := turn.Result
+
+	calls := res.ToolCalls
+	for _, want := range e.Tools {
+		found := false
+		for j, call := range calls {
+			if want.matches(call) {
+				calls, found = calls[j+1:], true
+				break
+			}
+			if e.OnlyTools {
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, "no call "+want.String()+" among "+describeCalls(res.ToolCalls))
+			break
+		}
+	}
+	if e.OnlyTools && len(res.ToolCalls) != len(e.Tools) && len(failures) == 0 {
+		failures = append(failures, fmt.Sprintf("expected only %d tool calls, made %s", len(e.Tools), describeCalls(res.ToolCalls)))
+	}
+
+	answer := strings.ToLower(res.Text)
+	for _, s := range e.Contains {
+		if !strings.Contains(answer, strings.ToLower(s)) {
+			failures = append(failures, fmt.Sprintf("the answer doesn't contain %q", s))
+		}
+	}
+	for _, s := range e.NotContains {
+		if strings.Contains(answer, strings.ToLower(s)) {
+			failures = append(failures, fmt.Sprintf("the answer contains %q", s))
+		}
+	}
+	return failures
+}
+
+func (t ToolExpectation) matches(call ToolCall) bool {
+	if call.Name != t.Name || (t.Error != nil && call.IsError != *t.Error) {
+		return false
+	}
+	for key, m := range t.Input {
+		value, ok := call.Input[key]
+		if !ok || !m.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t ToolExpectation) String() string {
+	var conds []string
+	for key, m := range t.Input {
+		conds = append(conds, key+" "+m.String())
+	}
+	sort.Strings(conds)
+	if t.Error != nil {
+		conds = append(conds, fmt.Sprintf("error %v", *t.Error))
+	}
+	if len(conds) == 0 {
+		return "to " + t.Name
+	}
+	return fmt.Sprintf("to %s with %s", t.Name, strings.Join(conds, ", "))
+}
+
+func describeCalls(calls []ToolCall) string {
+	if len(calls) == 0 {
+		return "no tool calls"
+	}
+	described := make([]string, len(calls))
+	for i, call := range calls {
+		described[i] = call.Name + " " + formatToolInput(call.Input)
+	}
+	return strings.Join(described, ", ")
+}
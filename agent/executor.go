@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # HTTP EXECUTOR
+// Tools whose JSON declares an `endpoint` are executed by building an HTTP request
+// from Claude's tool_use input, no Go plugin required
+//   - `{param}` placeholders in the URL are filled from the input and removed from it
+//   - Remaining input goes in the query string for GET/DELETE, or as a JSON body otherwise,
+//     except the inputs listed in `query`, which always go in the query string
+//   - Header values and URLs may reference environment variables as ${VAR}
+type Endpoint struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Query   []string          `json:"query,omitempty"`
+	Auth    *EndpointAuth     `json:"auth,omitempty"`
+}
+
+// EndpointAuth reads its secret from an environment variable so credentials stay out of tool JSON
+//   - bearer: `Authorization: Bearer $ENV`
+//   - basic:  $ENV holds `user:password`
+//   - header: `<Header>: $ENV`
+type EndpointAuth struct {
+	Type   string `json:"type"`
+	Env    string `json:"env"`
+	Header string `json:"header,omitempty"`
+}
+
+var urlParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+func (e *Endpoint) validate() error {
+	if e.URL == "" {
+		return fmt.Errorf("endpoint is missing a url")
+	}
+	if e.Auth != nil {
+		switch e.Auth.Type {
+		case "bearer", "basic":
+		case "header":
+			if e.Auth.Header == "" {
+				return fmt.Errorf("header auth requires a 'header' name")
+			}
+		default:
+			return fmt.Errorf("unknown auth type '%s'", e.Auth.Type)
+		}
+	}
+	return nil
+}
+
+// executor returns a tool function that calls the endpoint
+func (e *Endpoint) executor() useTool {
+	return func(ctx context.Context, params map[string]any) anthropic.Content {
+		req, err := e.buildRequest(ctx, params)
+		if err != nil {
+			return toolError("building request: " + err.Error())
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return toolError("on request: " + err.Error())
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return toolError(fmt.Sprintf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err))
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return toolError(fmt.Sprintf("API request failed with status code: %d, response body: %s", resp.StatusCode, string(body)))
+		}
+		return anthropic.Content{Type: anthropic.ToolResult, Content: string(body)}
+	}
+}
+
+func (e *Endpoint) buildRequest(ctx context.Context, params map[string]any) (*http.Request, error) {
+	method := strings.ToUpper(e.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// Fill the URL template, consuming the params it uses
+	remaining := make(map[string]any, len(params))
+	for k, v := range params {
+		remaining[k] = v
+	}
+	var missing []string
+	rawURL := urlParamPattern.ReplaceAllStringFunc(os.ExpandEnv(e.URL), func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := remaining[name]
+		if !ok {
+			missing = append(missing, name)
+			return m
+		}
+		delete(remaining, name)
+		return url.PathEscape(fmt.Sprint(v))
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required url parameters: %s", strings.Join(missing, ", "))
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url '%s': %v", rawURL, err)
+	}
+
+	var body io.Reader
+	q := u.Query()
+	for k, v := range remaining {
+		if method == http.MethodGet || method == http.MethodDelete || slices.Contains(e.Query, k) {
+			q.Set(k, fmt.Sprint(v))
+			delete(remaining, k)
+		}
+	}
+	u.RawQuery = q.Encode()
+	if len(remaining) > 0 {
+		data, err := json.Marshal(remaining)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range e.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+
+	if e.Auth != nil {
+		secret := os.Getenv(e.Auth.Env)
+		if secret == "" {
+			return nil, fmt.Errorf("auth env var '%s' is not set", e.Auth.Env)
+		}
+		switch e.Auth.Type {
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+secret)
+		case "basic":
+			user, pass, _ := strings.Cut(secret, ":")
+			req.SetBasicAuth(user, pass)
+		case "header":
+			req.Header.Set(e.Auth.Header, secret)
+		}
+	}
+
+	return req, nil
+}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # EXPORT
+// Renders a conversation, including tool calls and per-turn token usage, as Markdown or HTML
+// Usage is only known for assistant messages received during this session, it isn't saved with the conversation
+
+// exportEntry is one rendered block of the transcript
+type exportEntry struct {
+	Speaker string
+	Kind    string // text, tool_use, tool_result, image or document
+	Title   string
+	Body    string
+	Usage   *anthropic.Usage
+}
+
+// buildExport renders the transcript's blocks, masking the redact patterns in them if redacted
+func buildExport(convo Conversation, redacted bool) []exportEntry {
+	toolNames := map[string]string{}
+	var entries []exportEntry
+	for _, msg := range convo {
+		speaker := "You"
+		if msg.Role == anthropic.Assistant {
+			speaker = "Claude"
+		}
+		for _, cont := range withCitations(msg.Content) {
+			switch cont.Type {
+			case anthropic.Text, anthropic.MessageResp:
+				_, message := parseThoughts(cont.Text)
+				entries = append(entries, exportEntry{Speaker: speaker, Kind: "text", Body: message})
+			case anthropic.ToolUse:
+				toolNames[cont.Id] = cont.Name
+				input, _ := json.MarshalIndent(cont.Input, "", "  ")
+				entries = append(entries, exportEntry{Speaker: speaker, Kind: "tool_use", Title: cont.Name, Body: string(input)})
+			case anthropic.ToolResult:
+				entries = append(entries, exportEntry{Speaker: "Tool", Kind: "tool_result", Title: toolNames[cont.ToolUseId], Body: cont.Content})
+			case anthropic.Image:
+				mediaType := "unknown"
+				if cont.Source != nil {
+					mediaType = cont.Source.MediaType
+				}
+				entries = append(entries, exportEntry{Speaker: speaker, Kind: "image", Title: mediaType})
+			case anthropic.Document:
+				entries = append(entries, exportEntry{Speaker: speaker, Kind: "document", Title: cont.Title})
+			}
+		}
+		if msg.Usage != nil && len(entries) > 0 {
+			usage := *msg.Usage
+			entries[len(entries)-1].Usage = &usage
+		}
+	}
+	if redacted {
+		for i := range entries {
+			entries[i].Title, entries[i].Body = redact(entries[i].Title), redact(entries[i].Body)
+		}
+	}
+	return entries
+}
+
+func renderMarkdown(convo Conversation, redacted bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Super Claude conversation\n\n_Exported %s_\n", time.Now().Format(time.RFC1123))
+
+	lastSpeaker := ""
+	for _, e := range buildExport(convo, redacted) {
+		if e.Speaker != lastSpeaker {
+			fmt.Fprintf(&b, "\n## %s\n\n", e.Speaker)
+			lastSpeaker = e.Speaker
+		}
+		switch e.Kind {
+		case "text":
+			fmt.Fprintf(&b, "%s\n\n", e.Body)
+		case "tool_use":
+			fmt.Fprintf(&b, "**Tool call** `%s`\n\n```json\n%s\n```\n\n", e.Title, e.Body)
+		case "tool_result":
+			fmt.Fprintf(&b, "**Result** of `%s`\n\n```\n%s\n```\n\n", e.Title, e.Body)
+		case "image":
+			fmt.Fprintf(&b, "_[%s image]_\n\n", e.Title)
+		case "document":
+			fmt.Fprintf(&b, "_[document %s]_\n\n", e.Title)
+		}
+		if e.Usage != nil {
+			fmt.Fprintf(&b, "_Tokens: %d in, %d out_\n\n", e.Usage.InputTokens, e.Usage.OutputTokens)
+		}
+	}
+	usage, cost := sessionTotals()
+	fmt.Fprintf(&b, "---\n\n_Session total: %d input tokens, %d output tokens, $%.4f_\n", usage.InputTokens, usage.OutputTokens, cost)
+	return b.String()
+}
+
+var htmlExport = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Super Claude conversation</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; line-height: 1.5; }
+.entry { margin: 0.5em 0; padding: 0.5em 1em; border-radius: 6px; }
+.You { background: #eef6ee; } .Claude { background: #fdf0f5; } .Tool { background: #f2f2f2; }
+.speaker { font-weight: bold; } .usage { color: #888; font-size: 0.85em; }
+pre { white-space: pre-wrap; background: #fff; padding: 0.5em; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>Super Claude conversation</h1>
+<p><em>Exported {{.Exported}}</em></p>
+{{range .Entries}}<div class="entry {{.Speaker}}">
+<div class="speaker">{{.Speaker}}</div>
+{{if eq .Kind "text"}}<pre>{{.Body}}</pre>
+{{else if eq .Kind "tool_use"}}<div>Tool call <code>{{.Title}}</code></div><pre>{{.Body}}</pre>
+{{else if eq .Kind "tool_result"}}<div>Result of <code>{{.Title}}</code></div><pre>{{.Body}}</pre>
+{{else if eq .Kind "image"}}<div><em>[{{.Title}} image]</em></div>
+{{else if eq .Kind "document"}}<div><em>[document {{.Title}}]</em></div>
+{{end}}{{with .Usage}}<div class="usage">Tokens: {{.InputTokens}} in, {{.OutputTokens}} out</div>{{end}}
+</div>
+{{end}}<hr>
+<p class="usage">Session total: {{.Usage.InputTokens}} input tokens, {{.Usage.OutputTokens}} output tokens, ${{printf "%.4f" .Cost}}</p>
+</body>
+</html>
+`))
+
+func renderHTML(convo Conversation, redacted bool) (string, error) {
+	var b strings.Builder
+	usage, cost := sessionTotals()
+	err := htmlExport.Execute(&b, map[string]any{
+		"Exported": time.Now().Format(time.RFC1123),
+		"Entries":  buildExport(convo, redacted),
+		"Usage":    usage,
+		"Cost":     cost,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render HTML: %v", err)
+	}
+	return b.String(), nil
+}
+
+// exportConvo writes the conversation to filename as "md" or "html", masking the redact patterns if redacted
+func exportConvo(convo Conversation, format string, filename string, redacted bool) error {
+	if redacted {
+		if len(redactPatterns) == 0 {
+			return fmt.Errorf("there are no redact_patterns in the config to mask")
+		}
+	}
+	var out string
+	switch strings.ToLower(format) {
+	case "md", "markdown":
+		out = renderMarkdown(convo, redacted)
+	case "html":
+		html, err := renderHTML(convo, redacted)
+		if err != nil {
+			return err
+		}
+		out = html
+	default:
+		return fmt.Errorf("unknown export format '%s', must be md or html", format)
+	}
+	return os.WriteFile(filename, []byte(out), 0o644)
+}
+
+// # REDACTION
+// Masking personal data with `/export --redact`, for sharing a transcript outside the team
+//   - Patterns are regular expressions by name in redact_patterns, e.g. email or postal_code, each match becomes [REDACTED:name]
+//   - Text, tool calls and tool results are all masked; the conversation itself is left as it was
+type redactPattern struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+var redactPatterns []redactPattern
+
+// SetRedactPatterns sets the patterns `/export --redact` masks, regular expressions by name
+func SetRedactPatterns(patterns map[string]string) error {
+	redactPatterns = nil
+	for name, expr := range patterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid redact pattern '%s': %v", name, err)
+		}
+		redactPatterns = append(redactPatterns, redactPattern{name, re})
+	}
+	// in order of name, so overlapping patterns mask the same way every run
+	sort.Slice(redactPatterns, func(i, j int) bool { return redactPatterns[i].name < redactPatterns[j].name })
+	return nil
+}
+
+// redact masks every match of the redact patterns in s
+func redact(s string) string {
+	for _, p := range redactPatterns {
+		s = p.pattern.ReplaceAllLiteralString(s, "[REDACTED:"+p.name+"]")
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # JSON EXTRACTION
+// Asking Claude a question whose answer is decoded into a Go value, for scripts that need data rather than prose
+//   - The reply is prefilled with <json> and stopped at </json>, so nothing but the JSON comes back, and code fences are stripped anyway
+//   - Claude is shown the shape of T, as the JSON of its zero value, when it has one
+//   - A reply that doesn't decode is sent back once with the error, asking for a corrected one
+//   - One request without tools or extended thinking, using the session's model and system prompt
+const (
+	jsonOpen, jsonClose = "<json>", "</json>"
+	jsonInstructions    = "Reply with a single JSON value between " + jsonOpen + " and " + jsonClose + " tags and nothing else: no prose, no code fences."
+)
+
+// AskJSON sends the prompt and decodes Claude's reply into a T
+func AskJSON[T any](prompt string) (T, error) {
+	var value T
+	instructions := jsonInstructions
+	if shape, err := json.Marshal(value); err == nil && string(shape) != "null" {
+		instructions += "\nThe JSON must have the shape of this example, which has every field empty: " + string(shape)
+	}
+	system := instructions
+	if systemPrompt != "" {
+		system = systemPrompt + "\n\n" + instructions
+	}
+	req := &anthropic.Request{
+		Model:         model,
+		MaxTokens:     maxTokens,
+		System:        system,
+		Messages:      []anthropic.Message{{Role: anthropic.User, Content: makeTextContent(prompt)}},
+		StopSequences: []string{jsonClose},
+		Temperature:   sampling.Temperature,
+	}
+	if req.MaxTokens <= 0 {
+		req.MaxTokens = model.MaxOutputTokens()
+	}
+
+	ctx := context.Background()
+	for attempt := 0; ; attempt++ {
+		reply, err := askPrefilled(ctx, req)
+		if err != nil {
+			return value, err
+		}
+		var decoded T // a fresh one, as a failed decode can leave fields set
+		err = json.Unmarshal([]byte(stripCodeFences(reply)), &decoded)
+		if err == nil {
+			return decoded, nil
+		}
+		if attempt == 1 {
+			return value, fmt.Errorf("the reply is not valid JSON: %v", err)
+		}
+		req.Messages = append(req.Messages,
+			anthropic.Message{Role: anthropic.Assistant, Content: makeTextContent(jsonOpen + reply + jsonClose)},
+			anthropic.Message{Role: anthropic.User, Content: makeTextContent(fmt.Sprintf("That JSON could not be decoded: %v. Reply again with corrected JSON only.", err))},
+		)
+	}
+}
+
+// askPrefilled posts the request with the reply started at jsonOpen and returns the text after it
+func askPrefilled(ctx context.Context, req *anthropic.Request) (string, error) {
+	r := *req
+	r.Messages = append(append([]anthropic.Message(nil), req.Messages...), anthropic.Message{Role: anthropic.Assistant, Content: makeTextContent(jsonOpen)})
+	resp, err := r.Post(ctx)
+	if err != nil {
+		return "", err
+	}
+	addUsage(resp.Usage, resp.Model)
+	logUsage(ctx, resp.Model, resp.Usage, nil)
+	if resp.StopReason == anthropic.MaxTokens {
+		return "", fmt.Errorf("the reply was cut off at %d tokens", r.MaxTokens)
+	}
+	var text []string
+	for _, cont := range resp.Content {
+		if cont.Type == anthropic.Text {
+			text = append(text, cont.Text)
+		}
+	}
+	reply := strings.TrimSpace(strings.Join(text, ""))
+	return strings.TrimSpace(strings.TrimSuffix(reply, jsonClose)), nil
+}
+
+// stripCodeFences removes a ``` or ```json fence around the text, if there is one
+func stripCodeFences(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if newline := strings.IndexByte(text, '\n'); newline >= 0 && !strings.ContainsAny(text[:newline], "{[\"") {
+		text = text[newline+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
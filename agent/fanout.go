@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # FAN-OUT
+// Asking many independent questions at once, e.g. classifying a batch of support tickets, each in a conversation of its own
+//   - At most Parallelism questions are in flight, and requests share the API rate limits set with --rpm and --tpm like any others
+//   - Every question gets the same tools, run for real as with Ask; calls needing approval are refused, there is nobody to ask
+//   - One question failing doesn't stop the others, its error is kept with its answer
+const defaultFanOutParallelism = 8
+
+type FanOutOptions struct {
+	Tools       []anthropic.Tool
+	Parallelism int // defaultFanOutParallelism if 0
+	// OnAnswer is called as each question is answered, from the goroutine that asked it
+	OnAnswer func(i int, answer FanOutAnswer)
+}
+
+type FanOutAnswer struct {
+	Prompt string
+	Result *TurnResult // nil if the question failed before Claude answered
+	Err    error
+}
+
+// FanOutResults are the answers in the order of the prompts, with the usage and cost of all of them
+type FanOutResults struct {
+	Answers []FanOutAnswer
+	Usage   anthropic.Usage
+	Cost    float64 // in USD, priced by the model that answered each question
+	Failed  int
+}
+
+// FanOut asks each prompt as a single turn of a fresh conversation, concurrently
+func FanOut(ctx context.Context, prompts []string, opts FanOutOptions) *FanOutResults {
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultFanOutParallelism
+	}
+	answers := make([]FanOutAnswer, len(prompts))
+	var g errgroup.Group
+	g.SetLimit(parallelism)
+	for i, prompt := range prompts {
+		g.Go(func() error {
+			answer := FanOutAnswer{Prompt: prompt}
+			if err := ctx.Err(); err != nil {
+				answer.Err = err
+			} else {
+				var convo Conversation
+				answer.Result, answer.Err = convo.Ask(ctx, prompt, opts.Tools)
+			}
+			answers[i] = answer
+			if opts.OnAnswer != nil {
+				opts.OnAnswer(i, answer)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	results := &FanOutResults{Answers: answers}
+	for _, answer := range answers {
+		if answer.Err != nil {
+			results.Failed++
+		}
+		if answer.Result != nil {
+			results.Usage.Add(answer.Result.Usage)
+			results.Cost += answer.Result.Usage.Cost(answer.Result.Model)
+		}
+	}
+	return results
+}
@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # FETCH TOOL
+// The built-in fetch_url tool, GETting pages from an allowlist of domains such as internal docs and status pages
+//   - A URL's host must be one of the domains or a subdomain of one, and redirects are held to the same list
+//   - HTML is reduced to its text, keeping headings, list items and where links go; JSON, XML and other text come back as they are
+//   - Bodies are read up to maxFetchBodyBytes, and the text returned is cut off at maxFetchTextBytes
+const (
+	maxFetchBodyBytes = 2 * 1024 * 1024
+	maxFetchTextBytes = 64 * 1024
+	maxFetchRedirects = 5
+)
+
+type fetcher struct {
+	domains []string
+	client  *http.Client
+}
+
+// LoadFetchTool registers the fetch_url tool for the given domains and returns its definition
+func LoadFetchTool(domains []string) (anthropic.Tool, error) {
+	f := &fetcher{}
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "*.")
+		if domain == "" {
+			continue
+		}
+		if strings.ContainsAny(domain, "/:") {
+			return anthropic.Tool{}, fmt.Errorf("invalid fetch domain '%s', expected a host name such as docs.example.com", domain)
+		}
+		f.domains = append(f.domains, domain)
+	}
+	if len(f.domains) == 0 {
+		return anthropic.Tool{}, fmt.Errorf("fetch_url needs at least one allowed domain")
+	}
+	f.client = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxFetchRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+		}
+		return f.check(req.URL)
+	}}
+
+	tool := anthropic.Tool{
+		Name: "fetch_url",
+		Description: "Fetch a web page with a GET request and return its text, with HTML reduced to headings, paragraphs, lists and links. " +
+			"Use it to look up current documentation or service status instead of relying on memory. " +
+			"Only pages on these domains and their subdomains can be fetched: " + strings.Join(f.domains, ", ") + ".",
+		InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+			"url": map[string]any{"type": "string", "description": "The http or https URL to fetch, e.g. https://" + f.domains[0] + "/"},
+		}, Required: []string{"url"}},
+	}
+	registerTool(tool, f.fetch)
+	return tool, nil
+}
+
+// check refuses URLs that aren't http or https, or whose host isn't allowed
+func (f *fetcher) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be fetched, not '%s'", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range f.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not an allowed domain, only %s can be fetched", host, strings.Join(f.domains, ", "))
+}
+
+func (f *fetcher) fetch(ctx context.Context, params map[string]any) anthropic.Content {
+	raw, _ := params["url"].(string)
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return toolError(fmt.Sprintf("invalid URL '%s'", raw))
+	}
+	if err := f.check(u); err != nil {
+		return toolError(err.Error())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	req.Header.Set("User-Agent", "super-claude")
+	req.Header.Set("Accept", "text/html, text/plain, application/json, */*;q=0.5")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return toolError(fmt.Sprintf("failed to fetch %s: %v", u, err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBodyBytes))
+	if err != nil {
+		return toolError(fmt.Sprintf("failed to read %s: %v", u, err))
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(body)
+		mediaType, _, _ = mime.ParseMediaType(mediaType)
+	}
+	var text string
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		text = htmlToText(body, resp.Request.URL)
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml"):
+		text = string(body)
+	default:
+		return toolError(fmt.Sprintf("%s is %s, only web pages and text can be fetched", u, mediaType))
+	}
+	if len(text) > maxFetchTextBytes {
+		text = text[:maxFetchTextBytes] + fmt.Sprintf("\n[truncated, the page's text was %d bytes]", len(text))
+	}
+
+	header := fmt.Sprintf("%s (%s)\n\n", resp.Request.URL, resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return toolError(header + text)
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: header + text}
+}
+
+// htmlToText renders an HTML page as plain text in the spirit of Markdown, dropping scripts, styles and navigation chrome,
+// and resolving links against base
+func htmlToText(page []byte, base *url.URL) string {
+	doc, err := html.Parse(strings.NewReader(string(page)))
+	if err != nil {
+		return string(page)
+	}
+	var b strings.Builder
+	var title string
+	var walk func(n *html.Node, pre bool)
+	newline := func(n int) {
+		s := b.String()
+		trailing := len(s) - len(strings.TrimRight(s, "\n"))
+		if len(s) > 0 && trailing < n {
+			b.WriteString(strings.Repeat("\n", n-trailing))
+		}
+	}
+	// space separates words, but never doubles up or starts a line
+	space := func() {
+		if s := b.String(); s != "" && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+			b.WriteString(" ")
+		}
+	}
+	walk = func(n *html.Node, pre bool) {
+		switch n.Type {
+		case html.TextNode:
+			if pre {
+				b.WriteString(n.Data)
+				return
+			}
+			words := strings.Fields(n.Data)
+			if len(words) == 0 {
+				if n.Data != "" {
+					space()
+				}
+				return
+			}
+			if unicode.IsSpace(rune(n.Data[0])) {
+				space()
+			}
+			b.WriteString(strings.Join(words, " "))
+			if unicode.IsSpace(rune(n.Data[len(n.Data)-1])) {
+				space()
+			}
+			return
+		case html.ElementNode:
+		default:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				walk(c, pre)
+			}
+			return
+		}
+
+		switch n.Data {
+		case "script", "style", "noscript", "template", "svg", "iframe", "nav", "form", "button":
+			return
+		case "title":
+			if n.FirstChild != nil && title == "" {
+				title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+			}
+			return
+		case "br":
+			b.WriteString("\n")
+			return
+		case "hr":
+			newline(2)
+			b.WriteString("---")
+			newline(2)
+			return
+		case "img":
+			if alt := attr(n, "alt"); alt != "" {
+				b.WriteString("[image: " + alt + "]")
+			}
+			return
+		}
+
+		before, after := 0, 0
+		switch n.Data {
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			newline(2)
+			b.WriteString(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+			after = 2
+		case "p", "div", "section", "article", "header", "footer", "main", "aside", "table", "blockquote", "ul", "ol", "dl", "figure":
+			before, after = 2, 2
+		case "pre":
+			newline(2)
+			b.WriteString("```\n")
+			pre, after = true, 2
+		case "li":
+			newline(1)
+			b.WriteString("- ")
+			after = 1
+		case "tr", "dt", "dd":
+			before, after = 1, 1
+		case "td", "th":
+			if !strings.HasSuffix(b.String(), "\n") {
+				b.WriteString(" | ")
+			}
+		}
+		if before > 0 {
+			newline(before)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, pre)
+		}
+		switch n.Data {
+		case "a":
+			if href := attr(n, "href"); href != "" && !strings.HasPrefix(href, "#") && !strings.HasPrefix(href, "javascript:") {
+				if ref, err := base.Parse(href); err == nil {
+					b.WriteString(" (" + ref.String() + ")")
+				}
+			}
+		case "pre":
+			newline(1)
+			b.WriteString("```")
+		}
+		if after > 0 {
+			newline(after)
+		}
+	}
+	walk(doc, false)
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+	if title != "" {
+		text = "Title: " + title + "\n\n" + text
+	}
+	return text
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # FILE TOOLS
+// Built-in read_file, write_file and list_dir tools confined to a workspace directory
+// Paths are taken relative to the workspace root, and any path that resolves outside it,
+// by `..` or through a symlink, is refused, as are dangling symlinks and writes through a symlink
+const maxReadFileBytes = 256 * 1024
+
+type workspace struct {
+	root string // absolute, with symlinks resolved
+}
+
+// LoadFileTools registers the file tools for the workspace at root and returns their definitions
+func LoadFileTools(root string) ([]anthropic.Tool, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace '%s': %v", root, err)
+	}
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace '%s': %v", root, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace '%s': %v", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("invalid workspace '%s': not a directory", root)
+	}
+
+	ws := &workspace{root: abs}
+	pathProp := map[string]any{"type": "string", "description": "Path relative to the workspace root"}
+	tools := []anthropic.Tool{
+		{
+			Name:        "read_file",
+			Description: "Read a text file from the local workspace",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{"path": pathProp}, Required: []string{"path"}},
+		},
+		{
+			Name:        "write_file",
+			Description: "Create or overwrite a file in the local workspace with the given content, creating parent directories as needed",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+				"path":    pathProp,
+				"content": map[string]any{"type": "string", "description": "The full new content of the file"},
+			}, Required: []string{"path", "content"}},
+		},
+		{
+			Name:        "list_dir",
+			Description: "List the files and directories in a directory of the local workspace, directories end with /",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+				"path": map[string]any{"type": "string", "description": "Directory relative to the workspace root, defaults to the root"},
+			}},
+		},
+	}
+	registerTool(tools[0], ws.readFile)
+	registerTool(tools[1], ws.writeFile)
+	registerTool(tools[2], ws.listDir)
+	return tools, nil
+}
+
+// resolve maps a path given by Claude to an absolute path inside the workspace
+func (ws *workspace) resolve(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("path '%s' must be relative to the workspace", path)
+	}
+	full := filepath.Join(ws.root, path)
+	if !ws.contains(full) {
+		return "", fmt.Errorf("path '%s' is outside the workspace", path)
+	}
+
+	// Resolve symlinks in the longest part of the path that exists, so a link can't point outside
+	existing := full
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !ws.contains(resolved) {
+				return "", fmt.Errorf("path '%s' is outside the workspace", path)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		// A dangling link would be followed when the file is created, wherever it points
+		if info, err := os.Lstat(existing); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path '%s' goes through a symlink to a file that doesn't exist", path)
+		}
+		existing = filepath.Dir(existing)
+	}
+	return full, nil
+}
+
+func (ws *workspace) contains(path string) bool {
+	rel, err := filepath.Rel(ws.root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (ws *workspace) readFile(_ context.Context, params map[string]any) anthropic.Content {
+	path, _ := params["path"].(string)
+	full, err := ws.resolve(path)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxReadFileBytes+1))
+	if err != nil {
+		return toolError(err.Error())
+	}
+	if len(data) > maxReadFileBytes {
+		size := int64(len(data))
+		if info, err := f.Stat(); err == nil {
+			size = info.Size()
+		}
+		return anthropic.Content{Type: anthropic.ToolResult, Content: string(data[:runeStart(string(data), maxReadFileBytes)]) + fmt.Sprintf("\n[truncated, file is %d bytes]", size)}
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: string(data)}
+}
+
+func (ws *workspace) writeFile(_ context.Context, params map[string]any) anthropic.Content {
+	path, _ := params["path"].(string)
+	content, ok := params["content"].(string)
+	if !ok {
+		return toolError("content is required")
+	}
+	full, err := ws.resolve(path)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	if full == ws.root {
+		return toolError("path is required")
+	}
+	if info, err := os.Lstat(full); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return toolError(fmt.Sprintf("path '%s' is a symlink, write to the file it points to instead", path))
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		return toolError(err.Error())
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		return toolError(err.Error())
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: fmt.Sprintf("wrote %d bytes to %s", len(content), path)}
+}
+
+func (ws *workspace) listDir(_ context.Context, params map[string]any) anthropic.Content {
+	path, _ := params["path"].(string)
+	full, err := ws.resolve(path)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	var b strings.Builder
+	for _, e := range entries {
+		if e.IsDir() {
+			fmt.Fprintf(&b, "%s/\n", e.Name())
+			continue
+		}
+		size := int64(0)
+		if info, err := e.Info(); err == nil {
+			size = info.Size()
+		}
+		fmt.Fprintf(&b, "%s\t%d bytes\n", e.Name(), size)
+	}
+	if b.Len() == 0 {
+		return anthropic.Content{Type: anthropic.ToolResult, Content: "(empty directory)"}
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: b.String()}
+}
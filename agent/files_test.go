@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWorkspace makes a workspace with a directory outside it, returning both
+func testWorkspace(t *testing.T) (*workspace, string) {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, outside := filepath.Join(dir, "workspace"), filepath.Join(dir, "outside")
+	for _, d := range []string{root, outside} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &workspace{root: root}, outside
+}
+
+func TestWorkspaceResolve(t *testing.T) {
+	ws, outside := testWorkspace(t)
+	if err := os.WriteFile(filepath.Join(ws.root, "in.txt"), []byte("in"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"out":      outside,
+		"dangling": filepath.Join(outside, "new.txt"),
+		"gone":     filepath.Join(outside, "missing"),
+		"in-link":  filepath.Join(ws.root, "in.txt"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(ws.root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]bool{
+		"in.txt":        true,
+		"new/file.txt":  true,
+		"in-link":       true,
+		"":              true,
+		"../outside/x":  false,
+		"/etc/passwd":   false,
+		"out/x":         false,
+		"dangling":      false,
+		"gone/file.txt": false,
+		"sub/../../x":   false,
+		"sub/../in.txt": true,
+	}
+	for path, allowed := range tests {
+		if _, err := ws.resolve(path); (err == nil) != allowed {
+			t.Errorf("resolve(%q): got %v, want allowed %v", path, err, allowed)
+		}
+	}
+}
+
+func TestWriteFileStaysInWorkspace(t *testing.T) {
+	ws, outside := testWorkspace(t)
+	if err := os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(ws.root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws.root, "in.txt"), []byte("in"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(ws.root, "in.txt"), filepath.Join(ws.root, "in-link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"dangling", "in-link"} {
+		if result := ws.writeFile(context.Background(), map[string]any{"path": path, "content": "x"}); !result.IsError {
+			t.Errorf("wrote through the symlink %s: %s", path, result.Content)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Error("a file was created outside the workspace")
+	}
+	if result := ws.writeFile(context.Background(), map[string]any{"path": "sub/new.txt", "content": "x"}); result.IsError {
+		t.Errorf("could not write a new file: %s", result.Content)
+	}
+}
+
+func TestReadFileTruncates(t *testing.T) {
+	ws, _ := testWorkspace(t)
+	size := maxReadFileBytes + 100
+	if err := os.WriteFile(filepath.Join(ws.root, "big.txt"), []byte(strings.Repeat("a", size)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	result := ws.readFile(context.Background(), map[string]any{"path": "big.txt"})
+	content := result.Content
+	if result.IsError || !strings.HasPrefix(content, strings.Repeat("a", maxReadFileBytes)+"\n[truncated, file is ") || !strings.HasSuffix(content, " 262244 bytes]") {
+		t.Errorf("got %d bytes ending %q", len(content), content[max(len(content)-40, 0):])
+	}
+}
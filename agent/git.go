@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # GIT TOOLS
+// Built-in git_status, git_diff and git_commit tools on the repository super-claude was started in, enabled with --git
+//   - They run the git binary with -C on the repository's top level, never through a shell
+//   - Refs and paths starting with - are refused, so Claude can't slip options in
+//   - git_commit stages the paths it is given and commits everything staged, after a yes/no confirmation
+//     showing the message and files, like run_command's; --yolo skips it, and without a terminal commits are refused
+type gitRepo struct {
+	root    string
+	confirm bool
+}
+
+// LoadGitTools registers the git tools for the repository containing dir and returns their definitions
+func LoadGitTools(dir string, confirm bool) ([]anthropic.Tool, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not in a git repository: %s", dir, strings.TrimSpace(string(out)))
+	}
+	repo := &gitRepo{root: strings.TrimSpace(string(out)), confirm: confirm}
+
+	pathsProp := map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Paths relative to the repository root, defaults to all of them"}
+	tools := []anthropic.Tool{
+		{
+			Name:        "git_status",
+			Description: "Show the current branch of the local git repository, how far it is ahead of or behind its upstream, and its changed, staged and untracked files",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{}},
+		},
+		{
+			Name:        "git_diff",
+			Description: "Show the changes in the local git repository as a unified diff: by default the unstaged changes, with staged the changes that would be committed, or with ref the changes since a commit, branch or tag",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+				"staged": map[string]any{"type": "boolean", "description": "Show the staged changes instead of the unstaged ones"},
+				"ref":    map[string]any{"type": "string", "description": "Compare the working tree with this commit, branch or tag instead, e.g. main or HEAD~3"},
+				"stat":   map[string]any{"type": "boolean", "description": "Only list the changed files with their number of changed lines"},
+				"paths":  pathsProp,
+			}},
+		},
+		{
+			Name:        "git_commit",
+			Description: "Commit to the local git repository. The given paths are staged first, then everything staged is committed. The user is asked to confirm each commit.",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+				"message": map[string]any{"type": "string", "description": "The commit message, a short summary line, optionally followed by a blank line and a longer description"},
+				"paths":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": "Paths relative to the repository root to stage before committing"},
+			}, Required: []string{"message"}},
+		},
+	}
+	registerTool(tools[0], repo.status)
+	registerTool(tools[1], repo.diff)
+	registerTool(tools[2], repo.commit)
+	return tools, nil
+}
+
+// git runs a git command in the repository, returning its output and, if it failed, an error with that output
+func (r *gitRepo) git(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "git", append([]string{"-C", r.root}, args...)...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], msg)
+	}
+	return capOutput(out), nil
+}
+
+// gitPaths reads a paths input, refusing any that would be taken as an option
+func gitPaths(params map[string]any) ([]string, error) {
+	list, _ := params["paths"].([]any)
+	paths := make([]string, 0, len(list))
+	for _, p := range list {
+		path, ok := p.(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("paths must be a list of non-empty strings")
+		}
+		if strings.HasPrefix(path, "-") {
+			return nil, fmt.Errorf("path '%s' must not start with -", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func (r *gitRepo) status(ctx context.Context, _ map[string]any) anthropic.Content {
+	out, err := r.git(ctx, "status", "--short", "--branch")
+	if err != nil {
+		return toolError(err.Error())
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: out}
+}
+
+func (r *gitRepo) diff(ctx context.Context, params map[string]any) anthropic.Content {
+	args := []string{"diff"}
+	if staged, _ := params["staged"].(bool); staged {
+		args = append(args, "--cached")
+	}
+	if stat, _ := params["stat"].(bool); stat {
+		args = append(args, "--stat")
+	}
+	if ref, _ := params["ref"].(string); ref != "" {
+		if strings.HasPrefix(ref, "-") {
+			return toolError(fmt.Sprintf("ref '%s' must not start with -", ref))
+		}
+		args = append(args, ref)
+	}
+	paths, err := gitPaths(params)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	out, err := r.git(ctx, append(append(args, "--"), paths...)...)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	if out == "" {
+		out = "No changes."
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: out}
+}
+
+func (r *gitRepo) commit(ctx context.Context, params map[string]any) anthropic.Content {
+	message, _ := params["message"].(string)
+	if strings.TrimSpace(message) == "" {
+		return toolError("message is required")
+	}
+	paths, err := gitPaths(params)
+	if err != nil {
+		return toolError(err.Error())
+	}
+
+	if r.confirm {
+		if confirmFunc == nil {
+			return toolError("commits need confirmation, which isn't possible in this mode (run with --yolo to skip it)")
+		}
+		staged, err := r.git(ctx, "diff", "--cached", "--name-status")
+		if err != nil {
+			return toolError(err.Error())
+		}
+		details := "Commit to " + r.root + " with the message\n" + indent(message, "    ")
+		if len(paths) > 0 {
+			details += "\nStaging first: " + strings.Join(paths, ", ")
+		}
+		if staged != "" {
+			details += "\nAlready staged:\n" + indent(strings.TrimRight(staged, "\n"), "    ")
+		}
+		confirmMu.Lock()
+		utils.Promptln("yellow", details) // printed before the question, which is redrawn as it is answered, and even when quiet
+		ok := confirmFunc("Commit?")
+		confirmMu.Unlock()
+		if !ok {
+			return toolError("the user declined this commit")
+		}
+	}
+
+	if len(paths) > 0 {
+		if _, err := r.git(ctx, append([]string{"add", "--"}, paths...)...); err != nil {
+			return toolError(err.Error())
+		}
+	}
+	out, err := r.git(ctx, "commit", "--message", message)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: out}
+}
+
+// indent prefixes each line of s
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,141 @@
+package agent_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+// # GOLDEN TESTS
+// Runs every case in testdata/golden against the fake Messages API and compares what comes of it with its golden file
+//   - go test ./agent -run TestGolden checks them, adding -update rewrites the golden files after an intended change
+//   - Each case gets the file tools on a fresh copy of testdata/golden/workspace, so tool calls give the same results every run
+//   - The golden file holds each turn's result and every request the agent sent, exactly as the server decoded it
+
+var update = flag.Bool("update", false, "Rewrite the golden files from the current output instead of checking them")
+
+// defaultSystem stands in for the built-in system prompt, so editing that doesn't change every golden file
+const defaultSystem = "You are a helpful assistant used in tests."
+
+var goldenDir = filepath.Join("testdata", "golden")
+
+type transcript struct {
+	Turns    []turnOutcome       `json:"turns"`
+	Requests []anthropic.Request `json:"requests"`
+	Unused   int                 `json:"unused_replies,omitempty"`
+}
+
+type turnOutcome struct {
+	Prompt string            `json:"prompt"`
+	Result *agent.TurnResult `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+func TestGolden(t *testing.T) {
+	cases, err := anthropictest.LoadCases(goldenDir)
+	if err != nil {
+		t.Fatalf("failed to load golden cases: %v", err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no golden cases in %s", goldenDir)
+	}
+	// Cases share the process's settings, the installed client among them, so they run one at a time
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got := runCase(t, c, filepath.Join(goldenDir, "workspace"))
+			if err := anthropictest.CompareGolden(filepath.Join(goldenDir, c.Name+".golden"), got, *update); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// runCase plays the case's turns against a server scripted with its replies and returns the transcript as indented JSON
+func runCase(t *testing.T, c anthropictest.Case, workspace string) []byte {
+	server := anthropictest.NewServer(c.Replies()...)
+	defer server.Close()
+	anthropic.SetClient(server.Client())
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyDir(workspace, dir); err != nil {
+		t.Fatal(err)
+	}
+	tools, err := agent.LoadFileTools(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agent.SetModel(c.Model)
+	if c.Model == "" {
+		agent.SetModel("haiku")
+	}
+	system := c.System
+	if system == "" {
+		system = defaultSystem
+	}
+	agent.SetSystemPrompt(system)
+	var fallbacks []anthropic.Model
+	for _, name := range c.FallbackModels {
+		fallbacks = append(fallbacks, anthropic.ParseModel(name))
+	}
+	anthropic.SetFallbackModels(fallbacks)
+	defer anthropic.SetFallbackModels(nil)
+
+	var convo agent.Conversation
+	var out transcript
+	for _, turn := range c.Turns {
+		outcome := turnOutcome{Prompt: turn.Prompt}
+		result, err := convo.Ask(context.Background(), turn.Prompt, tools)
+		if err != nil {
+			outcome.Error = err.Error()
+		} else {
+			result.Stats = agent.TurnStats{Requests: result.Stats.Requests} // timings differ every run
+			outcome.Result = result
+		}
+		out.Turns = append(out.Turns, outcome)
+	}
+	out.Requests = server.Requests()
+	out.Unused = server.Remaining()
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Error messages name the copy, which is somewhere else every run
+	data = bytes.ReplaceAll(data, []byte(dir), []byte("$WORKSPACE"))
+	return append(data, '\n')
+}
+
+// copyDir copies the files under src into dst, which exists
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0o644)
+	})
+}
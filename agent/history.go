@@ -0,0 +1,404 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # SESSION HISTORY
+// Every REPL session kept in the history directory, with an index to find it by and resume it from
+//   - A session is saved to <id>.json on exit, like conversation.json and sealed the same way, and index.json lists them
+//   - The index has each session's title, when it started and was last saved, its model and its token totals
+//   - index.json.lock is held while the index is updated, so REPLs exiting at once keep each other's entries
+//   - The title is written by Haiku from the first exchange, in the background so the REPL never waits on it
+//   - `super-claude sessions list` and `sessions search <query>` find sessions, `--resume <id>` carries one on
+//   - history_dir (HISTORY_DIR) moves the directory and "none" turns history off
+const (
+	historyIndexFile = "index.json"
+	titleModel       = anthropic.Haiku
+	titleMaxTokens   = 32
+	titleTimeout     = 30 * time.Second
+	historyLockWait  = 5 * time.Second  // for another REPL to finish updating the index
+	historyLockStale = 30 * time.Second // a lock older than this was left behind
+	titleMaxChars    = 8000             // of the first exchange shown to the model
+	titlePrompt      = `You title conversations between a user and an AI assistant, so the user can find them again later.
+Reply with a title of at most eight words saying what the conversation is about, without quotes or a final full stop.`
+)
+
+// SessionEntry is a saved session as the index lists it
+type SessionEntry struct {
+	ID       string          `json:"id"`
+	Title    string          `json:"title"`
+	Started  time.Time       `json:"started"`
+	Updated  time.Time       `json:"updated"`
+	Model    anthropic.Model `json:"model"`
+	Messages int             `json:"messages"`
+	Usage    anthropic.Usage `json:"usage"`
+}
+
+var history struct {
+	dir string // empty when history is off
+
+	mu        sync.Mutex
+	entry     SessionEntry    // this process's session
+	baseUsage anthropic.Usage // what a resumed session had used before this process
+	titling   chan struct{}   // closed when the title request is done, nil until it starts
+}
+
+// SetHistoryDir saves REPL sessions in dir, "" turns history off
+func SetHistoryDir(dir string) {
+	history.dir = dir
+}
+
+// historyEnabled reports whether sessions are being kept
+func historyEnabled() bool {
+	return history.dir != ""
+}
+
+// ResumeSession loads the saved session whose id starts with prefix, and has the REPL save to it from then on
+func ResumeSession(prefix string) (Conversation, error) {
+	entry, err := findSession(prefix)
+	if err != nil {
+		return nil, err
+	}
+	convo, err := readConvoFromFile(filepath.Join(history.dir, entry.ID+".json"))
+	if err != nil {
+		return nil, err
+	}
+	history.mu.Lock()
+	history.entry, history.baseUsage = entry, entry.Usage
+	history.mu.Unlock()
+	return convo, nil
+}
+
+// findSession is the entry whose id starts with prefix, which must be the only one that does
+func findSession(prefix string) (SessionEntry, error) {
+	entries, err := readHistoryIndex()
+	if err != nil {
+		return SessionEntry{}, err
+	}
+	var found []SessionEntry
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.ID, prefix) {
+			found = append(found, entry)
+		}
+	}
+	switch {
+	case prefix == "" || len(found) == 0:
+		return SessionEntry{}, fmt.Errorf("no saved session '%s', see `super-claude sessions list`", prefix)
+	case len(found) > 1:
+		return SessionEntry{}, fmt.Errorf("%d saved sessions start with '%s', give more of the id", len(found), prefix)
+	}
+	return found[0], nil
+}
+
+// maybeTitle has the session titled once it has its first reply
+func maybeTitle(convo Conversation) {
+	if !historyEnabled() {
+		return
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if history.titling != nil || history.entry.Title != "" || len(convo) < 2 {
+		return
+	}
+	transcript := renderTranscript(convo)
+	if len(transcript) > titleMaxChars {
+		transcript = transcript[:runeStart(transcript, titleMaxChars)]
+	}
+	done := make(chan struct{})
+	history.titling = done
+	go func() {
+		defer close(done)
+		title, err := titleSession(transcript)
+		if err != nil {
+			slog.Warn("could not title the session", "error", err)
+			return
+		}
+		history.mu.Lock()
+		history.entry.Title = title
+		history.mu.Unlock()
+	}()
+}
+
+// titleSession asks Haiku for a title for the transcript
+func titleSession(transcript string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+	req := &anthropic.Request{
+		Model:     titleModel,
+		MaxTokens: titleMaxTokens,
+		System:    titlePrompt,
+		Messages:  []anthropic.Message{{Role: anthropic.User, Content: makeTextContent("<conversation>\n" + transcript + "</conversation>\n\nTitle this conversation.")}},
+	}
+	resp, err := req.Post(ctx)
+	if err != nil {
+		return "", err
+	}
+	addUsage(resp.Usage, resp.Model)
+	logUsage(ctx, resp.Model, resp.Usage, nil)
+	var text []string
+	for _, cont := range resp.Content {
+		if cont.Type == anthropic.Text {
+			text = append(text, cont.Text)
+		}
+	}
+	title := strings.Trim(strings.TrimSpace(strings.Join(text, " ")), `"'.`)
+	if title == "" {
+		return "", fmt.Errorf("the reply had no title")
+	}
+	if first, _, ok := strings.Cut(title, "\n"); ok {
+		title = strings.TrimSpace(first)
+	}
+	return title, nil
+}
+
+// saveHistory writes the session and its index entry, waiting a little for a title still being written
+func saveHistory(convo Conversation) error {
+	if !historyEnabled() || len(convo) == 0 {
+		return nil
+	}
+	history.mu.Lock()
+	titling := history.titling
+	history.mu.Unlock()
+	if titling != nil {
+		select {
+		case <-titling:
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	now := time.Now().UTC()
+	if history.entry.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		history.entry.ID, history.entry.Started = id[:12], now
+	}
+	entry := &history.entry
+	entry.Updated, entry.Model, entry.Messages = now, model, len(convo)
+	entry.Usage = history.baseUsage
+	usageMu.Lock()
+	entry.Usage.Add(sessionUsage)
+	usageMu.Unlock()
+
+	if err := os.MkdirAll(history.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create the history directory: %v", err)
+	}
+	data, err := json.MarshalIndent(convo, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeSealed(filepath.Join(history.dir, entry.ID+".json"), append(data, '\n')); err != nil {
+		return err
+	}
+
+	if err := updateHistoryIndex(*entry); err != nil {
+		return err
+	}
+	utils.Eprintf("green", "Session saved as %s, resume it with --resume %s\n", entry.ID, entry.ID)
+	return nil
+}
+
+// updateHistoryIndex adds or replaces the entry in the index, holding its lock so REPLs saving at once don't drop each other's entries
+func updateHistoryIndex(entry SessionEntry) error {
+	unlock, err := lockHistoryIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := readHistoryIndex()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(entries, func(e SessionEntry) bool { return e.ID == entry.ID })
+	if i < 0 {
+		entries = append(entries, entry)
+	} else {
+		entries[i] = entry
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSealed(filepath.Join(history.dir, historyIndexFile), append(data, '\n'))
+}
+
+// lockHistoryIndex creates index.json.lock holding this process's pid, waiting for another REPL to remove its own,
+// and takes over one left by a process that is gone or has held it far longer than a save takes
+func lockHistoryIndex() (func(), error) {
+	path := filepath.Join(history.dir, historyIndexFile+".lock")
+	deadline := time.Now().Add(historyLockWait)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			fmt.Fprint(f, os.Getpid())
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock the session index: %v", err)
+		}
+		if staleLock(path) {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the session index is locked by another REPL, remove %s if none is saving", path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// staleLock reports whether the lock file's process is gone or it is older than historyLockStale
+func staleLock(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false // removed meanwhile, the next try creates it
+	}
+	if time.Since(info.ModTime()) > historyLockStale {
+		return true
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && pid != os.Getpid() && !processAlive(pid)
+}
+
+// writeSealed seals data and swaps it in for filename, so another REPL saving at the same time never sees half a file
+func writeSealed(filename string, data []byte) error {
+	data, err := sealData(data)
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", filename, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// readHistoryIndex reads the index, most recently saved first
+func readHistoryIndex() ([]SessionEntry, error) {
+	if !historyEnabled() {
+		return nil, fmt.Errorf("session history is off, history_dir is none")
+	}
+	data, err := os.ReadFile(filepath.Join(history.dir, historyIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session index: %v", err)
+	}
+	if data, err = openData(data); err != nil {
+		return nil, fmt.Errorf("failed to read the session index: %v", err)
+	}
+	var entries []SessionEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse the session index: %v", err)
+	}
+	slices.SortFunc(entries, func(a, b SessionEntry) int { return b.Updated.Compare(a.Updated) })
+	return entries, nil
+}
+
+// ListHistory prints the saved sessions, most recent first
+func ListHistory() error {
+	entries, err := readHistoryIndex()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		utils.Cprintln(commandColor, "No saved sessions yet, they are saved when the REPL exits.")
+		return nil
+	}
+	for _, entry := range entries {
+		printSessionEntry(entry)
+	}
+	return nil
+}
+
+// SearchHistory prints the saved sessions whose title or text contains query, ignoring case, with where it matched
+func SearchHistory(query string) error {
+	entries, err := readHistoryIndex()
+	if err != nil {
+		return err
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	found := 0
+	for _, entry := range entries {
+		match := ""
+		if strings.Contains(strings.ToLower(entry.Title), query) {
+			match = "in the title"
+		} else {
+			convo, err := readConvoFromFile(filepath.Join(history.dir, entry.ID+".json"))
+			if err != nil {
+				slog.Warn("could not search a session", "session", entry.ID, "error", err)
+				continue
+			}
+			match = searchSnippet(convo, query)
+		}
+		if match == "" {
+			continue
+		}
+		printSessionEntry(entry)
+		utils.Cprintln("gray", "    "+match)
+		found++
+	}
+	if found == 0 {
+		utils.Cprintf(commandColor, "No saved sessions mention '%s'.\n", query)
+	}
+	return nil
+}
+
+// searchSnippet is the text around the first mention of query in the conversation, "" if there is none
+func searchSnippet(convo Conversation, query string) string {
+	for _, msg := range convo {
+		for _, cont := range msg.Content {
+			text := cont.Text
+			if cont.Type == anthropic.ToolResult {
+				text = cont.Content
+			}
+			i := strings.Index(strings.ToLower(text), query)
+			if i < 0 {
+				continue
+			}
+			start, end := runeStart(text, max(i-40, 0)), runeStart(text, min(i+len(query)+40, len(text)))
+			snippet := strings.Join(strings.Fields(text[start:end]), " ")
+			if start > 0 {
+				snippet = "..." + snippet
+			}
+			if end < len(text) {
+				snippet += "..."
+			}
+			return fmt.Sprintf("%s: %s", msg.Role, snippet)
+		}
+	}
+	return ""
+}
+
+func printSessionEntry(entry SessionEntry) {
+	title := entry.Title
+	if title == "" {
+		title = "(untitled)"
+	}
+	utils.Cprintf(commandColor, "%s  %s  %s\n", entry.ID, entry.Updated.Local().Format("2006-01-02 15:04"), title)
+	utils.Cprintf("gray", "    %s, %d messages, %d tokens in, %d out\n", entry.Model, entry.Messages, entry.Usage.InputTokens, entry.Usage.OutputTokens)
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHistoryIndexKeepsConcurrentSaves(t *testing.T) {
+	SetHistoryDir(t.TempDir())
+	defer SetHistoryDir("")
+
+	var saves sync.WaitGroup
+	for i := range 20 {
+		saves.Add(1)
+		go func() {
+			defer saves.Done()
+			if err := updateHistoryIndex(SessionEntry{ID: fmt.Sprintf("session%02d", i)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	saves.Wait()
+
+	entries, err := readHistoryIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 20 {
+		t.Errorf("the index has %d of the 20 sessions saved at once", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(history.dir, historyIndexFile+".lock")); !os.IsNotExist(err) {
+		t.Errorf("the lock was left behind: %v", err)
+	}
+}
+
+func TestHistoryIndexTakesOverStaleLock(t *testing.T) {
+	SetHistoryDir(t.TempDir())
+	defer SetHistoryDir("")
+	// a pid past the usual pid_max, so no process has it
+	if err := os.WriteFile(filepath.Join(history.dir, historyIndexFile+".lock"), []byte("99999999"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateHistoryIndex(SessionEntry{ID: "session01"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := readHistoryIndex(); len(entries) != 1 {
+		t.Errorf("the index has %d sessions, want the one saved", len(entries))
+	}
+}
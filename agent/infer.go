@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # SCHEMA INFERENCE
+// Drafting a tool file from example calls, for `super-claude tools infer`, so a new endpoint starts from more than a blank schema
+//   - An example is a JSON file holding {"request": {...}, "response": ...}, or only the response
+//   - The input schema has a property for each field of the requests, typed from their values, with the fields every example has required
+//   - Placeholders in --url, such as {code}, are required string inputs, and --url adds an endpoint section calling it
+//   - The description is a skeleton: a TODO for what the tool is for, then the shape of the responses, which tells Claude what it gets back
+//   - Every property description is a TODO with an example value, the draft is for a person to finish, not for use as it is
+const (
+	inferMaxFields    = 30 // fields listed in the response shape before the rest are summarized
+	exampleValueLimit = 40 // bytes of an example value shown in a property's description
+)
+
+// ToolInference says what tool to draft and where, for InferTool
+type ToolInference struct {
+	Name   string
+	URL    string // the endpoint to call, e.g. ${GO_POSTAL_URL}/postal_codes/{code}; no endpoint section if empty
+	Method string // GET unless set, which sends the inputs not in the URL as query parameters
+	Force  bool   // overwrite a tool file that already exists
+}
+
+type toolExample struct {
+	Request  map[string]any
+	Response any
+}
+
+var (
+	toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	urlEnvVar       = regexp.MustCompile(`\$\{\w+\}`) // expanded before the placeholders are filled
+	inferMethods    = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+)
+
+// InferTool writes dir/<name>/<name>.json, drafted from the example files, and returns its path
+func InferTool(exampleFiles []string, dir string, opts ToolInference) (string, error) {
+	if !toolNamePattern.MatchString(opts.Name) {
+		return "", fmt.Errorf("invalid tool name '%s', it must be 1 to 64 letters, digits, _ or -", opts.Name)
+	}
+	var examples []toolExample
+	for _, filename := range exampleFiles {
+		example, err := loadToolExample(filename)
+		if err != nil {
+			return "", err
+		}
+		examples = append(examples, example)
+	}
+	tool, err := inferTool(examples, opts)
+	if err != nil {
+		return "", err
+	}
+	if err := writeToolFile(dir, tool, opts.Force); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tool.Name, tool.Name+".json"), nil
+}
+
+// loadToolExample reads a request and response pair, or a file that is only the response
+func loadToolExample(filename string) (toolExample, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return toolExample{}, fmt.Errorf("failed to read example: %v", err)
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return toolExample{}, fmt.Errorf("failed to parse example %s: %v", filename, err)
+	}
+	pair, ok := value.(map[string]any)
+	if !ok || len(pair) == 0 || len(pair) > 2 {
+		return toolExample{Response: value}, nil
+	}
+	for key := range pair {
+		if key != "request" && key != "response" {
+			return toolExample{Response: value}, nil
+		}
+	}
+	example := toolExample{Response: pair["response"]}
+	if request, ok := pair["request"]; ok && request != nil {
+		if example.Request, ok = request.(map[string]any); !ok {
+			return toolExample{}, fmt.Errorf("the request in example %s must be a JSON object, as tool inputs are", filename)
+		}
+	}
+	return example, nil
+}
+
+func inferTool(examples []toolExample, opts ToolInference) (*toolFile, error) {
+	var requests, responses []any
+	for _, example := range examples {
+		if example.Request != nil {
+			requests = append(requests, example.Request)
+		}
+		if example.Response != nil {
+			responses = append(responses, example.Response)
+		}
+	}
+
+	input := map[string]any{"type": "object", "properties": map[string]any{}}
+	if len(requests) > 0 {
+		input = inferSchema(requests)
+		if len(requests) < len(examples) {
+			delete(input, "required") // some calls had no input at all
+		}
+	}
+	props := input["properties"].(map[string]any)
+	required, _ := input["required"].([]string)
+	for _, match := range urlParamPattern.FindAllStringSubmatch(urlEnvVar.ReplaceAllString(opts.URL, ""), -1) {
+		name := match[1]
+		if _, ok := props[name]; !ok {
+			props[name] = map[string]any{"type": "string", "description": "TODO: describe " + name + ", which goes in the URL path"}
+		}
+		if !slices.Contains(required, name) {
+			required = append(required, name)
+		}
+	}
+
+	desc := "TODO: say what " + opts.Name + " does and when to use it."
+	if len(responses) > 0 {
+		desc += "\n\nReturns " + describeSchema(inferSchema(responses), 0) + "."
+	}
+	tool := &toolFile{Tool: anthropic.Tool{
+		Name:        opts.Name,
+		Description: desc,
+		InputSchema: anthropic.InputSchema{Type: "object", Properties: props, Required: required},
+	}}
+
+	if opts.URL != "" {
+		method := strings.ToUpper(opts.Method)
+		if method == "" {
+			method = "GET"
+		}
+		if !slices.Contains(inferMethods, method) {
+			return nil, fmt.Errorf("unknown method '%s', expected one of %s", opts.Method, strings.Join(inferMethods, ", "))
+		}
+		tool.Endpoint = &Endpoint{Method: method, URL: opts.URL, Headers: map[string]string{"Accept": "application/json"}}
+	} else if opts.Method != "" {
+		return nil, fmt.Errorf("--method needs --url")
+	}
+	return tool, nil
+}
+
+// inferSchema is a JSON schema that the values all match, with a TODO description and an example for each leaf
+func inferSchema(values []any) map[string]any {
+	types := map[string]bool{}
+	var objects []map[string]any
+	var items []any
+	var example any
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			continue
+		case map[string]any:
+			types["object"] = true
+			objects = append(objects, v)
+		case []any:
+			types["array"] = true
+			items = append(items, v...)
+		case string:
+			types["string"] = true
+		case bool:
+			types["boolean"] = true
+		case float64:
+			if v == math.Trunc(v) {
+				types["integer"] = true
+			} else {
+				types["number"] = true
+			}
+		}
+		if example == nil {
+			example = v
+		}
+	}
+	if types["number"] && types["integer"] {
+		delete(types, "integer")
+	}
+
+	if len(types) != 1 {
+		schema := map[string]any{"description": "TODO: describe this"}
+		if len(types) > 1 {
+			seen := make([]string, 0, len(types))
+			for t := range types {
+				seen = append(seen, t)
+			}
+			slices.Sort(seen)
+			schema["description"] = "TODO: describe this, seen as " + strings.Join(seen, " and ")
+		}
+		return schema
+	}
+	switch {
+	case types["object"]:
+		props := map[string]any{}
+		var required []string
+		for _, name := range fieldNames(objects) {
+			var fieldValues []any
+			everywhere := true
+			for _, object := range objects {
+				value, ok := object[name]
+				if !ok || value == nil {
+					everywhere = false
+				}
+				fieldValues = append(fieldValues, value)
+			}
+			props[name] = inferSchema(fieldValues)
+			if everywhere {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	case types["array"]:
+		schema := map[string]any{"type": "array", "description": "TODO: describe this"}
+		if len(items) > 0 {
+			schema["items"] = inferSchema(items)
+		}
+		return schema
+	}
+	for t := range types {
+		return map[string]any{"type": t, "description": "TODO: describe this, e.g. " + exampleValue(example)}
+	}
+	return nil
+}
+
+// fieldNames are the fields of any of the objects, sorted since decoded JSON objects don't keep their order
+func fieldNames(objects []map[string]any) []string {
+	var names []string
+	for _, object := range objects {
+		for key := range object {
+			if !slices.Contains(names, key) {
+				names = append(names, key)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func exampleValue(v any) string {
+	out, _ := json.Marshal(v)
+	s := string(out)
+	if len(s) > exampleValueLimit {
+		s = s[:runeStart(s, exampleValueLimit)] + "..."
+	}
+	return s
+}
+
+// describeSchema puts the shape of an inferred schema in words, e.g. "a JSON object with city (string) and state (string)"
+func describeSchema(schema map[string]any, depth int) string {
+	t, _ := schema["type"].(string)
+	switch t {
+	case "object":
+		props, _ := schema["properties"].(map[string]any)
+		if len(props) == 0 {
+			return "a JSON object"
+		}
+		if depth >= 2 {
+			return "an object"
+		}
+		names := sortedKeys(props)
+		var fields []string
+		for _, name := range names[:min(len(names), inferMaxFields)] {
+			field, _ := props[name].(map[string]any)
+			fields = append(fields, fmt.Sprintf("%s (%s)", name, strings.TrimPrefix(describeSchema(field, depth+1), "a JSON ")))
+		}
+		if len(names) > inferMaxFields {
+			fields = append(fields, fmt.Sprintf("%d more fields", len(names)-inferMaxFields))
+		}
+		prefix := "a JSON object with "
+		if depth > 0 {
+			prefix = "an object with "
+		}
+		return prefix + joinList(fields)
+	case "array":
+		items, ok := schema["items"].(map[string]any)
+		if !ok {
+			return "a JSON array"
+		}
+		if depth > 0 {
+			return "an array of " + plural(describeSchema(items, depth+1))
+		}
+		return "a JSON array of " + plural(describeSchema(items, depth+1))
+	case "":
+		return "a value of varying type"
+	}
+	if depth == 0 {
+		return "a JSON " + t
+	}
+	return t
+}
+
+// plural turns "an object with ..." into "objects with ...", and string into strings
+func plural(s string) string {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "an "), "a ")
+	word, rest, _ := strings.Cut(s, " ")
+	if rest != "" {
+		rest = " " + rest
+	}
+	return word + "s" + rest
+}
+
+// joinList is "a, b and c"
+func joinList(items []string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # INPUT
+// Reading user input, with line editing and history when attached to a terminal
+//   - Up/down browse history, Ctrl+R searches it, history persists across sessions
+//   - A line starting with ``` reads a fenced block until the closing ```
+//   - /paste reads everything until a blank line, for pasting multi-line text
+const (
+	continuationPrompt = "... "
+	historyFileName    = ".super-claude_history"
+)
+
+// LineReader reads one line of user input, returning io.EOF when input ends
+type LineReader interface {
+	ReadLine(prompt string) (string, error)
+	Close() error
+}
+
+// NewLineReader returns a terminal reader with editing and history if stdin is a terminal,
+// and a plain line reader otherwise
+func NewLineReader() (LineReader, error) {
+	if !readline.DefaultIsTerminal() {
+		return NewScannerReader(bufio.NewScanner(os.Stdin)), nil
+	}
+	return NewTerminalReader(defaultHistoryFile())
+}
+
+// ReadSecret reads a line without echoing it if stdin is a terminal, e.g. for an API key
+func ReadSecret(prompt string) (string, error) {
+	if !readline.DefaultIsTerminal() {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+	secret, err := readline.Password(prompt)
+	return strings.TrimSpace(string(secret)), err
+}
+
+// StdinIsPiped reports whether stdin is a pipe or file rather than a terminal
+func StdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// StdoutIsTerminal reports whether output goes to a terminal rather than a pipe or file
+func StdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFileName)
+}
+
+type terminalReader struct {
+	rl *readline.Instance
+}
+
+// NewTerminalReader reads from the terminal with line editing, persisting history to historyFile
+func NewTerminalReader(historyFile string) (LineReader, error) {
+	rl, err := readline.NewEx(&readline.Config{
+		HistoryFile:       historyFile,
+		HistorySearchFold: true,
+		InterruptPrompt:   "^C",
+		EOFPrompt:         "exit",
+		Stdout:            os.Stderr, // prompts and echo, leaving stdout to the replies
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &terminalReader{rl: rl}, nil
+}
+
+func (r *terminalReader) ReadLine(prompt string) (string, error) {
+	r.rl.SetPrompt(prompt)
+	line, err := r.rl.Readline()
+	if errors.Is(err, readline.ErrInterrupt) && line != "" {
+		return "", nil // Ctrl+C clears the line, on an empty line it is reported
+	}
+	return line, err
+}
+
+func (r *terminalReader) Close() error {
+	return r.rl.Close()
+}
+
+type scannerReader struct {
+	scanner *bufio.Scanner
+}
+
+// NewScannerReader reads lines from a scanner, printing the prompt to stderr but without editing or history
+func NewScannerReader(scanner *bufio.Scanner) LineReader {
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // long pasted lines
+	return &scannerReader{scanner: scanner}
+}
+
+func (r *scannerReader) ReadLine(prompt string) (string, error) {
+	os.Stderr.WriteString(prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scannerReader) Close() error {
+	return nil
+}
+
+// readMultiline keeps reading lines until done reports the end of the block, returning the lines read
+func readMultiline(in LineReader, done func(line string) bool) ([]string, error) {
+	var lines []string
+	for {
+		line, err := in.ReadLine(continuationPrompt)
+		if err != nil {
+			return lines, err
+		}
+		if done(line) {
+			return append(lines, line), nil
+		}
+		lines = append(lines, line)
+	}
+}
+
+// handleUserInput reads the next message, returning false when the user quits
+func handleUserInput(in LineReader, interrupts *interrupter) (string, bool) {
+	input, err := in.ReadLine(utils.Csprintf(userColor, "%s: ", "You"))
+	if errors.Is(err, readline.ErrInterrupt) {
+		return "", !interrupts.interrupt()
+	}
+	if err != nil {
+		return "", false
+	}
+	if strings.TrimSpace(input) != "" {
+		interrupts.reset()
+	}
+	if strings.ToLower(strings.TrimSpace(input)) == "exit" {
+		return "", false
+	}
+
+	trimmed := strings.TrimSpace(input)
+	switch {
+	case strings.HasPrefix(trimmed, "```") && !(len(trimmed) > 3 && strings.HasSuffix(trimmed, "```")):
+		// Fenced block, kept with its fences so Claude sees the code block
+		lines, _ := readMultiline(in, func(line string) bool { return strings.TrimSpace(line) == "```" })
+		return strings.Join(append([]string{input}, lines...), "\n"), true
+	case strings.ToLower(trimmed) == "/paste":
+		lines, _ := readMultiline(in, func(line string) bool { return line == "" })
+		return strings.TrimSpace(strings.Join(lines, "\n")), true
+	}
+	return input, true
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # INTERRUPTS
+// Ctrl+C handling for the REPL
+//   - The first Ctrl+C during a turn cancels the in-flight API call or tool and returns to the prompt
+//   - A second Ctrl+C, during the turn or at the prompt, saves the conversation and exits
+//   - At the prompt a single Ctrl+C only clears the line
+type interrupter struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc // cancels the running turn, nil at the prompt
+	pending bool               // the last thing the user did was press Ctrl+C
+}
+
+// startTurn returns the context for a turn, cancelled by the next Ctrl+C
+func (in *interrupter) startTurn() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	in.mu.Lock()
+	in.cancel = cancel
+	in.pending = false
+	in.mu.Unlock()
+	return ctx
+}
+
+func (in *interrupter) endTurn() {
+	in.mu.Lock()
+	if in.cancel != nil {
+		in.cancel()
+		in.cancel = nil
+	}
+	in.mu.Unlock()
+}
+
+// interrupt handles one Ctrl+C, returning true if it was the second in a row and the user wants out
+func (in *interrupter) interrupt() bool {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.pending {
+		return true
+	}
+	in.pending = true
+	if in.cancel != nil {
+		in.cancel()
+		in.cancel = nil
+		utils.Eprintln("yellow", "\nInterrupted, press Ctrl+C again to exit")
+	} else {
+		utils.Eprintln("yellow", "(press Ctrl+C again to exit)")
+	}
+	return false
+}
+
+// reset forgets an earlier Ctrl+C once the user has moved on
+func (in *interrupter) reset() {
+	in.mu.Lock()
+	in.pending = false
+	in.mu.Unlock()
+}
+
+// trapInterrupts delivers SIGINT to in until the returned function is called.
+// On a second Ctrl+C it waits for the conversation to be free, then calls quit and exits.
+func (in *interrupter) trapInterrupts(convoMu *sync.Mutex, quit func()) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigs:
+				if in.interrupt() {
+					convoMu.Lock()
+					quit()
+					os.Exit(0)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # LOOP LIMITS
+// Safeguards on the tool loop of a turn, so a confused model can't ping-pong tool calls forever
+//   - max_turns caps the requests to Claude in one turn, each round of tool calls taking one more
+//   - max_tool_calls caps the tool calls in one turn, and turn_deadline how long a turn keeps calling tools
+//   - They are checked before each round of tool calls; at a limit, the round is not run and its results say why,
+//     then one last request with tools off has Claude answer from what it found so far
+//   - The turn's result names the limit in Limit, the REPL and Slack say so under the answer,
+//     and one-shot mode prints it and exits with status 3
+type LoopLimits struct {
+	MaxTurns     int
+	MaxToolCalls int
+	Deadline     time.Duration
+}
+
+const (
+	limitTurns     = "max_turns"
+	limitToolCalls = "max_tool_calls"
+	limitDeadline  = "turn_deadline"
+
+	wrapUpPrompt = "You have reached the limit on tool use for this turn, so those tool calls were not run. " +
+		"Answer now with what you have found so far, and say what is left unchecked."
+)
+
+var loopLimits LoopLimits
+
+// SetLoopLimits bounds the tool loop of every turn, a zero value disables that limit
+func SetLoopLimits(l LoopLimits) {
+	loopLimits = l
+}
+
+// loopLimit is the limit that stops a round of calls tool calls from running, "" if nothing does
+func (s *TurnStats) loopLimit(calls int) string {
+	switch {
+	case loopLimits.MaxTurns > 0 && s.Requests >= loopLimits.MaxTurns:
+		return limitTurns
+	case loopLimits.MaxToolCalls > 0 && s.toolCalls+calls > loopLimits.MaxToolCalls:
+		return limitToolCalls
+	case loopLimits.Deadline > 0 && !s.began.IsZero() && time.Since(s.began) >= loopLimits.Deadline:
+		return limitDeadline
+	}
+	return ""
+}
+
+// DescribeLimit says in words what a turn's Limit was
+func DescribeLimit(limit string) string {
+	switch limit {
+	case limitTurns:
+		return fmt.Sprintf("the limit of %d requests a turn (max_turns)", loopLimits.MaxTurns)
+	case limitToolCalls:
+		return fmt.Sprintf("the limit of %d tool calls a turn (max_tool_calls)", loopLimits.MaxToolCalls)
+	case limitDeadline:
+		return fmt.Sprintf("the turn's deadline of %s (turn_deadline)", loopLimits.Deadline)
+	}
+	return limit
+}
+
+// stopLoop answers uses without running them, saying which limit stopped them, and has the next request
+// write the answer with tools off
+func (convo *Conversation) stopLoop(req *anthropic.Request, uses []anthropic.Content, limit string) {
+	convo.appendToolResults(uses, refusedAtLimit(uses, limit))
+	last := &(*convo)[len(*convo)-1]
+	last.Content = append(last.Content, makeTextContent(wrapUpPrompt)...)
+	req.ToolChoice = &anthropic.ToolChoice{Type: "none"}
+}
+
+// refusedAtLimit are the results of tool calls not run because of a limit
+func refusedAtLimit(uses []anthropic.Content, limit string) []anthropic.Content {
+	results := make([]anthropic.Content, len(uses))
+	for i := range uses {
+		results[i] = toolError("not run, the turn reached " + DescribeLimit(limit))
+	}
+	return results
+}
@@ -0,0 +1,499 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # MCP
+// Client for Model Context Protocol servers, whose tools are exposed to Claude alongside the tools directory
+// Servers are declared in a JSON file in the same shape as Claude Desktop's config:
+//
+//	{"mcpServers": {
+//	    "files": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-filesystem", "."]},
+//	    "docs":  {"url": "http://localhost:3001/mcp", "headers": {"Authorization": "Bearer ${DOCS_TOKEN}"}}
+//	}}
+const (
+	mcpProtocolVersion = "2025-03-26"
+	mcpCallTimeout     = 60 * time.Second
+)
+
+type MCPServerConfig struct {
+	// stdio transport
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+
+	// HTTP transport
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type mcpConfigFile struct {
+	MCPServers map[string]MCPServerConfig `json:"mcpServers"`
+}
+
+type mcpTransport interface {
+	call(ctx context.Context, method string, params any) (json.RawMessage, error)
+	notify(method string, params any) error
+	close() error
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int   `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("MCP error %d: %s", e.Code, e.Message)
+}
+
+type mcpTool struct {
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	InputSchema anthropic.InputSchema `json:"inputSchema"`
+}
+
+type mcpCallResult struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text,omitempty"`
+	} `json:"content"`
+	IsError bool `json:"isError"`
+}
+
+var mcpServers []mcpTransport
+
+// LoadMCPServers connects to every server in the config file, registers their tools
+// and returns their definitions. Tool names already registered are skipped.
+func LoadMCPServers(filename string) ([]anthropic.Tool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MCP config: %v", err)
+	}
+	var cfg mcpConfigFile
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal MCP config: %v", err)
+	}
+
+	tools := make([]anthropic.Tool, 0)
+	for name, server := range cfg.MCPServers {
+		t, err := connectMCPServer(server)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to MCP server '%s': %v", name, err)
+		}
+		mcpServers = append(mcpServers, t)
+
+		serverTools, err := listMCPTools(t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tools of MCP server '%s': %v", name, err)
+		}
+		for _, tool := range serverTools {
+			if _, exists := findTool(context.Background(), tool.Name); exists {
+				slog.Warn("skipping MCP tool with a name already in use", "server", name, "tool", tool.Name)
+				continue
+			}
+			definition := anthropic.Tool{Name: tool.Name, Description: tool.Description, InputSchema: tool.InputSchema}
+			registerTool(definition, mcpExecutor(t, tool.Name))
+			tools = append(tools, definition)
+		}
+		slog.Info("connected to MCP server", "server", name, "tools", len(serverTools))
+	}
+	return tools, nil
+}
+
+// CloseMCPServers shuts down stdio servers and ends HTTP sessions
+func CloseMCPServers() {
+	for _, t := range mcpServers {
+		if err := t.close(); err != nil {
+			slog.Warn("error closing MCP server", "error", err)
+		}
+	}
+	mcpServers = nil
+}
+
+func connectMCPServer(cfg MCPServerConfig) (mcpTransport, error) {
+	var t mcpTransport
+	var err error
+	switch {
+	case cfg.Command != "":
+		t, err = newStdioTransport(cfg)
+	case cfg.URL != "":
+		t = newHTTPTransport(cfg)
+	default:
+		return nil, fmt.Errorf("server needs a 'command' or a 'url'")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.call(context.Background(), "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]string{"name": "super-claude", "version": "0.1.0"},
+	})
+	if err != nil {
+		t.close()
+		return nil, fmt.Errorf("initialize failed: %v", err)
+	}
+	err = t.notify("notifications/initialized", nil)
+	if err != nil {
+		t.close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func listMCPTools(t mcpTransport) ([]mcpTool, error) {
+	var tools []mcpTool
+	cursor := ""
+	for {
+		var params any
+		if cursor != "" {
+			params = map[string]string{"cursor": cursor}
+		}
+		raw, err := t.call(context.Background(), "tools/list", params)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		err = json.Unmarshal(raw, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tools/list result: %v", err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// mcpExecutor proxies a tool call to the MCP server, joining its text content into the tool result
+func mcpExecutor(t mcpTransport, name string) useTool {
+	return func(ctx context.Context, params map[string]any) anthropic.Content {
+		raw, err := t.call(ctx, "tools/call", map[string]any{"name": name, "arguments": params})
+		if err != nil {
+			return toolError("calling MCP tool: " + err.Error())
+		}
+		var result mcpCallResult
+		err = json.Unmarshal(raw, &result)
+		if err != nil {
+			return toolError("decoding MCP tool result: " + err.Error())
+		}
+
+		var texts []string
+		for _, c := range result.Content {
+			if c.Type == "text" {
+				texts = append(texts, c.Text)
+			} else {
+				texts = append(texts, fmt.Sprintf("[%s content omitted]", c.Type))
+			}
+		}
+		text := strings.Join(texts, "\n")
+		if result.IsError {
+			return toolError(text)
+		}
+		return anthropic.Content{Type: anthropic.ToolResult, Content: text}
+	}
+}
+
+// ## STDIO TRANSPORT
+// Newline-delimited JSON-RPC over a child process's stdin and stdout
+type stdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex // guards writes, nextID and pending
+	nextID  int
+	pending map[int]chan rpcResponse
+	done    chan struct{}
+}
+
+func newStdioTransport(cfg MCPServerConfig) (*stdioTransport, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start '%s': %v", cfg.Command, err)
+	}
+
+	t := &stdioTransport{cmd: cmd, stdin: stdin, pending: map[int]chan rpcResponse{}, done: make(chan struct{})}
+	go t.readLoop(stdout)
+	return t, nil
+}
+
+func (t *stdioTransport) readLoop(stdout io.Reader) {
+	defer close(t.done)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp rpcResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			slog.Debug("ignoring non JSON-RPC output from MCP server", "line", scanner.Text())
+			continue
+		}
+		if resp.ID == nil || resp.Method != "" {
+			continue // notifications and server-initiated requests are not supported
+		}
+		t.mu.Lock()
+		ch, ok := t.pending[*resp.ID]
+		delete(t.pending, *resp.ID)
+		t.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+func (t *stdioTransport) write(req rpcRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+func (t *stdioTransport) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	ch := make(chan rpcResponse, 1)
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.pending[id] = ch
+	err := t.write(rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	t.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write to MCP server: %v", err)
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-t.done:
+		return nil, fmt.Errorf("MCP server exited")
+	case <-ctx.Done():
+		t.mu.Lock()
+		delete(t.pending, id)
+		t.mu.Unlock()
+		t.notify("notifications/cancelled", map[string]any{"requestId": id, "reason": ctx.Err().Error()})
+		return nil, fmt.Errorf("MCP call '%s' cancelled: %v", method, ctx.Err())
+	case <-time.After(mcpCallTimeout):
+		t.mu.Lock()
+		delete(t.pending, id)
+		t.mu.Unlock()
+		return nil, fmt.Errorf("MCP call '%s' timed out after %s", method, mcpCallTimeout)
+	}
+}
+
+func (t *stdioTransport) notify(method string, params any) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.write(rpcRequest{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (t *stdioTransport) close() error {
+	t.stdin.Close()
+	select {
+	case <-t.done:
+	case <-time.After(2 * time.Second):
+		t.cmd.Process.Kill()
+	}
+	return t.cmd.Wait()
+}
+
+// ## HTTP TRANSPORT
+// Streamable HTTP: each message is POSTed, replies come back as JSON or as a server-sent event stream
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu        sync.Mutex // guards nextID and sessionID
+	nextID    int
+	sessionID string
+}
+
+func newHTTPTransport(cfg MCPServerConfig) *httpTransport {
+	return &httpTransport{url: os.ExpandEnv(cfg.URL), headers: cfg.Headers, client: &http.Client{Timeout: mcpCallTimeout}}
+}
+
+func (t *httpTransport) post(ctx context.Context, req rpcRequest) (*http.Response, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	t.setHeaders(httpReq)
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	if id := resp.Header.Get("Mcp-Session-Id"); id != "" {
+		t.mu.Lock()
+		t.sessionID = id
+		t.mu.Unlock()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("MCP request failed with status code: %d, response body: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+func (t *httpTransport) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	for k, v := range t.headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	t.mu.Unlock()
+}
+
+func (t *httpTransport) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	t.mu.Lock()
+	t.nextID++
+	id := t.nextID
+	t.mu.Unlock()
+
+	resp, err := t.post(ctx, rpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rpcResp *rpcResponse
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		rpcResp, err = readSSEResponse(resp.Body, id)
+	} else {
+		rpcResp = &rpcResponse{}
+		err = json.NewDecoder(resp.Body).Decode(rpcResp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode MCP response: %v", err)
+	}
+	if rpcResp.Error != nil {
+		return nil, rpcResp.Error
+	}
+	return rpcResp.Result, nil
+}
+
+// readSSEResponse reads server-sent events until the response to id arrives
+func readSSEResponse(body io.Reader, id int) (*rpcResponse, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		// A blank line ends the event
+		var resp rpcResponse
+		err := json.Unmarshal([]byte(data.String()), &resp)
+		data.Reset()
+		if err == nil && resp.ID != nil && *resp.ID == id && resp.Method == "" {
+			return &resp, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if data.Len() > 0 {
+		var resp rpcResponse
+		if err := json.Unmarshal([]byte(data.String()), &resp); err == nil && resp.ID != nil && *resp.ID == id {
+			return &resp, nil
+		}
+	}
+	return nil, fmt.Errorf("event stream ended without a response")
+}
+
+func (t *httpTransport) notify(method string, params any) error {
+	resp, err := t.post(context.Background(), rpcRequest{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	sessionID := t.sessionID
+	t.mu.Unlock()
+	if sessionID == "" {
+		return nil
+	}
+
+	// Ending the session is best effort, servers may not support it
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	t.setHeaders(req)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # FILE MENTIONS
+// Expanding @path tokens in REPL input into the file's contents, so "explain @handlers/postal.go" needs no copy-pasting
+//   - The mention stays in the message as written, and each file follows it in a fenced code block named with its path
+//   - Only words starting with @ that name a readable file count, so "@alice" and emails are left alone; trailing punctuation is ignored
+//   - Binary files are skipped and files over maxMentionBytes are cut off, with a warning either way
+const maxMentionBytes = 100 * 1024
+
+var mentionPattern = regexp.MustCompile(`(^|\s)@(\S+)`)
+
+// fenceLanguages are the code block languages of common extensions, the extension itself otherwise
+var fenceLanguages = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".ts": "typescript", ".rb": "ruby", ".rs": "rust",
+	".sh": "bash", ".yml": "yaml", ".md": "markdown", ".txt": "", ".h": "c", ".hpp": "cpp", ".cc": "cpp",
+}
+
+// expandFileMentions appends the files mentioned in input to it as code blocks
+func expandFileMentions(input string) string {
+	var blocks []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(input, -1) {
+		mention, path := mentionedFile(match[2])
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		block, err := mentionBlock(mention, path)
+		if err != nil {
+			utils.Eprintln("yellow", fmt.Sprintf("Not including @%s: %v", mention, err))
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		return input
+	}
+	return input + "\n\n" + strings.Join(blocks, "\n\n")
+}
+
+// mentionedFile is the mention as written and the file it names, trying it without trailing punctuation too,
+// with a path of "" if it names none
+func mentionedFile(word string) (string, string) {
+	for _, mention := range []string{word, strings.TrimRight(word, ".,;:!?)]}'\"")} {
+		path := mention
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, rest)
+			}
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return mention, path
+		}
+	}
+	return word, ""
+}
+
+// mentionBlock reads the file into a fenced code block headed by the mention
+func mentionBlock(mention, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxMentionBytes))
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(trimPartialRune(data)) {
+		return "", fmt.Errorf("it is a binary file")
+	}
+
+	text := string(data)
+	note := ""
+	if info.Size() > maxMentionBytes {
+		text = string(trimPartialRune(data))
+		note = fmt.Sprintf("\n[truncated, the file is %d bytes and only the first %d are included]", info.Size(), maxMentionBytes)
+		utils.Eprintln("yellow", fmt.Sprintf("@%s is %d bytes, including only the first %d", mention, info.Size(), maxMentionBytes))
+	}
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	lang, ok := fenceLanguages[ext]
+	if !ok {
+		lang = strings.TrimPrefix(ext, ".")
+	}
+	return fmt.Sprintf("%s:\n%s%s\n%s\n%s%s", mention, fence, lang, strings.TrimSuffix(text, "\n"), fence, note), nil
+}
+
+// trimPartialRune drops a UTF-8 sequence cut off at the end of data
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
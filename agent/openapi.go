@@ -0,0 +1,411 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"gopkg.in/yaml.v3"
+)
+
+// # OPENAPI IMPORT
+// Generating endpoint tools from an OpenAPI 3 document, one per operation, so onboarding a service needs no hand-written JSON
+//   - A tool is named after the operationId in snake_case, or the method and path, and described by the summary and description
+//   - Path and query parameters and the properties of a JSON body make up the input schema, with $refs resolved
+//   - URLs start with ${<TITLE>_URL} and credentials come from <TITLE>_TOKEN, so profiles can point them at each deployment
+//   - Operations the HTTP executor can't call, e.g. with a required header or a body that isn't a JSON object, are skipped
+var openAPIMethods = []string{"get", "post", "put", "patch", "delete"}
+
+// OpenAPIImport changes how an OpenAPI document is turned into tools, its zero value uses the defaults
+type OpenAPIImport struct {
+	BaseURLEnv string // env var holding the service's URL, <TITLE>_URL by default
+	AuthEnv    string // env var holding its credential, <TITLE>_TOKEN by default
+	Prefix     string // prepended to every tool name
+	Force      bool   // overwrite tool files that already exist
+}
+
+// OpenAPIResult is what an import wrote and what it couldn't
+type OpenAPIResult struct {
+	Tools      []string // names of the tools written
+	Skipped    []string // operations left out, with why
+	BaseURLEnv string
+	AuthEnv    string // empty if no operation needs credentials
+}
+
+type openAPIDoc struct {
+	root map[string]any
+}
+
+var (
+	snakeBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+	nonNameChars  = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+)
+
+// ImportOpenAPI writes a tool directory under dir for each operation of the OpenAPI document in specFile
+func ImportOpenAPI(specFile, dir string, opts OpenAPIImport) (*OpenAPIResult, error) {
+	data, err := os.ReadFile(specFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI document: %v", err)
+	}
+	var root map[string]any
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %v", err)
+	}
+	if version, _ := root["openapi"].(string); !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("only OpenAPI 3 documents are supported, this one has openapi: '%v'", root["openapi"])
+	}
+	doc := &openAPIDoc{root: root}
+
+	envPrefix := "API"
+	if title := envName(doc.get("info", "title")); title != "" {
+		envPrefix = title
+	}
+	result := &OpenAPIResult{BaseURLEnv: opts.BaseURLEnv}
+	if result.BaseURLEnv == "" {
+		result.BaseURLEnv = envPrefix + "_URL"
+	}
+	authEnv := opts.AuthEnv
+	if authEnv == "" {
+		authEnv = envPrefix + "_TOKEN"
+	}
+	baseURL := "${" + result.BaseURLEnv + "}" + doc.serverPath()
+
+	paths, _ := root["paths"].(map[string]any)
+	for _, p := range sortedKeys(paths) {
+		item, _ := doc.resolve(paths[p], nil).(map[string]any)
+		for _, method := range openAPIMethods {
+			op, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			where := strings.ToUpper(method) + " " + p
+			tool, err := doc.tool(method, p, item, op, baseURL, authEnv, opts.Prefix)
+			if err != nil {
+				result.Skipped = append(result.Skipped, where+": "+err.Error())
+				continue
+			}
+			if err := writeToolFile(dir, tool, opts.Force); err != nil {
+				result.Skipped = append(result.Skipped, where+": "+err.Error())
+				continue
+			}
+			result.Tools = append(result.Tools, tool.Name)
+			if tool.Endpoint.Auth != nil || strings.Contains(tool.Endpoint.URL, "${"+authEnv+"}") {
+				result.AuthEnv = authEnv
+			}
+		}
+	}
+	return result, nil
+}
+
+// tool converts one operation into a tool file
+func (d *openAPIDoc) tool(method, p string, item, op map[string]any, baseURL, authEnv, prefix string) (*toolFile, error) {
+	name := toolName(prefix, op["operationId"], method, p)
+	var desc []string
+	for _, key := range []string{"summary", "description"} {
+		if s, _ := op[key].(string); strings.TrimSpace(s) != "" {
+			desc = append(desc, strings.TrimSpace(s))
+		}
+	}
+	if len(desc) == 0 {
+		desc = append(desc, strings.ToUpper(method)+" "+p)
+	}
+
+	props := map[string]any{}
+	var required, query []string
+	rawPath := p
+	params := append(slices.Clone(anySlice(item["parameters"])), anySlice(op["parameters"])...)
+	for _, raw := range params {
+		param, _ := d.resolve(raw, nil).(map[string]any)
+		pname, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		isRequired, _ := param["required"].(bool)
+		switch in {
+		case "path":
+			// the executor's placeholders are word characters only
+			safe := nonNameChars.ReplaceAllString(pname, "_")
+			rawPath = strings.ReplaceAll(rawPath, "{"+pname+"}", "{"+safe+"}")
+			pname, isRequired = safe, true
+		case "query":
+			query = append(query, pname)
+		case "header", "cookie":
+			if isRequired {
+				return nil, fmt.Errorf("needs the %s parameter %s, which tools can't send", in, pname)
+			}
+			continue
+		default:
+			return nil, fmt.Errorf("parameter %s is in an unknown place '%s'", pname, in)
+		}
+		schema, _ := d.resolve(param["schema"], nil).(map[string]any)
+		prop := maps.Clone(schema)
+		if prop == nil {
+			prop = map[string]any{}
+		}
+		if s, _ := param["description"].(string); s != "" && prop["description"] == nil {
+			prop["description"] = s
+		}
+		if len(prop) == 0 {
+			prop["type"] = "string"
+		}
+		props[pname] = prop
+		if isRequired && !slices.Contains(required, pname) {
+			required = append(required, pname)
+		}
+	}
+
+	if body, ok := d.resolve(op["requestBody"], nil).(map[string]any); ok {
+		if method == "get" || method == "delete" {
+			return nil, fmt.Errorf("has a request body, which the executor only sends with POST, PUT and PATCH")
+		}
+		schema, err := d.bodySchema(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyRequired, _ := body["required"].(bool)
+		fields, _ := schema["properties"].(map[string]any)
+		for field, prop := range fields {
+			if _, ok := props[field]; ok {
+				return nil, fmt.Errorf("has both a parameter and a body field named %s", field)
+			}
+			props[field] = prop
+		}
+		if bodyRequired {
+			for _, field := range anySlice(schema["required"]) {
+				if s, ok := field.(string); ok {
+					required = append(required, s)
+				}
+			}
+		}
+	} else {
+		query = nil // without a body, every input not in the path goes in the query string anyway
+	}
+
+	rawURL := baseURL + rawPath
+	auth, authQuery := d.auth(op, authEnv)
+	if authQuery != "" {
+		rawURL += "?" + url.QueryEscape(authQuery) + "=${" + authEnv + "}"
+	}
+	tool := &toolFile{
+		Tool: anthropic.Tool{
+			Name:        name,
+			Description: strings.Join(desc, "\n\n"),
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: props, Required: required},
+		},
+		Endpoint: &Endpoint{
+			Method:  strings.ToUpper(method),
+			URL:     rawURL,
+			Headers: map[string]string{"Accept": "application/json"},
+			Query:   query,
+			Auth:    auth,
+		},
+	}
+	return tool, nil
+}
+
+// bodySchema is the schema of a JSON request body, which must be an object since the executor sends the input as one
+func (d *openAPIDoc) bodySchema(body map[string]any) (map[string]any, error) {
+	content, _ := body["content"].(map[string]any)
+	for _, mediaType := range sortedKeys(content) {
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			continue
+		}
+		media, _ := content[mediaType].(map[string]any)
+		schema, _ := d.resolve(media["schema"], nil).(map[string]any)
+		schema = mergeAllOf(schema)
+		if t, _ := schema["type"].(string); t != "" && t != "object" {
+			return nil, fmt.Errorf("its request body is a JSON %s, tools can only send JSON objects", t)
+		}
+		return schema, nil
+	}
+	return nil, fmt.Errorf("has no JSON request body, tools can only send JSON")
+}
+
+// auth maps the operation's security scheme to endpoint auth, or to the name of a query parameter for API keys sent that way
+func (d *openAPIDoc) auth(op map[string]any, env string) (*EndpointAuth, string) {
+	security, ok := op["security"]
+	if !ok {
+		security = d.root["security"]
+	}
+	for _, requirement := range anySlice(security) {
+		for _, name := range sortedKeys(requirement.(map[string]any)) {
+			scheme, _ := d.resolve(d.get("components", "securitySchemes", name), nil).(map[string]any)
+			kind, _ := scheme["type"].(string)
+			switch kind {
+			case "http":
+				if s, _ := scheme["scheme"].(string); strings.EqualFold(s, "basic") {
+					return &EndpointAuth{Type: "basic", Env: env}, ""
+				}
+				return &EndpointAuth{Type: "bearer", Env: env}, ""
+			case "apiKey":
+				header, _ := scheme["name"].(string)
+				if in, _ := scheme["in"].(string); in == "query" {
+					return nil, header
+				}
+				return &EndpointAuth{Type: "header", Env: env, Header: header}, ""
+			case "oauth2", "openIdConnect":
+				return &EndpointAuth{Type: "bearer", Env: env}, ""
+			}
+		}
+	}
+	return nil, ""
+}
+
+// resolve replaces $refs within the document with what they point to,
+// a schema that refers back to itself is summarized where it recurses
+func (d *openAPIDoc) resolve(v any, refs []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok {
+			if !strings.HasPrefix(ref, "#/") {
+				return map[string]any{"type": "object", "description": "see " + ref}
+			}
+			if slices.Contains(refs, ref) {
+				return map[string]any{"type": "object", "description": "a nested " + ref[strings.LastIndex(ref, "/")+1:]}
+			}
+			var path []string
+			for _, part := range strings.Split(ref[2:], "/") {
+				path = append(path, strings.NewReplacer("~1", "/", "~0", "~").Replace(part))
+			}
+			return d.resolve(d.get(path...), append(slices.Clip(refs), ref))
+		}
+		out := make(map[string]any, len(v))
+		for k, field := range v {
+			if k == "example" || k == "examples" || k == "xml" || k == "externalDocs" || k == "discriminator" {
+				continue // documentation only, and not JSON Schema
+			}
+			out[k] = d.resolve(field, refs)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = d.resolve(item, refs)
+		}
+		return out
+	}
+	return v
+}
+
+// get looks up a path of keys from the root of the document
+func (d *openAPIDoc) get(path ...string) any {
+	var v any = d.root
+	for _, key := range path {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// serverPath is the path of the document's first server, which goes between the base URL and each operation's path
+func (d *openAPIDoc) serverPath() string {
+	servers := anySlice(d.root["servers"])
+	if len(servers) == 0 {
+		return ""
+	}
+	server, _ := servers[0].(map[string]any)
+	raw, _ := server["url"].(string)
+	vars, _ := server["variables"].(map[string]any)
+	for name, v := range vars {
+		def, _ := v.(map[string]any)["default"].(string)
+		raw = strings.ReplaceAll(raw, "{"+name+"}", def)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
+// mergeAllOf folds the properties and required fields of an allOf into one object schema
+func mergeAllOf(schema map[string]any) map[string]any {
+	parts := anySlice(schema["allOf"])
+	if len(parts) == 0 {
+		return schema
+	}
+	merged := map[string]any{"type": "object"}
+	props := map[string]any{}
+	var required []any
+	for _, part := range parts {
+		part, _ := part.(map[string]any)
+		part = mergeAllOf(part)
+		fields, _ := part["properties"].(map[string]any)
+		for k, v := range fields {
+			props[k] = v
+		}
+		required = append(required, anySlice(part["required"])...)
+	}
+	merged["properties"], merged["required"] = props, required
+	return merged
+}
+
+// toolName is the prefix and the operationId in snake_case, or the method and path if there is no operationId
+func toolName(prefix string, operationID any, method, p string) string {
+	name, _ := operationID.(string)
+	if name == "" {
+		parts := []string{method}
+		for _, segment := range strings.Split(p, "/") {
+			if strings.HasPrefix(segment, "{") {
+				segment = "by_" + strings.Trim(segment, "{}")
+			}
+			parts = append(parts, segment)
+		}
+		name = strings.Join(parts, "_")
+	}
+	name = snakeBoundary.ReplaceAllString(name, "${1}_${2}")
+	name = strings.Trim(strings.ToLower(nonNameChars.ReplaceAllString(name, "_")), "_")
+	name = prefix + name
+	if len(name) > 64 {
+		name = name[:64] // the API's limit on tool names
+	}
+	return name
+}
+
+// envName turns a title such as "Go Postal API" into GO_POSTAL
+func envName(v any) string {
+	title, _ := v.(string)
+	title = strings.TrimSuffix(strings.TrimSpace(title), " API")
+	return strings.Trim(strings.ToUpper(nonNameChars.ReplaceAllString(title, "_")), "_")
+}
+
+// writeToolFile writes dir/<name>/<name>.json, the layout LoadToolsFromDirectory reads
+func writeToolFile(dir string, tool *toolFile, force bool) error {
+	toolDir := filepath.Join(dir, tool.Name)
+	filename := filepath.Join(toolDir, tool.Name+".json")
+	if _, err := os.Stat(filename); err == nil && !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", filename)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	data, err := json.MarshalIndent(tool, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tool: %v", err)
+	}
+	if err := os.MkdirAll(toolDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create tool directory: %v", err)
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0o644)
+}
+
+func anySlice(v any) []any {
+	s, _ := v.([]any)
+	return s
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # PERSONAS
+// Named bundles of a system prompt, a default model and a subset of the tools, switched between with --persona or /persona
+//   - A persona's tools are names or globs such as postal_*, all tools if it lists none
+//   - Anything a persona leaves empty comes from the settings the agent started with
+//   - "default" is always there, switching back to those settings
+const defaultPersona = "default"
+
+type Persona struct {
+	SystemPrompt string
+	Model        string
+	Tools        []string
+}
+
+var (
+	personas       = map[string]Persona{}
+	currentPersona = defaultPersona
+
+	// startup holds what the agent started with, for anything a persona leaves empty
+	startup struct {
+		systemPrompt string
+		model        anthropic.Model
+		tools        []anthropic.Tool
+	}
+)
+
+// SetPersonas makes the personas available, to pick from the tools, and records the current settings as the default
+func SetPersonas(p map[string]Persona, tools []anthropic.Tool) error {
+	for name, persona := range p {
+		if name == defaultPersona {
+			return fmt.Errorf("persona '%s' is reserved for the settings the agent starts with", name)
+		}
+		for _, pattern := range persona.Tools {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid tool pattern '%s' in persona %s: %v", pattern, name, err)
+			}
+		}
+	}
+	personas = p
+	startup.systemPrompt, startup.model, startup.tools = systemPrompt, model, tools
+	return nil
+}
+
+// UsePersona switches the system prompt and model to a persona's, returning the tools it may use
+func UsePersona(name string) ([]anthropic.Tool, error) {
+	persona, ok := personas[name]
+	if !ok && name != defaultPersona {
+		return nil, fmt.Errorf("unknown persona '%s', expected one of %s", name, strings.Join(personaNames(), ", "))
+	}
+
+	systemPrompt, model = startup.systemPrompt, startup.model
+	if persona.SystemPrompt != "" {
+		systemPrompt = persona.SystemPrompt
+	}
+	SetModel(persona.Model)
+	tools := startup.tools
+	if len(persona.Tools) > 0 {
+		tools = nil
+		for _, tool := range startup.tools {
+			if slices.ContainsFunc(persona.Tools, func(pattern string) bool { ok, _ := path.Match(pattern, tool.Name); return ok }) {
+				tools = append(tools, tool)
+			}
+		}
+	}
+	if toolChoice != nil && toolChoice.Name != "" && !hasTool(tools, toolChoice.Name) {
+		toolChoice = nil // forcing a tool the persona doesn't have would fail every request
+	}
+	currentPersona = name
+	return tools, nil
+}
+
+// personaNames lists the personas, default first
+func personaNames() []string {
+	names := []string{defaultPersona}
+	for name := range personas {
+		names = append(names, name)
+	}
+	slices.Sort(names[1:])
+	return names
+}
+
+func cmdPersona(_ context.Context, _ *Conversation, args string, t *[]anthropic.Tool) {
+	if args == "" {
+		for _, name := range personaNames() {
+			marker := " "
+			if name == currentPersona {
+				marker = "*"
+			}
+			utils.Cprintf(commandColor, "%s %s\n", marker, name)
+		}
+		return
+	}
+	tools, err := UsePersona(args)
+	if err != nil {
+		utils.Eprintln("red", err.Error())
+		return
+	}
+	*t = tools
+	utils.Cprintf(commandColor, "Switched to the %s persona: %s with %d tools.\n", args, model, len(tools))
+}
@@ -0,0 +1,302 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # POSTAL TOOLS
+// Built-in postal_lookup and postal_distance tools on go-postal, enabled with --postal, for location questions raw lookups can't answer
+//   - Both call go-postal's ${GO_POSTAL_URL}/postal_codes/{code}, with GO_POSTAL_TOKEN as a bearer token if it is set
+//   - Codes are normalized before they are looked up: spaces trimmed, letters upper-cased and ZIP+4 codes such as 30350-1234 cut to the ZIP
+//   - Responses become one shape, code, city, state, country, latitude and longitude, whatever go-postal calls the fields;
+//     a response without a country is taken to be in the US, which is all go-postal covers
+//   - postal_lookup takes a list of codes and can keep only those in a country, postal_distance is the great-circle distance between two
+const (
+	postalURLEnv      = "GO_POSTAL_URL"
+	postalTokenEnv    = "GO_POSTAL_TOKEN"
+	postalCountry     = "US" // of responses that don't say
+	maxPostalLookups  = 50
+	earthRadiusKM     = 6371.0088
+	kilometersPerMile = 1.609344
+)
+
+// PostalCode is a go-postal response in the shape the tools return
+type PostalCode struct {
+	Code      string   `json:"code"`
+	City      string   `json:"city,omitempty"`
+	State     string   `json:"state,omitempty"`
+	Country   string   `json:"country"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// postalFields are the names go-postal, and services like it, give each field, in the order they are tried
+var postalFields = map[string][]string{
+	"code":      {"postal_code", "code", "zip", "zip_code", "zipcode", "post code"},
+	"city":      {"city", "place_name", "place name", "place"},
+	"state":     {"state_code", "state", "state_abbreviation", "state abbreviation", "admin_code1"},
+	"country":   {"country_code", "country", "country abbreviation"},
+	"latitude":  {"latitude", "lat"},
+	"longitude": {"longitude", "lng", "lon", "long"},
+}
+
+var zipPlusFour = regexp.MustCompile(`^(\d{5})-?\d{4}$`)
+
+type postalClient struct {
+	endpoint Endpoint
+}
+
+// LoadPostalTools registers the postal tools on the go-postal at GO_POSTAL_URL and returns their definitions
+func LoadPostalTools() ([]anthropic.Tool, error) {
+	if os.Getenv(postalURLEnv) == "" {
+		return nil, fmt.Errorf("%s is not set, in the environment or under endpoints in the config file", postalURLEnv)
+	}
+	p := &postalClient{endpoint: Endpoint{
+		Method:  http.MethodGet,
+		URL:     "${" + postalURLEnv + "}/postal_codes/{code}",
+		Headers: map[string]string{"Accept": "application/json"},
+	}}
+	if os.Getenv(postalTokenEnv) != "" {
+		p.endpoint.Auth = &EndpointAuth{Type: "bearer", Env: postalTokenEnv}
+	}
+
+	tools := []anthropic.Tool{
+		{
+			Name: "postal_lookup",
+			Description: "Look up postal codes in go-postal, returning each one's code, city, state, country, latitude and longitude as JSON. " +
+				"Takes several codes at once, e.g. to check a customer list, and with country keeps only the codes in that country, listing the others apart. " +
+				"Codes not found are listed under not_found.",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+				"codes":   map[string]any{"type": "array", "items": map[string]any{"type": "string"}, "description": fmt.Sprintf("Postal codes to look up, at most %d, e.g. [\"30350\", \"10001-1234\"]", maxPostalLookups)},
+				"country": map[string]any{"type": "string", "description": "Only return codes in this country, as an ISO 3166 code such as US"},
+			}, Required: []string{"codes"}},
+		},
+		{
+			Name:        "postal_distance",
+			Description: "Compute the great-circle distance between two postal codes from their coordinates in go-postal, returning both codes' details and the distance as JSON",
+			InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+				"from": map[string]any{"type": "string", "description": "Postal code to measure from, e.g. 30350"},
+				"to":   map[string]any{"type": "string", "description": "Postal code to measure to"},
+				"unit": map[string]any{"type": "string", "enum": []string{"km", "mi"}, "description": "Unit of the distance, km unless given"},
+			}, Required: []string{"from", "to"}},
+		},
+	}
+	registerTool(tools[0], p.lookup)
+	registerTool(tools[1], p.distance)
+	return tools, nil
+}
+
+// normalizePostalCode is code as go-postal expects it
+func normalizePostalCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if m := zipPlusFour.FindStringSubmatch(code); m != nil {
+		return m[1]
+	}
+	return code
+}
+
+// get looks a code up, returning false if go-postal doesn't know it
+func (p *postalClient) get(ctx context.Context, code string) (PostalCode, bool, error) {
+	code = normalizePostalCode(code)
+	if code == "" {
+		return PostalCode{}, false, fmt.Errorf("postal codes must not be empty")
+	}
+	req, err := p.endpoint.buildRequest(ctx, map[string]any{"code": code})
+	if err != nil {
+		return PostalCode{}, false, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return PostalCode{}, false, fmt.Errorf("go-postal request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return PostalCode{}, false, fmt.Errorf("failed to read go-postal's response: %v", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return PostalCode{}, false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return PostalCode{}, false, fmt.Errorf("go-postal failed with status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return PostalCode{}, false, fmt.Errorf("go-postal's response for %s is not JSON: %v", code, err)
+	}
+	if list, ok := value.([]any); ok {
+		if len(list) == 0 {
+			return PostalCode{}, false, nil
+		}
+		value = list[0]
+	}
+	fields, ok := value.(map[string]any)
+	if !ok {
+		return PostalCode{}, false, fmt.Errorf("go-postal's response for %s is not a JSON object", code)
+	}
+	return normalizePostalResponse(code, fields), true, nil
+}
+
+// normalizePostalResponse reads a response into a PostalCode, looking one level down for fields missing at the top,
+// as with {"post code": ..., "places": [{"place name": ...}]}
+func normalizePostalResponse(code string, fields map[string]any) PostalCode {
+	find := func(field string) any {
+		for _, name := range postalFields[field] {
+			if v, ok := lookupFold(fields, name); ok {
+				return v
+			}
+		}
+		for _, key := range sortedKeys(fields) {
+			nested := fields[key]
+			if list, ok := nested.([]any); ok && len(list) > 0 {
+				nested = list[0]
+			}
+			if object, ok := nested.(map[string]any); ok {
+				for _, name := range postalFields[field] {
+					if v, ok := lookupFold(object, name); ok {
+						return v
+					}
+				}
+			}
+		}
+		return nil
+	}
+	text := func(field string) string {
+		switch v := find(field).(type) {
+		case string:
+			return strings.TrimSpace(v)
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return ""
+	}
+	number := func(field string) *float64 {
+		switch v := find(field).(type) {
+		case float64:
+			return &v
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return &f
+			}
+		}
+		return nil
+	}
+
+	pc := PostalCode{Code: text("code"), City: text("city"), State: text("state"), Country: strings.ToUpper(text("country")), Latitude: number("latitude"), Longitude: number("longitude")}
+	if pc.Code == "" {
+		pc.Code = code
+	}
+	if pc.Country == "" {
+		pc.Country = postalCountry
+	}
+	return pc
+}
+
+// lookupFold is the value of key in fields, ignoring case
+func lookupFold(fields map[string]any, key string) (any, bool) {
+	if v, ok := fields[key]; ok && v != nil {
+		return v, true
+	}
+	for k, v := range fields {
+		if strings.EqualFold(k, key) && v != nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (p *postalClient) lookup(ctx context.Context, params map[string]any) anthropic.Content {
+	list, _ := params["codes"].([]any)
+	if len(list) == 0 {
+		return toolError("codes must be a non-empty list of postal codes")
+	}
+	if len(list) > maxPostalLookups {
+		return toolError(fmt.Sprintf("at most %d codes can be looked up at once, got %d", maxPostalLookups, len(list)))
+	}
+	country, _ := params["country"].(string)
+	country = strings.ToUpper(strings.TrimSpace(country))
+
+	out := struct {
+		Results        []PostalCode `json:"results"`
+		OtherCountries []PostalCode `json:"other_countries,omitempty"`
+		NotFound       []string     `json:"not_found,omitempty"`
+	}{Results: []PostalCode{}}
+	for _, v := range list {
+		code, ok := v.(string)
+		if !ok {
+			return toolError("codes must be a list of strings")
+		}
+		pc, found, err := p.get(ctx, code)
+		if err != nil {
+			return toolError(err.Error())
+		}
+		switch {
+		case !found:
+			out.NotFound = append(out.NotFound, code)
+		case country != "" && pc.Country != country:
+			out.OtherCountries = append(out.OtherCountries, pc)
+		default:
+			out.Results = append(out.Results, pc)
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return toolError(err.Error())
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: string(data)}
+}
+
+func (p *postalClient) distance(ctx context.Context, params map[string]any) anthropic.Content {
+	unit, _ := params["unit"].(string)
+	if unit == "" {
+		unit = "km"
+	}
+	if unit != "km" && unit != "mi" {
+		return toolError(fmt.Sprintf("unknown unit '%s', expected km or mi", unit))
+	}
+	var ends [2]PostalCode
+	for i, name := range []string{"from", "to"} {
+		code, _ := params[name].(string)
+		pc, found, err := p.get(ctx, code)
+		if err != nil {
+			return toolError(err.Error())
+		}
+		if !found {
+			return toolError(fmt.Sprintf("postal code '%s' was not found", code))
+		}
+		if pc.Latitude == nil || pc.Longitude == nil {
+			return toolError(fmt.Sprintf("go-postal has no coordinates for %s", pc.Code))
+		}
+		ends[i] = pc
+	}
+
+	distance := haversineKM(*ends[0].Latitude, *ends[0].Longitude, *ends[1].Latitude, *ends[1].Longitude)
+	if unit == "mi" {
+		distance /= kilometersPerMile
+	}
+	out := map[string]any{"from": ends[0], "to": ends[1], "distance": math.Round(distance*10) / 10, "unit": unit}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return toolError(err.Error())
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: string(data)}
+}
+
+// haversineKM is the great-circle distance between two points in kilometers
+func haversineKM(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := rad(lat2-lat1), rad(lon2-lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
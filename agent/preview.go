@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # TOOL PREVIEW
+// Showing each tool call's input before it runs, so it can be run as is, fixed in $EDITOR first, or skipped
+//   - Meant for tuning tool schemas: an input that doesn't match its schema is pointed out along with the preview
+//   - An edited input replaces Claude's in the conversation too, with a note in the result, so Claude sees what actually ran
+//   - Only the interactive CLI previews calls, other modes have nobody to ask; approval policies still apply after a preview
+var previewTools bool
+
+// previewInput asks the user a question at the prompt, nil when nobody is there to answer
+var previewInput func(prompt string) (string, error)
+
+// SetPreviewTools shows each tool call's input for accepting, editing or skipping before it runs
+func SetPreviewTools(enabled bool) {
+	previewTools = enabled
+}
+
+// previewed is what the user made of a call: its result if they skipped it, and whether they edited it
+type previewed struct {
+	skipped *anthropic.Content
+	edited  bool
+}
+
+// previewToolUses previews each call in turn, editing uses and the last message in place
+func (convo *Conversation) previewToolUses(uses []anthropic.Content) []previewed {
+	previews := make([]previewed, len(uses))
+	if !previewTools || previewInput == nil {
+		return previews
+	}
+	for i := range uses {
+		use := &uses[i]
+		for decided := false; !decided; {
+			printToolPreview(*use)
+			answer, err := previewInput(utils.Csprintf("yellow", "Run %s? [Y]es, [e]dit, [s]kip: ", use.Name))
+			if err != nil {
+				answer = "s"
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "", "y", "yes":
+				decided = true
+			case "e", "edit":
+				input, err := editToolInput(use.Input)
+				if err != nil {
+					utils.Promptln("red", "Not edited: "+err.Error())
+					continue
+				}
+				if formatToolInput(input) != formatToolInput(use.Input) {
+					use.Input, previews[i].edited = input, true
+				}
+			case "s", "skip", "n", "no":
+				result := toolError("the user skipped this call to " + use.Name)
+				previews[i].skipped, decided = &result, true
+			default:
+				utils.Promptln("red", "Answer y, e or s.")
+			}
+		}
+		if previews[i].edited {
+			convo.replaceToolInput(use.Id, use.Input)
+		}
+	}
+	return previews
+}
+
+// printToolPreview shows the input the question is about, so even in quiet mode
+func printToolPreview(use anthropic.Content) {
+	data, _ := json.MarshalIndent(use.Input, "", "  ")
+	utils.Promptln(toolRequestColor, fmt.Sprintf("%s input:\n%s", use.Name, data))
+	if tool, ok := findTool(context.Background(), use.Name); ok && tool.schema != nil {
+		if err := tool.schema.Validate(use.Input); err != nil {
+			utils.Promptln("yellow", "Doesn't match the tool's schema: "+err.Error())
+		}
+	}
+}
+
+// editToolInput opens the input as JSON in $VISUAL or $EDITOR, vi by default, and reads it back
+func editToolInput(input map[string]any) (map[string]any, error) {
+	file, err := os.CreateTemp("", "tool-input-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	data, _ := json.MarshalIndent(input, "", "  ")
+	_, err = file.Write(append(data, '\n'))
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args, err := splitCommand(editor)
+	if err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("invalid editor '%s'", editor)
+	}
+	cmd := exec.Command(args[0], append(args[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v", editor, err)
+	}
+
+	data, err = os.ReadFile(file.Name())
+	if err != nil {
+		return nil, err
+	}
+	var edited map[string]any
+	if err := json.Unmarshal(data, &edited); err != nil {
+		return nil, fmt.Errorf("the input must be a JSON object: %v", err)
+	}
+	return edited, nil
+}
+
+// replaceToolInput puts an edited input in the tool_use block it came from, in the last assistant message
+func (convo *Conversation) replaceToolInput(id string, input map[string]any) {
+	for i := len(*convo) - 1; i >= 0; i-- {
+		if (*convo)[i].Role != anthropic.Assistant {
+			continue
+		}
+		for j, cont := range (*convo)[i].Content {
+			if cont.Type == anthropic.ToolUse && cont.Id == id {
+				(*convo)[i].Content[j].Input = input
+				return
+			}
+		}
+		return
+	}
+}
+
+// runPreviewed runs the calls that weren't skipped, returning every call's result in order
+func runPreviewed(ctx context.Context, uses []anthropic.Content, previews []previewed) []anthropic.Content {
+	var run []anthropic.Content
+	for i, use := range uses {
+		if previews[i].skipped == nil {
+			run = append(run, use)
+		}
+	}
+	ran := runTools(ctx, run)
+	results := make([]anthropic.Content, len(uses))
+	for i := range uses {
+		if previews[i].skipped != nil {
+			results[i] = *previews[i].skipped
+			continue
+		}
+		results[i], ran = ran[0], ran[1:]
+		if previews[i].edited {
+			results[i].Content = "(The user edited the input before it ran.)\n" + results[i].Content
+		}
+	}
+	return results
+}
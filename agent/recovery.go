@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # CRASH RECOVERY
+// The REPL's conversation kept in a recovery file until the REPL exits cleanly, so no failure loses more than the turn in flight
+//   - Each REPL writes <pid>.json to the recovery directory after every turn, sealed like conversation.json,
+//     and again on SIGTERM, SIGHUP (the terminal closing), a panic or utils.Fatal, then removes it when it exits normally
+//   - A recovery file whose process is gone is a session that didn't exit cleanly; the next REPL offers to restore it,
+//     newest first, and one turned down is deleted
+//   - A restored session carries on the history entry it was saved under, if it had one
+//   - recovery_dir (RECOVERY_DIR) moves the directory and "none" turns recovery off
+const (
+	// signalSaveWait is how long SIGTERM and SIGHUP wait for the turn in flight to let go of the conversation
+	signalSaveWait = 3 * time.Second
+)
+
+// recoveryFile is a recovery file's contents
+type recoveryFile struct {
+	Saved        time.Time       `json:"saved"`
+	Model        anthropic.Model `json:"model"`
+	Session      string          `json:"session,omitempty"` // the history entry, empty until the session is first saved
+	Conversation Conversation    `json:"conversation"`
+}
+
+var recovery struct {
+	dir string // empty when recovery is off
+
+	mu     sync.Mutex
+	active bool // a REPL is running, so Fatal should save it
+}
+
+// SetRecoveryDir keeps recovery files for the REPL in dir, "" turns recovery off
+func SetRecoveryDir(dir string) {
+	recovery.dir = dir
+}
+
+func recoveryPath(pid int) string {
+	return filepath.Join(recovery.dir, strconv.Itoa(pid)+".json")
+}
+
+// saveRecovery writes this process's recovery file
+func saveRecovery(convo Conversation) {
+	if recovery.dir == "" {
+		return
+	}
+	if len(convo) == 0 {
+		clearRecovery() // e.g. after /clear, so an older conversation isn't offered back
+		return
+	}
+	history.mu.Lock()
+	session := history.entry.ID
+	history.mu.Unlock()
+	data, err := json.Marshal(recoveryFile{Saved: time.Now(), Model: model, Session: session, Conversation: convo})
+	if err == nil {
+		if err = os.MkdirAll(recovery.dir, 0o700); err == nil {
+			err = writeSealed(recoveryPath(os.Getpid()), data)
+		}
+	}
+	if err != nil {
+		slog.Warn("could not write the recovery file", "error", err)
+	}
+}
+
+// clearRecovery removes this process's recovery file once the conversation is saved for good
+func clearRecovery() {
+	if recovery.dir == "" {
+		return
+	}
+	if err := os.Remove(recoveryPath(os.Getpid())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("could not remove the recovery file", "error", err)
+	}
+}
+
+// guardRecovery saves convo on SIGTERM, SIGHUP and utils.Fatal until the returned function is called.
+// A signal cancels the turn in flight and waits a little for it to finish first.
+func (convo *Conversation) guardRecovery(convoMu *sync.Mutex, interrupts *interrupter) (stop func()) {
+	if recovery.dir == "" {
+		return func() {}
+	}
+	recovery.mu.Lock()
+	recovery.active = true
+	recovery.mu.Unlock()
+	utils.AtFatal(func() {
+		recovery.mu.Lock()
+		defer recovery.mu.Unlock()
+		if recovery.active {
+			saveRecovery(*convo) // Fatal may be called mid-turn, by the goroutine holding the conversation
+		}
+	})
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			interrupts.endTurn()
+			locked := make(chan struct{})
+			go func() {
+				convoMu.Lock()
+				close(locked)
+			}()
+			select {
+			case <-locked:
+				saveRecovery(*convo)
+			case <-time.After(signalSaveWait):
+				slog.Warn("the turn in flight didn't stop in time, keeping the recovery file from before it")
+			}
+			utils.Eprintf("yellow", "\nGot %s, the session was saved for recovery and will be offered when the REPL starts next\n", sig)
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+		recovery.mu.Lock()
+		recovery.active = false
+		recovery.mu.Unlock()
+	}
+}
+
+// offerRecovery offers to restore the newest session that didn't exit cleanly, asking about older ones if it is turned down
+func (convo *Conversation) offerRecovery() {
+	if recovery.dir == "" || len(*convo) > 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(recovery.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	type orphan struct {
+		path  string
+		saved recoveryFile
+	}
+	var found []orphan
+	for _, path := range files {
+		pid, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		saved, err := readRecovery(path)
+		if err != nil {
+			slog.Warn("skipping an unreadable recovery file", "file", path, "error", err)
+			continue
+		}
+		found = append(found, orphan{path, saved})
+	}
+	slices.SortFunc(found, func(a, b orphan) int { return b.saved.Saved.Compare(a.saved.Saved) })
+
+	for _, o := range found {
+		saved := o.saved
+		when := saved.Saved.Local().Format("15:04")
+		if !sameDay(saved.Saved, time.Now()) {
+			when = saved.Saved.Local().Format("2006-01-02 15:04")
+		}
+		question := fmt.Sprintf("Found an unsaved session from %s (%d messages), resume?", when, len(saved.Conversation))
+		if !confirmFunc(question) {
+			os.Remove(o.path)
+			continue
+		}
+		*convo = saved.Conversation
+		if saved.Session != "" && historyEnabled() {
+			if entry, err := findSession(saved.Session); err == nil {
+				history.mu.Lock()
+				history.entry, history.baseUsage = entry, entry.Usage
+				history.mu.Unlock()
+			}
+		}
+		// the restored session is this process's now, and is saved for recovery under its pid
+		saveRecovery(*convo)
+		os.Remove(o.path)
+		utils.Cprintf("green", "Restored a session of %d messages.\n", len(*convo))
+		return
+	}
+}
+
+func readRecovery(path string) (recoveryFile, error) {
+	var saved recoveryFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return saved, err
+	}
+	if data, err = openData(data); err != nil {
+		return saved, err
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return saved, fmt.Errorf("failed to parse the recovery file: %v", err)
+	}
+	return saved, nil
+}
+
+// processAlive reports whether a process with the pid is still running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Local().Date()
+	by, bm, bd := b.Local().Date()
+	return ay == by && am == bm && ad == bd
+}
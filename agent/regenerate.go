@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # RETRY AND EDIT
+// Replacing the last turn: /retry asks Claude again, optionally with another model or temperature,
+// and /edit sends a changed version of the last message instead
+//   - The turn is everything from the last message you typed on, so its tool calls are made again too
+//   - If the new turn fails or is interrupted, the old one is put back, so the history never ends unanswered
+
+// lastUserTurn is the index of the last message the user typed, or -1. Messages of tool results are skipped
+// even with text in them, such as the wrap-up prompt added when the tool loop is stopped
+func (convo Conversation) lastUserTurn() int {
+	for i := len(convo) - 1; i >= 0; i-- {
+		if convo[i].Role == anthropic.User && !hasToolResult(convo[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replaceTurn drops the turn starting at i and sends content in its place, restoring the turn if no reply replaces it
+func (convo *Conversation) replaceTurn(ctx context.Context, i int, content []anthropic.Content, t *[]anthropic.Tool) {
+	old := append(Conversation(nil), (*convo)[i:]...)
+
+	convo.rollback(i)
+	convo.sendMessage(ctx, content, t)
+	if len(*convo) > i+1 {
+		return
+	}
+	convo.rollback(i)
+	*convo = append(*convo, old...)
+	utils.Eprintln("yellow", "No new reply, kept the previous one.")
+}
+
+// cmdRetry regenerates the last turn: /retry [model] [temperature], e.g. /retry opus 0.7
+func cmdRetry(ctx context.Context, convo *Conversation, args string, t *[]anthropic.Tool) {
+	i := convo.lastUserTurn()
+	if i < 0 {
+		utils.Eprintln("red", "Nothing to retry yet.")
+		return
+	}
+	if budgetStops() {
+		return
+	}
+
+	retryModel, retrySampling := model, sampling
+	for _, arg := range strings.Fields(args) {
+		if temperature, err := strconv.ParseFloat(arg, 64); err == nil {
+			if thinkingBudget > 0 {
+				utils.Eprintln("red", "The temperature can't be changed with extended thinking on.")
+				return
+			}
+			retrySampling.Temperature = &temperature
+		} else {
+			retryModel = anthropic.ParseModel(arg)
+		}
+	}
+	// Only this turn uses them, the session's settings are put back after
+	defer func(m anthropic.Model, s Sampling) { model, sampling = m, s }(model, sampling)
+	model, sampling = retryModel, retrySampling
+
+	convo.replaceTurn(ctx, i, (*convo)[i].Content, t)
+}
+
+// cmdEdit replaces the text of the last message, keeping its attachments, and sends it again
+func cmdEdit(ctx context.Context, convo *Conversation, args string, t *[]anthropic.Tool) {
+	i := convo.lastUserTurn()
+	if i < 0 {
+		utils.Eprintln("red", "Nothing to edit yet.")
+		return
+	}
+	var content []anthropic.Content
+	var text []string
+	for _, cont := range (*convo)[i].Content {
+		if cont.Type == anthropic.Text {
+			text = append(text, cont.Text)
+		} else {
+			content = append(content, cont)
+		}
+	}
+	if strings.TrimSpace(args) == "" {
+		utils.Cprintln(commandColor, "Your last message was:\n"+strings.Join(text, "\n"))
+		utils.Cprintln(commandColor, "Usage: /edit <new message>")
+		return
+	}
+	if budgetStops() {
+		return
+	}
+	convo.replaceTurn(ctx, i, append(content, makeTextContent(args)...), t)
+}
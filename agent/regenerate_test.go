@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+func TestLastUserTurnSkipsToolResults(t *testing.T) {
+	uses := []anthropic.Content{{Type: anthropic.ToolUse, Id: "toolu_1", Name: "postal_codes"}}
+	convo := Conversation{
+		{Role: anthropic.User, Content: makeTextContent("first")},
+		{Role: anthropic.Assistant, Content: makeTextContent("one")},
+		{Role: anthropic.User, Content: makeTextContent("look it up")},
+		{Role: anthropic.Assistant, Content: uses},
+	}
+	convo.stopLoop(&anthropic.Request{}, uses, limitToolCalls)
+	convo = append(convo, anthropic.Message{Role: anthropic.Assistant, Content: makeTextContent("wrapped up")})
+
+	if i := convo.lastUserTurn(); i != 2 {
+		t.Errorf("lastUserTurn() = %d, want 2, the message the user typed rather than the wrap-up prompt", i)
+	}
+	if i := (Conversation{}).lastUserTurn(); i != -1 {
+		t.Errorf("lastUserTurn() = %d for an empty conversation", i)
+	}
+}
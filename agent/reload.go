@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # TOOL RELOADING
+// Picking up tools added to, changed in or removed from the tools directories mid-session, for iterating on schemas
+// without restarting and losing the conversation
+//   - Changes are applied between turns, never while one runs, and Claude is told about them ahead of the next message
+//   - A definition that fails to load keeps every tool as it was, so a half-saved file doesn't take tools away
+//   - Plugins can only be loaded once, so a changed .so needs a restart; its JSON definition reloads like any other
+var toolReload struct {
+	dirs    []string
+	render  func(anthropic.Tool) (anthropic.Tool, error)
+	names   map[string]bool // the tools that came from dirs, as opposed to the built-in ones
+	changed atomic.Bool
+	watcher *fsnotify.Watcher
+}
+
+// WatchToolDirectories reloads the tool definitions in dirs when their files change, rendering each with render.
+// current is the tools loaded from dirs at startup.
+func WatchToolDirectories(dirs []string, current []anthropic.Tool, render func(anthropic.Tool) (anthropic.Tool, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch the tools: %v", err)
+	}
+	for _, dir := range dirs {
+		if err := watchToolDir(watcher, dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	toolReload.dirs, toolReload.render, toolReload.watcher = dirs, render, watcher
+	toolReload.names = map[string]bool{}
+	for _, tool := range current {
+		toolReload.names[tool.Name] = true
+	}
+	go watchToolEvents(watcher)
+	return nil
+}
+
+// watchToolDir watches a tools directory and the tool directories in it, fsnotify isn't recursive
+func watchToolDir(watcher *fsnotify.Watcher, dir string) error {
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %v", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %v", dir, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), "__") {
+			if err := watcher.Add(filepath.Join(dir, entry.Name())); err != nil {
+				return fmt.Errorf("failed to watch %s: %v", entry.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func watchToolEvents(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) && slices.Contains(toolReload.dirs, filepath.Dir(event.Name)) && !strings.HasPrefix(filepath.Base(event.Name), "__") {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watcher.Add(event.Name) // a new tool, whose JSON may be written before the watch starts
+					toolReload.changed.Store(true)
+				}
+			}
+			ext := filepath.Ext(event.Name)
+			if ext == ".json" || ext == ".so" || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				slog.Debug("tool file changed", "file", event.Name, "op", event.Op.String())
+				toolReload.changed.Store(true)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("watching the tools failed", "error", err)
+		}
+	}
+}
+
+// reloadTools applies changes to the tools directories to *t, between turns
+func reloadTools(t *[]anthropic.Tool) {
+	if toolReload.watcher == nil || !toolReload.changed.Swap(false) {
+		return
+	}
+	loaded, files, err := loadToolDirs(toolReload.dirs)
+	if err != nil {
+		utils.Eprintln("red", "Not reloading the tools: "+err.Error())
+		return
+	}
+
+	var added, updated, removed []string
+	tools := make([]anthropic.Tool, 0, len(*t))
+	for _, tool := range *t {
+		if !toolReload.names[tool.Name] {
+			tools = append(tools, tool)
+			continue
+		}
+		i := slices.IndexFunc(loaded, func(l anthropic.Tool) bool { return l.Name == tool.Name })
+		if i < 0 {
+			removed = append(removed, tool.Name)
+			unregisterTool(tool.Name)
+			toolSettingsMu.Lock()
+			clearFileSettings(tool.Name)
+			toolSettingsMu.Unlock()
+			continue
+		}
+		if before, after := toolDefinition(tool), toolDefinition(loaded[i]); before != after {
+			updated = append(updated, tool.Name)
+		}
+		tools = append(tools, loaded[i])
+	}
+	for _, tool := range loaded {
+		if !toolReload.names[tool.Name] {
+			added = append(added, tool.Name)
+			tools = append(tools, tool)
+		}
+	}
+	toolReload.names = map[string]bool{}
+	for _, tool := range loaded {
+		toolReload.names[tool.Name] = true
+		files[tool.Name].toolFile.applySettings()
+		registerTool(tool, files[tool.Name].fn)
+	}
+	*t = tools
+
+	if len(added)+len(updated)+len(removed) == 0 {
+		return
+	}
+	var changes []string
+	if len(added) > 0 {
+		changes = append(changes, "added "+strings.Join(added, ", "))
+	}
+	if len(updated) > 0 {
+		changes = append(changes, "updated "+strings.Join(updated, ", "))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, "removed "+strings.Join(removed, ", "))
+	}
+	utils.Eprintln("yellow", "Reloaded the tools: "+strings.Join(changes, "; "))
+	note := "(System note: the tools changed since the last message: " + strings.Join(changes, "; ") +
+		". Use the current definitions; removed tools can no longer be called.)"
+	pendingAttachments = append(pendingAttachments, anthropic.Content{Type: anthropic.Text, Text: note})
+}
+
+type loadedTool struct {
+	toolFile *toolFile
+	fn       useTool
+}
+
+// loadToolDirs loads the definitions in dirs the way LoadToolsFromDirectory does, without registering them
+func loadToolDirs(dirs []string) ([]anthropic.Tool, map[string]loadedTool, error) {
+	var tools []anthropic.Tool
+	files := map[string]loadedTool{}
+	plugins := registeredTools()
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), "__") {
+				continue
+			}
+			tf, fn, err := loadToolDir(filepath.Join(dir, entry.Name()), plugins)
+			if err != nil {
+				return nil, nil, err
+			}
+			tool := tf.Tool
+			if toolReload.render != nil {
+				if tool, err = toolReload.render(tool); err != nil {
+					return nil, nil, err
+				}
+			}
+			tools = append(tools, tool)
+			files[tool.Name] = loadedTool{tf, fn}
+		}
+	}
+	return tools, files, nil
+}
+
+// toolDefinition is the tool as sent to the API, for telling whether it changed
+func toolDefinition(tool anthropic.Tool) string {
+	data, _ := json.Marshal(tool)
+	return string(data)
+}
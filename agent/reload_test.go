@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// writeEndpointTool writes an endpoint tool's JSON file, with settings added to it
+func writeEndpointTool(t *testing.T, dir, name, settings string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	def := `{"name": "` + name + `", "description": "Look up a thing", "input_schema": {"type": "object", "properties": {}},
+		"endpoint": {"method": "GET", "url": "http://127.0.0.1:1/thing"}` + settings + `}`
+	if err := os.WriteFile(filepath.Join(dir, name, name+".json"), []byte(def), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadClearsRemovedSettings(t *testing.T) {
+	dir := t.TempDir()
+	writeEndpointTool(t, dir, "reload_thing", `, "timeout": "5s", "cache_ttl": "1m", "max_result_bytes": 10`)
+	writeEndpointTool(t, dir, "reload_other", `, "cache_ttl": "1m", "max_result_bytes": 10`)
+	SetToolResultLimit("reload_other", 20) // from the config, over the file
+	tools, err := LoadToolsFromDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Set up as WatchToolDirectories would, without watching: the test says when the files changed
+	toolReload.dirs, toolReload.watcher = []string{dir}, &fsnotify.Watcher{}
+	toolReload.names = map[string]bool{"reload_thing": true, "reload_other": true}
+	defer func() { toolReload.dirs, toolReload.watcher, toolReload.names = nil, nil, nil }()
+	if toolTimeout("reload_thing") != 5*time.Second || toolCacheTTL("reload_thing") != time.Minute || resultLimit("reload_thing") != 10 {
+		t.Fatalf("the file's settings weren't applied")
+	}
+
+	writeEndpointTool(t, dir, "reload_thing", "")
+	writeEndpointTool(t, dir, "reload_other", "")
+	toolReload.changed.Store(true)
+	reloadTools(&tools)
+	if d := toolTimeout("reload_thing"); d != defaultToolTimeout {
+		t.Errorf("timeout %v after it was taken out of the file", d)
+	}
+	if ttl := toolCacheTTL("reload_thing"); ttl != 0 {
+		t.Errorf("cache TTL %v after it was taken out of the file", ttl)
+	}
+	if n := resultLimit("reload_thing"); n != toolResultLimit {
+		t.Errorf("result limit %d after it was taken out of the file", n)
+	}
+	if n := resultLimit("reload_other"); n != 20 {
+		t.Errorf("result limit %d, want the configured 20 to outlast the reload", n)
+	}
+}
+
+func TestReloadWhileToolsRun(t *testing.T) {
+	dir := t.TempDir()
+	writeEndpointTool(t, dir, "reload_busy", `, "timeout": "5s"`)
+	if _, err := LoadToolsFromDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	var readers sync.WaitGroup
+	stop := make(chan struct{})
+	for range 4 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					toolTimeout("reload_busy")
+					toolCacheTTL("reload_busy")
+					resultLimit("reload_busy")
+				}
+			}
+		}()
+	}
+	for range 100 {
+		loadToolDirFile(t, dir, "reload_busy")
+	}
+	close(stop)
+	readers.Wait()
+}
+
+// loadToolDirFile loads a tool's file again and applies its settings, as a reload does
+func loadToolDirFile(t *testing.T, dir, name string) {
+	t.Helper()
+	tf, _, err := loadToolDir(filepath.Join(dir, name), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf.applySettings()
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # ROLES
+// Which tools each caller of the REST API and Slack bot may use, e.g. read-only tools for support staff and mutating ones for SREs
+//   - A role is a list of tool names or globs, as in personas, with * for all of them
+//   - REST API clients send `Authorization: Bearer <key>`, and each key has a role; once keys are configured, requests without one get a 401
+//   - Slack users are given roles by user id, anyone not listed gets the default role
+//   - A caller without a role gets no tools, unless no roles are configured at all, which leaves every tool to everyone as before
+//   - Only the role's tools are sent to the API, and a tool_use for any other is refused before it runs, in case Claude asks anyway
+//   - Only keys of the approver roles may decide the REST API's pending approvals; without API keys there is nobody to tell apart
+type AccessPolicy struct {
+	Roles         map[string][]string // tool names or globs by role
+	APIKeys       []APIKey
+	SlackUsers    map[string]string // role by Slack user id
+	DefaultRole   string            // for Slack users not listed, and REST API requests when there are no API keys
+	ApproverRoles []string          // roles whose API keys may decide tool calls waiting for approval
+}
+
+// APIKey lets a REST API client in with a role, Name is what the audit log records it as
+type APIKey struct {
+	Name string
+	Key  string
+	Role string
+}
+
+type roleKey struct{}
+
+var accessPolicy AccessPolicy
+
+// SetAccessPolicy checks the policy and applies it to the REST API and Slack bot
+func SetAccessPolicy(p AccessPolicy) error {
+	for name, patterns := range p.Roles {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid tool pattern '%s' in role %s: %v", pattern, name, err)
+			}
+		}
+	}
+	known := func(role, what string) error {
+		if _, ok := p.Roles[role]; !ok {
+			return fmt.Errorf("%s has role '%s', which isn't one of the roles", what, role)
+		}
+		return nil
+	}
+	names := map[string]bool{}
+	for i, key := range p.APIKeys {
+		if key.Name == "" {
+			return fmt.Errorf("API key %d has no name", i+1)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("API key name %s is used twice", key.Name)
+		}
+		names[key.Name] = true
+		if key.Key == "" {
+			return fmt.Errorf("API key %s is empty", key.Name)
+		}
+		if err := known(key.Role, "API key "+key.Name); err != nil {
+			return err
+		}
+	}
+	for _, role := range p.ApproverRoles {
+		if _, ok := p.Roles[role]; !ok {
+			return fmt.Errorf("approver role '%s' isn't one of the roles", role)
+		}
+	}
+	for user, role := range p.SlackUsers {
+		if err := known(role, "Slack user "+user); err != nil {
+			return err
+		}
+	}
+	if p.DefaultRole != "" {
+		if err := known(p.DefaultRole, "the default"); err != nil {
+			return err
+		}
+	}
+	accessPolicy = p
+	return nil
+}
+
+// apiCaller finds the name and role of the API key a request is made with, false if the key is missing or unknown
+func apiCaller(r *http.Request) (name, role string, ok bool) {
+	if len(accessPolicy.APIKeys) == 0 {
+		return "", accessPolicy.DefaultRole, true
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return "", "", false
+	}
+	for _, key := range accessPolicy.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			return key.Name, key.Role, true
+		}
+	}
+	return "", "", false
+}
+
+// canApprove reports whether API keys of role may decide pending approvals, which anyone may without API keys
+func canApprove(role string) bool {
+	return len(accessPolicy.APIKeys) == 0 || slices.Contains(accessPolicy.ApproverRoles, role)
+}
+
+// slackRole is the role of a Slack user
+func slackRole(user string) string {
+	if role, ok := accessPolicy.SlackUsers[user]; ok {
+		return role
+	}
+	return accessPolicy.DefaultRole
+}
+
+// roleTools is the part of tools role may use
+func roleTools(role string, tools []anthropic.Tool) []anthropic.Tool {
+	if len(accessPolicy.Roles) == 0 {
+		return tools
+	}
+	allowed := []anthropic.Tool{}
+	for _, tool := range tools {
+		if roleAllows(role, tool.Name) {
+			allowed = append(allowed, tool)
+		}
+	}
+	return allowed
+}
+
+func roleAllows(role, tool string) bool {
+	patterns, ok := accessPolicy.Roles[role]
+	return ok && slices.ContainsFunc(patterns, func(pattern string) bool { ok, _ := path.Match(pattern, tool); return ok })
+}
+
+// withRole limits the tool calls under ctx to those role may make
+func withRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// checkRole refuses a tool call the role under ctx may not make; without a role, as in the CLI, any call may be made
+func checkRole(ctx context.Context, use anthropic.Content) (anthropic.Content, bool) {
+	role, ok := ctx.Value(roleKey{}).(string)
+	if !ok || len(accessPolicy.Roles) == 0 || roleAllows(role, use.Name) {
+		return anthropic.Content{}, true
+	}
+	if role == "" {
+		return toolError(fmt.Sprintf("%s is not available to this user, who has no role", use.Name)), false
+	}
+	return toolError(fmt.Sprintf("%s is not available to the %s role", use.Name, role)), false
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+// # SCENARIOS
+// Scripted conversations in a YAML or JSON file, whose user turns are sent in order whatever Claude replies
+//   - A turn is a message, written as a plain string or as {message: ...}
+//   - variants name the models and system prompts to run the scenario with, for comparing them
+//   - expect says what a turn should do, for super-claude eval, and replies script the API's answers to run it without one
+//   - Tools run for real, with the session's tools, policies and audit log, and there is nobody to approve calls
+type Scenario struct {
+	Name     string         `yaml:"name"`
+	System   string         `yaml:"system"` // the system prompt of variants without their own, the session's if empty
+	Turns    []ScenarioTurn `yaml:"turns"`
+	Variants []Variant      `yaml:"variants"`
+}
+
+type ScenarioTurn struct {
+	Message string                `yaml:"message"`
+	Expect  Expectation           `yaml:"expect"`
+	Replies []anthropictest.Reply `yaml:"replies"` // the fake API's replies until the turn ends, run against it if any turn has them
+}
+
+// UnmarshalYAML reads a turn written as just its message
+func (t *ScenarioTurn) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&t.Message)
+	}
+	type plain ScenarioTurn
+	return node.Decode((*plain)(t))
+}
+
+// Variant is a model and system prompt to run a scenario with, empty fields keep the session's
+type Variant struct {
+	Name       string `yaml:"name"`
+	Model      string `yaml:"model"`
+	System     string `yaml:"system"`
+	SystemFile string `yaml:"system_file"`
+}
+
+// LoadScenario reads a scenario, JSON being YAML as far as the parser is concerned
+func LoadScenario(filename string) (*Scenario, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %v", err)
+	}
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", filename, err)
+	}
+	if len(s.Turns) == 0 {
+		return nil, fmt.Errorf("scenario %s has no turns", filename)
+	}
+	for i, turn := range s.Turns {
+		if turn.Message == "" {
+			return nil, fmt.Errorf("turn %d of scenario %s has no message", i+1, filename)
+		}
+	}
+	return &s, nil
+}
+
+// Mocked reports whether the scenario scripts the API's replies
+func (s *Scenario) Mocked() bool {
+	for _, turn := range s.Turns {
+		if len(turn.Replies) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ScenarioRun is how a scenario went with one variant
+type ScenarioRun struct {
+	Variant Variant
+	Model   anthropic.Model
+	Turns   []ScenarioTurnResult
+}
+
+type ScenarioTurnResult struct {
+	Message string
+	Result  *TurnResult
+	Latency time.Duration
+	Error   string
+}
+
+// Usage sums the usage of every turn
+func (run ScenarioRun) Usage() anthropic.Usage {
+	var usage anthropic.Usage
+	for _, turn := range run.Turns {
+		if turn.Result != nil {
+			usage.Add(turn.Result.Usage)
+		}
+	}
+	return usage
+}
+
+// Latency sums the time every turn took
+func (run ScenarioRun) Latency() time.Duration {
+	var total time.Duration
+	for _, turn := range run.Turns {
+		total += turn.Latency
+	}
+	return total
+}
+
+// RunScenario plays the scenario in a fresh conversation with the variant's model and system prompt.
+// A failed turn is recorded and the conversation carries on, minus the turn.
+func RunScenario(ctx context.Context, s *Scenario, v Variant, tools []anthropic.Tool) (*ScenarioRun, error) {
+	system, err := v.systemPrompt(s)
+	if err != nil {
+		return nil, err
+	}
+	m := model
+	if v.Model != "" {
+		m = anthropic.ParseModel(v.Model)
+	}
+	run := &ScenarioRun{Variant: v, Model: m}
+
+	var convo Conversation
+	for _, turn := range s.Turns {
+		start := len(convo)
+		convo.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(turn.Message)})
+		req := newRequest(nil, tools)
+		req.Model, req.MaxTokens, req.System = m, requestMaxTokens(m), system
+
+		began := time.Now()
+		result, err := convo.exchange(ctx, req, nil)
+		outcome := ScenarioTurnResult{Message: turn.Message, Result: result, Latency: time.Since(began)}
+		if err != nil {
+			outcome.Error = err.Error()
+			convo = convo[:start]
+		}
+		run.Turns = append(run.Turns, outcome)
+		if ctx.Err() != nil {
+			return run, ctx.Err()
+		}
+	}
+	return run, nil
+}
+
+// systemPrompt is the variant's system prompt, falling back to the scenario's and then the session's
+func (v Variant) systemPrompt(s *Scenario) (string, error) {
+	switch {
+	case v.System != "":
+		return v.System, nil
+	case v.SystemFile != "":
+		data, err := os.ReadFile(v.SystemFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the system prompt of variant %s: %v", v.Name, err)
+		}
+		return string(data), nil
+	case s.System != "":
+		return s.System, nil
+	}
+	return systemPrompt, nil
+}
@@ -0,0 +1,322 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// # SERVER
+// REST API exposing the conversation engine to other services
+//   - POST /v1/chat            send a message, optionally continuing a session
+//   - GET  /v1/sessions/{id}   fetch the history and usage of a session
+//   - GET  /v1/approvals       list the tool calls waiting for approval
+//   - POST /v1/approvals/{id}  approve or decline one of them
+//   - GET  /metrics            Prometheus metrics, if enabled
+//   - With API keys configured, the /v1 endpoints need one, chat only offers the tools of its role,
+//     and the approvals are only open to keys of an approver role
+//   - A session belongs to the API key that started it, any other key is told it doesn't exist
+type ChatRequest struct {
+	SessionID string `json:"session_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+type ChatResponse struct {
+	SessionID    string               `json:"session_id"`
+	Reply        string               `json:"reply"`
+	ToolCalls    []ToolCall           `json:"tool_calls"`
+	StopReason   anthropic.StopReason `json:"stop_reason"`
+	Model        anthropic.Model      `json:"model"`
+	FallbackFrom anthropic.Model      `json:"fallback_from,omitempty"` // the model asked for, if a fallback answered
+	Limit        string               `json:"limit,omitempty"`         // the loop limit that cut the tool calls short, if one did
+	Usage        anthropic.Usage      `json:"usage"`
+}
+
+type Session struct {
+	ID       string          `json:"id"`
+	Owner    string          `json:"owner,omitempty"` // the name of the API key that started it
+	Messages Conversation    `json:"messages"`
+	Usage    anthropic.Usage `json:"usage"`
+
+	mu sync.Mutex
+}
+
+type ApprovalDecision struct {
+	Approve bool `json:"approve"`
+}
+
+type Server struct {
+	Tools   *[]anthropic.Tool
+	Metrics http.Handler // served at /metrics if set
+	Store   Store        // where sessions are saved after every turn, only in memory if nil
+
+	mu        sync.Mutex
+	sessions  map[string]*Session
+	approvals approvalQueue
+}
+
+func NewServer(tools *[]anthropic.Tool) *Server {
+	return &Server{Tools: tools, sessions: map[string]*Session{}, approvals: approvalQueue{pending: map[string]*PendingApproval{}}}
+}
+
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat", s.handleChat)
+	mux.HandleFunc("GET /v1/sessions/{id}", requireAPIKey(s.handleGetSession))
+	mux.HandleFunc("GET /v1/approvals", requireApprover(s.handleListApprovals))
+	mux.HandleFunc("POST /v1/approvals/{id}", requireApprover(s.handleDecideApproval))
+	if s.Metrics != nil {
+		mux.Handle("GET /metrics", s.Metrics)
+	}
+	return mux
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	caller, role, ok := apiCaller(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, errAPIKey)
+		return
+	}
+
+	var chatReq ChatRequest
+	err := json.NewDecoder(r.Body).Decode(&chatReq)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(chatReq.Message) == "" {
+		writeJSONError(w, http.StatusBadRequest, "message must not be empty")
+		return
+	}
+
+	session, err := s.session(r.Context(), chatReq.SessionID, caller)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	// Turns within a session are serialized, sessions run independently
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if err := s.refresh(r.Context(), session); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if session.Owner != caller {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("session '%s' not found", session.ID))
+		return
+	}
+
+	start := len(session.Messages)
+	session.Messages.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(chatReq.Message)})
+	req := newRequest(nil, roleTools(role, *s.Tools))
+	ctx, span := startTurnSpan(r.Context(), attribute.String("session.id", session.ID))
+	defer span.End()
+	ctx = withApprover(withAuditSession(withRole(ctx, role), "api:"+session.ID, caller), s.approvals.approver(session.ID, caller))
+	result, err := session.Messages.exchange(ctx, req, nil)
+	session.Usage.Add(result.Usage)
+	if err != nil {
+		// Failed or cancelled: drop the unfinished turn so the saved history doesn't end on an unanswered message
+		session.Messages = session.Messages[:start]
+	}
+	if saveErr := s.save(context.WithoutCancel(ctx), session); saveErr != nil {
+		slog.Error("could not save session", "session", session.ID, "error", saveErr)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, "error making request: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ChatResponse{
+		SessionID:    session.ID,
+		Reply:        result.Text,
+		ToolCalls:    result.ToolCalls,
+		StopReason:   result.StopReason,
+		Model:        result.Model,
+		FallbackFrom: result.FallbackFrom,
+		Limit:        result.Limit,
+		Usage:        session.Usage,
+	})
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeJSONError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	caller, _, _ := apiCaller(r)
+	session, err := s.session(r.Context(), id, caller)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if err := s.refresh(r.Context(), session); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if session.Owner != caller {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("session '%s' not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.approvals.list())
+}
+
+func (s *Server) handleDecideApproval(w http.ResponseWriter, r *http.Request) {
+	var decision ApprovalDecision
+	err := json.NewDecoder(r.Body).Decode(&decision)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	caller, _, _ := apiCaller(r)
+	switch err := s.approvals.decide(r.PathValue("id"), decision.Approve, caller); {
+	case errors.Is(err, errApprovalNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const errAPIKey = "a valid API key is required, as Authorization: Bearer <key>"
+
+// requireAPIKey refuses requests without a known API key, if keys are configured
+func requireAPIKey(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := apiCaller(r); !ok {
+			writeJSONError(w, http.StatusUnauthorized, errAPIKey)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requireApprover refuses requests without an API key of an approver role, if keys are configured
+func requireApprover(handler http.HandlerFunc) http.HandlerFunc {
+	return requireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		if _, role, _ := apiCaller(r); !canApprove(role) {
+			writeJSONError(w, http.StatusForbidden, "this API key's role may not decide tool calls")
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// session returns the session with the given id, from the store if this process hasn't seen it yet,
+// or starts a new one for caller if id is empty. Callers check the owner once they hold the session's lock.
+func (s *Server) session(ctx context.Context, id, caller string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id != "" {
+		if session, ok := s.sessions[id]; ok {
+			return session, nil
+		}
+		session := &Session{ID: id}
+		if s.Store == nil || !sessionIDPattern.MatchString(id) || s.refresh(ctx, session) != nil || session.Messages == nil {
+			return nil, fmt.Errorf("session '%s' not found", id)
+		}
+		s.sessions[id] = session
+		sessionsOpened("api", 1)
+		return session, nil
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{ID: id, Owner: caller, Messages: Conversation{}}
+	s.sessions[id] = session
+	sessionsOpened("api", 1)
+	return session, nil
+}
+
+// refresh loads the session from the store, where another process may have added turns to it.
+// The caller holds the session's lock.
+func (s *Server) refresh(ctx context.Context, session *Session) error {
+	return loadSession(ctx, s.Store, session)
+}
+
+// save writes the session to the store, if there is one. The caller holds the session's lock.
+func (s *Server) save(ctx context.Context, session *Session) error {
+	return saveSession(ctx, s.Store, session)
+}
+
+// loadSession reads the session saved under its id from store, leaving it as it is if store is nil or it isn't saved yet
+func loadSession(ctx context.Context, store Store, session *Session) error {
+	if store == nil {
+		return nil
+	}
+	data, err := store.Get(ctx, session.ID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil // not saved yet
+	}
+	if err != nil {
+		return err
+	}
+	if data, err = openData(data); err != nil {
+		return fmt.Errorf("failed to load session '%s': %v", session.ID, err)
+	}
+	if err := json.Unmarshal(data, session); err != nil {
+		return fmt.Errorf("failed to load session '%s': %v", session.ID, err)
+	}
+	return nil
+}
+
+// saveSession writes the session to store sealed, if store isn't nil
+func saveSession(ctx context.Context, store Store, session *Session) error {
+	if store == nil {
+		return nil
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if data, err = sealData(data); err != nil {
+		return err
+	}
+	return store.Put(ctx, session.ID, data)
+}
+
+// sessionIDPattern matches the ids newSessionID makes, which are also what the store is asked for
+var sessionIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+// testAccess has support keys that may only chat and sre keys that may also decide approvals
+func testAccess(t *testing.T) {
+	t.Helper()
+	err := SetAccessPolicy(AccessPolicy{
+		Roles: map[string][]string{"support": {"read_*"}, "sre": {"*"}},
+		APIKeys: []APIKey{
+			{Name: "portal", Key: "portal-key", Role: "support"},
+			{Name: "oncall", Key: "oncall-key", Role: "sre"},
+			{Name: "oncall-2", Key: "oncall-2-key", Role: "sre"},
+		},
+		ApproverRoles: []string{"sre"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { accessPolicy = AccessPolicy{} })
+}
+
+func call(t *testing.T, handler http.Handler, method, path, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// queueCall queues a tool call made by caller's chat and returns its id and the channel its decision arrives on
+func queueCall(t *testing.T, s *Server, caller string) (string, chan bool) {
+	t.Helper()
+	decided := make(chan bool, 1)
+	ask := s.approvals.approver("session", caller)
+	go func() {
+		approved, _ := ask(context.Background(), anthropic.Content{Id: "toolu_1", Name: "read_file"}, "a test")
+		decided <- approved
+	}()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if list := s.approvals.list(); len(list) == 1 {
+			return list[0].ID, decided
+		}
+	}
+	t.Fatal("the call was never queued")
+	return "", nil
+}
+
+func TestApprovalsNeedApproverRole(t *testing.T) {
+	testAccess(t)
+	s := NewServer(&[]anthropic.Tool{})
+	routes := s.Routes()
+	id, decided := queueCall(t, s, "portal")
+
+	if rec := call(t, routes, "GET", "/v1/approvals", "portal-key", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("a support key listed approvals: %d", rec.Code)
+	}
+	if rec := call(t, routes, "POST", "/v1/approvals/"+id, "portal-key", `{"approve": true}`); rec.Code != http.StatusForbidden {
+		t.Errorf("a support key approved its own call: %d", rec.Code)
+	}
+	if rec := call(t, routes, "POST", "/v1/approvals/"+id, "", `{"approve": true}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a request without a key approved a call: %d", rec.Code)
+	}
+
+	rec := call(t, routes, "GET", "/v1/approvals", "oncall-key", "")
+	var list []PendingApproval
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Caller != "portal" {
+		t.Fatalf("an approver listed %s", rec.Body)
+	}
+	if rec := call(t, routes, "POST", "/v1/approvals/"+id, "oncall-key", `{"approve": true}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("an approver couldn't approve: %d %s", rec.Code, rec.Body)
+	}
+	if !<-decided {
+		t.Error("the approved call was declined")
+	}
+	if rec := call(t, routes, "POST", "/v1/approvals/"+id, "oncall-key", `{"approve": true}`); rec.Code != http.StatusNotFound {
+		t.Errorf("a decided call was decided again: %d", rec.Code)
+	}
+}
+
+func TestApprovalsRefuseOwnCalls(t *testing.T) {
+	testAccess(t)
+	s := NewServer(&[]anthropic.Tool{})
+	routes := s.Routes()
+	id, decided := queueCall(t, s, "oncall")
+
+	if rec := call(t, routes, "POST", "/v1/approvals/"+id, "oncall-key", `{"approve": true}`); rec.Code != http.StatusForbidden {
+		t.Errorf("an approver decided a call of its own chat: %d", rec.Code)
+	}
+	if rec := call(t, routes, "POST", "/v1/approvals/"+id, "oncall-2-key", `{"approve": false}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("another approver couldn't decline: %d %s", rec.Code, rec.Body)
+	}
+	if <-decided {
+		t.Error("the declined call was approved")
+	}
+}
+
+func TestSetAccessPolicyChecksApprovers(t *testing.T) {
+	t.Cleanup(func() { accessPolicy = AccessPolicy{} })
+	roles := map[string][]string{"sre": {"*"}}
+	tests := map[string]AccessPolicy{
+		"unknown approver role": {Roles: roles, ApproverRoles: []string{"admin"}},
+		"unnamed key":           {Roles: roles, APIKeys: []APIKey{{Key: "k", Role: "sre"}}},
+		"duplicate key name":    {Roles: roles, APIKeys: []APIKey{{Name: "a", Key: "k1", Role: "sre"}, {Name: "a", Key: "k2", Role: "sre"}}},
+	}
+	for name, policy := range tests {
+		if err := SetAccessPolicy(policy); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
+
+func TestSessionsBelongToTheirKey(t *testing.T) {
+	testAccess(t)
+	s := NewServer(&[]anthropic.Tool{})
+	routes := s.Routes()
+	id := strings.Repeat("ab", 16)
+	s.sessions[id] = &Session{ID: id, Owner: "portal", Messages: Conversation{}}
+
+	if rec := call(t, routes, "GET", "/v1/sessions/"+id, "portal-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("the owner couldn't fetch its session: %d %s", rec.Code, rec.Body)
+	}
+	if rec := call(t, routes, "GET", "/v1/sessions/"+id, "oncall-key", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("another key fetched the session: %d", rec.Code)
+	}
+	body := `{"session_id": "` + id + `", "message": "Hi"}`
+	if rec := call(t, routes, "POST", "/v1/chat", "oncall-key", body); rec.Code != http.StatusNotFound {
+		t.Errorf("another key continued the session: %d", rec.Code)
+	}
+	if n := len(s.sessions[id].Messages); n != 0 {
+		t.Errorf("another key's message was added to the session, which has %d messages", n)
+	}
+}
+
+func TestFailedTurnIsRolledBack(t *testing.T) {
+	testAccess(t)
+	server := anthropictest.NewServer(
+		anthropictest.Reply{Status: http.StatusBadRequest, Error: "prompt is too long"},
+		anthropictest.Reply{Text: "Hello."},
+	)
+	defer server.Close()
+	anthropic.SetClient(server.Client())
+	s := NewServer(&[]anthropic.Tool{})
+	routes := s.Routes()
+	id := strings.Repeat("cd", 16)
+	s.sessions[id] = &Session{ID: id, Owner: "portal", Messages: Conversation{}}
+
+	body := `{"session_id": "` + id + `", "message": "Hi"}`
+	if rec := call(t, routes, "POST", "/v1/chat", "portal-key", body); rec.Code != http.StatusBadGateway {
+		t.Fatalf("a failed request answered %d %s", rec.Code, rec.Body)
+	}
+	if n := len(s.sessions[id].Messages); n != 0 {
+		t.Fatalf("the failed turn left %d messages in the session", n)
+	}
+	if rec := call(t, routes, "POST", "/v1/chat", "portal-key", body); rec.Code != http.StatusOK {
+		t.Fatalf("the next turn answered %d %s", rec.Code, rec.Body)
+	}
+	if n := len(s.sessions[id].Messages); n != 2 {
+		t.Errorf("the session has %d messages after one answered turn, want 2", n)
+	}
+}
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # SHELL TOOL
+// The built-in run_command tool, running a program on the user's machine
+//   - Commands are split into arguments and run directly, never through a shell
+//   - A command must match the allowlist (if any) and must not match the denylist
+//   - With a denylist, programs that run other commands, like env, sudo, sh -c and xargs, are refused unless allowed by name
+//   - The policy only sees the command line, not what a script or program does once it runs,
+//     so confirming each command is the real safeguard, the lists keep Claude away from the obvious mistakes
+//   - Each command is confirmed interactively unless confirmation is turned off
+const maxCommandOutputBytes = 64 * 1024
+
+// commandWrappers run the command given in their arguments, which deny entries can't see
+var commandWrappers = []string{
+	"env", "sudo", "doas", "su", "xargs", "nohup", "nice", "ionice", "timeout", "time", "command", "exec", "watch",
+	"setsid", "stdbuf", "chroot", "strace", "busybox", "sh", "bash", "zsh", "dash", "ksh", "fish",
+}
+
+// CommandPolicy decides which commands run_command may run. Programs are matched by
+// name, so "rm" also matches "/bin/rm". Allow entries are matched word by word
+// against the start of a command, so "kubectl get" allows "kubectl get pods" but not
+// "kubectl delete pods"; deny entries match their words anywhere after the program,
+// in order, so "kubectl delete" also refuses "kubectl -n postal delete pods".
+type CommandPolicy struct {
+	Allow   []string // if not empty, only matching commands may run
+	Deny    []string // matching commands never run, even if allowed
+	Confirm bool     // ask the user before each command
+}
+
+var (
+	commandPolicy = CommandPolicy{Confirm: true}
+
+	// confirmFunc asks the user a yes/no question, nil when nobody is there to answer
+	confirmFunc func(question string) bool
+	confirmMu   sync.Mutex // one question at a time when tools run in parallel
+)
+
+// LoadCommandTool registers the run_command tool with the given policy and returns its definition
+func LoadCommandTool(policy CommandPolicy) anthropic.Tool {
+	commandPolicy = policy
+	desc := "Run a command on the user's machine and return its combined output and exit code. " +
+		"The command is run directly, not through a shell, so pipes, redirects and variables are not supported."
+	if len(policy.Allow) > 0 {
+		desc += " Only these commands are allowed: " + strings.Join(policy.Allow, ", ") + "."
+	}
+	if len(policy.Deny) > 0 {
+		desc += " These commands are refused: " + strings.Join(policy.Deny, ", ") + ", as are programs that run other commands, like env, sudo or sh -c."
+	}
+	tool := anthropic.Tool{
+		Name:        "run_command",
+		Description: desc,
+		InputSchema: anthropic.InputSchema{Type: "object", Properties: map[string]any{
+			"command": map[string]any{"type": "string", "description": "The command line to run, e.g. kubectl get pods -n postal"},
+		}, Required: []string{"command"}},
+	}
+	registerTool(tool, runCommand)
+	return tool
+}
+
+// setConfirmInput answers run_command confirmations and tool previews by reading from in
+func setConfirmInput(in LineReader) {
+	previewInput = in.ReadLine
+	confirmFunc = func(question string) bool {
+		answer, err := in.ReadLine(utils.Csprintf("yellow", "%s [y/N] ", question))
+		if err != nil {
+			return false
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
+
+func runCommand(ctx context.Context, params map[string]any) anthropic.Content {
+	line, _ := params["command"].(string)
+	args, err := splitCommand(line)
+	if err != nil {
+		return toolError(err.Error())
+	}
+	if len(args) == 0 {
+		return toolError("command is required")
+	}
+	if err := commandPolicy.check(args); err != nil {
+		return toolError(err.Error())
+	}
+	if commandPolicy.Confirm {
+		if confirmFunc == nil {
+			return toolError("commands need confirmation, which isn't possible in this mode (run with --yolo to skip it)")
+		}
+		confirmMu.Lock()
+		ok := confirmFunc(fmt.Sprintf("Run `%s`?", line))
+		confirmMu.Unlock()
+		if !ok {
+			return toolError("the user declined to run this command")
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	result := capOutput(out)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return anthropic.Content{Type: anthropic.ToolResult, Content: fmt.Sprintf("%s\n[exit code %d]", result, exitErr.ExitCode())}
+	}
+	if err != nil {
+		return toolError(err.Error())
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: result + "\n[exit code 0]"}
+}
+
+// capOutput is a command's output, cut off at maxCommandOutputBytes
+func capOutput(out []byte) string {
+	if len(out) > maxCommandOutputBytes {
+		return string(out[:maxCommandOutputBytes]) + fmt.Sprintf("\n[truncated, output was %d bytes]", len(out))
+	}
+	return string(out)
+}
+
+func (p CommandPolicy) check(args []string) error {
+	for _, deny := range p.Deny {
+		if commandContains(args, deny) {
+			return fmt.Errorf("'%s' is denied by the command policy", deny)
+		}
+	}
+	allowed := slices.ContainsFunc(p.Allow, func(allow string) bool { return commandMatches(args, allow) })
+	program := filepath.Base(args[0])
+	if len(p.Deny) > 0 && slices.Contains(commandWrappers, program) && !allowed {
+		return fmt.Errorf("'%s' runs other commands, which the command policy can't check; allow it by name to run it", program)
+	}
+	if len(p.Allow) == 0 || allowed {
+		return nil
+	}
+	return fmt.Errorf("'%s' is not in the list of allowed commands", strings.Join(args, " "))
+}
+
+// commandMatches reports whether the words of pattern are the first arguments of the command, the program by name
+func commandMatches(args []string, pattern string) bool {
+	words := strings.Fields(pattern)
+	if len(words) == 0 || len(words) > len(args) || filepath.Base(args[0]) != filepath.Base(words[0]) {
+		return false
+	}
+	for i, w := range words[1:] {
+		if args[i+1] != w {
+			return false
+		}
+	}
+	return true
+}
+
+// commandContains reports whether the command is pattern's program, by name, with the rest of pattern's words
+// among its arguments in order, whatever options come between them
+func commandContains(args []string, pattern string) bool {
+	words := strings.Fields(pattern)
+	if len(words) == 0 || filepath.Base(args[0]) != filepath.Base(words[0]) {
+		return false
+	}
+	rest := words[1:]
+	for _, arg := range args[1:] {
+		if len(rest) > 0 && arg == rest[0] {
+			rest = rest[1:]
+		}
+	}
+	return len(rest) == 0
+}
+
+// splitCommand splits a command line into arguments, honouring single and double quotes and backslash escapes
+func splitCommand(line string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in command")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCommandPolicy(t *testing.T) {
+	tests := []struct {
+		policy  CommandPolicy
+		command string
+		allowed bool
+	}{
+		{CommandPolicy{Deny: []string{"rm"}}, "rm -rf /tmp/x", false},
+		{CommandPolicy{Deny: []string{"rm"}}, "/bin/rm -rf /tmp/x", false},
+		{CommandPolicy{Deny: []string{"rm"}}, "ls /tmp", true},
+		{CommandPolicy{Deny: []string{"rm"}}, "env rm -rf /tmp/x", false},
+		{CommandPolicy{Deny: []string{"rm"}}, "sudo ls", false},
+		{CommandPolicy{Deny: []string{"rm"}}, "sh -c 'rm -rf /tmp/x'", false},
+		{CommandPolicy{Deny: []string{"rm"}}, "/usr/bin/xargs rm", false},
+		{CommandPolicy{Deny: []string{"rm"}, Allow: []string{"timeout 10 curl"}}, "timeout 10 curl https://example.com", true},
+		{CommandPolicy{Deny: []string{"kubectl delete"}}, "kubectl delete pods x", false},
+		{CommandPolicy{Deny: []string{"kubectl delete"}}, "kubectl -n postal delete pods x", false},
+		{CommandPolicy{Deny: []string{"kubectl delete"}}, "/usr/local/bin/kubectl --context prod delete ns postal", false},
+		{CommandPolicy{Deny: []string{"kubectl delete"}}, "kubectl get pods", true},
+		{CommandPolicy{Allow: []string{"kubectl get"}}, "kubectl get pods", true},
+		{CommandPolicy{Allow: []string{"kubectl get"}}, "/usr/bin/kubectl get pods", true},
+		{CommandPolicy{Allow: []string{"kubectl get"}}, "kubectl delete pods", false},
+		{CommandPolicy{Allow: []string{"kubectl get"}}, "kubectl delete get", false},
+		{CommandPolicy{Allow: []string{"kubectl get"}}, "kubectl", false},
+		{CommandPolicy{Allow: []string{"kubectl get"}, Deny: []string{"kubectl get secrets"}}, "kubectl get -n postal secrets", false},
+		{CommandPolicy{}, "env FOO=1 printenv", true}, // without a denylist there is nothing for a wrapper to get around
+	}
+	for _, tt := range tests {
+		args, err := splitCommand(tt.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.policy.check(args); (err == nil) != tt.allowed {
+			t.Errorf("%+v on `%s`: got %v, want allowed %v", tt.policy, tt.command, err, tt.allowed)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := map[string][]string{
+		`kubectl get pods`:       {"kubectl", "get", "pods"},
+		`grep "two words" file`:  {"grep", "two words", "file"},
+		`echo 'a "b"'  c`:        {"echo", `a "b"`, "c"},
+		`echo a\ b`:              {"echo", "a b"},
+		`curl -H 'X-A: \1' "\""`: {"curl", "-H", `X-A: \1`, `"`},
+	}
+	for line, want := range tests {
+		got, err := splitCommand(line)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("splitCommand(`%s`) = %q, %v, want %q", line, got, err, want)
+		}
+	}
+	if _, err := splitCommand(`echo "unterminated`); err == nil {
+		t.Error("accepted an unterminated quote")
+	}
+}
@@ -0,0 +1,446 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// # SLACK
+// A Slack bot connected with Socket Mode, so it needs no public URL
+//   - Each Slack thread is its own conversation, started by mentioning the bot or messaging it directly
+//   - Later messages in a thread the bot is answering continue the conversation without a mention
+//   - The reply is posted straight away and edited as Claude writes and calls tools, then replaced with the answer
+//   - Threads run independently, turns within a thread one at a time
+const (
+	slackAPIURL     = "https://slack.com/api/"
+	slackMaxText    = 3900 // Slack cuts messages off at 4000 characters
+	slackThreadIdle = 24 * time.Hour
+	slackThinking   = "_Thinking..._"
+	// slackEditInterval is how often a streaming reply is edited at most, well within chat.update's rate limit
+	slackEditInterval = time.Second
+)
+
+type SlackBot struct {
+	AppToken   string // xapp-..., opens Socket Mode connections
+	BotToken   string // xoxb-..., posts and edits messages
+	Tools      []anthropic.Tool
+	APIURL     string // defaults to slackAPIURL
+	HTTPClient *http.Client
+
+	botUser string
+	running sync.WaitGroup
+
+	mu      sync.Mutex
+	threads map[string]*slackThread // by channel/thread_ts
+}
+
+type slackThread struct {
+	messages Conversation
+	updated  time.Time
+
+	mu sync.Mutex
+}
+
+type slackEnvelope struct {
+	Type       string `json:"type"` // hello, events_api or disconnect, among others
+	EnvelopeID string `json:"envelope_id"`
+	Reason     string `json:"reason"`
+	Payload    struct {
+		Event slackEvent `json:"event"`
+	} `json:"payload"`
+}
+
+type slackEvent struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+}
+
+func NewSlackBot(appToken, botToken string, tools []anthropic.Tool) *SlackBot {
+	return &SlackBot{AppToken: appToken, BotToken: botToken, Tools: tools, threads: map[string]*slackThread{}}
+}
+
+// Run answers Slack messages until ctx is done, reconnecting whenever the connection drops
+func (b *SlackBot) Run(ctx context.Context) error {
+	var auth struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.call(ctx, b.BotToken, "auth.test", nil, &auth); err != nil {
+		return fmt.Errorf("failed to check the bot token: %v", err)
+	}
+	b.botUser = auth.UserID
+	defer b.running.Wait()
+
+	delay := time.Second
+	for connected := false; ; {
+		var open struct {
+			URL string `json:"url"`
+		}
+		err := b.call(ctx, b.AppToken, "apps.connections.open", nil, &open)
+		if err != nil && !connected {
+			return fmt.Errorf("failed to open a Socket Mode connection: %v", err)
+		}
+		if err == nil {
+			connected = true
+			err = b.listen(ctx, open.URL)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			delay = time.Second // Slack asked us to reconnect
+			continue
+		}
+		slog.Warn("lost the Slack connection, reconnecting", "error", err, "in", delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, time.Minute)
+	}
+}
+
+// listen reads events from one Socket Mode connection, returning nil when Slack asks for a new one
+func (b *SlackBot) listen(ctx context.Context, url string) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	for {
+		var envelope slackEnvelope
+		if err := conn.ReadJSON(&envelope); err != nil {
+			return err
+		}
+		// Every envelope must be acknowledged within 3 seconds or Slack sends it again
+		if envelope.EnvelopeID != "" {
+			if err := conn.WriteJSON(map[string]string{"envelope_id": envelope.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+		switch envelope.Type {
+		case "hello":
+			slog.Info("connected to Slack", "bot", b.botUser)
+		case "disconnect":
+			slog.Info("Slack asked to reconnect", "reason", envelope.Reason)
+			return nil
+		case "events_api":
+			b.handleEvent(ctx, envelope.Payload.Event)
+		}
+	}
+}
+
+func (b *SlackBot) handleEvent(ctx context.Context, ev slackEvent) {
+	if ev.Subtype != "" || ev.BotID != "" || ev.User == "" || ev.User == b.botUser {
+		return // edits, joins, and messages from bots including this one
+	}
+	mention := "<@" + b.botUser + ">"
+	threadTS := ev.ThreadTS
+	if threadTS == "" {
+		threadTS = ev.TS
+	}
+	key := ev.Channel + "/" + threadTS
+
+	switch {
+	case ev.Type == "app_mention":
+	case ev.Type == "message" && ev.ChannelType == "im":
+	case ev.Type == "message" && ev.ThreadTS != "" && !strings.Contains(ev.Text, mention) && b.hasThread(key):
+		// a follow-up in a thread the bot is answering, mentions arrive as app_mention too
+	default:
+		return
+	}
+	text := strings.TrimSpace(strings.ReplaceAll(ev.Text, mention, ""))
+	if text == "" {
+		return
+	}
+
+	b.running.Add(1)
+	go func() {
+		defer b.running.Done()
+		b.reply(ctx, key, ev.Channel, threadTS, ev.User, text)
+	}()
+}
+
+func (b *SlackBot) hasThread(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.threads[key]
+	return ok
+}
+
+// thread returns the conversation of a Slack thread, starting one if needed and forgetting idle ones
+func (b *SlackBot) thread(key string) *slackThread {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.threads[key]; ok {
+		return t
+	}
+	for k, t := range b.threads {
+		if t.mu.TryLock() {
+			if time.Since(t.updated) > slackThreadIdle {
+				delete(b.threads, k)
+				sessionsOpened("slack", -1)
+			}
+			t.mu.Unlock()
+		}
+	}
+	t := &slackThread{messages: Conversation{}, updated: time.Now()}
+	b.threads[key] = t
+	sessionsOpened("slack", 1)
+	return t
+}
+
+// reply runs a turn of the thread's conversation, showing its progress by editing a placeholder message
+func (b *SlackBot) reply(ctx context.Context, key, channel, threadTS, user, text string) {
+	thread := b.thread(key)
+	thread.mu.Lock()
+	defer thread.mu.Unlock()
+
+	ts, err := b.post(ctx, channel, threadTS, slackThinking)
+	if err != nil {
+		slog.Error("could not reply in Slack", "channel", channel, "error", err)
+		return
+	}
+	// Everything below is called from the turn's own goroutine, one call at a time
+	latest := &TurnResult{}
+	var streamed strings.Builder // the text of the response being streamed, not yet in latest
+	var edited time.Time
+	edit := func(running []string) {
+		shown := *latest
+		if shown.Text != "" && streamed.Len() > 0 {
+			shown.Text += "\n\n"
+		}
+		shown.Text += streamed.String()
+		if err := b.update(ctx, channel, ts, slackProgress(&shown, running)); err != nil {
+			slog.Warn("could not update the Slack reply", "channel", channel, "error", err)
+		}
+		edited = time.Now()
+	}
+	hooks := &turnHooks{
+		progress: func(result *TurnResult, running []string) {
+			latest = result
+			edit(running)
+		},
+		reply: func(*anthropic.Response) { streamed.Reset() },
+	}
+
+	start := len(thread.messages)
+	thread.messages.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(text)})
+	role := slackRole(user)
+	req := newRequest(nil, roleTools(role, b.Tools))
+	req.OnText = func(text string) {
+		streamed.WriteString(text)
+		if time.Since(edited) >= slackEditInterval {
+			edit(nil)
+		}
+	}
+	turnCtx, span := startTurnSpan(ctx, attribute.String("slack.channel", channel), attribute.String("slack.thread", threadTS))
+	result, err := thread.messages.exchange(withAuditSession(withRole(turnCtx, role), "slack:"+key, user), req, hooks)
+	span.End()
+	thread.updated = time.Now()
+
+	if ctx.Err() != nil {
+		// Shutting down: drop the unfinished turn and say so, with a fresh context since ctx is done
+		thread.messages = thread.messages[:start]
+		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		b.update(stopCtx, channel, ts, "_The bot was restarted before it could answer, please ask again._")
+		return
+	}
+	if err != nil {
+		thread.messages = thread.messages[:start]
+		slog.Error("Slack turn failed", "channel", channel, "thread", threadTS, "error", err)
+		b.update(ctx, channel, ts, ":warning: Error making request: "+err.Error())
+		return
+	}
+	answer := slackText(result.Text)
+	if answer == "" {
+		answer = "_(no reply)_"
+	}
+	if len(result.ToolCalls) > 0 {
+		answer += "\n_" + slackToolSummary(result.ToolCalls) + "_"
+	}
+	if result.FallbackFrom != "" {
+		answer += fmt.Sprintf("\n_Answered by %s, %s was unavailable_", result.Model, result.FallbackFrom)
+	}
+	if result.Limit != "" {
+		answer += "\n_Stopped calling tools at " + DescribeLimit(result.Limit) + ", so this may be incomplete_"
+	}
+	chunks := splitSlackText(answer)
+	if err := b.update(ctx, channel, ts, chunks[0]); err != nil {
+		slog.Error("could not post the Slack reply", "channel", channel, "error", err)
+		return
+	}
+	for _, chunk := range chunks[1:] {
+		if _, err := b.post(ctx, channel, threadTS, chunk); err != nil {
+			slog.Error("could not post the Slack reply", "channel", channel, "error", err)
+			return
+		}
+	}
+}
+
+// slackProgress renders a turn in progress: the reply so far, the tools called and the ones running
+func slackProgress(result *TurnResult, running []string) string {
+	var b strings.Builder
+	if result.Text != "" {
+		b.WriteString(slackText(result.Text) + "\n\n")
+	}
+	for _, call := range result.ToolCalls {
+		mark := ":white_check_mark:"
+		if call.IsError {
+			mark = ":x:"
+		}
+		fmt.Fprintf(&b, "%s `%s`\n", mark, call.Name)
+	}
+	for _, name := range running {
+		fmt.Fprintf(&b, ":hourglass_flowing_sand: `%s`\n", name)
+	}
+	if len(running) == 0 {
+		b.WriteString(slackThinking)
+	}
+	return splitSlackText(strings.TrimSpace(b.String()))[0]
+}
+
+func slackToolSummary(calls []ToolCall) string {
+	names := make([]string, 0, len(calls))
+	seen := map[string]bool{}
+	for _, call := range calls {
+		if !seen[call.Name] {
+			seen[call.Name] = true
+			names = append(names, call.Name)
+		}
+	}
+	return "Used " + strings.Join(names, ", ")
+}
+
+var (
+	markdownBold    = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	markdownLink    = regexp.MustCompile(`\[([^\]]+)\]\((https?://[^)\s]+)\)`)
+	markdownHeading = regexp.MustCompile(`(?m)^#{1,6}\s+(.+)$`)
+)
+
+// slackText converts the Markdown Claude writes to Slack's mrkdwn
+func slackText(text string) string {
+	text = markdownBold.ReplaceAllString(text, "*$1*")
+	text = markdownLink.ReplaceAllString(text, "<$2|$1>")
+	return markdownHeading.ReplaceAllString(text, "*$1*")
+}
+
+// splitSlackText breaks text into messages Slack won't cut off, at line breaks where possible and never inside a character
+func splitSlackText(text string) []string {
+	var chunks []string
+	for len(text) > slackMaxText {
+		cut := strings.LastIndex(text[:slackMaxText], "\n")
+		if cut <= 0 {
+			cut = runeStart(text, slackMaxText)
+		}
+		chunks = append(chunks, text[:cut])
+		text = strings.TrimLeft(text[cut:], "\n")
+	}
+	return append(chunks, text)
+}
+
+// post sends a message to a thread, returning its ts for later edits
+func (b *SlackBot) post(ctx context.Context, channel, threadTS, text string) (string, error) {
+	var resp struct {
+		TS string `json:"ts"`
+	}
+	err := b.call(ctx, b.BotToken, "chat.postMessage", map[string]string{"channel": channel, "thread_ts": threadTS, "text": text}, &resp)
+	return resp.TS, err
+}
+
+func (b *SlackBot) update(ctx context.Context, channel, ts, text string) error {
+	return b.call(ctx, b.BotToken, "chat.update", map[string]string{"channel": channel, "ts": ts, "text": text}, nil)
+}
+
+// call invokes a Web API method, waiting out rate limits a few times before giving up
+func (b *SlackBot) call(ctx context.Context, token, method string, params any, out any) error {
+	if params == nil {
+		params = map[string]string{}
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url := b.APIURL
+	if url == "" {
+		url = slackAPIURL
+	}
+	client := b.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "POST", url+method, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s failed: %v", method, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%s failed: %v", method, err)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(max(wait, 1)) * time.Second):
+			}
+			continue
+		}
+
+		var result struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("%s failed with status %d: %v", method, resp.StatusCode, err)
+		}
+		if !result.OK {
+			return fmt.Errorf("%s failed: %s", method, result.Error)
+		}
+		if out != nil {
+			return json.Unmarshal(data, out)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/anthropictest"
+)
+
+// slackAPI is a fake Slack Web API keeping the text of every message posted or edited
+type slackAPI struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	posts []string
+	edits []string
+}
+
+func newSlackAPI(t *testing.T) *slackAPI {
+	api := &slackAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]string
+		json.NewDecoder(r.Body).Decode(&params)
+		api.mu.Lock()
+		if strings.HasSuffix(r.URL.Path, "chat.update") {
+			api.edits = append(api.edits, params["text"])
+		} else {
+			api.posts = append(api.posts, params["text"])
+		}
+		api.mu.Unlock()
+		w.Write([]byte(`{"ok": true, "ts": "1.0"}`))
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func TestSlackReplyStreams(t *testing.T) {
+	answer := "The postal service for 10001 has been healthy all week, with no failed lookups."
+	server := anthropictest.NewServer(anthropictest.Reply{Text: answer})
+	defer server.Close()
+	anthropic.SetClient(server.Client())
+	SetModel("haiku")
+	api := newSlackAPI(t)
+	bot := NewSlackBot("xapp", "xoxb", nil)
+	bot.APIURL = api.URL + "/"
+
+	bot.reply(context.Background(), "C1/1.0", "C1", "1.0", "U1", "how is the postal service?")
+	if len(api.posts) != 1 || api.posts[0] != slackThinking {
+		t.Errorf("posted %q, want the placeholder", api.posts)
+	}
+	if len(api.edits) < 2 {
+		t.Fatalf("edited %q, want the streamed text and then the answer", api.edits)
+	}
+	if streamed := api.edits[0]; !strings.HasPrefix(streamed, answer[:8]) || strings.HasPrefix(streamed, answer) {
+		t.Errorf("first edit %q, want the start of the answer", streamed)
+	}
+	if final := api.edits[len(api.edits)-1]; final != answer {
+		t.Errorf("last edit %q, want %q", final, answer)
+	}
+}
+
+func TestSplitSlackText(t *testing.T) {
+	text := strings.Repeat("é", slackMaxText) // two bytes each, so a cut at slackMaxText bytes falls on a character
+	chunks := splitSlackText(text)
+	if strings.Join(chunks, "") != text {
+		t.Fatal("the chunks don't add up to the text")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > slackMaxText || !utf8.ValidString(chunk) {
+			t.Errorf("chunk %d is %d bytes, valid UTF-8 %v", i, len(chunk), utf8.ValidString(chunk))
+		}
+	}
+
+	lines := strings.Repeat("a", slackMaxText-10) + "\n" + strings.Repeat("b", 20)
+	if chunks := splitSlackText(lines); len(chunks) != 2 || chunks[1] != strings.Repeat("b", 20) {
+		t.Errorf("split %d chunks, want one at the line break", len(chunks))
+	}
+}
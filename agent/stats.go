@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # TURN STATS
+// How long each turn took and how fast the model wrote, for comparing models and prompts with numbers
+//   - Latency is the whole turn by the wall clock, APITime the part spent waiting on responses, ToolTime running tools
+//   - Tokens per second is output tokens over APITime, so it includes the time until the first token
+//   - TimeToFirstToken is from the start of the turn to the first streamed text, so it stays 0 and is left out of the JSON
+//     unless replies are streamed, as in the web UI
+//   - /stats shows the last turn and, for each model, the session's median and p95 latency and mean throughput
+type TurnStats struct {
+	Latency          time.Duration
+	APITime          time.Duration
+	ToolTime         time.Duration
+	TimeToFirstToken time.Duration
+	Requests         int
+	TokensPerSecond  float64
+
+	outputTokens int
+	began        time.Time // for the turn deadline
+	toolCalls    int
+}
+
+func (s TurnStats) MarshalJSON() ([]byte, error) {
+	out := struct {
+		LatencyMS          int64   `json:"latency_ms"`
+		APIMS              int64   `json:"api_ms"`
+		ToolMS             int64   `json:"tool_ms"`
+		TimeToFirstTokenMS int64   `json:"time_to_first_token_ms,omitempty"`
+		Requests           int     `json:"requests"`
+		TokensPerSecond    float64 `json:"tokens_per_second"`
+	}{s.Latency.Milliseconds(), s.APITime.Milliseconds(), s.ToolTime.Milliseconds(), s.TimeToFirstToken.Milliseconds(), s.Requests, math.Round(s.TokensPerSecond*10) / 10}
+	return json.Marshal(out)
+}
+
+// addRequest counts a response that took d and wrote outputTokens
+func (s *TurnStats) addRequest(d time.Duration, outputTokens int) {
+	s.Requests++
+	s.APITime += d
+	s.outputTokens += outputTokens
+}
+
+// finish sets the latency of a turn that began at began, and its throughput
+func (s *TurnStats) finish(began time.Time) {
+	s.Latency = time.Since(began)
+	if s.APITime > 0 {
+		s.TokensPerSecond = float64(s.outputTokens) / s.APITime.Seconds()
+	}
+}
+
+type modelStats struct {
+	model anthropic.Model
+	stats TurnStats
+}
+
+// sessionStats are the REPL's finished turns, in order
+var sessionStats []modelStats
+
+// recordTurnStats keeps a finished REPL turn's stats for /stats
+func recordTurnStats(m anthropic.Model, s TurnStats) {
+	sessionStats = append(sessionStats, modelStats{m, s})
+}
+
+func cmdStats(_ context.Context, _ *Conversation, _ string, _ *[]anthropic.Tool) {
+	if len(sessionStats) == 0 {
+		utils.Cprintln(commandColor, "No turns yet.")
+		return
+	}
+	last := sessionStats[len(sessionStats)-1]
+	utils.Cprintf(commandColor, "Last turn (%s): %s, %s waiting on %d requests, %s running tools, %.1f tokens/s\n",
+		last.model, roundStat(last.stats.Latency), roundStat(last.stats.APITime), last.stats.Requests, roundStat(last.stats.ToolTime), last.stats.TokensPerSecond)
+
+	var models []anthropic.Model
+	byModel := map[anthropic.Model][]TurnStats{}
+	for _, turn := range sessionStats {
+		if _, ok := byModel[turn.model]; !ok {
+			models = append(models, turn.model)
+		}
+		byModel[turn.model] = append(byModel[turn.model], turn.stats)
+	}
+	for _, m := range models {
+		turns := byModel[m]
+		latencies := make([]time.Duration, len(turns))
+		var tokens int
+		var apiTime time.Duration
+		for i, s := range turns {
+			latencies[i] = s.Latency
+			tokens += s.outputTokens
+			apiTime += s.APITime
+		}
+		slices.Sort(latencies)
+		throughput := 0.0
+		if apiTime > 0 {
+			throughput = float64(tokens) / apiTime.Seconds()
+		}
+		utils.Cprintf(commandColor, "%s: %d turns, latency median %s, p95 %s, %.1f tokens/s\n",
+			m, len(turns), roundStat(percentile(latencies, 50)), roundStat(percentile(latencies, 95)), throughput)
+	}
+}
+
+// percentile is the nearest-rank percentile p of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (p*len(sorted) + 99) / 100
+	return sorted[max(i-1, 0)]
+}
+
+func roundStat(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprint(d.Round(time.Millisecond))
+	}
+	return fmt.Sprint(d.Round(10 * time.Millisecond))
+}
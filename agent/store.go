@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// # SESSION STORES
+// Where the REST API and the daemon keep their sessions, so they survive restarts and can be shared between processes
+//   - A store holds JSON documents by key, within a namespace: api for the REST API, daemon for the daemon
+//   - file:///var/lib/claude-agent keeps one file per session, sqlite:///var/lib/claude-agent/sessions.db one row,
+//     and redis://host:6379/0 one key, which any number of REST API processes can share
+//   - Keys are session ids and names, checked by their users to be safe as file names
+var ErrSessionNotFound = errors.New("session not found")
+
+// Store persists sessions, which Get reports as ErrSessionNotFound if they don't exist
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context) ([]string, error)
+	Close() error
+}
+
+// OpenStore opens the store at a file://, sqlite:// or redis:// URL, keeping the sessions of namespace apart from others there
+func OpenStore(url, namespace string) (Store, error) {
+	switch {
+	case strings.HasPrefix(url, "file://"):
+		return NewFileStore(filepath.Join(strings.TrimPrefix(url, "file://"), namespace))
+	case strings.HasPrefix(url, "sqlite://"):
+		return newSQLiteStore(strings.TrimPrefix(url, "sqlite://"), namespace)
+	case strings.HasPrefix(url, "redis://"), strings.HasPrefix(url, "rediss://"):
+		return newRedisStore(url, namespace)
+	}
+	return nil, fmt.Errorf("unsupported session store '%s', expected file://, sqlite:// or redis://", url)
+}
+
+// FileStore keeps each session in its own JSON file in Dir
+type FileStore struct {
+	Dir string
+}
+
+// NewFileStore creates dir if needed and keeps sessions in it
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create sessions directory: %v", err)
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (s *FileStore) file(key string) string {
+	return filepath.Join(s.Dir, key+".json")
+}
+
+func (s *FileStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.file(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+	return data, nil
+}
+
+// Put writes the session to a temporary file and renames it into place, so a crash never leaves half a file
+func (s *FileStore) Put(_ context.Context, key string, data []byte) error {
+	tmp := s.file(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	return os.Rename(tmp, s.file(key))
+}
+
+func (s *FileStore) Delete(_ context.Context, key string) error {
+	if err := os.Remove(s.file(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) List(_ context.Context) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(files))
+	for i, file := range files {
+		keys[i] = strings.TrimSuffix(filepath.Base(file), ".json")
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
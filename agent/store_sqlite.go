@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore keeps sessions as rows of a sessions table, by namespace and key
+type sqliteStore struct {
+	db        *sql.DB
+	namespace string
+}
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS sessions (
+	namespace TEXT NOT NULL,
+	key       TEXT NOT NULL,
+	data      BLOB NOT NULL,
+	updated   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (namespace, key)
+)`
+
+func newSQLiteStore(path, namespace string) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the session database's directory: %v", err)
+	}
+	// WAL and a busy timeout let several processes share the file
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session database: %v", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create the sessions table: %v", err)
+	}
+	return &sqliteStore{db: db, namespace: namespace}, nil
+}
+
+func (s *sqliteStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, "SELECT data FROM sessions WHERE namespace = ? AND key = ?", s.namespace, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session: %v", err)
+	}
+	return data, nil
+}
+
+func (s *sqliteStore) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sessions (namespace, key, data, updated) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (namespace, key) DO UPDATE SET data = excluded.data, updated = excluded.updated`, s.namespace, key, data)
+	if err != nil {
+		return fmt.Errorf("failed to save session: %v", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE namespace = ? AND key = ?", s.namespace, key)
+	return err
+}
+
+func (s *sqliteStore) List(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT key FROM sessions WHERE namespace = ? ORDER BY key", s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # SUB-AGENTS
+// The built-in spawn_agent tool, delegating a scoped task to a fresh conversation and returning only its final answer
+//   - The sub-agent starts with nothing but the task, its own system prompt and the tools it is given
+//   - It runs on a cheaper model unless asked otherwise, so fan-out work doesn't cost main-model prices
+//   - Its tool calls go through the same limits, roles, approvals and audit log as the main conversation's
+//   - It can't spawn sub-agents of its own
+const (
+	spawnAgentTool  = "spawn_agent"
+	subAgentTimeout = 10 * time.Minute
+	subAgentPrompt  = `You are a sub-agent, working on one task delegated to you by another AI assistant.
+Do the task using the tools you have, then reply with your findings in full, as the assistant will see nothing but your final reply.
+You can't ask the assistant or the user questions, so make reasonable assumptions and say what they were.`
+)
+
+type subAgents struct {
+	tools []anthropic.Tool
+	model anthropic.Model
+}
+
+// LoadSpawnAgentTool registers spawn_agent, whose sub-agents may use any of tools and default to model, and returns its definition
+func LoadSpawnAgentTool(tools []anthropic.Tool, model anthropic.Model) anthropic.Tool {
+	s := &subAgents{model: model}
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Name != spawnAgentTool {
+			s.tools = append(s.tools, tool)
+			names = append(names, tool.Name)
+		}
+	}
+
+	props := map[string]any{
+		"task":          map[string]any{"type": "string", "description": "The task, with all the context needed to do it, and what the answer should contain"},
+		"system_prompt": map[string]any{"type": "string", "description": "Instructions for the sub-agent, e.g. the role to take or the format to answer in"},
+		"model":         map[string]any{"type": "string", "enum": []string{"haiku", "sonnet", "opus"}, "description": fmt.Sprintf("The model to run it on, %s if omitted; a stronger one only for hard reasoning", model)},
+	}
+	if len(names) > 0 {
+		props["tools"] = map[string]any{"type": "array", "items": map[string]any{"type": "string", "enum": names}, "description": "The tools the sub-agent may use, none if omitted"}
+	}
+	tool := anthropic.Tool{
+		Name: spawnAgentTool,
+		Description: "Delegate a self-contained task to a sub-agent, a fresh assistant that sees only the task you give it, and get back its final answer. " +
+			"Use it for broad or repetitive work, such as enumerating many cases with a tool, to keep that work out of this conversation. " +
+			"Put everything the sub-agent needs in the task, as it can't see this conversation or ask questions.",
+		InputSchema: anthropic.InputSchema{Type: "object", Properties: props, Required: []string{"task"}},
+	}
+	registerTool(tool, s.spawn)
+	toolSettingsMu.Lock()
+	toolTimeouts[tool.Name] = subAgentTimeout
+	toolSettingsMu.Unlock()
+	return tool
+}
+
+func (s *subAgents) spawn(ctx context.Context, params map[string]any) anthropic.Content {
+	task, _ := params["task"].(string)
+	if strings.TrimSpace(task) == "" {
+		return toolError("task is required")
+	}
+	system := subAgentPrompt
+	if prompt, _ := params["system_prompt"].(string); prompt != "" {
+		system += "\n\n" + prompt
+	}
+	model := s.model
+	if name, _ := params["model"].(string); name != "" {
+		model = anthropic.ParseModel(name)
+	}
+
+	var tools []anthropic.Tool
+	names, _ := params["tools"].([]any)
+	for _, name := range names {
+		i := slices.IndexFunc(s.tools, func(t anthropic.Tool) bool { return t.Name == name })
+		if i < 0 {
+			return toolError(fmt.Sprintf("the sub-agent can't be given the tool %v", name))
+		}
+		if refused, ok := checkToolSet(ctx, anthropic.Content{Name: s.tools[i].Name}); !ok {
+			return refused // so do the session's
+		}
+		if refused, ok := checkRole(ctx, anthropic.Content{Name: s.tools[i].Name}); !ok {
+			return refused // the role's limits apply to the sub-agent too
+		}
+		tools = append(tools, s.tools[i])
+	}
+
+	convo := Conversation{}
+	convo.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(task)})
+	req := &anthropic.Request{
+		Model:         model,
+		MaxTokens:     model.MaxOutputTokens(),
+		System:        system,
+		Tools:         tools,
+		PromptCaching: promptCaching,
+	}
+	slog.Info("spawning sub-agent", "model", model, "tools", len(tools))
+	result, err := convo.exchange(ctx, req, nil)
+	addUsage(result.Usage, model)
+	if err != nil {
+		return toolError(fmt.Sprintf("the sub-agent failed after %d tool calls: %v", len(result.ToolCalls), err))
+	}
+	if result.Text == "" {
+		return toolError("the sub-agent finished without an answer")
+	}
+	return anthropic.Content{Type: anthropic.ToolResult, Content: result.Text}
+}
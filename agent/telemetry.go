@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// # TELEMETRY
+// A span for each turn, with the API calls and tool executions of the turn under it, and tool and session metrics
+// See the telemetry package for exporting them
+const instrumentation = "github.com/hunterjsb/super-claude/agent"
+
+var (
+	tracer = otel.Tracer(instrumentation)
+	meter  = otel.Meter(instrumentation)
+
+	toolCalls, _ = meter.Int64Counter("claude_agent.tool.calls",
+		metric.WithDescription("Tool executions, by tool and whether they failed"))
+	toolDuration, _ = meter.Float64Histogram("claude_agent.tool.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of tool executions"),
+		metric.WithExplicitBucketBoundaries(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600))
+	activeSessions, _ = meter.Int64UpDownCounter("claude_agent.sessions.active",
+		metric.WithDescription("Sessions held by the REST API, daemon and Slack bot, by mode"))
+)
+
+// sessionsOpened counts n sessions started, or -n ended, in a mode such as api, daemon or slack
+func sessionsOpened(mode string, n int) {
+	activeSessions.Add(context.Background(), int64(n), metric.WithAttributes(attribute.String("mode", mode)))
+}
+
+// startTurnSpan starts the span that parents everything sent for one user turn
+func startTurnSpan(ctx context.Context, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "turn", trace.WithAttributes(attrs...))
+}
+
+// tracedTool runs a tool call inside a span, recording its duration and whether it failed
+func tracedTool(ctx context.Context, use anthropic.Content, run func(context.Context, anthropic.Content) anthropic.Content) anthropic.Content {
+	ctx, span := tracer.Start(ctx, "execute_tool "+use.Name, trace.WithAttributes(
+		attribute.String("gen_ai.operation.name", "execute_tool"),
+		attribute.String("gen_ai.tool.name", use.Name),
+		attribute.String("gen_ai.tool.call.id", use.Id),
+	))
+	defer span.End()
+
+	start := time.Now()
+	result := run(ctx, use)
+	attrs := metric.WithAttributes(attribute.String("gen_ai.tool.name", use.Name), attribute.Bool("error", result.IsError))
+	toolDuration.Record(ctx, time.Since(start).Seconds(), attrs)
+	toolCalls.Add(ctx, 1, attrs)
+	if result.IsError {
+		span.SetStatus(codes.Error, result.Content)
+	}
+	return result
+}
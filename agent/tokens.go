@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # CONTEXT WINDOW
+// Checking that a turn fits the model's context window before sending it, instead of finding out from a 400
+//   - Input tokens are counted with the count_tokens endpoint, or estimated when it can't be reached
+//   - A turn that doesn't fit is compacted first, and refused if it still doesn't
+var showTokenCount bool
+
+// SetShowTokenCount prints how many input tokens each turn will send before sending it
+func SetShowTokenCount(enabled bool) {
+	showTokenCount = enabled
+}
+
+// estimateRequestTokens roughly sizes a request's input offline, see estimateTokens
+func estimateRequestTokens(req *anthropic.Request) int {
+	tools, _ := json.Marshal(req.Tools)
+	return estimateTokens(req.Messages) + (len(req.System)+len(tools))/4
+}
+
+// countTokens counts the request's input tokens, reporting false if it had to fall back to an estimate
+func countTokens(ctx context.Context, req *anthropic.Request) (int, bool) {
+	n, err := req.CountTokens(ctx)
+	if err != nil {
+		slog.Debug("count_tokens failed, estimating instead", "error", err)
+		return estimateRequestTokens(req), false
+	}
+	return n, true
+}
+
+// fitContext makes sure the request's input leaves room for max_tokens in the context window,
+// compacting the conversation for c if needed. The count is shown when SetShowTokenCount is on and c reports to the user.
+func (convo *Conversation) fitContext(ctx context.Context, req *anthropic.Request, c compaction) error {
+	show := c.report != nil
+	limit := req.Model.ContextWindow() - req.MaxTokens
+	// Counting costs a round trip, so it is skipped for requests that are clearly small enough
+	if !(show && showTokenCount) && estimateRequestTokens(req) < limit/2 {
+		return nil
+	}
+
+	n, exact := countTokens(ctx, req)
+	if show && showTokenCount {
+		approx := ""
+		if !exact {
+			approx = "~"
+		}
+		c.report(toolRequestColor, fmt.Sprintf("This turn will send %s%d input tokens (%d available)", approx, n, limit))
+	}
+	if n <= limit {
+		return nil
+	}
+
+	compacted, err := convo.compact(ctx, c)
+	if err != nil {
+		slog.Warn("could not compact an oversized conversation", "error", err)
+	} else if compacted > 0 {
+		req.Messages = *convo
+		n, _ = countTokens(ctx, req)
+		c.info(fmt.Sprintf("Compacted %d messages to fit the context window, now about %d input tokens", compacted, n))
+		if n <= limit {
+			return nil
+		}
+	}
+	return fmt.Errorf("this turn would send about %d input tokens, but %s only has room for %d with max_tokens %d", n, req.Model, limit, req.MaxTokens)
+}
@@ -0,0 +1,393 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"golang.org/x/sync/errgroup"
+)
+
+// # TOOLS
+// Tools that Claude can use to take actions on the user's behalf
+// They are specified in the `tools` directory as JSON files
+// The name of each tool is mapped to a function, either a Go plugin or an HTTP endpoint
+// Tools are passed the turn's context and should give up when it is cancelled
+type useTool func(context.Context, map[string]any) anthropic.Content
+
+// ToolMap is the registry of tools the process can run, filled by the tool loaders. It is guarded by toolsMu.
+var ToolMap = map[string]useTool{}
+
+// toolSchemas are the input schemas of registered tools, inputs are checked against them before the tool runs
+var toolSchemas = map[string]anthropic.InputSchema{}
+
+// toolsMu guards the registry, which reloading tools and loading them for embedded agents change while others run
+var toolsMu sync.RWMutex
+
+// registeredTool is a tool's entry in the registry, a function for every tool the process knows
+type registeredTool struct {
+	fn     useTool
+	schema *anthropic.InputSchema // nil if it has none
+}
+
+// registerTool maps a tool's name to its function and records its schema
+func registerTool(tool anthropic.Tool, fn useTool) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	ToolMap[tool.Name] = fn
+	toolSchemas[tool.Name] = tool.InputSchema
+}
+
+// unregisterTool removes a tool from the registry
+func unregisterTool(name string) {
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	delete(ToolMap, name)
+	delete(toolSchemas, name)
+}
+
+// registeredTools is a copy of the registry's functions
+func registeredTools() map[string]useTool {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	return maps.Clone(ToolMap)
+}
+
+type (
+	agentToolsKey struct{}
+	toolSetKey    struct{}
+)
+
+// withToolSet limits the tool calls under ctx to tools, for a session offered only some of the registered ones
+func withToolSet(ctx context.Context, tools []anthropic.Tool) context.Context {
+	names := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		names[tool.Name] = true
+	}
+	return context.WithValue(ctx, toolSetKey{}, names)
+}
+
+// checkToolSet refuses a call to a tool outside the set under ctx, so Claude can't reach a tool it wasn't offered by naming it
+func checkToolSet(ctx context.Context, use anthropic.Content) (anthropic.Content, bool) {
+	names, ok := ctx.Value(toolSetKey{}).(map[string]bool)
+	if !ok || names[use.Name] {
+		return anthropic.Content{}, true
+	}
+	return toolError(fmt.Sprintf("%s is not one of this session's tools", use.Name)), false
+}
+
+// withAgentTools runs the tools under ctx from tools before the registry, for an Agent's own tools
+func withAgentTools(ctx context.Context, tools map[string]registeredTool) context.Context {
+	return context.WithValue(ctx, agentToolsKey{}, tools)
+}
+
+// findTool is the function and schema that run a tool under ctx, false if there is none
+func findTool(ctx context.Context, name string) (registeredTool, bool) {
+	if tools, ok := ctx.Value(agentToolsKey{}).(map[string]registeredTool); ok {
+		if tool, ok := tools[name]; ok {
+			return tool, true
+		}
+	}
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	fn, ok := ToolMap[name]
+	if !ok {
+		return registeredTool{}, false
+	}
+	tool := registeredTool{fn: fn}
+	if schema, ok := toolSchemas[name]; ok {
+		tool.schema = &schema
+	}
+	return tool, true
+}
+
+// toolParallelism bounds how many tool_use blocks from one response run at once
+var toolParallelism = 4
+
+func SetToolParallelism(n int) {
+	if n < 1 {
+		n = 1
+	}
+	toolParallelism = n
+}
+
+// defaultToolTimeout bounds each tool call, unless the tool's JSON file sets its own timeout
+var (
+	defaultToolTimeout = 60 * time.Second
+	toolTimeouts       = map[string]time.Duration{}
+)
+
+// toolSettingsMu guards the per-tool timeouts, cache TTLs and result limits, which reloading the tools replaces while calls read them
+var toolSettingsMu sync.RWMutex
+
+// SetToolTimeout sets the timeout for tools without their own, zero disables it
+func SetToolTimeout(d time.Duration) {
+	defaultToolTimeout = d
+}
+
+func toolTimeout(name string) time.Duration {
+	toolSettingsMu.RLock()
+	defer toolSettingsMu.RUnlock()
+	if d, ok := toolTimeouts[name]; ok {
+		return d
+	}
+	return defaultToolTimeout
+}
+
+// toolError is a failed tool_result, returned to Claude with is_error so it can try another approach
+func toolError(msg string) anthropic.Content {
+	return anthropic.Content{Type: anthropic.ToolResult, Content: "ERROR " + msg, IsError: true}
+}
+
+// runTools executes tool_use blocks concurrently, returning their results in the same order
+func runTools(ctx context.Context, uses []anthropic.Content) []anthropic.Content {
+	results := make([]anthropic.Content, len(uses))
+	var g errgroup.Group
+	g.SetLimit(toolParallelism)
+	for i, use := range uses {
+		g.Go(func() error {
+			results[i] = callTool(ctx, use)
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
+
+// callTool runs the registered handler for a tool_use block, returning early if it times out
+// or ctx is cancelled. A panicking handler is reported to Claude as a failed call.
+func callTool(ctx context.Context, use anthropic.Content) anthropic.Content {
+	if refused, ok := checkToolSet(ctx, use); !ok {
+		auditTool(ctx, use, refused, 0)
+		return refused
+	}
+	if refused, ok := checkRole(ctx, use); !ok {
+		auditTool(ctx, use, refused, 0)
+		return refused
+	}
+	if refused, ok := approveTool(ctx, use); !ok {
+		auditTool(ctx, use, refused, 0)
+		return refused
+	}
+	start := time.Now()
+	result := tracedTool(ctx, use, execTool)
+	auditTool(ctx, use, result, time.Since(start))
+	return result
+}
+
+func execTool(ctx context.Context, use anthropic.Content) anthropic.Content {
+	tool, ok := findTool(ctx, use.Name)
+	if !ok {
+		return toolError("unknown tool: " + use.Name)
+	}
+	if tool.schema != nil {
+		if err := tool.schema.Validate(use.Input); err != nil {
+			return toolError(err.Error())
+		}
+	}
+	if cached, ok := cachedToolResult(use); ok {
+		return cached
+	}
+	result := limitToolResult(use.Name, invokeTool(ctx, tool.fn, use))
+	if !result.IsError {
+		cacheToolResult(use, result)
+	}
+	return result
+}
+
+// invokeTool calls fn, giving up when the tool's timeout passes or ctx is cancelled
+func invokeTool(ctx context.Context, fn useTool, use anthropic.Content) anthropic.Content {
+	timeout := toolTimeout(use.Name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	done := make(chan anthropic.Content, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("tool panicked", "tool", use.Name, "panic", r)
+				done <- toolError(fmt.Sprintf("tool %s crashed: %v", use.Name, r))
+			}
+		}()
+		done <- fn(ctx, use.Input)
+	}()
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return toolError(fmt.Sprintf("tool %s timed out after %s", use.Name, timeout))
+		}
+		return toolError("tool call cancelled: " + ctx.Err().Error())
+	}
+}
+
+// pluginTool adapts a plugin's function to useTool, plugins can't be interrupted so a cancelled call runs on in the background.
+// Plugins written before is_error existed report failures with an "ERROR" prefix, which is treated the same.
+func pluginTool(fn func(map[string]any) anthropic.Content) useTool {
+	return func(_ context.Context, params map[string]any) anthropic.Content {
+		result := fn(params)
+		if strings.HasPrefix(result.Content, "ERROR") {
+			result.IsError = true
+		}
+		return result
+	}
+}
+
+// toolFile is the on-disk format of a tool, a Tool plus its optional endpoint and timeout
+type toolFile struct {
+	anthropic.Tool
+	Endpoint *Endpoint `json:"endpoint,omitempty"`
+	Timeout  string    `json:"timeout,omitempty"` // e.g. "30s", "0" disables it
+	// CacheTTL caches successful results for this long, for tools that return the same thing for the same input
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	// MaxResultBytes truncates longer results instead of the default limit, see truncate.go
+	MaxResultBytes int `json:"max_result_bytes,omitempty"`
+	// Required is where older tool files put the required inputs, next to input_schema instead of in it
+	Required []string `json:"required,omitempty"`
+}
+
+func LoadToolFromJSONFile(filename string) (*anthropic.Tool, error) {
+	toolJSON, err := loadToolFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &toolJSON.Tool, nil
+}
+
+func loadToolFile(filename string) (*toolFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON file: %v", err)
+	}
+
+	var toolJSON toolFile
+	err = json.Unmarshal(data, &toolJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if toolJSON.Endpoint != nil {
+		if err := toolJSON.Endpoint.validate(); err != nil {
+			return nil, fmt.Errorf("invalid endpoint: %v", err)
+		}
+	}
+	if len(toolJSON.InputSchema.Required) == 0 {
+		toolJSON.InputSchema.Required = toolJSON.Required
+	}
+	if toolJSON.Timeout != "" {
+		if _, err := time.ParseDuration(toolJSON.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		}
+	}
+	if toolJSON.CacheTTL != "" {
+		if _, err := time.ParseDuration(toolJSON.CacheTTL); err != nil {
+			return nil, fmt.Errorf("invalid cache_ttl: %v", err)
+		}
+	}
+
+	return &toolJSON, nil
+}
+
+func LoadToolsFromDirectory(dir string) ([]anthropic.Tool, error) {
+	toolJSONs := make([]anthropic.Tool, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(filepath.Base(path), "__") {
+				return filepath.SkipDir
+			}
+			toolJSON, fn, err := loadToolDir(path, nil)
+			if err != nil {
+				return err
+			}
+			toolJSONs = append(toolJSONs, toolJSON.Tool)
+			toolJSON.applySettings()
+			// Add the tool to the Tools map
+			registerTool(toolJSON.Tool, fn)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory '%s': %v", dir, err)
+	}
+	return toolJSONs, nil
+}
+
+// loadToolDir loads the tool in a tools/<name> directory, without registering it.
+// A plugin tool already in plugins keeps its function, as a plugin can only be loaded once.
+func loadToolDir(path string, plugins map[string]useTool) (*toolFile, useTool, error) {
+	toolName := filepath.Base(path)
+	toolJSONPath := filepath.Join(path, toolName+".json")
+	toolGoPath := filepath.Join(path, toolName+".so")
+	// Load the tool JSON file
+	toolJSON, err := loadToolFile(toolJSONPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load tool JSON from file '%s': %v", toolJSONPath, err)
+	}
+	// Tools with an endpoint are executed over HTTP instead of by a plugin
+	if toolJSON.Endpoint != nil {
+		return toolJSON, toolJSON.Endpoint.executor(), nil
+	}
+	if fn, ok := plugins[toolJSON.Name]; ok {
+		return toolJSON, fn, nil
+	}
+	// Load the tool's Go plugin
+	plug, err := plugin.Open(toolGoPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load tool plugin from file '%s': %v", toolGoPath, err)
+	}
+	// Look up the UseTool function in the plugin
+	useToolFunc, err := plug.Lookup(strings.ToUpper(toolName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find %s function in plugin '%s': %v", toolName, toolGoPath, err)
+	}
+	// Assert that the UseTool function has the correct type
+	useTool, ok := useToolFunc.(func(map[string]any) anthropic.Content)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s function in plugin '%s' has incorrect type", toolName, toolGoPath)
+	}
+	return toolJSON, pluginTool(useTool), nil
+}
+
+// applySettings replaces the tool's timeout, cache TTL and result limit with its file's, so one taken out of the file goes back to the default
+func (toolJSON *toolFile) applySettings() {
+	toolSettingsMu.Lock()
+	defer toolSettingsMu.Unlock()
+	toolName := toolJSON.Name
+	clearFileSettings(toolName)
+	if toolJSON.Timeout != "" {
+		toolTimeouts[toolName], _ = time.ParseDuration(toolJSON.Timeout)
+	}
+	if toolJSON.CacheTTL != "" {
+		toolCacheTTLs[toolName], _ = time.ParseDuration(toolJSON.CacheTTL)
+	}
+	if toolJSON.MaxResultBytes > 0 {
+		toolResultLimits[toolName] = toolJSON.MaxResultBytes
+	}
+}
+
+// clearFileSettings forgets what the tool's file set, leaving what was set with SetToolCacheTTL and SetToolResultLimit.
+// toolSettingsMu must be held
+func clearFileSettings(toolName string) {
+	delete(toolTimeouts, toolName)
+	delete(toolCacheTTLs, toolName)
+	delete(toolResultLimits, toolName)
+}
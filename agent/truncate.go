@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # TOOL RESULT LIMITS
+// Oversized tool results are cut down before they go back to Claude, so one backend response can't fill the context window
+//   - Each tool's limit in bytes comes from SetToolResultLimit, its JSON file's max_result_bytes, or the default
+//   - JSON results are pruned: long arrays keep their first and last items, long strings their start,
+//     and deeply nested values are summarized, shrinking until the result fits
+//   - Anything else keeps its head and tail
+//   - A note at the end tells Claude the result was truncated and how
+const (
+	defaultToolResultLimit = 100 * 1024
+	minPrunedString        = 64
+)
+
+var (
+	toolResultLimit        = defaultToolResultLimit
+	toolResultLimits       = map[string]int{} // from the tools' files
+	configuredResultLimits = map[string]int{} // from SetToolResultLimit, over the files'
+)
+
+// SetDefaultToolResultLimit sets the limit for tools without their own, 0 disables it
+func SetDefaultToolResultLimit(bytes int) {
+	toolResultLimit = bytes
+}
+
+// SetToolResultLimit sets the limit for one tool whatever its file says, 0 disables it
+func SetToolResultLimit(tool string, bytes int) {
+	toolSettingsMu.Lock()
+	defer toolSettingsMu.Unlock()
+	configuredResultLimits[tool] = bytes
+}
+
+func resultLimit(tool string) int {
+	toolSettingsMu.RLock()
+	defer toolSettingsMu.RUnlock()
+	if n, ok := configuredResultLimits[tool]; ok {
+		return n
+	}
+	if n, ok := toolResultLimits[tool]; ok {
+		return n
+	}
+	return toolResultLimit
+}
+
+// limitToolResult truncates a result over the tool's limit and appends a note saying so
+func limitToolResult(tool string, result anthropic.Content) anthropic.Content {
+	limit := resultLimit(tool)
+	size := len(result.Content)
+	if limit <= 0 || size <= limit {
+		return result
+	}
+
+	content, how := pruneJSON(result.Content, limit)
+	if content == "" {
+		content, how = headTail(result.Content, limit), "the middle was cut out where marked"
+	}
+	result.Content = content + fmt.Sprintf("\n[truncated: this result was %d bytes, over the %d byte limit for %s, so %s. "+
+		"If you need what was left out, ask the tool for less at a time, e.g. with filters or paging.]", size, limit, tool, how)
+	slog.Info("truncated tool result", "tool", tool, "bytes", size, "limit", limit)
+	return result
+}
+
+// pruneJSON shrinks a JSON document until it fits within limit, or returns "" if it isn't JSON or can't be made to fit
+func pruneJSON(content string, limit int) (string, string) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		return "", ""
+	}
+
+	// Each round cuts back one thing: arrays first, then strings, then nesting
+	p := pruner{items: 64, chars: 4096, depth: 16}
+	for {
+		out, err := json.Marshal(p.prune(doc, 0))
+		if err != nil {
+			return "", ""
+		}
+		if len(out) <= limit {
+			return string(out), "long arrays, strings and nested values were shortened where marked"
+		}
+		switch {
+		case p.items > 4:
+			p.items /= 2
+		case p.chars > minPrunedString:
+			p.chars = max(p.chars/2, minPrunedString)
+		case p.items > 1:
+			p.items = 1
+		case p.depth > 1:
+			p.depth--
+		default:
+			return "", ""
+		}
+	}
+}
+
+type pruner struct {
+	items int // array items kept, the first items-1 and the last
+	chars int // bytes kept of each string
+	depth int // levels of nesting kept, deeper objects and arrays are summarized
+}
+
+func (p pruner) prune(v any, depth int) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if depth >= p.depth {
+			return fmt.Sprintf("[object with %d fields omitted]", len(v))
+		}
+		out := make(map[string]any, len(v))
+		for k, field := range v {
+			out[k] = p.prune(field, depth+1)
+		}
+		return out
+	case []any:
+		if depth >= p.depth {
+			return fmt.Sprintf("[array of %d items omitted]", len(v))
+		}
+		if len(v) <= p.items {
+			out := make([]any, len(v))
+			for i, item := range v {
+				out[i] = p.prune(item, depth+1)
+			}
+			return out
+		}
+		out := make([]any, 0, p.items+1)
+		for _, item := range v[:p.items-1] {
+			out = append(out, p.prune(item, depth+1))
+		}
+		out = append(out, fmt.Sprintf("[%d items omitted]", len(v)-p.items))
+		return append(out, p.prune(v[len(v)-1], depth+1))
+	case string:
+		if len(v) <= p.chars {
+			return v
+		}
+		cut := runeStart(v, p.chars)
+		return v[:cut] + fmt.Sprintf("[%d more bytes omitted]", len(v)-cut)
+	}
+	return v
+}
+
+// headTail keeps the first three quarters and the last quarter of what fits, marking the cut
+func headTail(content string, limit int) string {
+	head := runeStart(content, limit*3/4)
+	tail := runeStart(content, len(content)-limit/4)
+	return content[:head] + fmt.Sprintf("\n[... %d bytes omitted ...]\n", tail-head) + content[tail:]
+}
+
+// runeStart moves i back to the start of a UTF-8 character, so cutting there keeps the text valid
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
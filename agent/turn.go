@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # TURNS
+// Running a user turn to completion, and its structured result
+// This is what the REPL, the REST API, one-shot mode and `--output json` are built on; the REPL prints the turn through its hooks
+type TurnResult struct {
+	Text         string               `json:"text"`
+	Thinking     string               `json:"thinking,omitempty"` // extended thinking, when it is on
+	ToolCalls    []ToolCall           `json:"tool_calls"`
+	StopReason   anthropic.StopReason `json:"stop_reason"`
+	Model        anthropic.Model      `json:"model"`
+	FallbackFrom anthropic.Model      `json:"fallback_from,omitempty"` // the model asked for, if it was unavailable and Model answered instead
+	Usage        anthropic.Usage      `json:"usage"`                   // summed over every request made during the turn
+	Limit        string               `json:"limit,omitempty"`         // max_turns, max_tool_calls or turn_deadline, if one cut the tool loop short
+	Stats        TurnStats            `json:"stats"`
+}
+
+type ToolCall struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	Input   map[string]any `json:"input"`
+	Result  string         `json:"result"`
+	IsError bool           `json:"is_error,omitempty"`
+}
+
+// SetOutputJSON prints each REPL turn as a JSON TurnResult instead of colorized text
+func SetOutputJSON(enabled bool) {
+	outputJSON = enabled
+}
+
+// turnProgress is told about a turn as it goes: the reply and tool calls so far, and the tools about to run
+type turnProgress func(result *TurnResult, running []string)
+
+// turnHooks let a caller follow a turn as exchange runs it, any of them may be nil
+type turnHooks struct {
+	// progress is called before and after every round of tool calls, and before continuing a cut-off reply
+	progress turnProgress
+	// reply is called with each response once it is added to the conversation, before its tool calls run
+	reply func(resp *anthropic.Response)
+	// tools runs a round of tool calls instead of runTools, e.g. to show them and let the user confirm them first
+	tools func(ctx context.Context, uses []anthropic.Content) []anthropic.Content
+}
+
+func (h *turnHooks) report(result *TurnResult, running []string) {
+	if h != nil && h.progress != nil {
+		h.progress(result, running)
+	}
+}
+
+// exchange runs one user turn to completion, calling tools until Claude stops
+// asking for them. On error the result holds whatever was completed.
+// hooks, if not nil, follow the turn as it goes. If req.OnText is set the replies are streamed to it.
+func (convo *Conversation) exchange(ctx context.Context, req *anthropic.Request, hooks *turnHooks) (*TurnResult, error) {
+	result := &TurnResult{ToolCalls: []ToolCall{}, Model: req.Model}
+	began := time.Now()
+	result.Stats.began = began
+	defer result.Stats.finish(began)
+	requested := req.Model
+	if onText := req.OnText; onText != nil {
+		req.OnText = func(text string) {
+			if result.Stats.TimeToFirstToken == 0 {
+				result.Stats.TimeToFirstToken = time.Since(began)
+			}
+			onText(text)
+		}
+	}
+	var reply, thinking []string
+	continued := 0
+	for {
+		req.Messages = *convo
+		sent := time.Now()
+		resp, err := req.Post(ctx)
+		if err != nil {
+			result.Text = strings.Join(reply, "\n\n")
+			return result, err
+		}
+		result.Usage.Add(resp.Usage)
+		logUsage(ctx, resp.Model, resp.Usage, resp.Content)
+		result.Stats.addRequest(time.Since(sent), resp.Usage.OutputTokens)
+		result.Model = resp.Model
+		if req.Model != requested {
+			result.FallbackFrom = requested
+		}
+		result.StopReason = resp.StopReason
+
+		var toolUses []anthropic.Content
+		for _, cont := range withCitations(resp.Content) {
+			if cont.Type == anthropic.MessageResp || cont.Type == anthropic.Text {
+				_, message := parseThoughts(cont.Text)
+				if message != "" {
+					reply = append(reply, message)
+				}
+			} else if cont.Type == anthropic.ToolUse {
+				toolUses = append(toolUses, cont)
+			} else if cont.Type == anthropic.Thinking {
+				thinking = append(thinking, strings.TrimSpace(cont.Thinking))
+			} else if cont.Type != anthropic.RedactedThinking {
+				result.Text = strings.Join(reply, "\n\n")
+				return result, fmt.Errorf("unknown response type %s", cont.Type)
+			}
+		}
+		convo.appendAssistant(resp)
+		result.Text = strings.Join(reply, "\n\n")
+		result.Thinking = strings.Join(thinking, "\n\n")
+		if hooks != nil && hooks.reply != nil {
+			hooks.reply(resp)
+		}
+
+		if len(toolUses) > 0 && result.Limit != "" {
+			// asked for tools even with them off, so the turn ends here
+			convo.appendToolResults(toolUses, refusedAtLimit(toolUses, result.Limit))
+			return result, nil
+		}
+		if limit := result.Stats.loopLimit(len(toolUses)); len(toolUses) > 0 && limit != "" {
+			result.Limit = limit
+			slog.Warn("stopped the tool loop", "limit", DescribeLimit(limit), "requests", result.Stats.Requests, "tool_calls", len(result.ToolCalls))
+			convo.stopLoop(req, toolUses, limit)
+			continue
+		}
+		if len(toolUses) > 0 {
+			if hooks != nil && hooks.progress != nil {
+				running := make([]string, len(toolUses))
+				for i, use := range toolUses {
+					running[i] = use.Name
+				}
+				hooks.progress(result, running)
+			}
+			run := runTools
+			if hooks != nil && hooks.tools != nil {
+				run = hooks.tools
+			}
+			toolsBegan := time.Now()
+			results := run(withAuditTurn(ctx, resp.ID), toolUses)
+			result.Stats.ToolTime += time.Since(toolsBegan)
+			result.Stats.toolCalls += len(toolUses)
+			for i, use := range toolUses {
+				result.ToolCalls = append(result.ToolCalls, ToolCall{ID: use.Id, Name: use.Name, Input: use.Input, Result: results[i].Content, IsError: results[i].IsError})
+			}
+			convo.appendToolResults(toolUses, results)
+			hooks.report(result, nil)
+			req.ToolChoice = nil // forced tool use only applies to the first request of a turn
+			continue
+		}
+		if resp.StopReason == anthropic.MaxTokens && convo.continueTruncated(continued) {
+			continued++
+			hooks.report(result, nil)
+			continue
+		}
+		return result, nil
+	}
+}
+
+// PrintTurnJSON writes a turn to stdout as one line of JSON, with the error if there was one
+func PrintTurnJSON(result *TurnResult, err error) {
+	out := struct {
+		*TurnResult
+		Error string `json:"error,omitempty"`
+	}{TurnResult: result}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	json.NewEncoder(os.Stdout).Encode(out)
+}
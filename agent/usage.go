@@ -0,0 +1,300 @@
+package agent
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// # USAGE LEDGER
+// The tokens and estimated cost of every API response, by session, user and tool, for charging Claude costs back to teams
+//   - One JSONL line per response, appended like the audit log, with the session and user as the audit log names them
+//   - A response that asks for tools has its usage split evenly between the calls, so the tool totals add up to the whole;
+//     responses asking for none are under (no tools)
+//   - Compaction, titles and batches are recorded too, for whoever's session made them
+//   - `super-claude usage --since 7d` totals the ledger by user, session and tool, and --csv writes the totals for a spreadsheet
+//   - usage_log (USAGE_LOG) moves the file and "none" turns it off
+const noTools = "(no tools)"
+
+// UsageRecord is a line of the usage ledger
+type UsageRecord struct {
+	Time    time.Time       `json:"time"`
+	Session string          `json:"session"`
+	User    string          `json:"user,omitempty"`
+	Model   anthropic.Model `json:"model"`
+	Usage   anthropic.Usage `json:"usage"`
+	Cost    float64         `json:"cost_usd"`
+	Tools   []string        `json:"tools,omitempty"` // a name for each tool call the response asked for
+}
+
+// UsageGroupings are what the usage report can total by
+var UsageGroupings = []string{"user", "session", "tool"}
+
+var usageLog struct {
+	mu       sync.Mutex
+	filename string
+	file     *os.File
+}
+
+// sinceDays matches the day counts time.ParseDuration doesn't, e.g. 7d
+var sinceDays = regexp.MustCompile(`^(\d+)d$`)
+
+// SetUsageLog appends the usage of every API response to filename, "" turns the ledger off
+func SetUsageLog(filename string) error {
+	usageLog.mu.Lock()
+	defer usageLog.mu.Unlock()
+	if usageLog.file != nil {
+		usageLog.file.Close()
+		usageLog.file = nil
+	}
+	usageLog.filename = filename
+	if filename == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("failed to create usage log directory: %v", err)
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open usage log: %v", err)
+	}
+	usageLog.file = file
+	return identifyCLI()
+}
+
+// logUsage records a response's usage for the caller under ctx, with the tool calls in its content
+func logUsage(ctx context.Context, model anthropic.Model, usage anthropic.Usage, content []anthropic.Content) {
+	record := UsageRecord{Model: model, Usage: usage, Cost: usage.Cost(model)}
+	for _, cont := range content {
+		if cont.Type == anthropic.ToolUse {
+			record.Tools = append(record.Tools, cont.Name)
+		}
+	}
+	appendUsage(ctx, record)
+}
+
+// appendUsage writes a usage record, stamped with the time and the caller under ctx
+func appendUsage(ctx context.Context, record UsageRecord) {
+	usageLog.mu.Lock()
+	defer usageLog.mu.Unlock()
+	if usageLog.file == nil {
+		return
+	}
+	record.Time = time.Now().UTC()
+	record.Session, record.User = auditCaller(ctx)
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = usageLog.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		slog.Error("could not write usage record", "session", record.Session, "error", err)
+	}
+}
+
+// ParseSince reads --since: a number of days such as 7d, a duration such as 12h, or a date such as 2026-10-01
+func ParseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if m := sinceDays.FindStringSubmatch(s); m != nil {
+		days, _ := strconv.Atoi(m[1])
+		return time.Now().AddDate(0, 0, -days), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since '%s', expected e.g. 7d, 12h or 2026-10-01", s)
+}
+
+// UsageTotal is the usage of one user, session or tool
+type UsageTotal struct {
+	Key      string
+	Requests int // for a tool, the calls to it
+	Usage    anthropic.Usage
+	Cost     float64
+}
+
+func (t *UsageTotal) add(usage anthropic.Usage, cost float64) {
+	t.Requests++
+	t.Usage.Add(usage)
+	t.Cost += cost
+}
+
+// usageTotals reads the ledger since a time and totals it each way in by, most expensive first
+func usageTotals(since time.Time, by []string) (UsageTotal, map[string][]UsageTotal, error) {
+	var all UsageTotal
+	usageLog.mu.Lock()
+	filename := usageLog.filename
+	usageLog.mu.Unlock()
+	if filename == "" {
+		return all, nil, fmt.Errorf("the usage log is off, usage_log is none")
+	}
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return all, map[string][]UsageTotal{}, nil
+	}
+	if err != nil {
+		return all, nil, fmt.Errorf("failed to read the usage log: %v", err)
+	}
+	defer file.Close()
+
+	totals := map[string]map[string]*UsageTotal{}
+	for _, grouping := range by {
+		totals[grouping] = map[string]*UsageTotal{}
+	}
+	total := func(grouping, key string) *UsageTotal {
+		t, ok := totals[grouping][key]
+		if !ok {
+			t = &UsageTotal{Key: key}
+			totals[grouping][key] = t
+		}
+		return t
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			slog.Warn("skipping an unreadable usage record", "line", n, "error", err)
+			continue
+		}
+		if record.Time.Before(since) {
+			continue
+		}
+		all.add(record.Usage, record.Cost)
+		for _, grouping := range by {
+			switch grouping {
+			case "user":
+				user := record.User
+				if user == "" {
+					user = "(unknown)"
+				}
+				total(grouping, user).add(record.Usage, record.Cost)
+			case "session":
+				total(grouping, record.Session).add(record.Usage, record.Cost)
+			case "tool":
+				if len(record.Tools) == 0 {
+					total(grouping, noTools).add(record.Usage, record.Cost)
+				}
+				for i, tool := range record.Tools {
+					total(grouping, tool).add(usageShare(record.Usage, len(record.Tools), i), record.Cost/float64(len(record.Tools)))
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return all, nil, fmt.Errorf("failed to read the usage log: %v", err)
+	}
+
+	sorted := map[string][]UsageTotal{}
+	for grouping, byKey := range totals {
+		for _, t := range byKey {
+			sorted[grouping] = append(sorted[grouping], *t)
+		}
+		slices.SortFunc(sorted[grouping], func(a, b UsageTotal) int {
+			if c := cmp.Compare(b.Cost, a.Cost); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Key, b.Key)
+		})
+	}
+	return all, sorted, nil
+}
+
+// usageShare is the i'th of n even parts of u, the first parts taking what doesn't divide, so the parts add up to u
+func usageShare(u anthropic.Usage, n, i int) anthropic.Usage {
+	part := func(v int) int {
+		p := v / n
+		if i < v%n {
+			p++
+		}
+		return p
+	}
+	return anthropic.Usage{
+		InputTokens:              part(u.InputTokens),
+		OutputTokens:             part(u.OutputTokens),
+		CacheCreationInputTokens: part(u.CacheCreationInputTokens),
+		CacheReadInputTokens:     part(u.CacheReadInputTokens),
+	}
+}
+
+// PrintUsage prints the usage since a time totalled each way in by
+func PrintUsage(since time.Time, by []string) error {
+	all, totals, err := usageTotals(since, by)
+	if err != nil {
+		return err
+	}
+	from := "the start of the usage log"
+	if !since.IsZero() {
+		from = since.Local().Format("2006-01-02 15:04")
+	}
+	if all.Requests == 0 {
+		utils.Cprintf(commandColor, "No API usage recorded since %s.\n", from)
+		return nil
+	}
+	utils.Cprintf(commandColor, "Since %s: %d requests, %d tokens in, %d out, $%.4f\n", from, all.Requests, all.Usage.InputTokens, all.Usage.OutputTokens, all.Cost)
+	for _, grouping := range by {
+		count := "request"
+		if grouping == "tool" {
+			count = "call"
+		}
+		utils.Cprintf(commandColor, "\nBy %s\n", grouping)
+		width := 0
+		for _, t := range totals[grouping] {
+			width = max(width, len(t.Key))
+		}
+		for _, t := range totals[grouping] {
+			noun := count
+			if t.Key == noTools {
+				noun = "request"
+			}
+			if t.Requests != 1 {
+				noun += "s"
+			}
+			utils.Cprintf("gray", "  %-*s  %6d %-8s  %10d in  %9d out  $%.4f\n", width, t.Key, t.Requests, noun, t.Usage.InputTokens, t.Usage.OutputTokens, t.Cost)
+		}
+	}
+	return nil
+}
+
+// WriteUsageCSV writes the usage since a time totalled each way in by, a row per user, session or tool
+func WriteUsageCSV(w io.Writer, since time.Time, by []string) error {
+	_, totals, err := usageTotals(since, by)
+	if err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	out.Write([]string{"by", "key", "requests", "input_tokens", "output_tokens", "cache_creation_input_tokens", "cache_read_input_tokens", "cost_usd"})
+	for _, grouping := range by {
+		for _, t := range totals[grouping] {
+			out.Write([]string{
+				grouping, t.Key, strconv.Itoa(t.Requests),
+				strconv.Itoa(t.Usage.InputTokens), strconv.Itoa(t.Usage.OutputTokens),
+				strconv.Itoa(t.Usage.CacheCreationInputTokens), strconv.Itoa(t.Usage.CacheReadInputTokens),
+				strconv.FormatFloat(t.Cost, 'f', 6, 64),
+			})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// # WEB UI
+// A chat page served by `super-claude web`, for teammates who'd rather not use a terminal
+//   - GET / is the page, embedded in the binary; GET /ws is the WebSocket it talks to the conversation engine over
+//   - Each connection is a conversation of its own, which ends when the tab is closed
+//   - Replies are streamed as they are written, and tool calls show in an activity panel as they run
+//   - Calls needing approval are asked about on the page; the Stop button cancels the turn in progress
+//   - It listens on 127.0.0.1 unless --addr says otherwise, and only answers requests for localhost or the host it listens on,
+//     with WebSockets only from pages of those hosts, so neither another site nor a DNS-rebinding page can drive the agent
+//
+//go:embed web
+var webFiles embed.FS
+
+// webMessage is what the page and the server send each other, only the fields of its type are set
+type webMessage struct {
+	Type string `json:"type"`
+	// message from the page; text, streamed from the server
+	Text string `json:"text,omitempty"`
+	// approval, asked by the server and answered by the page
+	ID      string         `json:"id,omitempty"`
+	Tool    string         `json:"tool,omitempty"`
+	Input   map[string]any `json:"input,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+	Approve bool           `json:"approve,omitempty"`
+	// tools, the tools about to run, and tool_call, one that finished
+	Running []string  `json:"running,omitempty"`
+	Call    *ToolCall `json:"call,omitempty"`
+	// done, the finished turn, and error
+	Result *TurnResult `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Hint   string      `json:"hint,omitempty"`
+}
+
+type WebUI struct {
+	Tools []anthropic.Tool
+	Addr  string // the address listened on, whose host is accepted besides localhost
+}
+
+func NewWebUI(tools []anthropic.Tool, addr string) *WebUI {
+	return &WebUI{Tools: tools, Addr: addr}
+}
+
+func (ui *WebUI) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, webFiles, "web/index.html")
+	})
+	mux.HandleFunc("GET /ws", ui.handleSocket)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ui.knownHost(r.Host) {
+			http.Error(w, fmt.Sprintf("unknown host '%s'", r.Host), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// knownHost reports whether host, with or without a port, is localhost or the host listened on
+func (ui *WebUI) knownHost(host string) bool {
+	name := hostName(host)
+	if name == "localhost" || name == "127.0.0.1" || name == "::1" {
+		return true
+	}
+	listening := hostName(ui.Addr)
+	return listening != "" && strings.EqualFold(name, listening)
+}
+
+// checkOrigin accepts WebSockets from pages served for a known host, and from clients that aren't browsers and send no Origin
+func (ui *WebUI) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && ui.knownHost(u.Host)
+}
+
+// hostName strips the port and brackets from a host, lowercased
+func hostName(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// webSession is one page's conversation and the turn it is running, if any
+type webSession struct {
+	id   string
+	conn *websocket.Conn
+
+	writeMu sync.Mutex // gorilla allows one writer at a time
+
+	mu        sync.Mutex
+	messages  Conversation
+	cancel    context.CancelFunc // the running turn's, nil between turns
+	approvals map[string]chan bool
+}
+
+func (ui *WebUI) handleSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: ui.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade has answered with the error
+	}
+	defer conn.Close()
+	id, err := newSessionID()
+	if err != nil {
+		slog.Error("could not start a web session", "error", err)
+		return
+	}
+	session := &webSession{id: id[:12], conn: conn, messages: Conversation{}, approvals: map[string]chan bool{}}
+	sessionsOpened("web", 1)
+	defer sessionsOpened("web", -1)
+	slog.Info("web session started", "session", session.id, "remote", r.RemoteAddr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // the tab closed, stop its turn
+	var turns sync.WaitGroup
+	defer turns.Wait()
+	for {
+		var msg webMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Debug("web session ended", "session", session.id, "error", err)
+			}
+			return
+		}
+		switch msg.Type {
+		case "message":
+			if msg.Text == "" {
+				continue
+			}
+			turnCtx, ok := session.startTurn(ctx)
+			if !ok {
+				session.send(webMessage{Type: "error", Error: "Claude is still answering, wait for the reply or press Stop"})
+				continue
+			}
+			turns.Add(1)
+			go func() {
+				defer turns.Done()
+				session.turn(turnCtx, msg.Text, ui.Tools)
+			}()
+		case "approval":
+			session.decide(msg.ID, msg.Approve)
+		case "stop":
+			session.stop()
+		default:
+			session.send(webMessage{Type: "error", Error: fmt.Sprintf("unknown message type '%s'", msg.Type)})
+		}
+	}
+}
+
+// startTurn reserves the session for a turn, unless one is already running
+func (s *webSession) startTurn(ctx context.Context) (context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return nil, false
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	return ctx, true
+}
+
+func (s *webSession) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// turn runs one turn of the conversation, streaming its progress to the page
+func (s *webSession) turn(ctx context.Context, text string, tools []anthropic.Tool) {
+	defer func() {
+		s.mu.Lock()
+		s.cancel()
+		s.cancel = nil
+		s.mu.Unlock()
+	}()
+
+	start := len(s.messages)
+	s.messages.appendMsg(anthropic.Message{Role: anthropic.User, Content: makeTextContent(text)})
+	req := newRequest(nil, tools)
+	req.OnText = func(text string) {
+		s.send(webMessage{Type: "text", Text: text})
+	}
+	sent := 0
+	progress := func(result *TurnResult, running []string) {
+		for ; sent < len(result.ToolCalls); sent++ {
+			s.send(webMessage{Type: "tool_call", Call: &result.ToolCalls[sent]})
+		}
+		if len(running) > 0 {
+			s.send(webMessage{Type: "tools", Running: running})
+		}
+	}
+
+	turnCtx, span := startTurnSpan(ctx, attribute.String("web.session", s.id))
+	turnCtx = withApprover(withAuditSession(turnCtx, "web:"+s.id, cliUser), s.approve)
+	result, err := s.messages.exchange(turnCtx, req, &turnHooks{progress: progress})
+	span.End()
+	if err != nil {
+		// the page shows the error instead of a reply, so nothing of the turn is kept,
+		// and the conversation never ends on a tool_use without its result
+		s.messages = s.messages[:start]
+		if ctx.Err() != nil {
+			s.send(webMessage{Type: "error", Error: "Stopped."})
+			return
+		}
+		s.send(webMessage{Type: "error", Error: err.Error(), Hint: ErrorHint(err)})
+		return
+	}
+	s.send(webMessage{Type: "done", Result: result})
+}
+
+// approve asks the page whether a tool call may run, declining it if nobody answers in time
+func (s *webSession) approve(ctx context.Context, use anthropic.Content, reason string) (bool, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return false, err
+	}
+	decision := make(chan bool, 1)
+	s.mu.Lock()
+	s.approvals[id] = decision
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.approvals, id)
+		s.mu.Unlock()
+	}()
+
+	s.send(webMessage{Type: "approval", ID: id, Tool: use.Name, Input: use.Input, Reason: reason})
+	select {
+	case approved := <-decision:
+		return approved, nil
+	case <-time.After(approvalTimeout):
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (s *webSession) decide(id string, approve bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if decision, ok := s.approvals[id]; ok {
+		select {
+		case decision <- approve:
+		default: // already decided
+		}
+	}
+}
+
+// send writes a message to the page, a page that went away is noticed by the read loop
+func (s *webSession) send(msg webMessage) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteJSON(msg); err != nil {
+		slog.Debug("could not write to the web session", "session", s.id, "error", err)
+	}
+}
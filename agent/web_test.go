@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebUIHosts(t *testing.T) {
+	server := httptest.NewServer(NewWebUI(nil, "chat.internal:8080").Routes())
+	defer server.Close()
+	tests := map[string]int{
+		"":                     http.StatusOK, // the test server's own 127.0.0.1 address
+		"localhost:8080":       http.StatusOK,
+		"[::1]:8080":           http.StatusOK,
+		"chat.internal:8080":   http.StatusOK,
+		"CHAT.internal":        http.StatusOK,
+		"rebind.example.com":   http.StatusForbidden,
+		"localhost.evil.com":   http.StatusForbidden,
+		"127.0.0.1.nip.io:80":  http.StatusForbidden,
+		"chat.internal.evil.x": http.StatusForbidden,
+	}
+	for host, want := range tests {
+		req, err := http.NewRequest("GET", server.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Host %q: got %d, want %d", host, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestWebUIOrigins(t *testing.T) {
+	server := httptest.NewServer(NewWebUI(nil, "").Routes())
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	tests := map[string]bool{
+		"":                        true, // not a browser
+		server.URL:                true,
+		"http://localhost:8080":   true,
+		"https://rebind.example":  false,
+		"http://127.0.0.1.evil.x": false,
+		"null":                    false,
+	}
+	for origin, allowed := range tests {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if (err == nil) != allowed {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			t.Errorf("Origin %q: got %v (status %d), want allowed %v", origin, err, status, allowed)
+		}
+	}
+}
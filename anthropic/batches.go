@@ -0,0 +1,137 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// # MESSAGE BATCHES
+// Submitting many requests at once at half the price, for offline runs that can wait for results
+const BATCHES_PATH = "/v1/messages/batches"
+
+type BatchRequest struct {
+	CustomID string   `json:"custom_id"`
+	Params   *Request `json:"params"`
+}
+
+type BatchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+type MessageBatch struct {
+	ID               string             `json:"id"`
+	ProcessingStatus string             `json:"processing_status"` // in_progress, canceling or ended
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	ResultsURL       string             `json:"results_url"`
+	CreatedAt        time.Time          `json:"created_at"`
+	EndedAt          *time.Time         `json:"ended_at"`
+}
+
+// BatchResult is the outcome of one request, Result.Type is succeeded, errored, canceled or expired
+type BatchResult struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string    `json:"type"`
+		Message *Response `json:"message,omitempty"`
+		Error   *struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"error,omitempty"`
+	} `json:"result"`
+}
+
+func (c *Client) CreateBatch(ctx context.Context, requests []BatchRequest) (*MessageBatch, error) {
+	body, err := c.doJSON(ctx, "POST", strings.TrimRight(c.BaseURL, "/")+BATCHES_PATH, map[string]any{"requests": requests})
+	if err != nil {
+		return nil, err
+	}
+	var batch MessageBatch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &batch, nil
+}
+
+func (c *Client) GetBatch(ctx context.Context, id string) (*MessageBatch, error) {
+	body, err := c.doJSON(ctx, "GET", strings.TrimRight(c.BaseURL, "/")+BATCHES_PATH+"/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	var batch MessageBatch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &batch, nil
+}
+
+// BatchResults downloads the results of an ended batch, which come in no particular order
+func (c *Client) BatchResults(ctx context.Context, batch *MessageBatch) ([]BatchResult, error) {
+	if batch.ResultsURL == "" {
+		return nil, fmt.Errorf("batch %s has no results yet", batch.ID)
+	}
+	body, err := c.doJSON(ctx, "GET", batch.ResultsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var results []BatchResult
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r BatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to decode batch result: %v", err)
+		}
+		results = append(results, r)
+	}
+	return results, scanner.Err()
+}
+
+// doJSON sends an API request with an optional JSON body, returning the response body if the status is 200
+func (c *Client) doJSON(ctx context.Context, method, url string, in any) ([]byte, error) {
+	var reqBody io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	c.setHeaders(req)
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err)
+	}
+	slog.Debug("anthropic response", "url", url, "status", resp.StatusCode, "body", rawOrString(body))
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp.StatusCode, resp.Header, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,182 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// # BEDROCK
+// Claude on AWS Bedrock, through the InvokeModel API
+//   - Requests are signed with AWS Signature Version 4, or sent with a Bedrock API key as a bearer token
+//   - The model goes in the URL, as a Bedrock model id or inference profile
+//   - Betas go in the body as anthropic_beta
+const bedrockVersion = "bedrock-2023-05-31"
+
+var BedrockModels = map[Model]string{
+	Opus:   "anthropic.claude-3-opus-20240229-v1:0",
+	Sonnet: "anthropic.claude-3-sonnet-20240229-v1:0",
+	Haiku:  "anthropic.claude-3-haiku-20240307-v1:0",
+
+	Sonnet37: "us.anthropic.claude-3-7-sonnet-20250219-v1:0", // only available through the cross-region profile
+}
+
+type Bedrock struct {
+	Region     string
+	BaseURL    string // defaults to https://bedrock-runtime.<region>.amazonaws.com
+	HTTPClient *http.Client
+	Betas      []string // sent with every request, along with any it needs
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// APIKey is a Bedrock API key, sent instead of signing the request
+	APIKey string
+}
+
+// NewBedrock reads the credentials from the standard AWS env vars, and the region too if it is empty
+func NewBedrock(region string) *Bedrock {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	return &Bedrock{
+		Region:          region,
+		HTTPClient:      NewHTTPClient(DefaultTimeouts),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		APIKey:          os.Getenv("AWS_BEARER_TOKEN_BEDROCK"),
+	}
+}
+
+// HasCredentials reports whether requests can be authenticated, unauthenticated requests are only useful with --replay
+func (b *Bedrock) HasCredentials() bool {
+	return b.APIKey != "" || (b.AccessKeyID != "" && b.SecretAccessKey != "")
+}
+
+func (b *Bedrock) CreateMessage(ctx context.Context, r *Request) (*Response, error) {
+	extra := map[string]any{}
+	if betas := mergeBetas(b.Betas, r.betas()); len(betas) > 0 {
+		extra["anthropic_beta"] = betas
+	}
+	jsonRequest, err := cloudBody(r, bedrockVersion, extra)
+	if err != nil {
+		return nil, err
+	}
+
+	base := b.BaseURL
+	if base == "" {
+		base = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", b.Region)
+	}
+	// Model ids contain a colon, which AWS wants escaped in the path
+	path := "/model/" + awsEscape(cloudModel(BedrockModels, r.Model)) + "/invoke"
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(base, "/")+path, bytes.NewReader(jsonRequest))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	switch {
+	case b.APIKey != "":
+		req.Header.Set("Authorization", "Bearer "+b.APIKey)
+	case b.HasCredentials():
+		b.sign(req, jsonRequest, time.Now())
+	}
+
+	client := b.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := postMessage(client, req, jsonRequest)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Model == "" {
+		resp.Model = r.Model
+	}
+	return resp, nil
+}
+
+// sign adds a Signature Version 4 Authorization header for the bedrock service
+func (b *Bedrock) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + b.Region + "/bedrock/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	if b.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// The already escaped path is escaped again, as every service but S3 expects
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+	for i, s := range segments {
+		segments[i] = awsEscape(s)
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		strings.Join(segments, "/"),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + b.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], b.Region, "bedrock", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsEscape percent-encodes everything but unreserved characters, the way SigV4 canonicalizes paths
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
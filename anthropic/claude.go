@@ -1,20 +1,20 @@
 package anthropic
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-
-	"github.com/hunterjsb/super-claude/config"
+	"strings"
 )
 
 // # CLAUDE API TYPES
 // - String literals for api-specific values
 // - Structs for interacting with the Messages API
 // - Methods for interacting with the Messages API
-const MESSAGES_URL = "https://api.anthropic.com/v1/messages"
+const (
+	DEFAULT_BASE_URL = "https://api.anthropic.com"
+	MESSAGES_PATH    = "/v1/messages"
+)
 
 type (
 	MessageRole  string
@@ -26,21 +26,108 @@ type (
 const (
 	User, Assistant                        MessageRole  = "user", "assistant"
 	Opus, Sonnet, Haiku                    Model        = "claude-3-opus-20240229", "claude-3-sonnet-20240229", "claude-3-haiku-20240307"
+	Sonnet37                               Model        = "claude-3-7-sonnet-20250219" // the first model with extended thinking
 	EndTurn, MaxTokens, StopSequence       StopReason   = "end_turn", "max_tokens", "stop_sequence"
 	Text, ToolUse, MessageResp, ToolResult ResponseType = "text", "tool_use", "message", "tool_result"
+	Image, Document                        ResponseType = "image", "document"
+	Thinking, RedactedThinking             ResponseType = "thinking", "redacted_thinking"
 )
 
+// ParseModel reads a model id, or one of the short names opus, sonnet, haiku and sonnet-3.7
+func ParseModel(s string) Model {
+	switch strings.ToLower(s) {
+	case "opus":
+		return Opus
+	case "sonnet":
+		return Sonnet
+	case "haiku":
+		return Haiku
+	case "sonnet-3.7":
+		return Sonnet37
+	}
+	return Model(s)
+}
+
 type Message struct {
 	Role    MessageRole `json:"role"`
 	Content []Content   `json:"content"`
+
+	// Usage is the usage of the response an assistant message came from, kept for exports and never sent
+	Usage *Usage `json:"-"`
 }
 
 type Request struct {
-	Model     Model        `json:"model"`
-	Messages  Conversation `json:"messages"`
-	MaxTokens int          `json:"max_tokens"`
-	System    string       `json:"system,omitempty"`
-	Tools     []Tool       `json:"tools,omitempty"`
+	Model     Model     `json:"model"`
+	Messages  []Message `json:"messages"`
+	MaxTokens int       `json:"max_tokens"`
+	System    string    `json:"system,omitempty"`
+	Tools     []Tool    `json:"tools,omitempty"`
+
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+
+	// Optional sampling parameters, nil leaves the API default
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
+
+	// Thinking turns on extended thinking, nil leaves it off
+	Thinking *ThinkingConfig `json:"thinking,omitempty"`
+
+	// PromptCaching marks the system prompt and tool definitions as cacheable
+	PromptCaching bool `json:"-"`
+
+	// OnText streams the reply, and is called with each piece of its text as it arrives
+	OnText func(text string) `json:"-"`
+
+	// Provider sends the request instead of the one installed by SetProvider, e.g. for an agent embedded with a client of its own
+	Provider Provider `json:"-"`
+}
+
+// ThinkingConfig lets Claude reason for up to BudgetTokens before answering, which count towards max_tokens.
+// The budget must be at least 1024 tokens and below max_tokens, and temperature, top_k and forced tool use can't be set with it.
+type ThinkingConfig struct {
+	Type         string `json:"type"` // enabled
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+const MinThinkingBudget = 1024
+
+// EnableThinking returns the config for thinking with the given budget, or nil for a budget of 0
+func EnableThinking(budgetTokens int) *ThinkingConfig {
+	if budgetTokens <= 0 {
+		return nil
+	}
+	return &ThinkingConfig{Type: "enabled", BudgetTokens: budgetTokens}
+}
+
+// CacheControl marks a block as a prompt caching breakpoint, everything up to and including it is cached
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+var Ephemeral = &CacheControl{Type: "ephemeral"}
+
+// MarshalJSON sends the system prompt as a cacheable text block and puts a breakpoint
+// on the last tool when PromptCaching is set, otherwise the request is sent as-is
+func (r Request) MarshalJSON() ([]byte, error) {
+	type request Request // drops this method to avoid recursion
+	if !r.PromptCaching {
+		return json.Marshal(request(r))
+	}
+
+	if len(r.Tools) > 0 {
+		r.Tools = append([]Tool(nil), r.Tools...)
+		r.Tools[len(r.Tools)-1].CacheControl = Ephemeral
+	}
+	cached := struct {
+		request
+		System []Content `json:"system,omitempty"`
+	}{request: request(r)}
+	if r.System != "" {
+		cached.System = []Content{{Type: Text, Text: r.System, CacheControl: Ephemeral}}
+	}
+	return json.Marshal(cached)
 }
 
 type Content struct {
@@ -57,6 +144,22 @@ type Content struct {
 	// tool_response user response
 	ToolUseId string `json:"tool_use_id,omitempty"`
 	Content   string `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+
+	// image and document user content
+	Source *ImageSource `json:"source,omitempty"`
+	Title  string       `json:"title,omitempty"`
+
+	// enabled on documents, and the passages cited by a text response
+	Citations *Citations `json:"citations,omitempty"`
+
+	// thinking response, which must be sent back unchanged with the rest of the assistant message
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// redacted_thinking response, encrypted
+	Data string `json:"data,omitempty"`
+
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
 type Response struct {
@@ -67,55 +170,54 @@ type Response struct {
 	Model        Model        `json:"model"`
 	StopReason   StopReason   `json:"stop_reason"`
 	StopSequence string       `json:"stop_sequence"`
-	Usage        struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+	Usage        Usage        `json:"usage"`
 }
 
-func (r *Request) Post() (*Response, error) {
-	// Marshal the JSON body
-	jsonRequest, err := json.Marshal(r)
-	if err != nil {
-		return nil, err
-	}
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
 
-	// Instantiate the http request
-	req, err := http.NewRequest("POST", MESSAGES_URL, bytes.NewBuffer(jsonRequest))
-	if err != nil {
-		return nil, err
-	}
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+}
 
-	// Set the headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", config.Cfg.AnthropicApiKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
-	req.Header.Set("anthropic-beta", "tools-2024-04-04")
-
-	// Make the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+// Post sends the request with its Provider, or else the one installed by SetProvider or SetClient, giving up when ctx is done.
+// If a fallback model answered instead, r.Model is left set to it.
+func (r *Request) Post(ctx context.Context) (*Response, error) {
+	if r.sender() == nil {
+		return nil, fmt.Errorf("no API client configured, call anthropic.SetClient or anthropic.SetProvider first")
 	}
-	defer resp.Body.Close()
-
-	// Check the response status code
-	if resp.StatusCode != http.StatusOK {
-		// Read the response body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err)
+	resp, err := withFallback(ctx, r, func(ctx context.Context, r *Request) (*Response, error) {
+		return rateLimited(ctx, r, tracedPost)
+	})
+	if err == nil && r.OnText != nil && !streams(r.sender()) {
+		for _, cont := range resp.Content {
+			if cont.Type == Text {
+				r.OnText(cont.Text)
+			}
 		}
-		return nil, fmt.Errorf("API request failed with status code: %d, response body: %s", resp.StatusCode, string(body))
 	}
+	return resp, err
+}
 
-	// Decode the JSON response
-	var respData Response
-	err = json.NewDecoder(resp.Body).Decode(&respData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+// sender is the provider the request is sent with
+func (r *Request) sender() Provider {
+	if r.Provider != nil {
+		return r.Provider
 	}
+	return provider
+}
 
-	return &respData, nil
+// betas are the beta features the request needs beyond tools, which every provider but the Anthropic API has generally available
+func (r *Request) betas() []string {
+	if r.PromptCaching {
+		return []string{"prompt-caching-2024-07-31"}
+	}
+	return nil
 }
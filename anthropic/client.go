@@ -0,0 +1,212 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// # CLIENT
+// HTTP client for the Messages API
+// The base URL and http.Client are exported so tests can point it at an httptest server
+//   - Version is the anthropic-version header, DefaultAPIVersion unless the config sets anthropic_version
+//   - Betas are sent with every request in the anthropic-beta header, along with any a request or endpoint needs;
+//     tools, batches and token counting are generally available and need none
+//   - Headers are sent with every request too, e.g. workspace attribution for a gateway, and can't replace the ones above
+//   - SetKeys rotates Messages calls over several API keys instead of APIKey
+const DefaultAPIVersion = "2023-06-01"
+
+type Client struct {
+	APIKey     string
+	BaseURL    string
+	Version    string
+	Betas      []string
+	Headers    map[string]string
+	HTTPClient *http.Client
+
+	keys *keyRing
+}
+
+var apiClient *Client
+
+func NewClient(apiKey string) *Client {
+	return &Client{APIKey: apiKey, BaseURL: DEFAULT_BASE_URL, HTTPClient: NewHTTPClient(DefaultTimeouts)}
+}
+
+// Timeouts bound API requests, 0 for no limit. Connect covers dialing and the TLS handshake,
+// Request the whole exchange, which includes waiting for Claude to write the full reply.
+type Timeouts struct {
+	Connect time.Duration
+	Request time.Duration
+}
+
+var DefaultTimeouts = Timeouts{Connect: 10 * time.Second, Request: 10 * time.Minute}
+
+// NewHTTPClient returns an http.Client for the API with the given timeouts
+func NewHTTPClient(t Timeouts) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: t.Connect, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = t.Connect
+	return &http.Client{Transport: transport, Timeout: t.Request}
+}
+
+// SetClient installs the client used by Request.Post, the same as SetProvider with the Anthropic API
+func SetClient(c *Client) {
+	if c == nil {
+		SetProvider(nil)
+		return
+	}
+	SetProvider(c)
+}
+
+// GetClient returns the client installed by SetClient, or nil if the provider isn't the Anthropic API
+func GetClient() *Client {
+	return apiClient
+}
+
+func (c *Client) CreateMessage(ctx context.Context, r *Request) (*Response, error) {
+	// Marshal the JSON body
+	jsonRequest, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.OnText != nil {
+		if jsonRequest, err = withStream(jsonRequest); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.keys == nil {
+		return c.sendMessage(ctx, r, jsonRequest, c.APIKey, nil)
+	}
+	return c.keys.send(ctx, estimateInputTokens(r), func(k *ringKey) (*Response, error) {
+		return c.sendMessage(ctx, r, jsonRequest, k.Key, k.Headers)
+	})
+}
+
+// sendMessage sends a Messages request on one API key, with that key's extra headers
+func (c *Client) sendMessage(ctx context.Context, r *Request, jsonRequest []byte, apiKey string, headers map[string]string) (*Response, error) {
+	// Instantiate the http request
+	url := strings.TrimRight(c.BaseURL, "/") + MESSAGES_PATH
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonRequest))
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the headers
+	c.setKeyHeaders(req, apiKey, headers, r.betas()...)
+	if r.OnText != nil {
+		return streamMessage(c.httpClient(), req, jsonRequest, r.OnText)
+	}
+	return postMessage(c.httpClient(), req, jsonRequest)
+}
+
+// postMessage sends a Messages request built by a provider and decodes the response
+func postMessage(client *http.Client, req *http.Request, jsonRequest []byte) (*Response, error) {
+	slog.Debug("anthropic request", "url", req.URL.String(), "headers", redactHeaders(req.Header), "body", json.RawMessage(jsonRequest))
+
+	// Make the request
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err)
+	}
+	slog.Debug("anthropic response", "status", resp.StatusCode, "body", rawOrString(body))
+
+	// Check the response status code
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("anthropic request failed", "status", resp.StatusCode)
+		return nil, newAPIError(resp.StatusCode, resp.Header, body)
+	}
+
+	// Decode the JSON response
+	var respData Response
+	err = json.Unmarshal(body, &respData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &respData, nil
+}
+
+// setHeaders sets the auth, version and beta headers, with betas on top of the client's
+func (c *Client) setHeaders(req *http.Request, betas ...string) {
+	c.setKeyHeaders(req, c.APIKey, nil, betas...)
+}
+
+// setKeyHeaders sets the client's extra headers, then a key's, then the auth, version and beta headers over them
+func (c *Client) setKeyHeaders(req *http.Request, apiKey string, headers map[string]string, betas ...string) {
+	for _, extra := range []map[string]string{c.Headers, headers} {
+		for k, v := range extra {
+			req.Header.Set(k, v)
+		}
+	}
+	version := c.Version
+	if version == "" {
+		version = DefaultAPIVersion
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", version)
+	if all := mergeBetas(c.Betas, betas); len(all) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(all, ","))
+	}
+}
+
+// mergeBetas joins lists of beta features, leaving out blanks and repeats
+func mergeBetas(lists ...[]string) []string {
+	var betas []string
+	for _, list := range lists {
+		for _, beta := range list {
+			beta = strings.TrimSpace(beta)
+			if beta != "" && !slices.Contains(betas, beta) {
+				betas = append(betas, beta)
+			}
+		}
+	}
+	return betas
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// redactHeaders copies request headers for logging with credentials masked
+func redactHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k := range h {
+		switch http.CanonicalHeaderKey(k) {
+		case "X-Api-Key", "Authorization", "X-Amz-Security-Token":
+			out[k] = "REDACTED"
+		default:
+			out[k] = h.Get(k)
+		}
+	}
+	return out
+}
+
+// rawOrString logs a body as embedded JSON when valid, falling back to a plain string
+func rawOrString(body []byte) any {
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
@@ -0,0 +1,198 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testClient is a client for a server answering with handler
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c := NewClient("sk-ant-test")
+	c.BaseURL = server.URL
+	c.HTTPClient = server.Client()
+	return c
+}
+
+func testRequest() *Request {
+	return &Request{Model: Haiku, MaxTokens: 100, Messages: []Message{{Role: User, Content: []Content{{Type: Text, Text: "Hi"}}}}}
+}
+
+func writeReply(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{
+		ID: "msg_1", Type: "message", Role: Assistant, Model: Haiku, StopReason: EndTurn,
+		Content: []Content{{Type: Text, Text: text}},
+		Usage:   Usage{InputTokens: 12, OutputTokens: 3},
+	})
+}
+
+func TestCreateMessage(t *testing.T) {
+	var got Request
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != MESSAGES_PATH {
+			t.Errorf("got %s %s, want POST %s", r.Method, r.URL.Path, MESSAGES_PATH)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("request body is not JSON: %v", err)
+		}
+		writeReply(w, "Hello!")
+	})
+
+	resp, err := c.CreateMessage(context.Background(), testRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != "msg_1" || resp.StopReason != EndTurn || len(resp.Content) != 1 || resp.Content[0].Text != "Hello!" {
+		t.Errorf("decoded %+v", resp)
+	}
+	if resp.Usage != (Usage{InputTokens: 12, OutputTokens: 3}) {
+		t.Errorf("usage %+v", resp.Usage)
+	}
+	if got.Model != Haiku || got.MaxTokens != 100 || len(got.Messages) != 1 || got.Messages[0].Content[0].Text != "Hi" {
+		t.Errorf("sent %+v", got)
+	}
+}
+
+func TestCreateMessageHeaders(t *testing.T) {
+	var got http.Header
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		writeReply(w, "ok")
+	})
+	c.Betas = []string{"beta-a", " ", "prompt-caching-2024-07-31"}
+	c.Headers = map[string]string{"X-Workspace": "support", "x-api-key": "not-this-one"}
+	req := testRequest()
+	req.PromptCaching = true
+
+	if _, err := c.CreateMessage(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Content-Type":      "application/json",
+		"X-Api-Key":         "sk-ant-test", // extra headers can't replace the key
+		"Anthropic-Version": DefaultAPIVersion,
+		"Anthropic-Beta":    "beta-a,prompt-caching-2024-07-31",
+		"X-Workspace":       "support",
+	}
+	for k, v := range want {
+		if got.Get(k) != v {
+			t.Errorf("%s: got %q, want %q", k, got.Get(k), v)
+		}
+	}
+
+	c.Version, c.Betas = "2099-01-01", nil
+	if _, err := c.CreateMessage(context.Background(), testRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Anthropic-Version") != "2099-01-01" {
+		t.Errorf("anthropic-version %q, want the client's Version", got.Get("Anthropic-Version"))
+	}
+	if _, ok := got["Anthropic-Beta"]; ok {
+		t.Errorf("sent anthropic-beta %q without any betas", got.Get("Anthropic-Beta"))
+	}
+}
+
+func TestCreateMessageErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     map[string]string
+		body       string
+		wantType   string
+		wantMsg    string
+		wantAs     func(error) bool
+		retryAfter time.Duration
+	}{
+		{
+			name: "authentication", status: 401, body: `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			wantType: "authentication_error", wantMsg: "invalid x-api-key",
+			wantAs: func(err error) bool { var e *AuthenticationError; return errors.As(err, &e) },
+		},
+		{
+			name: "rate limit with retry-after", status: 429, header: map[string]string{"retry-after": "7", "request-id": "req_42"},
+			body:     `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+			wantType: "rate_limit_error", wantMsg: "slow down", retryAfter: 7 * time.Second,
+			wantAs: func(err error) bool { var e *RateLimitError; return errors.As(err, &e) },
+		},
+		{
+			name: "overloaded", status: StatusOverloaded, body: `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			wantType: "overloaded_error", wantMsg: "Overloaded",
+			wantAs: func(err error) bool { var e *OverloadedError; return errors.As(err, &e) },
+		},
+		{
+			name: "invalid request", status: 400, body: `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: too big"}}`,
+			wantType: "invalid_request_error", wantMsg: "max_tokens: too big",
+			wantAs: func(err error) bool { var e *InvalidRequestError; return errors.As(err, &e) },
+		},
+		{
+			name: "type from the status of a body that isn't JSON", status: 404, body: "<html>not here</html>",
+			wantType: "not_found_error", wantMsg: "<html>not here</html>",
+			wantAs: func(err error) bool { var e *NotFoundError; return errors.As(err, &e) },
+		},
+		{
+			name: "empty body of an unknown status", status: 503, body: "",
+			wantType: "api_error", wantMsg: "Service Unavailable",
+			wantAs: func(err error) bool { var e *APIError; return errors.As(err, &e) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+			_, err := c.CreateMessage(context.Background(), testRequest())
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if !tt.wantAs(err) {
+				t.Errorf("error %T is not the expected type", err)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %T doesn't unwrap to an *APIError", err)
+			}
+			if apiErr.Status != tt.status || apiErr.Type != tt.wantType || apiErr.Message != tt.wantMsg || apiErr.RetryAfter != tt.retryAfter {
+				t.Errorf("got %+v", *apiErr)
+			}
+			if apiErr.RequestID != tt.header["request-id"] {
+				t.Errorf("request id %q, want %q", apiErr.RequestID, tt.header["request-id"])
+			}
+		})
+	}
+}
+
+func TestCreateMessageUndecodableResponse(t *testing.T) {
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "{not json")
+	})
+	if _, err := c.CreateMessage(context.Background(), testRequest()); err == nil {
+		t.Fatal("got no error for a response that isn't JSON")
+	}
+}
+
+func TestCreateMessageCancelled(t *testing.T) {
+	release := make(chan struct{})
+	c := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	t.Cleanup(func() { close(release) }) // before the server closes, which waits for the handler
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.CreateMessage(ctx, testRequest()); err == nil || ctx.Err() == nil {
+		t.Fatalf("got %v, want the request to give up with ctx", err)
+	}
+}
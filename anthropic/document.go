@@ -0,0 +1,79 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// # DOCUMENTS
+// Document content blocks for PDFs and plain text, with citations so Claude's answers point at the passages they came from
+//   - PDFs are sent as base64 and plain text as it is, both in an ImageSource
+//   - A reply drawing on documents comes back as several text blocks, each with the passages it cites
+type Citations struct {
+	Enabled bool       // requests: cite passages of this document
+	List    []Citation // responses: the passages a text block cites
+}
+
+// Citation is a quoted passage of a document: characters of a text document, pages of a PDF, or blocks of custom content
+type Citation struct {
+	Type            string `json:"type"` // char_location, page_location or content_block_location
+	CitedText       string `json:"cited_text"`
+	DocumentIndex   int    `json:"document_index"`
+	DocumentTitle   string `json:"document_title,omitempty"`
+	StartCharIndex  *int   `json:"start_char_index,omitempty"`
+	EndCharIndex    *int   `json:"end_char_index,omitempty"`
+	StartPageNumber *int   `json:"start_page_number,omitempty"`
+	EndPageNumber   *int   `json:"end_page_number,omitempty"`
+	StartBlockIndex *int   `json:"start_block_index,omitempty"`
+	EndBlockIndex   *int   `json:"end_block_index,omitempty"`
+}
+
+// MarshalJSON writes {"enabled": true} for documents, and the list of citations for text
+func (c Citations) MarshalJSON() ([]byte, error) {
+	if c.List == nil {
+		return json.Marshal(map[string]bool{"enabled": c.Enabled})
+	}
+	return json.Marshal(c.List)
+}
+
+func (c *Citations) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, &c.List)
+	}
+	var config struct {
+		Enabled bool `json:"enabled"`
+	}
+	err := json.Unmarshal(data, &config)
+	c.Enabled = config.Enabled
+	return err
+}
+
+// NewDocumentContent wraps a PDF or UTF-8 text in a document content block with citations enabled, titled with its name
+func NewDocumentContent(data []byte, title string) (Content, error) {
+	var source *ImageSource
+	switch mediaType := http.DetectContentType(data); {
+	case mediaType == "application/pdf":
+		source = &ImageSource{Type: "base64", MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(data)}
+	case strings.HasPrefix(mediaType, "text/plain") && utf8.Valid(data):
+		source = &ImageSource{Type: "text", MediaType: "text/plain", Data: string(data)}
+	default:
+		return Content{}, fmt.Errorf("unsupported document type '%s', must be PDF or plain text", mediaType)
+	}
+	return Content{Type: Document, Source: source, Title: title, Citations: &Citations{Enabled: true}}, nil
+}
+
+// NewDocumentContentFromFile reads a local PDF or text file into a document content block
+func NewDocumentContentFromFile(filename string) (Content, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Content{}, fmt.Errorf("failed to read document: %v", err)
+	}
+	return NewDocumentContent(data, filepath.Base(filename))
+}
@@ -0,0 +1,116 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// # API ERRORS
+// Error responses decoded from the API's {"type": "error", "error": {"type": ..., "message": ...}} body, as typed errors
+//   - The common types get their own Go type, to be told apart with errors.As, e.g. a *RateLimitError; the rest are an *APIError
+//   - Every typed error unwraps to its *APIError, which has the status, type, message and request id
+//   - Bedrock and Vertex answer with bodies of their own, whose message is kept and whose type is inferred from the status
+type APIError struct {
+	Status     int
+	Type       string // e.g. invalid_request_error, from the body or else the status
+	Message    string
+	RequestID  string        // the request-id header, for reporting the error to Anthropic
+	RetryAfter time.Duration // from the retry-after header, 0 if it had none
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("API error %d %s: %s", e.Status, e.Type, e.Message)
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// AuthenticationError is a 401, the API key is missing or invalid
+type AuthenticationError struct{ APIError }
+
+// PermissionError is a 403, the API key may not use the model or feature
+type PermissionError struct{ APIError }
+
+// NotFoundError is a 404, usually a model that doesn't exist
+type NotFoundError struct{ APIError }
+
+// InvalidRequestError is a 400, the request itself is wrong, e.g. max_tokens over the model's limit
+type InvalidRequestError struct{ APIError }
+
+// RateLimitError is a 429, the organization's rate limits are used up for now
+type RateLimitError struct{ APIError }
+
+// OverloadedError is a 529, the API is too busy to answer
+type OverloadedError struct{ APIError }
+
+func (e *AuthenticationError) Unwrap() error { return &e.APIError }
+func (e *PermissionError) Unwrap() error     { return &e.APIError }
+func (e *NotFoundError) Unwrap() error       { return &e.APIError }
+func (e *InvalidRequestError) Unwrap() error { return &e.APIError }
+func (e *RateLimitError) Unwrap() error      { return &e.APIError }
+func (e *OverloadedError) Unwrap() error     { return &e.APIError }
+
+// statusTypes are the error types the API gives each status
+var statusTypes = map[int]string{
+	http.StatusBadRequest:            "invalid_request_error",
+	http.StatusUnauthorized:          "authentication_error",
+	http.StatusForbidden:             "permission_error",
+	http.StatusNotFound:              "not_found_error",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusTooManyRequests:       "rate_limit_error",
+	http.StatusInternalServerError:   "api_error",
+	StatusOverloaded:                 "overloaded_error",
+}
+
+// newAPIError decodes an error response into the typed error for its type
+func newAPIError(status int, header http.Header, body []byte) error {
+	var decoded struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+		Message string `json:"message"` // Bedrock
+	}
+	e := APIError{Status: status, RequestID: header.Get("request-id")}
+	if json.Unmarshal(body, &decoded) == nil {
+		e.Type, e.Message = decoded.Error.Type, decoded.Error.Message
+		if e.Message == "" {
+			e.Message = decoded.Message
+		}
+	} else {
+		e.Message = strings.TrimSpace(string(body)) // e.g. an HTML page from a proxy
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(status)
+	}
+	if e.Type == "" {
+		e.Type = statusTypes[status]
+	}
+	if e.Type == "" {
+		e.Type = "api_error"
+	}
+	if secs, err := strconv.Atoi(header.Get("retry-after")); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	switch e.Type {
+	case "authentication_error":
+		return &AuthenticationError{e}
+	case "permission_error":
+		return &PermissionError{e}
+	case "not_found_error":
+		return &NotFoundError{e}
+	case "invalid_request_error":
+		return &InvalidRequestError{e}
+	case "rate_limit_error":
+		return &RateLimitError{e}
+	case "overloaded_error":
+		return &OverloadedError{e}
+	}
+	return &e
+}
@@ -0,0 +1,109 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// # MODEL FALLBACK
+// Asking the next model of a chain when one is overloaded, e.g. opus, then sonnet, then haiku, instead of failing the turn
+//   - A 529 overloaded moves on at once; a 429 is retried on the same model after its retry-after, and moves on if it happens again
+//   - A request starts at its own model and continues with the models after it in the chain, or the whole chain if it isn't in it
+//   - Post leaves the request on the model that answered, so the rest of a turn stays there rather than trying the busy model again
+//   - max_tokens is lowered to what a smaller model can write; the fallbacks must support everything else the request uses
+const (
+	StatusOverloaded = 529
+
+	// rateLimitAttempts is how many 429s in a row make a model count as unavailable
+	rateLimitAttempts = 2
+	// maxRetryAfter bounds the wait before retrying a 429, a longer retry-after is better spent on the next model
+	maxRetryAfter = 10 * time.Second
+)
+
+var (
+	fallbackModels []Model
+
+	apiFallbacks, _ = meter.Int64Counter("claude_agent.api.fallbacks",
+		metric.WithDescription("Messages API calls sent to a fallback model because the one requested was unavailable"))
+)
+
+// SetFallbackModels sets the chain of models to fall back on, nil or empty turns fallback off
+func SetFallbackModels(models []Model) {
+	fallbackModels = models
+}
+
+// unavailable reports whether err means the model can't answer right now, and how long to wait before asking it again
+func unavailable(err error) (status int, retryAfter time.Duration, ok bool) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || (apiErr.Status != http.StatusTooManyRequests && apiErr.Status != StatusOverloaded) {
+		return 0, 0, false
+	}
+	return apiErr.Status, apiErr.RetryAfter, true
+}
+
+// fallbackChain is the models to try for a request to m, in order
+func fallbackChain(m Model) []Model {
+	chain := []Model{m}
+	if i := slices.Index(fallbackModels, m); i >= 0 {
+		return append(chain, fallbackModels[i+1:]...)
+	}
+	for _, fallback := range fallbackModels {
+		if fallback != m {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// withFallback sends the request, moving down the chain of fallback models while they are unavailable
+func withFallback(ctx context.Context, r *Request, post func(context.Context, *Request) (*Response, error)) (*Response, error) {
+	if len(fallbackModels) == 0 {
+		return post(ctx, r)
+	}
+	chain := fallbackChain(r.Model)
+	var err error
+	for i, m := range chain {
+		attempt := *r
+		attempt.Model = m
+		attempt.MaxTokens = min(r.MaxTokens, m.MaxOutputTokens())
+		for tries := 1; ; tries++ {
+			var resp *Response
+			resp, err = post(ctx, &attempt)
+			if err == nil {
+				if m != r.Model {
+					slog.Warn("answered by a fallback model", "requested", r.Model, "model", m)
+					apiFallbacks.Add(ctx, 1, metric.WithAttributes(attribute.String("gen_ai.request.model", string(r.Model)), attribute.String("gen_ai.response.model", string(m))))
+					r.Model, r.MaxTokens = m, attempt.MaxTokens
+				}
+				return resp, nil
+			}
+			status, retryAfter, ok := unavailable(err)
+			if !ok {
+				return nil, err
+			}
+			if status == StatusOverloaded || tries == rateLimitAttempts || retryAfter > maxRetryAfter {
+				break
+			}
+			if retryAfter == 0 {
+				retryAfter = time.Second
+			}
+			slog.Info("rate limited by the API, retrying", "model", m, "wait", retryAfter)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryAfter):
+			}
+		}
+		if i+1 < len(chain) {
+			slog.Warn("model unavailable, falling back", "model", m, "next", chain[i+1], "error", err)
+		}
+	}
+	return nil, err
+}
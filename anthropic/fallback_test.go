@@ -0,0 +1,144 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// scriptedPost answers each call with the next of errs, nil meaning a response, and records the models asked
+type scriptedPost struct {
+	errs   []error
+	models []Model
+	tokens []int
+}
+
+func (s *scriptedPost) post(ctx context.Context, r *Request) (*Response, error) {
+	s.models = append(s.models, r.Model)
+	s.tokens = append(s.tokens, r.MaxTokens)
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Model: r.Model, StopReason: EndTurn}, nil
+}
+
+func overloaded() error {
+	return &OverloadedError{APIError{Status: StatusOverloaded, Type: "overloaded_error", Message: "Overloaded"}}
+}
+
+func rateLimit(retryAfter time.Duration) error {
+	return &RateLimitError{APIError{Status: http.StatusTooManyRequests, Type: "rate_limit_error", Message: "slow down", RetryAfter: retryAfter}}
+}
+
+func useFallbacks(t *testing.T, models ...Model) {
+	SetFallbackModels(models)
+	t.Cleanup(func() { SetFallbackModels(nil) })
+}
+
+func TestFallbackChain(t *testing.T) {
+	useFallbacks(t, Opus, Sonnet, Haiku)
+	tests := []struct {
+		model Model
+		want  []Model
+	}{
+		{Opus, []Model{Opus, Sonnet, Haiku}},
+		{Sonnet, []Model{Sonnet, Haiku}},
+		{Haiku, []Model{Haiku}},
+		{Sonnet37, []Model{Sonnet37, Opus, Sonnet, Haiku}},
+	}
+	for _, tt := range tests {
+		if got := fallbackChain(tt.model); !slices.Equal(got, tt.want) {
+			t.Errorf("fallbackChain(%s) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestFallbackOnOverloaded(t *testing.T) {
+	useFallbacks(t, Sonnet37, Haiku)
+	s := &scriptedPost{errs: []error{overloaded(), nil}}
+	r := &Request{Model: Sonnet37, MaxTokens: 8000}
+
+	resp, err := withFallback(context.Background(), r, s.post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.models, []Model{Sonnet37, Haiku}) {
+		t.Errorf("asked %v, want the next model straight after a 529", s.models)
+	}
+	if !slices.Equal(s.tokens, []int{8000, Haiku.MaxOutputTokens()}) {
+		t.Errorf("max_tokens %v, want it lowered to what the fallback can write", s.tokens)
+	}
+	if resp.Model != Haiku || r.Model != Haiku || r.MaxTokens != Haiku.MaxOutputTokens() {
+		t.Errorf("request left on %s with max_tokens %d, want it on the model that answered", r.Model, r.MaxTokens)
+	}
+}
+
+func TestFallbackRetriesRateLimit(t *testing.T) {
+	useFallbacks(t, Sonnet, Haiku)
+	s := &scriptedPost{errs: []error{rateLimit(10 * time.Millisecond), nil}}
+	r := &Request{Model: Sonnet, MaxTokens: 1000}
+
+	if _, err := withFallback(context.Background(), r, s.post); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.models, []Model{Sonnet, Sonnet}) || r.Model != Sonnet {
+		t.Errorf("asked %v, want a single 429 retried on the same model", s.models)
+	}
+}
+
+func TestFallbackAfterRepeatedRateLimits(t *testing.T) {
+	useFallbacks(t, Sonnet, Haiku)
+	s := &scriptedPost{errs: []error{rateLimit(10 * time.Millisecond), rateLimit(10 * time.Millisecond), nil}}
+	r := &Request{Model: Sonnet, MaxTokens: 1000}
+
+	if _, err := withFallback(context.Background(), r, s.post); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.models, []Model{Sonnet, Sonnet, Haiku}) {
+		t.Errorf("asked %v, want the next model after %d 429s", s.models, rateLimitAttempts)
+	}
+
+	// A retry-after longer than the most worth waiting moves on at once
+	s = &scriptedPost{errs: []error{rateLimit(time.Minute), nil}}
+	r = &Request{Model: Sonnet, MaxTokens: 1000}
+	if _, err := withFallback(context.Background(), r, s.post); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(s.models, []Model{Sonnet, Haiku}) {
+		t.Errorf("asked %v, want the next model after a long retry-after", s.models)
+	}
+}
+
+func TestFallbackReturnsOtherErrors(t *testing.T) {
+	useFallbacks(t, Sonnet, Haiku)
+	invalid := &InvalidRequestError{APIError{Status: http.StatusBadRequest, Type: "invalid_request_error", Message: "bad"}}
+	s := &scriptedPost{errs: []error{invalid}}
+
+	_, err := withFallback(context.Background(), &Request{Model: Sonnet, MaxTokens: 1000}, s.post)
+	if !errors.Is(err, invalid) || len(s.models) != 1 {
+		t.Errorf("got %v after %d calls, want the 400 at once", err, len(s.models))
+	}
+}
+
+func TestFallbackExhausted(t *testing.T) {
+	useFallbacks(t, Sonnet, Haiku)
+	s := &scriptedPost{errs: []error{overloaded(), overloaded()}}
+
+	_, err := withFallback(context.Background(), &Request{Model: Sonnet, MaxTokens: 1000}, s.post)
+	var overloadedErr *OverloadedError
+	if !errors.As(err, &overloadedErr) {
+		t.Errorf("got %v, want the last model's 529", err)
+	}
+}
+
+func TestFallbackOff(t *testing.T) {
+	s := &scriptedPost{errs: []error{overloaded()}}
+	if _, err := withFallback(context.Background(), &Request{Model: Sonnet, MaxTokens: 1000}, s.post); err == nil || len(s.models) != 1 {
+		t.Errorf("got %v after %d calls, want the 529 without fallback models", err, len(s.models))
+	}
+}
@@ -0,0 +1,42 @@
+package anthropic
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// # IMAGES
+// Image content blocks for vision, sent inline as base64
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// NewImageContent wraps raw image bytes in an image content block, detecting the media type
+func NewImageContent(data []byte) (Content, error) {
+	mediaType := http.DetectContentType(data)
+	if !supportedImageTypes[mediaType] {
+		return Content{}, fmt.Errorf("unsupported image type '%s', must be PNG, JPEG, GIF or WebP", mediaType)
+	}
+	source := &ImageSource{Type: "base64", MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(data)}
+	return Content{Type: Image, Source: source}, nil
+}
+
+// NewImageContentFromFile reads a local image into an image content block
+func NewImageContentFromFile(filename string) (Content, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Content{}, fmt.Errorf("failed to read image: %v", err)
+	}
+	return NewImageContent(data)
+}
@@ -0,0 +1,160 @@
+package anthropic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// # KEY ROTATION
+// Spreading Messages API calls over several API keys, e.g. one per workspace, so one key's rate limits don't hold up everyone
+//   - round_robin takes the keys in turn, failover keeps to the first key and only moves on while it can't be used
+//   - Each key may have its own requests and tokens per minute, budgeted like the rate limits; a key out of budget is
+//     passed over while another has room, and waited on when none does
+//   - A 429 rests the key for its retry-after, or keyRest without one, and the call is sent again at once on the next key;
+//     once every key is resting the 429 is returned, for the fallback models to take over
+//   - Each key may send headers of its own, e.g. for a gateway that attributes spend by header; Client.Headers go with every key
+//   - Token counting and batches keep to the client's APIKey, which the caller sets to the first key
+const (
+	RoundRobin = "round_robin"
+	Failover   = "failover"
+
+	keyRest = 30 * time.Second
+)
+
+// APIKey is a key in the client's rotation
+type APIKey struct {
+	Name    string // for logs, never the key itself
+	Key     string
+	Limit   RateLimit
+	Headers map[string]string
+}
+
+type keyRing struct {
+	mu       sync.Mutex
+	keys     []*ringKey
+	next     int
+	failover bool
+}
+
+type ringKey struct {
+	APIKey
+	requests, tokens *bucket
+	restUntil        time.Time
+}
+
+// SetKeys rotates Messages API calls over keys, the rotation being RoundRobin, Failover or "" for round robin
+func (c *Client) SetKeys(keys []APIKey, rotation string) error {
+	if rotation != "" && rotation != RoundRobin && rotation != Failover {
+		return fmt.Errorf("unknown key rotation '%s', expected %s or %s", rotation, RoundRobin, Failover)
+	}
+	if len(keys) == 0 {
+		c.keys = nil
+		return nil
+	}
+	ring := &keyRing{failover: rotation == Failover}
+	for i, key := range keys {
+		if key.Key == "" {
+			return fmt.Errorf("API key %d (%s) is empty", i+1, key.Name)
+		}
+		if key.Name == "" {
+			key.Name = fmt.Sprintf("key %d", i+1)
+		}
+		ring.keys = append(ring.keys, &ringKey{APIKey: key, requests: newBucket(key.Limit.RequestsPerMinute), tokens: newBucket(key.Limit.TokensPerMinute)})
+	}
+	c.keys = ring
+	return nil
+}
+
+// send sends a call of about tokens input tokens on the keys in turn, until one isn't rate limited
+func (kr *keyRing) send(ctx context.Context, tokens int, post func(*ringKey) (*Response, error)) (*Response, error) {
+	tried := map[*ringKey]bool{}
+	var err error
+	for {
+		k, rest := kr.pick(tried, tokens)
+		if k == nil {
+			if err == nil {
+				err = &RateLimitError{APIError{Status: http.StatusTooManyRequests, Type: statusTypes[http.StatusTooManyRequests],
+					Message: "every API key is resting after a 429", RetryAfter: rest}}
+			}
+			return nil, err
+		}
+		tried[k] = true
+		if err := k.requests.take(ctx, 1); err != nil {
+			return nil, err
+		}
+		if err := k.tokens.take(ctx, tokens); err != nil {
+			k.requests.give(1)
+			return nil, err
+		}
+		slog.Debug("sending on API key", "key", k.Name)
+
+		var resp *Response
+		resp, err = post(k)
+		used := 0
+		if resp != nil {
+			used = resp.Usage.InputTokens + resp.Usage.CacheCreationInputTokens + resp.Usage.OutputTokens
+		}
+		k.tokens.give(tokens - used)
+		if status, retryAfter, ok := unavailable(err); ok && status == http.StatusTooManyRequests {
+			if retryAfter <= 0 {
+				retryAfter = keyRest
+			}
+			kr.rest(k, retryAfter)
+			slog.Warn("API key rate limited, resting it", "key", k.Name, "rest", retryAfter)
+			continue
+		}
+		return resp, err
+	}
+}
+
+// pick is the next key to send on that isn't resting or tried already, preferring one with room in its budget;
+// when there is none, it returns how long until the first resting key may be used again
+func (kr *keyRing) pick(tried map[*ringKey]bool, tokens int) (*ringKey, time.Duration) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	now := time.Now()
+	start := kr.next
+	if kr.failover {
+		start = 0
+	}
+	var chosen *ringKey
+	at, rest := 0, time.Duration(0)
+	for i := range kr.keys {
+		j := (start + i) % len(kr.keys)
+		k := kr.keys[j]
+		if tried[k] {
+			continue
+		}
+		if wait := k.restUntil.Sub(now); wait > 0 {
+			if rest == 0 || wait < rest {
+				rest = wait
+			}
+			continue
+		}
+		if chosen == nil || (!chosen.hasRoom(tokens) && k.hasRoom(tokens)) {
+			chosen, at = k, j
+		}
+		if chosen.hasRoom(tokens) {
+			break
+		}
+	}
+	if chosen != nil {
+		kr.next = (at + 1) % len(kr.keys)
+	}
+	return chosen, rest
+}
+
+func (kr *keyRing) rest(k *ringKey, d time.Duration) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k.restUntil = time.Now().Add(d)
+}
+
+// hasRoom reports whether the key's budget has a request and tokens to spare now
+func (k *ringKey) hasRoom(tokens int) bool {
+	return k.requests.room(1) && k.tokens.room(tokens)
+}
@@ -0,0 +1,110 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// keyServer answers every request, with a 429 for the keys in limited, and records the key each came on
+type keyServer struct {
+	mu      sync.Mutex
+	keys    []string
+	headers []http.Header
+	limited map[string]bool
+}
+
+func (s *keyServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	key := r.Header.Get("x-api-key")
+	s.keys = append(s.keys, key)
+	s.headers = append(s.headers, r.Header.Clone())
+	limited := s.limited[key]
+	s.mu.Unlock()
+	if limited {
+		w.Header().Set("retry-after", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+		return
+	}
+	writeReply(w, "ok")
+}
+
+func keyClient(t *testing.T, rotation string, limited ...string) (*Client, *keyServer) {
+	t.Helper()
+	s := &keyServer{limited: map[string]bool{}}
+	for _, key := range limited {
+		s.limited[key] = true
+	}
+	c := testClient(t, s.handle)
+	keys := []APIKey{{Name: "a", Key: "key-a"}, {Name: "b", Key: "key-b", Headers: map[string]string{"X-Team": "b"}}, {Name: "c", Key: "key-c"}}
+	if err := c.SetKeys(keys, rotation); err != nil {
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+func send(t *testing.T, c *Client, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := c.CreateMessage(context.Background(), testRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestKeysRoundRobin(t *testing.T) {
+	c, s := keyClient(t, RoundRobin)
+	send(t, c, 4)
+	if want := []string{"key-a", "key-b", "key-c", "key-a"}; !slices.Equal(s.keys, want) {
+		t.Errorf("sent on %v, want %v", s.keys, want)
+	}
+	if s.headers[1].Get("X-Team") != "b" || s.headers[0].Get("X-Team") != "" {
+		t.Errorf("a key's headers went with the wrong calls")
+	}
+}
+
+func TestKeysFailover(t *testing.T) {
+	c, s := keyClient(t, Failover, "key-a")
+	send(t, c, 2)
+	// key-a's 429 rests it, so the second call doesn't try it again
+	if want := []string{"key-a", "key-b", "key-b"}; !slices.Equal(s.keys, want) {
+		t.Errorf("sent on %v, want %v", s.keys, want)
+	}
+}
+
+func TestKeysAllResting(t *testing.T) {
+	c, s := keyClient(t, RoundRobin, "key-a", "key-b", "key-c")
+	_, err := c.CreateMessage(context.Background(), testRequest())
+	var rateErr *RateLimitError
+	if !errors.As(err, &rateErr) || len(s.keys) != 3 {
+		t.Fatalf("got %v after %d calls, want the last key's 429 once each key was tried", err, len(s.keys))
+	}
+
+	_, err = c.CreateMessage(context.Background(), testRequest())
+	if !errors.As(err, &rateErr) || rateErr.Message != "every API key is resting after a 429" || rateErr.RetryAfter <= 0 {
+		t.Fatalf("got %v, want a 429 saying every key is resting", err)
+	}
+	if len(s.keys) != 3 {
+		t.Errorf("sent %d calls, want none on resting keys", len(s.keys))
+	}
+}
+
+func TestSetKeys(t *testing.T) {
+	c := NewClient("")
+	if err := c.SetKeys([]APIKey{{Key: "k"}}, "random"); err == nil {
+		t.Error("accepted an unknown rotation")
+	}
+	if err := c.SetKeys([]APIKey{{Name: "empty"}}, ""); err == nil {
+		t.Error("accepted an empty key")
+	}
+	if err := c.SetKeys([]APIKey{{Key: "k"}}, ""); err != nil || c.keys.keys[0].Name != "key 1" {
+		t.Errorf("got %v, want an unnamed key named after its place", err)
+	}
+	if err := c.SetKeys(nil, ""); err != nil || c.keys != nil {
+		t.Error("no keys didn't turn rotation off")
+	}
+}
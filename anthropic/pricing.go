@@ -0,0 +1,47 @@
+package anthropic
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// # PRICING
+// Per-model token prices, in USD per million tokens
+//   - A model missing from ModelPricing costs nothing, which is warned about the first time each one is priced,
+//     since cost budgets can't count it
+type Pricing struct {
+	InputPerMTok  float64
+	OutputPerMTok float64
+}
+
+var ModelPricing = map[Model]Pricing{
+	Opus:   {InputPerMTok: 15, OutputPerMTok: 75},
+	Sonnet: {InputPerMTok: 3, OutputPerMTok: 15},
+	Haiku:  {InputPerMTok: 0.25, OutputPerMTok: 1.25},
+
+	Sonnet37: {InputPerMTok: 3, OutputPerMTok: 15},
+}
+
+// Prompt cache writes and reads are billed as multiples of the input price
+const (
+	cacheWriteMultiplier = 1.25
+	cacheReadMultiplier  = 0.1
+)
+
+// unpriced are the models without a price that have been warned about
+var unpriced sync.Map
+
+// Cost returns the price in USD of the usage on the given model, or 0 for unknown models
+func (u Usage) Cost(m Model) float64 {
+	p, ok := ModelPricing[m]
+	if !ok {
+		if _, warned := unpriced.LoadOrStore(m, true); !warned && m != "" {
+			slog.Warn("no price is known for the model, so its usage costs nothing and cost budgets don't count it", "model", m)
+		}
+		return 0
+	}
+	input := float64(u.InputTokens) +
+		float64(u.CacheCreationInputTokens)*cacheWriteMultiplier +
+		float64(u.CacheReadInputTokens)*cacheReadMultiplier
+	return input*p.InputPerMTok/1e6 + float64(u.OutputTokens)*p.OutputPerMTok/1e6
+}
@@ -0,0 +1,35 @@
+package anthropic
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestCost(t *testing.T) {
+	usage := Usage{InputTokens: 1_000_000, OutputTokens: 1_000_000, CacheCreationInputTokens: 1_000_000, CacheReadInputTokens: 1_000_000}
+	if got, want := usage.Cost(Sonnet), 3+15+3*1.25+3*0.1; got != want {
+		t.Errorf("cost %v, want %v", got, want)
+	}
+}
+
+func TestCostWarnsOnceAboutUnknownModels(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	unknown := Model("claude-unpriced-test")
+	for range 3 {
+		if cost := (Usage{InputTokens: 1000}).Cost(unknown); cost != 0 {
+			t.Errorf("cost %v for a model without a price", cost)
+		}
+	}
+	if n := strings.Count(logs.String(), "no price is known"); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, logs.String())
+	}
+	(Usage{InputTokens: 1000}).Cost(Haiku)
+	if strings.Count(logs.String(), "no price is known") != 1 {
+		t.Error("warned about a model with a price")
+	}
+}
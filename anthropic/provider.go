@@ -0,0 +1,61 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// # PROVIDERS
+// The services that serve Messages API requests: the Anthropic API itself (Client), AWS Bedrock and Google Vertex AI
+//   - They all take the same Request and return the same Response
+//   - They differ in the URL, the auth, and where the model, API version and betas go
+//   - count_tokens and batches are only available from the Anthropic API
+type Provider interface {
+	CreateMessage(ctx context.Context, r *Request) (*Response, error)
+}
+
+// TokenCounter is implemented by providers with a count_tokens endpoint
+type TokenCounter interface {
+	CountTokens(ctx context.Context, r *Request) (int, error)
+}
+
+var provider Provider
+
+// SetProvider installs the provider used by Request.Post
+func SetProvider(p Provider) {
+	provider = p
+	apiClient, _ = p.(*Client)
+}
+
+// GetProvider returns the provider installed by SetProvider or SetClient, or nil
+func GetProvider() Provider {
+	return provider
+}
+
+// cloudBody is the request body for Bedrock and Vertex, which take the model in the URL and the API version in the body
+func cloudBody(r *Request, version string, extra map[string]any) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	delete(body, "model")
+	body["anthropic_version"], _ = json.Marshal(version)
+	for k, v := range extra {
+		if body[k], err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(body)
+}
+
+// cloudModel maps the Anthropic model ids to a provider's ids, passing any other id through as it is
+func cloudModel(models map[Model]string, m Model) string {
+	if id, ok := models[m]; ok {
+		return id
+	}
+	return string(m)
+}
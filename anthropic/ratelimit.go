@@ -0,0 +1,180 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// # RATE LIMITS
+// Client-side token buckets that hold Messages API calls back to the organization's tier limits, instead of sending them to get a 429
+//   - Each model has a bucket of requests and one of tokens, refilled continuously over a minute,
+//     and shared by every conversation in the process, i.e. all sessions of the server, daemon or Slack bot
+//   - A call reserves an estimate of its input tokens before it is sent, and is charged what the response says it used,
+//     input and output tokens alike; tokens read from the prompt cache don't count, as the API doesn't count them either
+//   - A call bigger than a whole minute's tokens waits for a full bucket rather than forever
+//   - A limit of 0 is no limit
+const rateWindow = time.Minute
+
+// RateLimit is what a model may be sent per minute
+type RateLimit struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+}
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	fallback RateLimit
+	models   map[Model]RateLimit
+	buckets  map[Model]*modelBuckets
+}
+
+type modelBuckets struct {
+	requests, tokens *bucket
+}
+
+var (
+	limiter *rateLimiter
+
+	rateLimitWait, _ = meter.Float64Histogram("claude_agent.api.rate_limit_wait",
+		metric.WithUnit("s"), metric.WithDescription("Time Messages API calls were held back by the client-side rate limits"))
+)
+
+// SetRateLimits limits calls to each model to its entry in models, or to fallback for models without one
+func SetRateLimits(fallback RateLimit, models map[Model]RateLimit) {
+	if fallback == (RateLimit{}) && len(models) == 0 {
+		limiter = nil
+		return
+	}
+	limiter = &rateLimiter{fallback: fallback, models: models, buckets: map[Model]*modelBuckets{}}
+}
+
+// rateLimited waits until the request may be sent, then sends it and charges the tokens it used
+func rateLimited(ctx context.Context, r *Request, post func(context.Context, *Request) (*Response, error)) (*Response, error) {
+	if limiter == nil {
+		return post(ctx, r)
+	}
+	b := limiter.bucketsFor(r.Model)
+	estimate := estimateInputTokens(r)
+
+	start := time.Now()
+	if err := b.requests.take(ctx, 1); err != nil {
+		return nil, err
+	}
+	if err := b.tokens.take(ctx, estimate); err != nil {
+		b.requests.give(1)
+		return nil, err
+	}
+	if waited := time.Since(start); waited > 10*time.Millisecond {
+		slog.Info("rate limited, held the request back", "model", r.Model, "wait", waited.Round(time.Millisecond))
+		rateLimitWait.Record(ctx, waited.Seconds(), metric.WithAttributes(attribute.String("gen_ai.request.model", string(r.Model))))
+	}
+
+	resp, err := post(ctx, r)
+	used := 0
+	if resp != nil {
+		used = resp.Usage.InputTokens + resp.Usage.CacheCreationInputTokens + resp.Usage.OutputTokens
+	}
+	b.tokens.give(estimate - used) // negative when the call used more than estimated, which later calls wait out
+	return resp, err
+}
+
+func (l *rateLimiter) bucketsFor(m Model) *modelBuckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[m]; ok {
+		return b
+	}
+	limit, ok := l.models[m]
+	if !ok {
+		limit = l.fallback
+	}
+	b := &modelBuckets{requests: newBucket(limit.RequestsPerMinute), tokens: newBucket(limit.TokensPerMinute)}
+	l.buckets[m] = b
+	return b
+}
+
+// estimateInputTokens sizes the request from its JSON, at about 4 bytes a token
+func estimateInputTokens(r *Request) int {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0
+	}
+	return len(data) / 4
+}
+
+// bucket holds up to a minute's worth of tokens, nil when unlimited
+type bucket struct {
+	mu       sync.Mutex
+	capacity float64
+	level    float64
+	updated  time.Time
+}
+
+func newBucket(perMinute int) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{capacity: float64(perMinute), level: float64(perMinute), updated: time.Now()}
+}
+
+// refill adds what has dripped in since the last update, the caller holding mu
+func (b *bucket) refill() {
+	now := time.Now()
+	b.level = min(b.capacity, b.level+b.capacity*now.Sub(b.updated).Seconds()/rateWindow.Seconds())
+	b.updated = now
+}
+
+// take waits until n can be taken, or the bucket is full if n is more than it holds
+func (b *bucket) take(ctx context.Context, n int) error {
+	if b == nil {
+		return nil
+	}
+	want := min(float64(n), b.capacity)
+	for {
+		b.mu.Lock()
+		b.refill()
+		if b.level >= want {
+			b.level -= float64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((want - b.level) / b.capacity * float64(rateWindow))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// give returns n to the bucket, or takes -n more from it
+func (b *bucket) give(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.level = min(b.capacity, b.level+float64(n))
+}
+
+// room reports whether n could be taken without waiting
+func (b *bucket) room(n int) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.level >= min(float64(n), b.capacity)
+}
@@ -0,0 +1,115 @@
+package anthropic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBucketTake(t *testing.T) {
+	b := newBucket(600) // 10 a second
+	if err := b.take(context.Background(), 600); err != nil {
+		t.Fatal(err)
+	}
+	if b.room(50) {
+		t.Error("an emptied bucket has room")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.take(ctx, 100); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want to give up waiting with ctx", err)
+	}
+
+	b.give(600)
+	if !b.room(600) {
+		t.Error("a bucket given back its tokens has no room")
+	}
+}
+
+func TestBucketRefills(t *testing.T) {
+	b := newBucket(60000) // 1000 a second
+	if err := b.take(context.Background(), 60000); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := b.take(ctx, 50); err != nil {
+		t.Fatalf("got %v, want the bucket to refill", err)
+	}
+	if waited := time.Since(start); waited < 30*time.Millisecond {
+		t.Errorf("waited %s, want about 50ms for the refill", waited)
+	}
+}
+
+func TestBucketOversizedTake(t *testing.T) {
+	b := newBucket(100)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	// More than a minute's worth is taken from a full bucket rather than waited on forever, leaving it in debt
+	if err := b.take(ctx, 250); err != nil {
+		t.Fatalf("got %v, want a full bucket to serve it", err)
+	}
+	if b.room(1) {
+		t.Error("the bucket has room after an oversized take")
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	var b *bucket = newBucket(0)
+	if b != nil {
+		t.Fatal("a limit of 0 made a bucket")
+	}
+	if err := b.take(context.Background(), 1<<30); err != nil || !b.room(1<<30) {
+		t.Error("an unlimited bucket held a call back")
+	}
+	b.give(1)
+}
+
+func TestRateLimitedChargesUsage(t *testing.T) {
+	SetRateLimits(RateLimit{}, map[Model]RateLimit{Haiku: {RequestsPerMinute: 10, TokensPerMinute: 100000}})
+	t.Cleanup(func() { SetRateLimits(RateLimit{}, nil) })
+
+	r := testRequest()
+	estimate := estimateInputTokens(r)
+	post := func(ctx context.Context, r *Request) (*Response, error) {
+		return &Response{Usage: Usage{InputTokens: 1000, OutputTokens: 500, CacheReadInputTokens: 9000}}, nil
+	}
+	if _, err := rateLimited(context.Background(), r, post); err != nil {
+		t.Fatal(err)
+	}
+	b := limiter.bucketsFor(Haiku)
+	if got, want := b.tokens.level, float64(100000-1500); got < want || got > want+float64(estimate) {
+		t.Errorf("token bucket at %.0f, want about %.0f: charged the usage, not the estimate %d or the cache reads", got, want, estimate)
+	}
+	if got := b.requests.level; got < 9 || got >= 10 {
+		t.Errorf("request bucket at %.2f, want one request charged", got)
+	}
+	if limiter.bucketsFor(Sonnet).tokens != nil {
+		t.Error("a model without a limit and no fallback limit got a bucket")
+	}
+}
+
+func TestRateLimitedGivesUpWithContext(t *testing.T) {
+	SetRateLimits(RateLimit{RequestsPerMinute: 1}, nil)
+	t.Cleanup(func() { SetRateLimits(RateLimit{}, nil) })
+
+	calls := 0
+	post := func(ctx context.Context, r *Request) (*Response, error) {
+		calls++
+		return &Response{}, nil
+	}
+	if _, err := rateLimited(context.Background(), testRequest(), post); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := rateLimited(ctx, testRequest(), post); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the second request of the minute held back until ctx ended", err)
+	}
+	if calls != 1 {
+		t.Errorf("sent %d requests, want 1", calls)
+	}
+}
@@ -0,0 +1,122 @@
+package anthropic
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// # RECORD AND REPLAY
+// HTTP transports that save API responses to a directory and serve them back later,
+// for developing against the API offline without an API key or spending tokens
+//   - Responses are keyed by a hash of the method, path and request body, so headers and keys don't matter
+//   - A replayed request with no recording fails instead of falling through to the network
+
+type recording struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// requestKey hashes a request, restoring its body so it can still be sent
+func requestKey(req *http.Request) (string, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// RecordingTransport sends requests with Next, or http.DefaultTransport, and saves each response in Dir
+type RecordingTransport struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec := recording{Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Body: body}
+	if !json.Valid(body) {
+		rec.Body, _ = json.Marshal(string(body))
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(t.Dir, key+".json"), data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to save recording: %v", err)
+	}
+	return resp, nil
+}
+
+// ReplayTransport answers requests from the recordings in Dir without touching the network
+type ReplayTransport struct {
+	Dir string
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, err := requestKey(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(t.Dir, key+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording of %s %s (request %s) in %s, record it with --record first", req.Method, req.URL.Path, key, t.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %v", key, err)
+	}
+	body := []byte(rec.Body)
+	var s string
+	if json.Unmarshal(rec.Body, &s) == nil {
+		body = []byte(s) // a non-JSON body saved as a string
+	}
+	return &http.Response{
+		Status:        http.StatusText(rec.Status),
+		StatusCode:    rec.Status,
+		Header:        http.Header{"Content-Type": {rec.ContentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
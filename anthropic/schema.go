@@ -0,0 +1,188 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// # SCHEMA VALIDATION
+// Checking Claude's tool inputs against the tool's JSON Schema before running it
+//   - Checked: type, required, enum, and properties, additionalProperties and items, recursively
+//   - Other keywords are ignored, so a schema using them may let through inputs it doesn't allow
+//   - Every problem is reported at once, so Claude can fix them in one go
+
+func (s InputSchema) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(s.Extra)+3)
+	for k, v := range s.Extra {
+		out[k] = v
+	}
+	out["type"] = s.Type
+	out["properties"] = s.Properties
+	if s.Properties == nil {
+		out["properties"] = map[string]any{}
+	}
+	if len(s.Required) > 0 {
+		out["required"] = s.Required
+	}
+	return json.Marshal(out)
+}
+
+func (s *InputSchema) UnmarshalJSON(data []byte) error {
+	type schema InputSchema // drops these methods to avoid recursion
+	var known schema
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	delete(all, "type")
+	delete(all, "properties")
+	delete(all, "required")
+	if len(all) > 0 {
+		known.Extra = all
+	}
+	*s = InputSchema(known)
+	return nil
+}
+
+// Validate reports every way the input doesn't conform to the schema, or nil if it does
+func (s InputSchema) Validate(input map[string]any) error {
+	// A round trip through JSON gives schemas built in Go the same types as ones read from files
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return err
+	}
+	if input == nil {
+		input = map[string]any{}
+	}
+
+	var problems []string
+	validateValue("input", schema, input, &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("the input doesn't match the tool's schema: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validateValue(path string, schema map[string]any, v any, problems *[]string) {
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesType(v, types) {
+		*problems = append(*problems, fmt.Sprintf("%s must be %s, not %s", path, strings.Join(types, " or "), jsonType(v)))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			options := make([]string, len(enum))
+			for i, e := range enum {
+				b, _ := json.Marshal(e)
+				options[i] = string(b)
+			}
+			*problems = append(*problems, fmt.Sprintf("%s must be one of %s", path, strings.Join(options, ", ")))
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, ok := v[name]; !ok {
+					*problems = append(*problems, fmt.Sprintf("%s is required", join(path, name)))
+				}
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := properties[name].(map[string]any); ok {
+				validateValue(join(path, name), prop, v[name], problems)
+			} else if extra, ok := schema["additionalProperties"].(bool); ok && !extra {
+				*problems = append(*problems, fmt.Sprintf("%s is not an allowed property", join(path, name)))
+			} else if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+				validateValue(join(path, name), extra, v[name], problems)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				validateValue(fmt.Sprintf("%s[%d]", path, i), items, item, problems)
+			}
+		}
+	}
+}
+
+// join names a property, dropping the "input" root for top-level ones
+func join(path, name string) string {
+	if path == "input" {
+		return name
+	}
+	return path + "." + name
+}
+
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, s := range t {
+			if s, ok := s.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(v any, types []string) bool {
+	actual := jsonType(v)
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON type of a decoded value, telling integers from other numbers
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
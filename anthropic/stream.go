@@ -0,0 +1,190 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// # STREAMING
+// Receiving a reply as server-sent events, so text can be shown as it is written instead of all at once
+//   - A request with OnText set is streamed, and OnText is called with each piece of text as it arrives
+//   - The events are put back together into the same Response a request that isn't streamed gets,
+//     so fallback, rate limits, tracing and tool calls work the same either way
+//   - Only the Anthropic API streams; Bedrock and Vertex answer in one piece, which OnText then gets all at once
+//   - An error event mid-stream, such as overloaded_error, fails the request like the same error as a response would
+
+// streamEvent is any of the events of a streamed reply, only the fields of its type are set
+type streamEvent struct {
+	Type         string    `json:"type"`
+	Message      *Response `json:"message"` // message_start
+	Index        int       `json:"index"`
+	ContentBlock *Content  `json:"content_block"` // content_block_start
+	Delta        struct {
+		Type        string    `json:"type"`
+		Text        string    `json:"text"`         // text_delta
+		PartialJSON string    `json:"partial_json"` // input_json_delta
+		Thinking    string    `json:"thinking"`     // thinking_delta
+		Signature   string    `json:"signature"`    // signature_delta
+		Citation    *Citation `json:"citation"`     // citations_delta
+		// message_delta
+		StopReason   StopReason `json:"stop_reason"`
+		StopSequence string     `json:"stop_sequence"`
+	} `json:"delta"`
+	Usage *Usage `json:"usage"` // message_delta
+}
+
+// streams reports whether p streams replies to OnText itself
+func streams(p Provider) bool {
+	_, ok := p.(*Client)
+	return ok
+}
+
+// withStream adds "stream": true to a request body
+func withStream(jsonRequest []byte) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(jsonRequest, &body); err != nil {
+		return nil, err
+	}
+	body["stream"] = json.RawMessage("true")
+	return json.Marshal(body)
+}
+
+// streamMessage sends a streamed Messages request built by a provider, calling onText with each piece of text
+func streamMessage(client *http.Client, req *http.Request, jsonRequest []byte, onText func(string)) (*Response, error) {
+	slog.Debug("anthropic request", "url", req.URL.String(), "headers", redactHeaders(req.Header), "body", json.RawMessage(jsonRequest), "stream", true)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err)
+		}
+		slog.Debug("anthropic response", "status", resp.StatusCode, "body", rawOrString(body))
+		slog.Warn("anthropic request failed", "status", resp.StatusCode)
+		return nil, newAPIError(resp.StatusCode, resp.Header, body)
+	}
+
+	var message *Response
+	var inputs []strings.Builder // the tool input of each block so far, as JSON
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue // event: lines repeat the type that's in the data, blank lines end events
+		}
+		var event streamEvent
+		if err := json.Unmarshal(bytes.TrimSpace(data), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode stream event: %v", err)
+		}
+		if event.Type == "error" {
+			slog.Debug("anthropic stream error", "body", json.RawMessage(data))
+			return nil, newAPIError(streamErrorStatus(data), resp.Header, data)
+		}
+		if event.Type != "message_start" && event.Type != "ping" && message == nil {
+			return nil, fmt.Errorf("stream event %s before message_start", event.Type)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message == nil {
+				return nil, fmt.Errorf("message_start without a message")
+			}
+			message = event.Message
+			message.Content = nil
+		case "content_block_start":
+			if event.ContentBlock == nil || event.Index != len(message.Content) {
+				return nil, fmt.Errorf("content_block_start for block %d out of order", event.Index)
+			}
+			block := *event.ContentBlock
+			if block.Type == Text && block.Text != "" && onText != nil {
+				onText(block.Text)
+			}
+			message.Content = append(message.Content, block)
+			inputs = append(inputs, strings.Builder{})
+		case "content_block_delta":
+			if event.Index < 0 || event.Index >= len(message.Content) {
+				return nil, fmt.Errorf("content_block_delta for block %d that hasn't started", event.Index)
+			}
+			block := &message.Content[event.Index]
+			switch event.Delta.Type {
+			case "text_delta":
+				block.Text += event.Delta.Text
+				if onText != nil {
+					onText(event.Delta.Text)
+				}
+			case "input_json_delta":
+				inputs[event.Index].WriteString(event.Delta.PartialJSON)
+			case "thinking_delta":
+				block.Thinking += event.Delta.Thinking
+			case "signature_delta":
+				block.Signature += event.Delta.Signature
+			case "citations_delta":
+				if event.Delta.Citation != nil {
+					if block.Citations == nil {
+						block.Citations = &Citations{}
+					}
+					block.Citations.List = append(block.Citations.List, *event.Delta.Citation)
+				}
+			}
+		case "content_block_stop":
+			if event.Index < 0 || event.Index >= len(message.Content) {
+				return nil, fmt.Errorf("content_block_stop for block %d that hasn't started", event.Index)
+			}
+			block := &message.Content[event.Index]
+			if block.Type == ToolUse {
+				block.Input = map[string]any{}
+				if input := inputs[event.Index].String(); input != "" {
+					if err := json.Unmarshal([]byte(input), &block.Input); err != nil {
+						return nil, fmt.Errorf("failed to decode the input of tool %s: %v", block.Name, err)
+					}
+				}
+			}
+		case "message_delta":
+			message.StopReason = event.Delta.StopReason
+			message.StopSequence = event.Delta.StopSequence
+			if event.Usage != nil {
+				message.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			if message.Content == nil {
+				message.Content = []Content{}
+			}
+			if messageJSON, err := json.Marshal(message); err == nil {
+				slog.Debug("anthropic response", "status", resp.StatusCode, "body", json.RawMessage(messageJSON), "stream", true)
+			}
+			return message, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the stream: %v", err)
+	}
+	return nil, fmt.Errorf("the stream ended before message_stop")
+}
+
+// streamErrorStatus is the status an error event would have had as a response, for typing it the same way
+func streamErrorStatus(data []byte) int {
+	var body struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	json.Unmarshal(data, &body)
+	for status, errorType := range statusTypes {
+		if errorType == body.Error.Type {
+			return status
+		}
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,90 @@
+package anthropic
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// # TELEMETRY
+// A span and metrics for each Messages API call, named after the OpenTelemetry gen_ai semantic conventions
+// They go to the global OpenTelemetry providers, see the telemetry package
+const instrumentation = "github.com/hunterjsb/super-claude/anthropic"
+
+var (
+	tracer = otel.Tracer(instrumentation)
+	meter  = otel.Meter(instrumentation)
+
+	// The buckets are the ones the gen_ai conventions recommend, the defaults are sized for milliseconds
+	apiDuration, _ = meter.Float64Histogram("gen_ai.client.operation.duration",
+		metric.WithUnit("s"), metric.WithDescription("Duration of Messages API calls"),
+		metric.WithExplicitBucketBoundaries(0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28, 2.56, 5.12, 10.24, 20.48, 40.96, 81.92, 163.84, 327.68))
+	tokenUsage, _ = meter.Int64Histogram("gen_ai.client.token.usage",
+		metric.WithUnit("{token}"), metric.WithDescription("Tokens used per Messages API call, by gen_ai.token.type"),
+		metric.WithExplicitBucketBoundaries(1, 4, 16, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576))
+	apiErrors, _ = meter.Int64Counter("claude_agent.api.errors",
+		metric.WithDescription("Failed Messages API calls"))
+	apiCost, _ = meter.Float64Counter("claude_agent.api.cost",
+		metric.WithUnit("USD"), metric.WithDescription("Estimated cost of Messages API calls, at list prices"))
+)
+
+// providerSystem is the gen_ai.system of a provider
+func providerSystem(p Provider) string {
+	switch p.(type) {
+	case *Bedrock:
+		return "aws.bedrock"
+	case *Vertex:
+		return "gcp.vertex_ai"
+	}
+	return "anthropic"
+}
+
+// tracedPost calls the provider inside a span, recording the outcome as span attributes and metrics
+func tracedPost(ctx context.Context, r *Request) (*Response, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("gen_ai.system", providerSystem(r.sender())),
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.request.model", string(r.Model)),
+	}
+	ctx, span := tracer.Start(ctx, "chat "+string(r.Model), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...), trace.WithAttributes(attribute.Int("gen_ai.request.max_tokens", r.MaxTokens)))
+	defer span.End()
+
+	start := time.Now()
+	resp, err := r.sender().CreateMessage(ctx, r)
+	elapsed := time.Since(start).Seconds()
+
+	if err != nil {
+		errorType := "api_error"
+		if ctx.Err() != nil {
+			errorType = "cancelled"
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		attrs = append(attrs, attribute.String("error.type", errorType))
+		apiErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
+		apiDuration.Record(ctx, elapsed, metric.WithAttributes(attrs...))
+		return nil, err
+	}
+
+	attrs = append(attrs, attribute.String("gen_ai.response.model", string(resp.Model)))
+	span.SetAttributes(
+		attribute.String("gen_ai.response.id", resp.ID),
+		attribute.String("gen_ai.response.model", string(resp.Model)),
+		attribute.StringSlice("gen_ai.response.finish_reasons", []string{string(resp.StopReason)}),
+		attribute.Int("gen_ai.usage.input_tokens", resp.Usage.InputTokens),
+		attribute.Int("gen_ai.usage.output_tokens", resp.Usage.OutputTokens),
+		attribute.Int("gen_ai.usage.cache_creation_input_tokens", resp.Usage.CacheCreationInputTokens),
+		attribute.Int("gen_ai.usage.cache_read_input_tokens", resp.Usage.CacheReadInputTokens),
+	)
+	apiDuration.Record(ctx, elapsed, metric.WithAttributes(attrs...))
+	tokenUsage.Record(ctx, int64(resp.Usage.InputTokens), metric.WithAttributes(append(attrs, attribute.String("gen_ai.token.type", "input"))...))
+	tokenUsage.Record(ctx, int64(resp.Usage.OutputTokens), metric.WithAttributes(append(attrs, attribute.String("gen_ai.token.type", "output"))...))
+	apiCost.Add(ctx, resp.Usage.Cost(resp.Model), metric.WithAttributes(attrs...))
+	return resp, nil
+}
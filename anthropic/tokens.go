@@ -0,0 +1,85 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// # TOKEN COUNTING
+// Counting a request's input tokens with the count_tokens endpoint before sending it
+const COUNT_TOKENS_PATH = "/v1/messages/count_tokens"
+
+// DefaultContextWindow is the context window of every Claude 3 model, in tokens
+const DefaultContextWindow = 200000
+
+var ModelContextWindows = map[Model]int{
+	Opus:   200000,
+	Sonnet: 200000,
+	Haiku:  200000,
+
+	Sonnet37: 200000,
+}
+
+// DefaultMaxOutputTokens is the most every Claude 3 model can write in one response
+const DefaultMaxOutputTokens = 4096
+
+var ModelMaxOutputTokens = map[Model]int{
+	Opus:   4096,
+	Sonnet: 4096,
+	Haiku:  4096,
+
+	Sonnet37: 64000, // 128000 with the output-128k-2025-02-19 beta and max_tokens set to match
+}
+
+// MaxOutputTokens is the largest max_tokens the model accepts, and the default when none is configured
+func (m Model) MaxOutputTokens() int {
+	if n, ok := ModelMaxOutputTokens[m]; ok {
+		return n
+	}
+	return DefaultMaxOutputTokens
+}
+
+// ContextWindow is the most tokens a request's input and output may add up to on the model
+func (m Model) ContextWindow() int {
+	if n, ok := ModelContextWindows[m]; ok {
+		return n
+	}
+	return DefaultContextWindow
+}
+
+// countTokensRequest is the part of a Request the endpoint accepts, it rejects max_tokens and sampling parameters
+type countTokensRequest struct {
+	Model      Model           `json:"model"`
+	Messages   []Message       `json:"messages"`
+	System     string          `json:"system,omitempty"`
+	Tools      []Tool          `json:"tools,omitempty"`
+	ToolChoice *ToolChoice     `json:"tool_choice,omitempty"`
+	Thinking   *ThinkingConfig `json:"thinking,omitempty"`
+}
+
+// CountTokens returns the number of input tokens the request would use, if its provider can count them
+func (r *Request) CountTokens(ctx context.Context) (int, error) {
+	counter, ok := r.sender().(TokenCounter)
+	if !ok {
+		return 0, fmt.Errorf("the provider doesn't support count_tokens")
+	}
+	return counter.CountTokens(ctx, r)
+}
+
+func (c *Client) CountTokens(ctx context.Context, r *Request) (int, error) {
+	in := countTokensRequest{Model: r.Model, Messages: r.Messages, System: r.System, Tools: r.Tools, ToolChoice: r.ToolChoice, Thinking: r.Thinking}
+	body, err := c.doJSON(ctx, "POST", strings.TrimRight(c.BaseURL, "/")+COUNT_TOKENS_PATH, in)
+	if err != nil {
+		return 0, err
+	}
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	err = json.Unmarshal(body, &count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return count.InputTokens, nil
+}
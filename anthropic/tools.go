@@ -1,99 +1,53 @@
 package anthropic
 
-import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"path/filepath"
-	"plugin"
-	"strings"
-)
-
 // # TOOLS
 // Tools that Claude can use to take actions on the user's behalf
-// They are specified in the `tools` directory as JSON files
-// The name of each tool is mapped to a function
+// Their definitions are sent with each request, see the agent package for loading and running them
 type Tool struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
-	InputSchema inputSchema `json:"input_schema"`
-}
-
-type useTool func(map[string]any) Content
+	InputSchema InputSchema `json:"input_schema"`
 
-var ToolMap = map[string]useTool{}
-
-type inputSchema struct {
-	Type       string                 `json:"type"`
-	Properties map[string]interface{} `json:"properties"`
-	Requires   []string               `json:"requires"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
 }
 
-func LoadToolFromJSONFile(filename string) (*Tool, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read JSON file: %v", err)
-	}
+// InputSchema is the JSON Schema of a tool's input, see schema.go for validating inputs against it
+type InputSchema struct {
+	Type       string         `json:"type"`
+	Properties map[string]any `json:"properties"`
+	Required   []string       `json:"required,omitempty"`
+	// Extra holds any other keywords, such as additionalProperties or $defs, so the schema is sent as it was written
+	Extra map[string]any `json:"-"`
+}
 
-	var toolJSON Tool
-	err = json.Unmarshal(data, &toolJSON)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
-	}
+// ToolChoice controls whether Claude must use tools
+//   - auto: Claude decides (the default)
+//   - any:  Claude must use one of the tools
+//   - tool: Claude must use the tool called Name
+//   - none: Claude must not use tools, though they are still defined
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
 
-	tool := &Tool{
-		Name:        toolJSON.Name,
-		Description: toolJSON.Description,
-		InputSchema: toolJSON.InputSchema,
+// ParseToolChoice reads "auto", "any", or a tool name, returning nil for ""
+func ParseToolChoice(s string) *ToolChoice {
+	switch s {
+	case "":
+		return nil
+	case "auto", "any":
+		return &ToolChoice{Type: s}
+	default:
+		return &ToolChoice{Type: "tool", Name: s}
 	}
-
-	return tool, nil
 }
 
-func LoadToolsFromDirectory(dir string) ([]Tool, error) {
-	toolJSONs := make([]Tool, 0)
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if path == "tools" {
-			return nil
-		}
-		if info.IsDir() {
-			toolName := filepath.Base(path)
-			if strings.HasPrefix(toolName, "__") {
-				return filepath.SkipDir
-			}
-			toolJSONPath := filepath.Join(path, toolName+".json")
-			toolGoPath := filepath.Join(path, toolName+".so")
-			// Load the tool JSON file
-			toolJSON, err := LoadToolFromJSONFile(toolJSONPath)
-			if err != nil {
-				return fmt.Errorf("failed to load tool JSON from file '%s': %v", toolJSONPath, err)
-			}
-			toolJSONs = append(toolJSONs, *toolJSON)
-			// Load the tool's Go plugin
-			plug, err := plugin.Open(toolGoPath)
-			if err != nil {
-				return fmt.Errorf("failed to load tool plugin from file '%s': %v", toolGoPath, err)
-			}
-			// Look up the UseTool function in the plugin
-			useToolFunc, err := plug.Lookup(strings.ToUpper(toolName))
-			if err != nil {
-				return fmt.Errorf("failed to find %s function in plugin '%s': %v", toolName, toolGoPath, err)
-			}
-			// Assert that the UseTool function has the correct type
-			useTool, ok := useToolFunc.(func(map[string]any) Content)
-			if !ok {
-				return fmt.Errorf("%s function in plugin '%s' has incorrect type", toolName, toolGoPath)
-			}
-			// Add the tool to the Tools map
-			ToolMap[toolName] = useTool
-		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to walk directory '%s': %v", dir, err)
+func (tc *ToolChoice) String() string {
+	if tc == nil {
+		return "auto"
+	}
+	if tc.Type == "tool" {
+		return tc.Name
 	}
-	return toolJSONs, nil
+	return tc.Type
 }
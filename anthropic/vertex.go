@@ -0,0 +1,118 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// # VERTEX AI
+// Claude on Google Vertex AI, through the rawPredict endpoint
+//   - Requests carry an OAuth access token, from GOOGLE_ACCESS_TOKEN or the gcloud CLI
+//   - The model goes in the URL, as a Vertex model id
+//   - Betas go in the anthropic-beta header, as on the Anthropic API
+const (
+	vertexVersion  = "vertex-2023-10-16"
+	vertexTokenTTL = 45 * time.Minute // gcloud tokens last an hour
+)
+
+var VertexModels = map[Model]string{
+	Opus:   "claude-3-opus@20240229",
+	Sonnet: "claude-3-sonnet@20240229",
+	Haiku:  "claude-3-haiku@20240307",
+
+	Sonnet37: "claude-3-7-sonnet@20250219",
+}
+
+type Vertex struct {
+	ProjectID  string
+	Region     string
+	BaseURL    string // defaults to https://<region>-aiplatform.googleapis.com, or https://aiplatform.googleapis.com for the global region
+	HTTPClient *http.Client
+	Betas      []string // sent with every request, along with any it needs
+	// TokenSource returns an access token for each request, see NewVertex
+	TokenSource func(ctx context.Context) (string, error)
+}
+
+// NewVertex uses GOOGLE_ACCESS_TOKEN as the access token if it is set, otherwise tokens from
+// `gcloud auth print-access-token`, which are refreshed before they expire
+func NewVertex(projectID, region string) *Vertex {
+	v := &Vertex{ProjectID: projectID, Region: region, HTTPClient: NewHTTPClient(DefaultTimeouts)}
+	if token := os.Getenv("GOOGLE_ACCESS_TOKEN"); token != "" {
+		v.TokenSource = func(context.Context) (string, error) { return token, nil }
+	} else {
+		v.TokenSource = gcloudTokenSource()
+	}
+	return v
+}
+
+func gcloudTokenSource() func(ctx context.Context) (string, error) {
+	var (
+		mu      sync.Mutex
+		token   string
+		expires time.Time
+	)
+	return func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if token != "" && time.Now().Before(expires) {
+			return token, nil
+		}
+		out, err := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to get a Google access token from gcloud, set GOOGLE_ACCESS_TOKEN or run `gcloud auth login`: %v", err)
+		}
+		token, expires = strings.TrimSpace(string(out)), time.Now().Add(vertexTokenTTL)
+		return token, nil
+	}
+}
+
+func (v *Vertex) CreateMessage(ctx context.Context, r *Request) (*Response, error) {
+	jsonRequest, err := cloudBody(r, vertexVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	base := v.BaseURL
+	if base == "" && v.Region == "global" {
+		base = "https://aiplatform.googleapis.com"
+	} else if base == "" {
+		base = fmt.Sprintf("https://%s-aiplatform.googleapis.com", v.Region)
+	}
+	url := fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:rawPredict",
+		strings.TrimRight(base, "/"), v.ProjectID, v.Region, cloudModel(VertexModels, r.Model))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonRequest))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if betas := mergeBetas(v.Betas, r.betas()); len(betas) > 0 {
+		req.Header.Set("anthropic-beta", strings.Join(betas, ","))
+	}
+	if v.TokenSource != nil {
+		token, err := v.TokenSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := postMessage(client, req, jsonRequest)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Model == "" {
+		resp.Model = r.Model
+	}
+	return resp, nil
+}
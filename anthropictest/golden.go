@@ -0,0 +1,102 @@
+package anthropictest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// # GOLDEN CASES
+// Scripted conversations kept as JSON, each run against the fake server and compared with a golden file
+// holding what it should produce, so a change to the requests sent or the turns returned shows up as a diff
+//   - <name>.json holds the prompts and, for each, the replies the server gives until the turn ends
+//   - <name>.golden is rewritten from the current output when updating, and checked otherwise
+type Case struct {
+	Name        string `json:"-"` // the file name without .json
+	Description string `json:"description"`
+	Model       string `json:"model,omitempty"`
+	System      string `json:"system,omitempty"`
+	// FallbackModels answer when the model's replies are overloaded or rate limited errors
+	FallbackModels []string `json:"fallback_models,omitempty"`
+	Turns          []Turn   `json:"turns"`
+}
+
+type Turn struct {
+	Prompt  string  `json:"prompt"`
+	Replies []Reply `json:"replies"`
+}
+
+// Replies is every turn's replies in order, as the server serves them
+func (c Case) Replies() []Reply {
+	var replies []Reply
+	for _, turn := range c.Turns {
+		replies = append(replies, turn.Replies...)
+	}
+	return replies
+}
+
+// LoadCases reads the cases in dir, sorted by name
+func LoadCases(dir string) ([]Case, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	cases := make([]Case, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read case: %v", err)
+		}
+		var c Case
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid case %s: %v", file, err)
+		}
+		c.Name = strings.TrimSuffix(filepath.Base(file), ".json")
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// CompareGolden checks got against the golden file at path, or writes it there if update is set
+func CompareGolden(path string, got []byte, update bool) error {
+	if update {
+		return os.WriteFile(path, got, 0o644)
+	}
+	want, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no golden file %s yet, run with -update to create it", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read golden file: %v", err)
+	}
+	if bytes.Equal(got, want) {
+		return nil
+	}
+	return fmt.Errorf("output differs from %s:\n%s", path, firstDifference(string(want), string(got)))
+}
+
+// firstDifference shows the first line where want and got differ, with a few lines of context before it
+func firstDifference(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	i := 0
+	for i < len(wantLines) && i < len(gotLines) && wantLines[i] == gotLines[i] {
+		i++
+	}
+	var b strings.Builder
+	for j := max(0, i-3); j < i; j++ {
+		fmt.Fprintf(&b, "  %4d   %s\n", j+1, wantLines[j])
+	}
+	if i < len(wantLines) {
+		fmt.Fprintf(&b, "- %4d   %s\n", i+1, wantLines[i])
+	}
+	if i < len(gotLines) {
+		fmt.Fprintf(&b, "+ %4d   %s\n", i+1, gotLines[i])
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,268 @@
+package anthropictest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # FAKE MESSAGES API
+// An httptest server that answers Messages API requests with scripted replies, for testing without credentials
+//   - Replies are served in order, text and tool_use blocks alike, and the requests that got them are kept
+//   - A request asking to stream gets the reply as the server-sent events the real API sends
+//   - Requests are checked the way the API checks them, e.g. that every tool_use has its tool_result,
+//     and rejected with a 400 invalid_request_error if they would be
+//   - count_tokens is answered too, with an estimate
+type Reply struct {
+	Text       string               `json:"text,omitempty" yaml:"text"`
+	ToolUses   []ToolUse            `json:"tool_uses,omitempty" yaml:"tool_uses"`     // tool_use blocks after the text
+	StopReason anthropic.StopReason `json:"stop_reason,omitempty" yaml:"stop_reason"` // end_turn, or tool_use if there are ToolUses
+	Usage      *anthropic.Usage     `json:"usage,omitempty" yaml:"usage"`             // estimated from the request and reply if nil
+
+	// An error response instead, with this HTTP status, e.g. 429 or 529
+	Status int    `json:"status,omitempty" yaml:"status"`
+	Error  string `json:"error,omitempty" yaml:"error"`
+}
+
+type ToolUse struct {
+	Name  string         `json:"name" yaml:"name"`
+	Input map[string]any `json:"input" yaml:"input"`
+}
+
+// Server is a fake Messages API, the URL of which goes in a Client's BaseURL
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  []Reply
+	requests []anthropic.Request
+	served   int
+}
+
+// NewServer starts a server answering with replies, one per request
+func NewServer(replies ...Reply) *Server {
+	s := &Server{replies: replies}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+anthropic.MESSAGES_PATH, s.handleMessages)
+	mux.HandleFunc("POST "+anthropic.COUNT_TOKENS_PATH, s.handleCountTokens)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Client returns an API client for the server
+func (s *Server) Client() *anthropic.Client {
+	c := anthropic.NewClient("sk-ant-test")
+	c.BaseURL = s.URL
+	return c
+}
+
+// Requests returns the Messages requests received so far, including rejected ones
+func (s *Server) Requests() []anthropic.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]anthropic.Request(nil), s.requests...)
+}
+
+// Remaining is how many replies haven't been served yet
+func (s *Server) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.replies) - s.served
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req, stream, err := decodeRequest(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	if err := validate(req); err != nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.served == len(s.replies) {
+		s.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no scripted reply left for request %d", len(s.requests)))
+		return
+	}
+	reply := s.replies[s.served]
+	s.served++
+	n := s.served
+	s.mu.Unlock()
+
+	if reply.Status != 0 {
+		writeError(w, reply.Status, reply.Error)
+		return
+	}
+	resp := reply.response(n, req, body)
+	if stream {
+		writeEvents(w, resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleCountTokens(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"input_tokens": estimate(body)})
+}
+
+// decodeRequest reads a Messages request, putting a system prompt sent as cacheable blocks back into a string
+func decodeRequest(body []byte) (anthropic.Request, bool, error) {
+	type request anthropic.Request
+	var in struct {
+		request
+		System json.RawMessage `json:"system"`
+		Stream bool            `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return anthropic.Request{}, false, err
+	}
+	req := anthropic.Request(in.request)
+	var blocks []anthropic.Content
+	switch {
+	case len(in.System) == 0:
+	case json.Unmarshal(in.System, &blocks) == nil:
+		var system []string
+		for _, block := range blocks {
+			system = append(system, block.Text)
+		}
+		req.System, req.PromptCaching = strings.Join(system, "\n"), true
+	default:
+		if err := json.Unmarshal(in.System, &req.System); err != nil {
+			return req, false, fmt.Errorf("system: %v", err)
+		}
+	}
+	return req, in.Stream, nil
+}
+
+// response is the reply as the nth response, with ids that are the same on every run
+func (reply Reply) response(n int, req anthropic.Request, body []byte) anthropic.Response {
+	resp := anthropic.Response{
+		ID:         fmt.Sprintf("msg_%02d", n),
+		Type:       anthropic.MessageResp,
+		Role:       anthropic.Assistant,
+		Model:      req.Model,
+		StopReason: reply.StopReason,
+		Content:    []anthropic.Content{},
+	}
+	if reply.Text != "" {
+		resp.Content = append(resp.Content, anthropic.Content{Type: anthropic.Text, Text: reply.Text})
+	}
+	for i, use := range reply.ToolUses {
+		input := use.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+		resp.Content = append(resp.Content, anthropic.Content{Type: anthropic.ToolUse, Id: fmt.Sprintf("toolu_%02d_%02d", n, i+1), Name: use.Name, Input: input})
+	}
+	if resp.StopReason == "" {
+		resp.StopReason = anthropic.EndTurn
+		if len(reply.ToolUses) > 0 {
+			resp.StopReason = "tool_use"
+		}
+	}
+	if reply.Usage != nil {
+		resp.Usage = *reply.Usage
+	} else {
+		out, _ := json.Marshal(resp.Content)
+		resp.Usage = anthropic.Usage{InputTokens: estimate(body), OutputTokens: estimate(out)}
+	}
+	return resp
+}
+
+// estimate sizes JSON at about 4 tokens for every 3 words, which unlike its length in bytes
+// doesn't change with the paths of temporary directories
+func estimate(body []byte) int {
+	return len(bytes.Fields(body))*4/3 + 1
+}
+
+// validate rejects requests the real API would, with its wording where it matters
+func validate(req anthropic.Request) error {
+	if req.Model == "" {
+		return fmt.Errorf("model: Field required")
+	}
+	if req.MaxTokens < 1 {
+		return fmt.Errorf("max_tokens: Field required")
+	}
+	if len(req.Messages) == 0 {
+		return fmt.Errorf("messages: at least one message is required")
+	}
+	if req.Messages[0].Role != anthropic.User {
+		return fmt.Errorf("messages: first message must use the \"user\" role")
+	}
+	for i, msg := range req.Messages {
+		if len(msg.Content) == 0 {
+			return fmt.Errorf("messages.%d: all messages must have non-empty content", i)
+		}
+		if msg.Role != anthropic.Assistant {
+			continue
+		}
+		var uses []string
+		for _, cont := range msg.Content {
+			if cont.Type == anthropic.ToolUse {
+				uses = append(uses, cont.Id)
+			}
+		}
+		if len(uses) == 0 {
+			continue
+		}
+		if i+1 == len(req.Messages) {
+			return fmt.Errorf("messages.%d: tool_use ids were found without tool_result blocks immediately after: %s", i, strings.Join(uses, ", "))
+		}
+		results := map[string]bool{}
+		for _, cont := range req.Messages[i+1].Content {
+			if cont.Type == anthropic.ToolResult {
+				results[cont.ToolUseId] = true
+			}
+		}
+		for _, id := range uses {
+			if !results[id] {
+				return fmt.Errorf("messages.%d: tool_use ids were found without tool_result blocks immediately after: %s", i, id)
+			}
+		}
+	}
+	return nil
+}
+
+// writeError answers with the API's error body, typed by status
+func writeError(w http.ResponseWriter, status int, message string) {
+	errorType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errorType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case http.StatusNotFound:
+		errorType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	case 529:
+		errorType = "overloaded_error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"type": "error", "error": map[string]string{"type": errorType, "message": message}})
+}
@@ -0,0 +1,83 @@
+package anthropictest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+)
+
+// # STREAMING
+// The events of a streamed reply, in the order the API sends them:
+// message_start, then content_block_start, a delta per chunk and content_block_stop for each block,
+// then message_delta with the stop reason and output tokens, and message_stop
+//   - Text arrives a few words at a time, as text_delta, and tool input as input_json_delta fragments
+
+// chunkSize is how many bytes of text or tool input go in each delta
+const chunkSize = 16
+
+func writeEvents(w http.ResponseWriter, resp anthropic.Response) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	event := func(name string, data map[string]any) {
+		data["type"] = name
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	event("message_start", map[string]any{"message": map[string]any{
+		"id": resp.ID, "type": resp.Type, "role": resp.Role, "model": resp.Model, "content": []any{},
+		"stop_reason": nil, "stop_sequence": nil,
+		"usage": anthropic.Usage{InputTokens: resp.Usage.InputTokens, OutputTokens: 1},
+	}})
+
+	for i, cont := range resp.Content {
+		switch cont.Type {
+		case anthropic.ToolUse:
+			event("content_block_start", map[string]any{"index": i, "content_block": map[string]any{"type": cont.Type, "id": cont.Id, "name": cont.Name, "input": map[string]any{}}})
+			input, _ := json.Marshal(cont.Input)
+			for _, chunk := range chunks(string(input)) {
+				event("content_block_delta", map[string]any{"index": i, "delta": map[string]string{"type": "input_json_delta", "partial_json": chunk}})
+			}
+		default:
+			event("content_block_start", map[string]any{"index": i, "content_block": map[string]any{"type": cont.Type, "text": ""}})
+			for _, chunk := range chunks(cont.Text) {
+				event("content_block_delta", map[string]any{"index": i, "delta": map[string]string{"type": "text_delta", "text": chunk}})
+			}
+		}
+		event("content_block_stop", map[string]any{"index": i})
+	}
+
+	event("message_delta", map[string]any{
+		"delta": map[string]any{"stop_reason": resp.StopReason, "stop_sequence": nil},
+		"usage": map[string]int{"output_tokens": resp.Usage.OutputTokens},
+	})
+	event("message_stop", map[string]any{})
+}
+
+// chunks splits s into pieces of about chunkSize bytes, breaking after spaces where it can
+func chunks(s string) []string {
+	var out []string
+	for len(s) > chunkSize {
+		end := strings.LastIndexByte(s[:chunkSize], ' ') + 1
+		if end == 0 {
+			end = chunkSize
+		}
+		// Never split a UTF-8 sequence
+		for end < len(s) && s[end]&0xC0 == 0x80 {
+			end++
+		}
+		out = append(out, s[:end])
+		s = s[end:]
+	}
+	if s != "" {
+		out = append(out, s)
+	}
+	return out
+}
@@ -35,9 +35,17 @@ for tool_dir in tools/*/; do
     fi
 done
 
+# Run the tests, the golden tests among them
+echo "Running tests..."
+go test ./...
+if [ $? -ne 0 ]; then
+    echo "Tests failed. Exiting."
+    exit 1
+fi
+
 # Build the main Go project
 echo "Building the main Go project..."
-go build -o super-claude
+go build -o super-claude ./cmd/agent
 if [ $? -ne 0 ]; then
     echo "Go build failed. Exiting."
     exit 1
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/config"
+	"github.com/hunterjsb/super-claude/utils"
+	"github.com/joho/godotenv"
+)
+
+// authCommand runs `auth <command>`, managing the API key kept in the system keyring
+func authCommand(args []string) {
+	if len(args) != 1 {
+		utils.Fatal("usage: super-claude auth login|logout|status|storage-key")
+	}
+	switch args[0] {
+	case "login":
+		key, err := agent.ReadSecret("Anthropic API key: ")
+		if err != nil {
+			utils.Fatal("could not read the API key", "error", err)
+		}
+		if key == "" {
+			utils.Fatal("no API key given")
+		}
+		if !strings.HasPrefix(key, "sk-ant-") {
+			slog.Warn("this doesn't look like an Anthropic API key, which starts with sk-ant-")
+		}
+		if err := config.StoreAPIKey(key); err != nil {
+			utils.Fatal("could not log in", "error", err)
+		}
+		utils.Cprintln("green", "Stored the API key in the system keyring.")
+	case "logout":
+		if err := config.DeleteAPIKey(); err != nil {
+			utils.Fatal("could not log out", "error", err)
+		}
+		utils.Cprintln("green", "Removed the API key from the system keyring.")
+	case "storage-key":
+		// replacing a key would leave everything sealed with it unreadable, so there is only ever one
+		if stored, err := config.KeyringStorageKey(); err != nil {
+			utils.Fatal("could not read the system keyring", "error", err)
+		} else if stored != "" {
+			utils.Fatal("there is already a storage key in the system keyring, sessions saved with it would no longer load")
+		}
+		key, err := agent.NewStorageKey()
+		if err != nil {
+			utils.Fatal("could not make a storage key", "error", err)
+		}
+		if err := config.StoreStorageKey(key); err != nil {
+			utils.Fatal("could not store the storage key", "error", err)
+		}
+		utils.Cprintln("green", "Stored a new storage key in the system keyring, conversations and sessions are now saved encrypted.")
+	case "status":
+		stored, err := config.KeyringAPIKey()
+		switch {
+		case err != nil:
+			utils.Cprintln("yellow", "Keyring: unavailable,", err)
+		case stored != "":
+			utils.Cprintln("green", "Keyring: "+maskKey(stored))
+		default:
+			utils.Cprintln("pastel_cyan", "Keyring: no API key stored")
+		}
+		godotenv.Load() // as the agent would, so a key in .env counts
+		env := os.Getenv("ANTHROPIC_API_KEY")
+		if env != "" {
+			utils.Cprintln("green", "ANTHROPIC_API_KEY: "+maskKey(env))
+		} else {
+			utils.Cprintln("pastel_cyan", "ANTHROPIC_API_KEY: not set")
+		}
+		switch {
+		case stored != "":
+			utils.Cprintln("pastel_cyan", "Using the key in the keyring.")
+		case env != "":
+			utils.Cprintln("pastel_cyan", "Using ANTHROPIC_API_KEY.")
+		default:
+			utils.Cprintln("yellow", "No API key found, run `super-claude auth login`.")
+		}
+		if storageKey, err := config.KeyringStorageKey(); err == nil && storageKey != "" {
+			utils.Cprintln("green", "Storage key: in the keyring, saved sessions are encrypted")
+		} else if os.Getenv("STORAGE_KEY") != "" {
+			utils.Cprintln("green", "Storage key: STORAGE_KEY, saved sessions are encrypted")
+		} else {
+			utils.Cprintln("pastel_cyan", "Storage key: none, saved sessions are not encrypted")
+		}
+	default:
+		utils.Fatal("usage: super-claude auth login|logout|status|storage-key")
+	}
+}
+
+// maskKey shows only the ends of a key
+func maskKey(key string) string {
+	if len(key) < 16 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:7] + "..." + key[len(key)-4:]
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/config"
+	"github.com/hunterjsb/super-claude/telemetry"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// sessionsCommand finds saved REPL sessions: sessions list, or sessions search <query>
+func sessionsCommand() {
+	var err error
+	switch {
+	case flag.Arg(0) == "list" && flag.NArg() == 1:
+		err = agent.ListHistory()
+	case flag.Arg(0) == "search" && flag.NArg() > 1:
+		err = agent.SearchHistory(strings.Join(flag.Args()[1:], " "))
+	default:
+		utils.Fatal("usage: super-claude sessions list|search <query>")
+	}
+	if err != nil {
+		utils.Fatal("could not read the saved sessions", "error", err)
+	}
+}
+
+// usageCommand reports the usage ledger: usage [--since 7d] [--by user,session,tool] [--csv file]
+func usageCommand(o *options) {
+	if flag.NArg() != 0 {
+		utils.Fatal("usage: super-claude usage [--since 7d] [--by user|session|tool] [--csv file]")
+	}
+	since, err := agent.ParseSince(o.usageSince)
+	if err != nil {
+		utils.Fatal("could not report usage", "error", err)
+	}
+	by := o.usageBy
+	if len(by) == 0 {
+		by = agent.UsageGroupings
+	}
+	switch o.usageCSV {
+	case "":
+		err = agent.PrintUsage(since, by)
+	case "-":
+		err = agent.WriteUsageCSV(os.Stdout, since, by)
+	default:
+		var out *os.File
+		if out, err = os.Create(o.usageCSV); err == nil {
+			err = agent.WriteUsageCSV(out, since, by)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err == nil {
+			utils.Eprintln("green", "Wrote", o.usageCSV)
+		}
+	}
+	if err != nil {
+		utils.Fatal("could not report usage", "error", err)
+	}
+}
+
+// ingestCommand chunks and embeds docs into the index: ingest [--docs-index file] <file or directory>...
+func ingestCommand(docs agent.DocsConfig) {
+	if flag.NArg() == 0 {
+		utils.Fatal("usage: super-claude ingest [--docs-index file] <file or directory>...")
+	}
+	if err := agent.IngestDocs(context.Background(), flag.Args(), docs); err != nil {
+		utils.Fatal("could not ingest the docs", "error", err)
+	}
+}
+
+// attachCommand chats in a session of a running daemon: attach [flags] [name], listing the sessions without a name
+func attachCommand(o *options) {
+	if flag.Arg(0) == "" {
+		if err := agent.ListSessions(o.socket); err != nil {
+			utils.Fatal("could not list sessions", "error", err)
+		}
+		return
+	}
+	in, err := agent.NewLineReader()
+	if err != nil {
+		utils.Fatal("could not read input", "error", err)
+	}
+	defer in.Close()
+	if err := agent.Attach(o.socket, flag.Arg(0), agent.OpenSessionRequest{Model: o.model, Tools: o.sessionTools}, in); err != nil {
+		utils.Fatal("could not attach", "error", err)
+	}
+}
+
+// batchCommand runs a JSONL file of prompts through the Batches API: batch [flags] prompts.jsonl [results.jsonl]
+func batchCommand(o *options, tools []anthropic.Tool) {
+	in, out := flag.Arg(0), flag.Arg(1)
+	if in == "" {
+		utils.Fatal("usage: super-claude batch [flags] prompts.jsonl [results.jsonl]")
+	}
+	if out == "" {
+		out = strings.TrimSuffix(in, ".jsonl") + ".results.jsonl"
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	agent.SetBatchPollInterval(o.pollInterval)
+	if err := agent.RunBatch(ctx, in, out, tools); err != nil {
+		utils.Fatal("batch failed", "error", err)
+	}
+}
+
+// compareCommand runs a scenario with two models or system prompts and reports them side by side:
+// compare [flags] scenario.yaml [report.md]
+func compareCommand(o *options, tools []anthropic.Tool) {
+	if flag.Arg(0) == "" {
+		utils.Fatal("usage: super-claude compare [--against-model m] [--against-system-file f] [flags] scenario.yaml [report.md]")
+	}
+	scenario, err := agent.LoadScenario(flag.Arg(0))
+	if err != nil {
+		utils.Fatal("could not load the scenario", "error", err)
+	}
+	variants := scenario.Variants
+	if len(variants) == 0 {
+		if o.againstModel == "" && o.againstSystemFile == "" {
+			utils.Fatal("compare needs --against-model or --against-system-file, or variants in the scenario")
+		}
+		variants = []agent.Variant{{Name: "A"}, {Name: "B", Model: o.againstModel, SystemFile: o.againstSystemFile}}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	runs, err := agent.Compare(ctx, scenario, variants, tools)
+	if err != nil {
+		utils.Fatal("compare failed", "error", err)
+	}
+	report := agent.ComparisonReport(scenario, runs)
+	if flag.Arg(1) == "" {
+		fmt.Print(report)
+	} else if err := os.WriteFile(flag.Arg(1), []byte(report), 0o644); err != nil {
+		utils.Fatal("could not write the report", "error", err)
+	}
+}
+
+// evalCommand runs scenarios and checks their turns against their expectations, failing if any falls short:
+// eval [flags] scenario.yaml...
+func evalCommand(o *options, tools []anthropic.Tool) {
+	if flag.NArg() == 0 {
+		utils.Fatal("usage: super-claude eval [flags] scenario.yaml...")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	failed := 0
+	for _, file := range flag.Args() {
+		scenario, err := agent.LoadScenario(file)
+		if err == nil && len(scenario.Variants) > 1 {
+			err = fmt.Errorf("%s has several variants, eval runs a scenario once", file)
+		}
+		var result *agent.EvalResult
+		if err == nil {
+			result, err = agent.Eval(ctx, scenario, tools)
+		}
+		if err != nil {
+			failed++
+			utils.Cprintf("red", "FAIL %s: %v\n", file, err)
+			continue
+		}
+		if result.Passed() {
+			utils.Cprintf("green", "ok   %s (%d turns, %s)\n", file, len(result.Run.Turns), result.Run.Latency().Round(10*time.Millisecond))
+			continue
+		}
+		failed++
+		utils.Cprintf("red", "FAIL %s\n", file)
+		for i, failures := range result.Failures {
+			for _, failure := range failures {
+				utils.Cprintf("red", "     turn %d: %s\n", i+1, failure)
+			}
+		}
+	}
+	if failed > 0 {
+		utils.Fatal("eval failed", "failed", failed, "scenarios", flag.NArg())
+	}
+}
+
+// daemonCommand holds named sessions for terminals to attach to, until SIGINT or SIGTERM
+func daemonCommand(o *options, tools []anthropic.Tool) {
+	store, err := openStore(config.Cfg.SessionStore, "daemon")
+	if err == nil && store == nil {
+		store, err = agent.NewFileStore(o.sessionsDir)
+	}
+	if err != nil {
+		utils.Fatal("could not open the session store", "error", err)
+	}
+	defer store.Close()
+	daemon, err := agent.NewDaemon(store, tools)
+	if err != nil {
+		utils.Fatal("could not start daemon", "error", err)
+	}
+	if config.Cfg.Metrics {
+		serveMetrics(config.Cfg.MetricsAddr)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := daemon.Serve(ctx, o.socket); err != nil {
+		utils.Fatal("daemon stopped", "error", err)
+	}
+}
+
+// slackCommand answers mentions and direct messages in Slack, one conversation per thread
+func slackCommand(o *options, tools []anthropic.Tool) {
+	if config.Cfg.SlackAppToken == "" || config.Cfg.SlackBotToken == "" {
+		utils.Fatal("the slack subcommand needs SLACK_APP_TOKEN and SLACK_BOT_TOKEN")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	bot := agent.NewSlackBot(config.Cfg.SlackAppToken, config.Cfg.SlackBotToken, tools)
+	bot.APIURL = config.Cfg.SlackAPIURL
+	if config.Cfg.Metrics {
+		serveMetrics(config.Cfg.MetricsAddr)
+	}
+	if err := bot.Run(ctx); err != nil {
+		utils.Fatal("slack bot stopped", "error", err)
+	}
+}
+
+// serveCommand serves the REST API
+func serveCommand(o *options, tools []anthropic.Tool) {
+	server := agent.NewServer(&tools)
+	store, err := openStore(config.Cfg.SessionStore, "api")
+	if err != nil {
+		utils.Fatal("could not open the session store", "error", err)
+	}
+	if store != nil {
+		defer store.Close()
+		server.Store = store
+	}
+	if config.Cfg.Metrics && config.Cfg.MetricsAddr == "" {
+		server.Metrics = telemetry.MetricsHandler()
+	} else if config.Cfg.Metrics {
+		serveMetrics(config.Cfg.MetricsAddr)
+	}
+
+	slog.Info("starting REST API", "addr", o.addr)
+	utils.Fatal("server stopped", "error", http.ListenAndServe(o.addr, server.Routes()))
+}
+
+// webCommand serves the chat page, on localhost unless --addr is given
+func webCommand(o *options, tools []anthropic.Tool) {
+	webAddr := "127.0.0.1:8080"
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "addr" {
+			webAddr = o.addr
+		}
+	})
+	slog.Info("starting web UI", "addr", webAddr)
+	utils.Cprintln("green", "Chat with Claude at http://"+webAddr)
+	utils.Fatal("server stopped", "error", http.ListenAndServe(webAddr, agent.NewWebUI(tools, webAddr).Routes()))
+}
+
+// httpServerCommand starts the HTTP server of --server
+func httpServerCommand(o *options, tools []anthropic.Tool) {
+	handler := agent.Handler{Tools: &tools}
+	http.HandleFunc("/", handler.ConverseHttp)
+
+	slog.Info("starting HTTP server", "addr", o.addr)
+	utils.Fatal("server stopped", "error", http.ListenAndServe(o.addr, nil))
+}
+
+// promptCommand runs a single turn, with piped stdin appended to the prompt
+func promptCommand(o *options, tools []anthropic.Tool) {
+	message := o.prompt
+	if agent.StdinIsPiped() {
+		piped, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			utils.Fatal("could not read stdin", "error", err)
+		}
+		message = strings.TrimSpace(strings.TrimSpace(message) + "\n\n" + string(piped))
+	}
+	if message == "" {
+		utils.Fatal("no prompt given")
+	}
+	// Ctrl+C cancels the request instead of killing the process mid-write
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	conversation := make(agent.Conversation, 0)
+	result, err := conversation.Ask(ctx, message, tools)
+	if o.output == "json" {
+		agent.PrintTurnJSON(result, err)
+		if err != nil {
+			os.Exit(1)
+		}
+		if result.Limit != "" {
+			os.Exit(3)
+		}
+		return
+	}
+	if err != nil {
+		if hint := agent.ErrorHint(err); hint != "" {
+			utils.Fatal("request failed", "error", err, "hint", hint)
+		}
+		utils.Fatal("request failed", "error", err)
+	}
+	fmt.Println(result.Text)
+	if result.Limit != "" {
+		utils.Eprintln("yellow", fmt.Sprintf("Stopped calling tools at %s, so the answer may be incomplete.", agent.DescribeLimit(result.Limit)))
+		os.Exit(3)
+	}
+}
+
+// replCommand starts the conversation, carrying on the --resume session if given
+func replCommand(o *options, tools []anthropic.Tool) {
+	conversation := make(agent.Conversation, 0)
+	if o.resume != "" {
+		var err error
+		if conversation, err = agent.ResumeSession(o.resume); err != nil {
+			utils.Fatal("could not resume the session", "error", err)
+		}
+		utils.Eprintf("green", "Resumed a session of %d messages.\n", len(conversation))
+	}
+	in, err := agent.NewLineReader()
+	if err != nil {
+		utils.Fatal("could not read input", "error", err)
+	}
+	defer in.Close()
+	conversation.Converse(in, &tools)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/config"
+)
+
+// options holds the top-level flags, which configure applies over the config file and env vars
+type options struct {
+	configFile, persona, profile, providerName string
+	model, fallback, systemFile                string
+	startServer                                bool
+	addr                                       string
+
+	budget        float64
+	tokenBudget   int
+	budgetStop    bool
+	promptCaching bool
+	toolChoice    string
+	sampling      agent.Sampling
+	maxTokens     int
+
+	connectTimeout, requestTimeout string
+	maxTurns, maxToolCalls         int
+	turnDeadline                   string
+	rpm, tpm                       int
+	thinking                       int
+	showThinking                   bool
+	compactAt                      int
+	countTokens, autoContinue      bool
+
+	toolParallelism int
+	watchTools      bool
+	toolCacheDir    string
+	toolPolicies    map[string]string
+	toolResultLimit *int
+	toolTimeout     time.Duration
+	mcpConfig       string
+	workspace       string
+	database        string
+	databaseWrite   bool
+	databaseMaxRows int
+	runCommand      bool
+	allowCommands   []string
+	denyCommands    []string
+	gitTools        bool
+	postalTools     bool
+	docsTool        bool
+	docsIndex       string
+	fetchDomains    []string
+	subAgents       bool
+	subAgentModel   string
+	previewTools    bool
+	yolo            bool
+
+	socket, sessionsDir, sessionStore string
+	sessionTools                      []string
+	againstModel, againstSystemFile   string
+	pollInterval                      time.Duration
+
+	prompt             string
+	plain, quiet       bool
+	output             string
+	logLevel, logFile  string
+	historyDir, resume string
+	auditLog, usageLog string
+	usageSince         string
+	usageBy            []string
+	usageCSV           string
+	metrics            bool
+	metricsAddr        string
+	record, replay     string
+}
+
+// parseFlags defines and parses the top-level flags
+func parseFlags() *options {
+	o := &options{toolPolicies: map[string]string{}}
+	flag.StringVar(&o.configFile, "config", "", "YAML config file (default ~/.config/claude-agent/config.yaml)")
+	flag.StringVar(&o.persona, "persona", "", "Persona from the config file to act as, e.g. sre, switchable with /persona (overrides CLAUDE_PERSONA)")
+	flag.StringVar(&o.profile, "profile", "", "Config profile to use, e.g. staging (overrides CLAUDE_PROFILE)")
+	flag.StringVar(&o.providerName, "provider", "", "Serve the model from anthropic, bedrock or vertex (overrides CLAUDE_PROVIDER)")
+	flag.StringVar(&o.model, "model", "", "Model to use, a model id or opus, sonnet or haiku (overrides CLAUDE_MODEL)")
+	flag.StringVar(&o.fallback, "fallback", "", "Comma-separated models to answer with, in order, when the model is overloaded, e.g. sonnet,haiku (overrides FALLBACK_MODELS)")
+	flag.BoolVar(&o.startServer, "server", false, "Start the HTTP server")
+	flag.StringVar(&o.addr, "addr", ":8080", "Address for the HTTP server to listen on")
+	flag.StringVar(&o.systemFile, "system-file", "", "Read the system prompt from a file (overrides SYSTEM_PROMPT)")
+	flag.Float64Var(&o.budget, "budget", 0, "Warn when the session's estimated cost in USD reaches this amount")
+	flag.IntVar(&o.tokenBudget, "token-budget", 0, "Warn when the session's total tokens reach this amount")
+	flag.BoolVar(&o.budgetStop, "budget-stop", false, "Refuse to send further messages once a budget is exceeded")
+	flag.BoolVar(&o.promptCaching, "cache", false, "Cache the system prompt and tool definitions between requests")
+	flag.StringVar(&o.toolChoice, "tool-choice", "", "Force tool use: auto, any, or the name of a tool")
+	flag.Func("temperature", "Sampling temperature, 0 for deterministic runs", func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		o.sampling.Temperature = &v
+		return err
+	})
+	flag.Func("top-p", "Nucleus sampling probability", func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		o.sampling.TopP = &v
+		return err
+	})
+	flag.Func("top-k", "Only sample from the top K tokens", func(s string) error {
+		v, err := strconv.Atoi(s)
+		o.sampling.TopK = &v
+		return err
+	})
+	flag.Func("stop", "Stop generating at this sequence (repeatable)", func(s string) error {
+		o.sampling.StopSequences = append(o.sampling.StopSequences, s)
+		return nil
+	})
+	flag.IntVar(&o.maxTokens, "max-tokens", 0, "Cap each reply at this many tokens, defaulting to the most the model can write (overrides MAX_TOKENS)")
+	flag.StringVar(&o.connectTimeout, "connect-timeout", "", "Give up connecting to the API after this long, 0 for no limit (overrides CONNECT_TIMEOUT) (default 10s)")
+	flag.IntVar(&o.maxTurns, "max-turns", 0, "Stop a turn's tool calls after this many requests to Claude, and have it answer with what it has (overrides MAX_TURNS)")
+	flag.IntVar(&o.maxToolCalls, "max-tool-calls", 0, "Stop a turn's tool calls after this many, and have Claude answer with what it has (overrides MAX_TOOL_CALLS)")
+	flag.StringVar(&o.turnDeadline, "turn-deadline", "", "Stop a turn's tool calls after this long, e.g. 5m, and have Claude answer with what it has (overrides TURN_DEADLINE)")
+	flag.StringVar(&o.requestTimeout, "request-timeout", "", "Give up on an API request, including the reply, after this long, 0 for no limit (overrides REQUEST_TIMEOUT) (default 10m)")
+	flag.IntVar(&o.rpm, "rpm", 0, "Send at most this many API requests a minute to any model, shared by all sessions (overrides rate_limits default)")
+	flag.IntVar(&o.tpm, "tpm", 0, "Send at most this many tokens a minute to any model, input and output, shared by all sessions (overrides rate_limits default)")
+	flag.IntVar(&o.thinking, "thinking", 0, "Turn on extended thinking with this budget in tokens, at least 1024 (overrides THINKING_BUDGET)")
+	flag.BoolVar(&o.showThinking, "show-thinking", false, "Print Claude's extended thinking, dimmed, before its answer")
+	flag.IntVar(&o.compactAt, "compact-at", 0, "Summarize older turns with Haiku once the conversation reaches about this many tokens (overrides COMPACT_AT)")
+	flag.BoolVar(&o.countTokens, "count-tokens", false, "Show how many input tokens each turn will send, using the count_tokens endpoint")
+	flag.BoolVar(&o.autoContinue, "auto-continue", false, "Automatically continue replies cut off by max_tokens")
+	flag.IntVar(&o.toolParallelism, "tool-parallelism", 4, "Maximum number of tool calls from one reply to run at once")
+	flag.BoolVar(&o.watchTools, "watch-tools", false, "Reload the definitions in the tools directories when they change, between turns of the REPL")
+	flag.StringVar(&o.toolCacheDir, "tool-cache-dir", "", "Keep cached tool results in this directory, so they outlast the session (overrides TOOL_CACHE_DIR)")
+	flag.Func("tool-policy", "Set a tool's policy to auto, confirm or deny, as name=policy (repeatable)", func(s string) error {
+		name, policy, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected name=policy")
+		}
+		o.toolPolicies[name] = policy
+		return nil
+	})
+	flag.Func("tool-result-limit", "Truncate tool results longer than this many bytes before Claude sees them, 0 for no limit (default 102400)", func(s string) error {
+		v, err := strconv.Atoi(s)
+		o.toolResultLimit = &v
+		return err
+	})
+	flag.DurationVar(&o.toolTimeout, "tool-timeout", 60*time.Second, "Give up on a tool call after this long, unless its JSON file sets a timeout (0 for no limit)")
+	flag.StringVar(&o.mcpConfig, "mcp-config", "", "JSON file declaring MCP servers whose tools to expose (overrides MCP_CONFIG)")
+	flag.StringVar(&o.workspace, "workspace", "", "Enable the read_file, write_file and list_dir tools inside this directory (overrides WORKSPACE)")
+	flag.StringVar(&o.database, "database", "", "Enable the query_database tool on this postgres:// or mysql:// URL (overrides QUERY_DATABASE_URL)")
+	flag.BoolVar(&o.databaseWrite, "database-write", false, "Let query_database run statements that change data, not only queries")
+	flag.IntVar(&o.databaseMaxRows, "database-max-rows", 0, "Return at most this many rows from query_database (default 100)")
+	flag.BoolVar(&o.runCommand, "run-command", false, "Enable the run_command tool, asking before each command unless --yolo is given")
+	flag.Func("allow-command", "Only let run_command run commands starting with these words, e.g. \"kubectl get\" (repeatable)", func(s string) error {
+		o.allowCommands = append(o.allowCommands, s)
+		return nil
+	})
+	flag.Func("deny-command", "Never let run_command run commands starting with these words (repeatable)", func(s string) error {
+		o.denyCommands = append(o.denyCommands, s)
+		return nil
+	})
+	flag.BoolVar(&o.gitTools, "git", false, "Enable the git_status, git_diff and git_commit tools on the repository in the current directory, asking before each commit unless --yolo is given")
+	flag.BoolVar(&o.postalTools, "postal", false, "Enable the postal_lookup and postal_distance tools on the go-postal at GO_POSTAL_URL")
+	flag.BoolVar(&o.docsTool, "docs", false, "Enable the search_docs tool on the docs index built by super-claude ingest")
+	flag.StringVar(&o.docsIndex, "docs-index", "", "Docs index to ingest into and search (overrides DOCS_INDEX) (default ~/.config/claude-agent/docs-index.json)")
+	flag.Func("fetch-domain", "Enable the fetch_url tool on this domain and its subdomains, e.g. docs.example.com (repeatable, overrides FETCH_DOMAINS)", func(s string) error {
+		o.fetchDomains = append(o.fetchDomains, s)
+		return nil
+	})
+	flag.BoolVar(&o.subAgents, "sub-agents", false, "Enable the spawn_agent tool, letting Claude delegate tasks to sub-agents with a subset of the tools")
+	flag.StringVar(&o.subAgentModel, "sub-agent-model", "", "Model sub-agents run on unless Claude picks another (overrides SUB_AGENT_MODEL) (default haiku)")
+	flag.BoolVar(&o.previewTools, "preview-tools", false, "Show each tool call's input before it runs, to run it, edit it in $EDITOR first, or skip it")
+	flag.BoolVar(&o.yolo, "yolo", false, "Run commands and git commits without asking for confirmation")
+	flag.StringVar(&o.socket, "socket", filepath.Join(config.Dir(), "agent.sock"), "Unix socket of the `daemon`, for attach")
+	flag.StringVar(&o.sessionsDir, "sessions-dir", filepath.Join(config.Dir(), "sessions"), "Where the `daemon` saves its sessions, without a --session-store")
+	flag.StringVar(&o.sessionStore, "session-store", "", "Keep the sessions of `serve` and `daemon` in file:///dir, sqlite:///file.db or redis://host:6379/0 (overrides SESSION_STORE)")
+	flag.Func("tools", "Tools a new session may use, separated by commas, instead of all of them (attach)", func(s string) error {
+		o.sessionTools = append(o.sessionTools, strings.Split(s, ",")...)
+		return nil
+	})
+	flag.StringVar(&o.againstModel, "against-model", "", "Model to `compare` the configured one with, e.g. sonnet")
+	flag.StringVar(&o.againstSystemFile, "against-system-file", "", "System prompt file to `compare` the configured prompt with")
+	flag.DurationVar(&o.pollInterval, "poll", 30*time.Second, "How often `batch` checks whether the batch has ended")
+	flag.StringVar(&o.prompt, "p", "", "Run a single prompt non-interactively and print only the final answer")
+	flag.BoolVar(&o.plain, "plain", false, "Print replies as the raw Markdown Claude wrote instead of formatting it")
+	flag.BoolVar(&o.quiet, "quiet", false, "Print only Claude's replies, without warnings, tool calls, token counts or logs below errors")
+	flag.StringVar(&o.output, "output", "text", "Output format: text, or json for one JSON object per turn")
+	flag.StringVar(&o.logLevel, "log-level", "", "Log level: debug, info, warn or error (debug dumps API requests and responses) (default info)")
+	flag.StringVar(&o.logFile, "log-file", "", "Write logs as JSON to this file instead of stderr")
+	flag.StringVar(&o.historyDir, "history-dir", "", "Save REPL sessions in this directory, or none (overrides HISTORY_DIR) (default ~/.config/claude-agent/history)")
+	flag.StringVar(&o.resume, "resume", "", "Carry on a saved REPL session, by the id `sessions list` shows or the start of it")
+	flag.StringVar(&o.auditLog, "audit-log", "", "Append a record of every tool call to this JSONL file, or none (overrides AUDIT_LOG) (default ~/.config/claude-agent/audit.jsonl)")
+	flag.StringVar(&o.usageLog, "usage-log", "", "Append the tokens and cost of every API response to this JSONL file, or none (overrides USAGE_LOG) (default ~/.config/claude-agent/usage.jsonl)")
+	flag.StringVar(&o.usageSince, "since", "", "Report usage since this long ago or this date, e.g. 7d, 12h or 2026-10-01, instead of all of it (usage)")
+	flag.Func("by", "Total usage by user, session or tool, separated by commas, instead of all three (usage)", func(s string) error {
+		for _, grouping := range strings.Split(s, ",") {
+			if !slices.Contains(agent.UsageGroupings, grouping) {
+				return fmt.Errorf("expected user, session or tool")
+			}
+			o.usageBy = append(o.usageBy, grouping)
+		}
+		return nil
+	})
+	flag.StringVar(&o.usageCSV, "csv", "", "Write the usage totals to this CSV file instead, - for stdout (usage)")
+	flag.BoolVar(&o.metrics, "metrics", false, "Serve Prometheus metrics at /metrics, on the REST API's address or --metrics-addr (serve, daemon, slack)")
+	flag.StringVar(&o.metricsAddr, "metrics-addr", "", "Address to serve /metrics on, for the daemon and Slack bot (overrides METRICS_ADDR) (default 127.0.0.1:9464)")
+	flag.StringVar(&o.record, "record", "", "Save every API response in this directory, to be served back with --replay")
+	flag.StringVar(&o.replay, "replay", "", "Answer API requests from a --record directory instead of the network, no API key needed")
+	flag.Parse()
+	return o
+}
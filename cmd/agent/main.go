@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"slices"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// commands are the subcommands that run once the agent and its tools are set up
+var commands = map[string]func(o *options, tools []anthropic.Tool){
+	"batch":   batchCommand,
+	"compare": compareCommand,
+	"eval":    evalCommand,
+	"daemon":  daemonCommand,
+	"slack":   slackCommand,
+	"serve":   serveCommand,
+	"web":     webCommand,
+}
+
+// localCommands are the subcommands that work on local files without talking to Claude, and skip the setup they don't need
+var localCommands = []string{"sessions", "usage", "ingest", "attach"}
+
+func main() {
+	// Subcommands with flags of their own are picked off before the top-level flags are parsed
+	if len(os.Args) > 1 && os.Args[1] == "tools" {
+		toolsCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		authCommand(os.Args[2:])
+		return
+	}
+	subcommand := ""
+	if _, ok := commands[arg(1)]; ok || slices.Contains(localCommands, arg(1)) {
+		subcommand = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	o := parseFlags()
+
+	configure(o, subcommand)
+	if subcommand == "sessions" {
+		sessionsCommand()
+		return
+	}
+	openUsageLog(o)
+	if subcommand == "usage" {
+		usageCommand(o)
+		return
+	}
+	docs := docsConfig(o)
+	if subcommand == "ingest" {
+		ingestCommand(docs)
+		return
+	}
+	if subcommand == "attach" {
+		attachCommand(o)
+		return
+	}
+
+	defer setupTelemetry(o, subcommand)()
+	setupAgent(o)
+	tools := loadTools(o, docs)
+	defer agent.CloseMCPServers()
+	setupToolPolicies(o)
+
+	if o.resume != "" && (subcommand != "" || o.startServer || o.prompt != "" || agent.StdinIsPiped()) {
+		utils.Fatal("--resume is for the REPL")
+	}
+	switch {
+	case subcommand != "":
+		commands[subcommand](o, tools)
+	case o.startServer:
+		httpServerCommand(o, tools)
+	case o.prompt != "" || agent.StdinIsPiped():
+		promptCommand(o, tools)
+	default:
+		replCommand(o, tools)
+	}
+}
+
+// arg is the i-th command-line argument, "" if there are fewer
+func arg(i int) string {
+	if i < len(os.Args) {
+		return os.Args[i]
+	}
+	return ""
+}
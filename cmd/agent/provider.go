@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/config"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// newProvider builds the configured provider, recording or replaying its responses if asked
+func newProvider(record, replay string, timeouts anthropic.Timeouts) anthropic.Provider {
+	httpClient := anthropic.NewHTTPClient(timeouts)
+	if record != "" {
+		httpClient.Transport = &anthropic.RecordingTransport{Dir: record, Next: httpClient.Transport}
+	} else if replay != "" {
+		httpClient.Transport = &anthropic.ReplayTransport{Dir: replay}
+	}
+
+	switch config.Cfg.Provider {
+	case "bedrock":
+		bedrock := anthropic.NewBedrock(config.Cfg.AWSRegion)
+		bedrock.HTTPClient = httpClient
+		bedrock.Betas = config.Cfg.AnthropicBetas
+		if !bedrock.HasCredentials() && replay == "" {
+			utils.Fatal("the bedrock provider needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or AWS_BEARER_TOKEN_BEDROCK")
+		}
+		return bedrock
+	case "vertex":
+		vertex := anthropic.NewVertex(config.Cfg.VertexProject, config.Cfg.VertexRegion)
+		vertex.HTTPClient = httpClient
+		vertex.Betas = config.Cfg.AnthropicBetas
+		if replay != "" {
+			vertex.TokenSource = nil
+		}
+		return vertex
+	}
+	client := anthropic.NewClient(config.Cfg.AnthropicApiKey)
+	if config.Cfg.AnthropicBaseURL != "" {
+		client.BaseURL = config.Cfg.AnthropicBaseURL
+	}
+	client.Version = config.Cfg.AnthropicVersion
+	client.Betas = config.Cfg.AnthropicBetas
+	client.Headers = config.Cfg.AnthropicHeaders
+	client.HTTPClient = httpClient
+	if keys := anthropicKeys(config.Cfg.AnthropicKeys); len(keys) > 0 {
+		if err := client.SetKeys(keys, config.Cfg.KeyRotation); err != nil {
+			utils.Fatal("invalid anthropic_keys", "error", err)
+		}
+		client.APIKey = keys[0].Key
+	}
+	return client
+}
+
+// anthropicKeys reads the keys to rotate over from the environment
+func anthropicKeys(cfg []config.AnthropicKey) []anthropic.APIKey {
+	var keys []anthropic.APIKey
+	for _, key := range cfg {
+		if key.KeyEnv == "" {
+			utils.Fatal("Anthropic API key needs a key_env", "name", key.Name)
+		}
+		secret := os.Getenv(key.KeyEnv)
+		if secret == "" {
+			utils.Fatal("Anthropic API key is not set in the environment", "name", key.Name, "key_env", key.KeyEnv)
+		}
+		keys = append(keys, anthropic.APIKey{
+			Name:    key.Name,
+			Key:     secret,
+			Limit:   anthropic.RateLimit{RequestsPerMinute: key.RequestsPerMinute, TokensPerMinute: key.TokensPerMinute},
+			Headers: key.Headers,
+		})
+	}
+	return keys
+}
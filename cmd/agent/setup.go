@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/config"
+	"github.com/hunterjsb/super-claude/telemetry"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// configure loads the config file, then env vars over it, then flags over both, and sets up logging and local storage
+func configure(o *options, subcommand string) {
+	config.Cfg = config.New(true)
+	if o.configFile != "" {
+		if err := config.Cfg.LoadFile(o.configFile, true); err != nil {
+			utils.Fatal("could not load config", "error", err)
+		}
+	} else if err := config.Cfg.LoadFile(config.DefaultFile(), false); err != nil {
+		utils.Fatal("could not load config", "error", err)
+	}
+	config.Cfg.SelectProfile(o.profile)
+	config.Cfg.SelectProvider(o.providerName)
+	if o.record != "" && o.replay != "" {
+		utils.Fatal("--record and --replay can't be used together")
+	}
+	if o.replay != "" || subcommand == "attach" {
+		config.Cfg.SetOffline()
+	}
+	config.Cfg.Load()
+	if o.logLevel != "" {
+		config.Cfg.LogLevel = o.logLevel
+	}
+	if o.logFile != "" {
+		config.Cfg.LogFile = o.logFile
+	}
+	if o.quiet && o.logLevel == "" {
+		config.Cfg.LogLevel = "error"
+	}
+	if config.Cfg.LogLevel == "" {
+		config.Cfg.LogLevel = "info"
+	}
+	if err := utils.SetupLogger(config.Cfg.LogLevel, config.Cfg.LogFile); err != nil {
+		utils.Fatal("could not set up logging", "error", err)
+	}
+	agent.SetPlainOutput(o.plain || !agent.StdoutIsTerminal())
+	utils.SetQuiet(o.quiet)
+	utils.SetStdoutColor(agent.StdoutIsTerminal())
+	if config.Cfg.StorageKey != "" {
+		if err := agent.SetStorageKey(config.Cfg.StorageKey); err != nil {
+			utils.Fatal("invalid storage key", "error", err)
+		}
+	}
+	if o.historyDir != "" {
+		config.Cfg.HistoryDir = o.historyDir
+	}
+	if config.Cfg.HistoryDir == "" && config.Dir() != "" {
+		config.Cfg.HistoryDir = filepath.Join(config.Dir(), "history")
+	}
+	if config.Cfg.HistoryDir != "none" {
+		agent.SetHistoryDir(config.Cfg.HistoryDir)
+	}
+	if config.Cfg.RecoveryDir == "" && config.Dir() != "" {
+		config.Cfg.RecoveryDir = filepath.Join(config.Dir(), "recovery")
+	}
+	if config.Cfg.RecoveryDir != "none" {
+		agent.SetRecoveryDir(config.Cfg.RecoveryDir)
+	}
+}
+
+// openUsageLog opens the ledger every API response is recorded in, unless it is none
+func openUsageLog(o *options) {
+	if o.usageLog != "" {
+		config.Cfg.UsageLog = o.usageLog
+	}
+	if config.Cfg.UsageLog == "" && config.Dir() != "" {
+		config.Cfg.UsageLog = filepath.Join(config.Dir(), "usage.jsonl")
+	}
+	if config.Cfg.UsageLog != "none" {
+		if err := agent.SetUsageLog(config.Cfg.UsageLog); err != nil {
+			utils.Fatal("could not open the usage log", "error", err)
+		}
+	}
+}
+
+// docsConfig is where ingest writes the docs index and search_docs reads it
+func docsConfig(o *options) agent.DocsConfig {
+	if o.docsIndex != "" {
+		config.Cfg.DocsIndex = o.docsIndex
+	}
+	if config.Cfg.DocsIndex == "" && config.Dir() != "" {
+		config.Cfg.DocsIndex = filepath.Join(config.Dir(), "docs-index.json")
+	}
+	return agent.DocsConfig{Index: config.Cfg.DocsIndex, Model: config.Cfg.EmbeddingModel, VoyageAPIKey: config.Cfg.VoyageAPIKey, VoyageBaseURL: config.Cfg.VoyageBaseURL}
+}
+
+// setupTelemetry exports traces and metrics if configured, serving metrics for scraping if the subcommand is long-running,
+// and returns the function flushing them at exit
+func setupTelemetry(o *options, subcommand string) func() {
+	if o.sessionStore != "" {
+		config.Cfg.SessionStore = o.sessionStore
+	}
+	if o.metrics {
+		config.Cfg.Metrics = true
+	}
+	if o.metricsAddr != "" {
+		config.Cfg.MetricsAddr = o.metricsAddr
+	}
+	scrape := config.Cfg.Metrics && (subcommand == "serve" || subcommand == "daemon" || subcommand == "slack")
+	if config.Cfg.OTLPEndpoint == "" && !scrape {
+		return func() {}
+	}
+	shutdown, err := telemetry.Setup(context.Background(), config.Cfg.OTLPEndpoint, config.Cfg.OTLPHeaders, scrape)
+	if err != nil {
+		utils.Fatal("could not set up telemetry", "error", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("could not flush telemetry", "error", err)
+		}
+	}
+}
+
+// setupAgent installs the provider and applies the model, prompt, limits and sampling settings
+func setupAgent(o *options) {
+	if o.model != "" {
+		config.Cfg.Model = o.model
+	}
+	if o.fallback != "" {
+		config.Cfg.FallbackModels = strings.Split(o.fallback, ",")
+	}
+	if o.systemFile != "" {
+		if err := config.Cfg.LoadSystemPrompt(o.systemFile); err != nil {
+			utils.Fatal("could not load system prompt", "error", err)
+		}
+	}
+	if o.workspace != "" {
+		config.Cfg.Workspace = o.workspace
+	}
+	if o.connectTimeout != "" {
+		config.Cfg.ConnectTimeout = o.connectTimeout
+	}
+	if o.requestTimeout != "" {
+		config.Cfg.RequestTimeout = o.requestTimeout
+	}
+	timeouts, err := parseTimeouts(config.Cfg.ConnectTimeout, config.Cfg.RequestTimeout)
+	if err != nil {
+		utils.Fatal("invalid timeout", "error", err)
+	}
+	anthropic.SetProvider(newProvider(o.record, o.replay, timeouts))
+	anthropic.SetRateLimits(rateLimits(config.Cfg.RateLimits, o.rpm, o.tpm))
+	var fallbackModels []anthropic.Model
+	for _, name := range config.Cfg.FallbackModels {
+		fallbackModels = append(fallbackModels, anthropic.ParseModel(strings.TrimSpace(name)))
+	}
+	anthropic.SetFallbackModels(fallbackModels)
+	systemPrompt, err := config.Cfg.Render("system prompt", config.Cfg.SystemPrompt)
+	if err != nil {
+		utils.Fatal("could not load system prompt", "error", err)
+	}
+	agent.SetSystemPrompt(systemPrompt)
+	agent.SetModel(config.Cfg.Model)
+	if o.maxTokens > 0 {
+		config.Cfg.MaxTokens = o.maxTokens
+	}
+	agent.SetMaxTokens(config.Cfg.MaxTokens)
+	if o.maxTurns > 0 {
+		config.Cfg.MaxTurns = o.maxTurns
+	}
+	if o.maxToolCalls > 0 {
+		config.Cfg.MaxToolCalls = o.maxToolCalls
+	}
+	if o.turnDeadline != "" {
+		config.Cfg.TurnDeadline = o.turnDeadline
+	}
+	limits := agent.LoopLimits{MaxTurns: config.Cfg.MaxTurns, MaxToolCalls: config.Cfg.MaxToolCalls}
+	if config.Cfg.TurnDeadline != "" {
+		if limits.Deadline, err = time.ParseDuration(config.Cfg.TurnDeadline); err != nil {
+			utils.Fatal("invalid turn deadline", "error", err)
+		}
+	}
+	agent.SetLoopLimits(limits)
+	if o.compactAt > 0 {
+		config.Cfg.CompactAt = o.compactAt
+	}
+	agent.SetCompactThreshold(config.Cfg.CompactAt)
+	agent.SetShowTokenCount(o.countTokens)
+	agent.SetPromptCaching(o.promptCaching)
+	agent.SetToolChoice(o.toolChoice)
+	agent.SetSampling(o.sampling)
+	if o.thinking > 0 {
+		config.Cfg.ThinkingBudget = o.thinking
+	}
+	if err := checkThinking(config.Cfg.ThinkingBudget, o.sampling, o.toolChoice); err != nil {
+		utils.Fatal("invalid thinking settings", "error", err)
+	}
+	agent.SetThinking(config.Cfg.ThinkingBudget)
+	agent.SetShowThinking(o.showThinking)
+	agent.SetAutoContinue(o.autoContinue)
+	agent.SetToolParallelism(o.toolParallelism)
+	agent.SetPreviewTools(o.previewTools)
+	agent.SetToolTimeout(o.toolTimeout)
+	agent.SetOutputJSON(o.output == "json")
+	agent.SetBudget(agent.Budget{MaxCost: o.budget, MaxTokens: o.tokenBudget, Stop: o.budgetStop})
+}
+
+// checkThinking reports settings the API refuses to combine with extended thinking
+func checkThinking(budget int, sampling agent.Sampling, toolChoice string) error {
+	if budget == 0 {
+		return nil
+	}
+	if budget < anthropic.MinThinkingBudget {
+		return fmt.Errorf("the thinking budget must be at least %d tokens", anthropic.MinThinkingBudget)
+	}
+	if sampling.TopK != nil || (sampling.Temperature != nil && *sampling.Temperature != 1) {
+		return fmt.Errorf("--top-k and --temperature can't be used with extended thinking")
+	}
+	if choice := anthropic.ParseToolChoice(toolChoice); choice != nil && choice.Type != "auto" {
+		return fmt.Errorf("--tool-choice can only be auto with extended thinking")
+	}
+	return nil
+}
+
+// parseTimeouts reads the configured timeouts, keeping the defaults for those not set
+func parseTimeouts(connect, request string) (anthropic.Timeouts, error) {
+	timeouts := anthropic.DefaultTimeouts
+	var err error
+	if connect != "" {
+		if timeouts.Connect, err = time.ParseDuration(connect); err != nil {
+			return timeouts, fmt.Errorf("connect timeout: %v", err)
+		}
+	}
+	if request != "" {
+		if timeouts.Request, err = time.ParseDuration(request); err != nil {
+			return timeouts, fmt.Errorf("request timeout: %v", err)
+		}
+	}
+	return timeouts, nil
+}
+
+// rateLimits turns the configured rate limits into the default and per-model limits, with --rpm and --tpm over the default
+func rateLimits(cfg map[string]config.RateLimit, rpm, tpm int) (anthropic.RateLimit, map[anthropic.Model]anthropic.RateLimit) {
+	var fallback anthropic.RateLimit
+	models := map[anthropic.Model]anthropic.RateLimit{}
+	for name, limit := range cfg {
+		l := anthropic.RateLimit{RequestsPerMinute: limit.RequestsPerMinute, TokensPerMinute: limit.TokensPerMinute}
+		if name == "default" {
+			fallback = l
+		} else {
+			models[anthropic.ParseModel(name)] = l
+		}
+	}
+	if rpm > 0 {
+		fallback.RequestsPerMinute = rpm
+	}
+	if tpm > 0 {
+		fallback.TokensPerMinute = tpm
+	}
+	return fallback, models
+}
+
+// openStore opens the configured session store for namespace, or returns nil if none is configured
+func openStore(url, namespace string) (agent.Store, error) {
+	if url == "" {
+		return nil, nil
+	}
+	return agent.OpenStore(url, namespace)
+}
+
+// serveMetrics serves /metrics on its own address in the background, 127.0.0.1:9464 if none is given
+func serveMetrics(addr string) {
+	if addr == "" {
+		addr = "127.0.0.1:9464"
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", telemetry.MetricsHandler())
+	slog.Info("serving metrics", "addr", addr)
+	go func() {
+		utils.Fatal("metrics server stopped", "error", http.ListenAndServe(addr, mux))
+	}()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// toolsCommand runs `tools <command>`, which works on tool files without starting the agent
+func toolsCommand(args []string) {
+	if len(args) > 0 && args[0] == "infer" {
+		inferCommand(args[1:])
+		return
+	}
+	if len(args) == 0 || args[0] != "import-openapi" {
+		utils.Fatal("usage: super-claude tools import-openapi|infer [flags]")
+	}
+	flags := flag.NewFlagSet("import-openapi", flag.ExitOnError)
+	out := flags.String("out", "tools", "Directory to write the tools to")
+	prefix := flags.String("prefix", "", "Prepend this to every tool name, e.g. orders_")
+	baseURLEnv := flags.String("base-url-env", "", "Env var holding the service's URL (default <TITLE>_URL, from the document's title)")
+	authEnv := flags.String("auth-env", "", "Env var holding the service's credential (default <TITLE>_TOKEN)")
+	force := flags.Bool("force", false, "Overwrite tool files that already exist")
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		utils.Fatal("usage: super-claude tools import-openapi [flags] spec.yaml")
+	}
+
+	result, err := agent.ImportOpenAPI(flags.Arg(0), *out, agent.OpenAPIImport{BaseURLEnv: *baseURLEnv, AuthEnv: *authEnv, Prefix: *prefix, Force: *force})
+	if err != nil {
+		utils.Fatal("could not import OpenAPI document", "error", err)
+	}
+	for _, name := range result.Tools {
+		utils.Cprintln("green", "Wrote", filepath.Join(*out, name, name+".json"))
+	}
+	for _, skipped := range result.Skipped {
+		utils.Eprintln("yellow", "Skipped", skipped)
+	}
+	utils.Cprintf("pastel_cyan", "Imported %d tools. Set %s to the service's URL, e.g. under endpoints in the config file", len(result.Tools), result.BaseURLEnv)
+	if result.AuthEnv != "" {
+		utils.Cprintf("pastel_cyan", ", and %s to its credential", result.AuthEnv)
+	}
+	utils.Cprintln("pastel_cyan", ".")
+}
+
+// inferCommand runs `tools infer`, drafting a tool file from example requests and responses
+func inferCommand(args []string) {
+	flags := flag.NewFlagSet("infer", flag.ExitOnError)
+	name := flags.String("name", "", "Name of the tool, e.g. lookup_zip")
+	var examples []string
+	flags.Func("example", "JSON file with an example {\"request\": ..., \"response\": ...}, or only a response (repeatable)", func(s string) error {
+		examples = append(examples, s)
+		return nil
+	})
+	endpointURL := flags.String("url", "", "Add an endpoint section calling this URL, e.g. '${GO_POSTAL_URL}/postal_codes/{code}'")
+	method := flags.String("method", "", "HTTP method of the endpoint, e.g. POST to send the inputs as a JSON body (default GET)")
+	out := flags.String("out", "tools", "Directory to write the tool to")
+	force := flags.Bool("force", false, "Overwrite the tool file if it exists")
+	flags.Parse(args)
+	if *name == "" || len(examples) == 0 || flags.NArg() != 0 {
+		utils.Fatal("usage: super-claude tools infer --name lookup_zip --example response.json [flags]")
+	}
+
+	filename, err := agent.InferTool(examples, *out, agent.ToolInference{Name: *name, URL: *endpointURL, Method: *method, Force: *force})
+	if err != nil {
+		utils.Fatal("could not infer the tool", "error", err)
+	}
+	utils.Cprintln("green", "Wrote", filename)
+	utils.Cprintln("pastel_cyan", "It is a draft: replace the TODOs in its descriptions, and check which inputs are required.")
+}
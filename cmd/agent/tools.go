@@ -0,0 +1,212 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hunterjsb/super-claude/agent"
+	"github.com/hunterjsb/super-claude/anthropic"
+	"github.com/hunterjsb/super-claude/config"
+	"github.com/hunterjsb/super-claude/utils"
+)
+
+// loadTools loads the tools from the tools directories and the built-in tools the flags and config enable,
+// limited to the persona's if one is used
+func loadTools(o *options, docs agent.DocsConfig) []anthropic.Tool {
+	tools := make([]anthropic.Tool, 0)
+	renderTool := func(tool anthropic.Tool) (anthropic.Tool, error) {
+		var err error
+		tool.Description, err = config.Cfg.Render(tool.Name+" description", tool.Description)
+		return tool, err
+	}
+	for _, dir := range config.Cfg.ToolDirs {
+		dirTools, err := agent.LoadToolsFromDirectory(dir)
+		if err != nil {
+			utils.Fatal("error loading tools", "error", err)
+		}
+		for i, tool := range dirTools {
+			if dirTools[i], err = renderTool(tool); err != nil {
+				utils.Fatal("error loading tools", "error", err)
+			}
+		}
+		tools = append(tools, dirTools...)
+	}
+	if o.watchTools {
+		if err := agent.WatchToolDirectories(config.Cfg.ToolDirs, tools, renderTool); err != nil {
+			utils.Fatal("could not watch the tools", "error", err)
+		}
+	}
+	if config.Cfg.Workspace != "" {
+		fileTools, err := agent.LoadFileTools(config.Cfg.Workspace)
+		if err != nil {
+			utils.Fatal("error loading file tools", "error", err)
+		}
+		tools = append(tools, fileTools...)
+	}
+	if o.database != "" {
+		config.Cfg.DatabaseURL = o.database
+	}
+	if o.databaseWrite {
+		config.Cfg.DatabaseWrite = true
+	}
+	if o.databaseMaxRows > 0 {
+		config.Cfg.DatabaseMaxRows = o.databaseMaxRows
+	}
+	if config.Cfg.DatabaseURL != "" {
+		dbTool, err := agent.LoadDatabaseTool(agent.DatabaseConfig{URL: config.Cfg.DatabaseURL, Write: config.Cfg.DatabaseWrite, MaxRows: config.Cfg.DatabaseMaxRows})
+		if err != nil {
+			utils.Fatal("error loading the database tool", "error", err)
+		}
+		tools = append(tools, dbTool)
+	}
+	if o.runCommand {
+		tools = append(tools, agent.LoadCommandTool(agent.CommandPolicy{Allow: o.allowCommands, Deny: o.denyCommands, Confirm: !o.yolo}))
+	}
+	if o.gitTools {
+		repoTools, err := agent.LoadGitTools(".", !o.yolo)
+		if err != nil {
+			utils.Fatal("error loading the git tools", "error", err)
+		}
+		tools = append(tools, repoTools...)
+	}
+	if o.postalTools {
+		lookupTools, err := agent.LoadPostalTools()
+		if err != nil {
+			utils.Fatal("error loading the postal tools", "error", err)
+		}
+		tools = append(tools, lookupTools...)
+	}
+	if o.docsTool {
+		searchTool, err := agent.LoadDocsTool(docs)
+		if err != nil {
+			utils.Fatal("error loading the docs tool", "error", err)
+		}
+		tools = append(tools, searchTool)
+	}
+	if len(o.fetchDomains) > 0 {
+		config.Cfg.FetchDomains = o.fetchDomains
+	}
+	if len(config.Cfg.FetchDomains) > 0 {
+		fetchTool, err := agent.LoadFetchTool(config.Cfg.FetchDomains)
+		if err != nil {
+			utils.Fatal("error loading the fetch tool", "error", err)
+		}
+		tools = append(tools, fetchTool)
+	}
+	if o.mcpConfig != "" {
+		config.Cfg.MCPConfigFile = o.mcpConfig
+	}
+	if config.Cfg.MCPConfigFile != "" {
+		mcpTools, err := agent.LoadMCPServers(config.Cfg.MCPConfigFile)
+		if err != nil {
+			utils.Fatal("error loading MCP servers", "error", err)
+		}
+		tools = append(tools, mcpTools...)
+	}
+	if o.subAgents {
+		if o.subAgentModel != "" {
+			config.Cfg.SubAgentModel = o.subAgentModel
+		}
+		if config.Cfg.SubAgentModel == "" {
+			config.Cfg.SubAgentModel = "haiku"
+		}
+		tools = append(tools, agent.LoadSpawnAgentTool(tools, anthropic.ParseModel(config.Cfg.SubAgentModel)))
+	}
+
+	personas := map[string]agent.Persona{}
+	for name, p := range config.Cfg.Personas {
+		prompt, err := config.Cfg.PersonaPrompt(name)
+		if err != nil {
+			utils.Fatal("could not load persona", "persona", name, "error", err)
+		}
+		personas[name] = agent.Persona{SystemPrompt: prompt, Model: p.Model, Tools: p.Tools}
+	}
+	if err := agent.SetPersonas(personas, tools); err != nil {
+		utils.Fatal("invalid persona", "error", err)
+	}
+	if o.persona != "" {
+		config.Cfg.Persona = o.persona
+	}
+	if config.Cfg.Persona != "" {
+		var err error
+		if tools, err = agent.UsePersona(config.Cfg.Persona); err != nil {
+			utils.Fatal("could not use persona", "error", err)
+		}
+	}
+	return tools
+}
+
+// setupToolPolicies applies the caching, result limits, approval and access policies and the audit log to every tool call
+func setupToolPolicies(o *options) {
+	for name, ttl := range config.Cfg.ToolCacheTTL {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			utils.Fatal("invalid tool_cache_ttl", "tool", name, "error", err)
+		}
+		agent.SetToolCacheTTL(name, d)
+	}
+	if o.toolResultLimit != nil {
+		config.Cfg.ToolResultLimit = o.toolResultLimit
+	}
+	if config.Cfg.ToolResultLimit != nil {
+		agent.SetDefaultToolResultLimit(*config.Cfg.ToolResultLimit)
+	}
+	for name, limit := range config.Cfg.ToolResultLimits {
+		agent.SetToolResultLimit(name, limit)
+	}
+	if err := agent.SetApprovalPolicy(approvalPolicy(config.Cfg.ToolPolicy, o.toolPolicies)); err != nil {
+		utils.Fatal("invalid tool policy", "error", err)
+	}
+	if err := agent.SetAccessPolicy(accessPolicy(config.Cfg.Access)); err != nil {
+		utils.Fatal("invalid access roles", "error", err)
+	}
+	if o.toolCacheDir != "" {
+		config.Cfg.ToolCacheDir = o.toolCacheDir
+	}
+	agent.SetToolCacheDir(config.Cfg.ToolCacheDir)
+	if err := agent.SetRedactPatterns(config.Cfg.RedactPatterns); err != nil {
+		utils.Fatal("invalid redact_patterns", "error", err)
+	}
+	if o.auditLog != "" {
+		config.Cfg.AuditLog = o.auditLog
+	}
+	if config.Cfg.AuditLog == "" && config.Dir() != "" {
+		config.Cfg.AuditLog = filepath.Join(config.Dir(), "audit.jsonl")
+	}
+	if config.Cfg.AuditLog != "none" {
+		if err := agent.SetAuditLog(config.Cfg.AuditLog); err != nil {
+			utils.Fatal("could not open the audit log", "error", err)
+		}
+	}
+}
+
+// approvalPolicy turns the configured tool policy into the agent's, with --tool-policy flags over the config
+func approvalPolicy(cfg config.ToolPolicy, flags map[string]string) agent.ApprovalPolicy {
+	policy := agent.ApprovalPolicy{Default: agent.ToolPolicy(cfg.Default), Tools: map[string]agent.ToolPolicy{}}
+	for name, p := range cfg.Tools {
+		policy.Tools[name] = agent.ToolPolicy(p)
+	}
+	for name, p := range flags {
+		policy.Tools[name] = agent.ToolPolicy(p)
+	}
+	for _, rule := range cfg.Rules {
+		policy.Rules = append(policy.Rules, agent.ApprovalRule{Tool: rule.Tool, Match: rule.Match, Policy: agent.ToolPolicy(rule.Policy)})
+	}
+	return policy
+}
+
+func accessPolicy(cfg config.Access) agent.AccessPolicy {
+	policy := agent.AccessPolicy{Roles: cfg.Roles, SlackUsers: cfg.SlackUsers, DefaultRole: cfg.DefaultRole, ApproverRoles: cfg.ApproverRoles}
+	for _, key := range cfg.APIKeys {
+		if key.KeyEnv == "" {
+			utils.Fatal("API key needs a key_env", "name", key.Name)
+		}
+		secret := os.Getenv(key.KeyEnv)
+		if secret == "" {
+			utils.Fatal("API key is not set in the environment", "name", key.Name, "key_env", key.KeyEnv)
+		}
+		policy.APIKeys = append(policy.APIKeys, agent.APIKey{Name: key.Name, Key: secret, Role: key.Role})
+	}
+	return policy
+}
@@ -1,40 +1,523 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/hunterjsb/super-claude/utils"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
 var Cfg *Config
 
 // # CONFIGURATION
-// Config struct to type and load environment variables, and supporting methods
+// Config struct to type and load the config file and environment variables, and supporting methods
+// Values come from the config file, then env vars (and .env) override them, then command-line flags override both
 type Config struct {
 	requireDotEnv   bool
-	AnthropicApiKey string
+	offline         bool
+	AnthropicApiKey string `yaml:"-"` // only ever read from the system keyring or the environment
+	// SlackAppToken and SlackBotToken are for the slack subcommand, and only ever read from the environment
+	SlackAppToken    string `yaml:"-"`
+	SlackBotToken    string `yaml:"-"`
+	SlackAPIURL      string `yaml:"slack_api_url"` // e.g. https://slack-gov.com/api/ for GovSlack
+	AnthropicBaseURL string `yaml:"anthropic_base_url"`
+	// AnthropicVersion is the anthropic-version header, 2023-06-01 if unset; Bedrock and Vertex have fixed versions of their own
+	AnthropicVersion string `yaml:"anthropic_version"`
+	// AnthropicBetas are beta features sent in the anthropic-beta header with every request, with any provider
+	AnthropicBetas []string `yaml:"anthropic_betas"`
+	// AnthropicHeaders are sent with every API request, e.g. to attribute spend to a workspace through a gateway
+	AnthropicHeaders map[string]string `yaml:"anthropic_headers"`
+	// AnthropicKeys replace ANTHROPIC_API_KEY with several keys that Messages calls rotate over,
+	// taking turns with KeyRotation round_robin (the default) or with failover only moving on from a rate-limited key
+	AnthropicKeys []AnthropicKey `yaml:"anthropic_keys"`
+	KeyRotation   string         `yaml:"key_rotation"`
+	// Provider serves the model: anthropic (the default), bedrock or vertex
+	Provider      string `yaml:"provider"`
+	AWSRegion     string `yaml:"aws_region"`
+	VertexProject string `yaml:"vertex_project"`
+	VertexRegion  string `yaml:"vertex_region"`
+	Model         string `yaml:"model"`
+	// FallbackModels answer in order when the model is overloaded or keeps being rate limited, e.g. [sonnet, haiku]
+	FallbackModels []string `yaml:"fallback_models"`
+	// MaxTokens caps each reply, 0 for the most the model can write
+	MaxTokens int `yaml:"max_tokens"`
+	// MaxTurns and MaxToolCalls cap a turn's requests and tool calls, and TurnDeadline how long it calls tools, 0 for no limit
+	MaxTurns     int    `yaml:"max_turns"`
+	MaxToolCalls int    `yaml:"max_tool_calls"`
+	TurnDeadline string `yaml:"turn_deadline"`
+	// ConnectTimeout and RequestTimeout bound API calls, as durations such as 10s, 0 for no limit
+	ConnectTimeout string `yaml:"connect_timeout"`
+	RequestTimeout string `yaml:"request_timeout"`
+	// RateLimits hold API calls to each model under the organization's tier limits, keyed by model id or short name,
+	// with "default" for the models not listed
+	RateLimits map[string]RateLimit `yaml:"rate_limits"`
+	// ThinkingBudget turns on extended thinking with this many tokens, 0 leaves it off
+	ThinkingBudget int `yaml:"thinking_budget"`
+	// CompactAt is the estimated conversation size in tokens at which older turns are summarized, 0 disables it
+	CompactAt int `yaml:"compact_at"`
+	// ToolDirs are scanned for tools in order, defaulting to ./tools
+	ToolDirs []string `yaml:"tool_dirs"`
+	// ToolCacheTTL caches the results of the named tools for a duration such as 10m, overriding their JSON files
+	ToolCacheTTL map[string]string `yaml:"tool_cache_ttl"`
+	// ToolResultLimit truncates tool results longer than this many bytes, 0 for no limit, and
+	// ToolResultLimits sets it per tool, overriding their JSON files
+	ToolResultLimit  *int           `yaml:"tool_result_limit"`
+	ToolResultLimits map[string]int `yaml:"tool_result_limits"`
+	// DatabaseURL enables query_database on a postgres:// or mysql:// database, read-only unless DatabaseWrite is set
+	DatabaseURL     string `yaml:"database_url"`
+	DatabaseWrite   bool   `yaml:"database_write"`
+	DatabaseMaxRows int    `yaml:"database_max_rows"`
+	// DocsIndex is the vector index `ingest` builds and search_docs searches, ~/.config/claude-agent/docs-index.json by default
+	DocsIndex string `yaml:"docs_index"`
+	// EmbeddingModel is what `ingest` embeds a new index with: a Voyage AI model, or local; voyage-3.5 with VoyageAPIKey, else local
+	EmbeddingModel string `yaml:"embedding_model"`
+	VoyageAPIKey   string `yaml:"-"` // only ever read from the environment
+	VoyageBaseURL  string `yaml:"voyage_base_url"`
+	// FetchDomains enables fetch_url on these domains and their subdomains
+	FetchDomains []string `yaml:"fetch_domains"`
+	// SubAgentModel is what spawn_agent's sub-agents run on unless Claude picks another
+	SubAgentModel string `yaml:"sub_agent_model"`
+	// ToolPolicy decides which tool calls run on their own, need approval or are refused
+	ToolPolicy ToolPolicy `yaml:"tool_policy"`
+	// ToolCacheDir keeps cached tool results on disk too
+	ToolCacheDir     string `yaml:"tool_cache_dir"`
+	SystemPrompt     string `yaml:"system_prompt"`
+	SystemPromptFile string `yaml:"system_prompt_file"`
+	MCPConfigFile    string `yaml:"mcp_config"`
+	Workspace        string `yaml:"workspace"`
+	LogLevel         string `yaml:"log_level"`
+	LogFile          string `yaml:"log_file"`
+	// AuditLog is the JSONL file every tool call is appended to, ~/.config/claude-agent/audit.jsonl by default, "none" turns it off
+	AuditLog string `yaml:"audit_log"`
+	// UsageLog is the JSONL file the usage of every API response is appended to, ~/.config/claude-agent/usage.jsonl by default, "none" turns it off
+	UsageLog string `yaml:"usage_log"`
+	// HistoryDir is where REPL sessions are saved with their index, ~/.config/claude-agent/history by default, "none" turns it off
+	HistoryDir string `yaml:"history_dir"`
+	// RecoveryDir keeps the REPL's conversation until it exits cleanly, to offer back after a crash,
+	// ~/.config/claude-agent/recovery by default, "none" turns it off
+	RecoveryDir string `yaml:"recovery_dir"`
+	// OTLPEndpoint is where OpenTelemetry traces and metrics are sent over OTLP/HTTP, e.g. http://otel-collector:4318
+	OTLPEndpoint string            `yaml:"otlp_endpoint"`
+	OTLPHeaders  map[string]string `yaml:"otlp_headers"`
+	// SessionStore is where the REST API and daemon keep sessions: file:///dir, sqlite:///file.db or redis://host:6379/0.
+	// The REST API keeps them only in memory without one, and the daemon in the --sessions-dir directory.
+	SessionStore string `yaml:"session_store"`
+	// StorageKey encrypts saved conversations and sessions, 32 bytes in base64; the system keyring's is used if unset
+	StorageKey string `yaml:"storage_key"`
+	// Access limits the tools each REST API key and Slack user may use, every tool is open to everyone without roles
+	Access Access `yaml:"access"`
+	// RedactPatterns are regular expressions by name that `/export --redact` masks, e.g. email: '[\w.+-]+@[\w-]+\.[\w.]+'
+	RedactPatterns map[string]string `yaml:"redact_patterns"`
+	// Metrics serves Prometheus metrics at /metrics: on the REST API's address, or on MetricsAddr if set,
+	// which the daemon and Slack bot default to 127.0.0.1:9464
+	Metrics     bool   `yaml:"metrics"`
+	MetricsAddr string `yaml:"metrics_addr"`
+	// Endpoints are backend URLs exported as env vars for endpoint tools, e.g. GO_POSTAL_URL for ${GO_POSTAL_URL}
+	Endpoints map[string]string `yaml:"endpoints"`
+	// Vars are extra variables for the system prompt and tool description templates
+	Vars map[string]string `yaml:"vars"`
+
+	// Persona is the default of Personas to act as, overridden by CLAUDE_PERSONA and --persona
+	Persona  string             `yaml:"persona"`
+	Personas map[string]Persona `yaml:"personas"`
+
+	// Profile is the default of Profiles to use, overridden by CLAUDE_PROFILE and SelectProfile
+	Profile  string             `yaml:"profile"`
+	Profiles map[string]Profile `yaml:"profiles"`
+	selected string
+
+	selectedProvider string
+}
+
+// ToolPolicy is auto, confirm or deny for each tool, tightened by rules matching tool names and inputs
+type ToolPolicy struct {
+	Default string            `yaml:"default"`
+	Tools   map[string]string `yaml:"tools"`
+	Rules   []ToolPolicyRule  `yaml:"rules"`
+}
+
+type ToolPolicyRule struct {
+	Tool   string `yaml:"tool"`  // glob of tool names, empty for any
+	Match  string `yaml:"match"` // regular expression matched against the input as JSON
+	Policy string `yaml:"policy"`
+}
+
+// Access gives REST API keys and Slack users roles, each a list of tool names or globs
+type Access struct {
+	Roles       map[string][]string `yaml:"roles"`
+	APIKeys     []APIKey            `yaml:"api_keys"`
+	SlackUsers  map[string]string   `yaml:"slack_users"`  // role by Slack user id
+	DefaultRole string              `yaml:"default_role"` // for Slack users not listed, and the REST API without api_keys
+	// ApproverRoles are the roles whose API keys may decide the tool calls waiting for approval
+	ApproverRoles []string `yaml:"approver_roles"`
+}
+
+// APIKey is a REST API key with a role, read from the environment variable KeyEnv so it stays out of the file
+type APIKey struct {
+	Name   string `yaml:"name"`
+	KeyEnv string `yaml:"key_env"`
+	Role   string `yaml:"role"`
+}
+
+// AnthropicKey is an Anthropic API key in the rotation, read from the environment variable KeyEnv so it stays out of the file,
+// with its own budget per minute and headers sent with it
+type AnthropicKey struct {
+	Name              string            `yaml:"name"`
+	KeyEnv            string            `yaml:"key_env"`
+	RequestsPerMinute int               `yaml:"requests_per_minute"`
+	TokensPerMinute   int               `yaml:"tokens_per_minute"`
+	Headers           map[string]string `yaml:"headers"`
+}
+
+// RateLimit is what a model may be sent per minute, 0 for no limit
+type RateLimit struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	TokensPerMinute   int `yaml:"tokens_per_minute"`
+}
+
+// Persona is a system prompt, default model and subset of the tools to act with, e.g. an SRE or a code reviewer
+type Persona struct {
+	SystemPrompt     string   `yaml:"system_prompt"`
+	SystemPromptFile string   `yaml:"system_prompt_file"`
+	Model            string   `yaml:"model"`
+	Tools            []string `yaml:"tools"` // names or globs, all tools if empty
+}
+
+// Profile overrides parts of the config for one deployment, e.g. dev, staging or prod.
+// Unlike the rest of the file, its endpoints and env are set even if the variables already are.
+type Profile struct {
+	AnthropicBaseURL string `yaml:"anthropic_base_url"`
+	Provider         string `yaml:"provider"`
+	AWSRegion        string `yaml:"aws_region"`
+	VertexProject    string `yaml:"vertex_project"`
+	VertexRegion     string `yaml:"vertex_region"`
+	Model            string `yaml:"model"`
+	MaxTokens        int    `yaml:"max_tokens"`
+	SystemPrompt     string `yaml:"system_prompt"`
+	SystemPromptFile string `yaml:"system_prompt_file"`
+	MCPConfigFile    string `yaml:"mcp_config"`
+	// Endpoints are exported like the top-level endpoints
+	Endpoints map[string]string `yaml:"endpoints"`
+	// Vars override the top-level vars of the same name
+	Vars map[string]string `yaml:"vars"`
+	// Env sets any other env vars, such as auth tokens for endpoint tools. Values are expanded,
+	// so `GO_POSTAL_TOKEN: ${STAGING_POSTAL_TOKEN}` keeps the secret itself out of the file.
+	Env map[string]string `yaml:"env"`
 }
 
 func New(requireDotEnv bool) *Config {
-	return &Config{requireDotEnv: requireDotEnv}
+	return &Config{requireDotEnv: requireDotEnv, ToolDirs: []string{"tools"}}
 }
 
-func (c *Config) Load() {
-	err := godotenv.Load()
+// SetOffline lets Load go on without .env or ANTHROPIC_API_KEY, for replaying recorded responses
+func (c *Config) SetOffline() {
+	c.offline = true
+}
+
+// Dir is where the agent keeps its config and state, ~/.config/claude-agent
+func Dir() string {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		if c.requireDotEnv {
-			log.Fatal("FATAL: Could not load .env")
-		} else {
-			fmt.Println("Could not load .env, continuing...")
-		}
+		return ""
+	}
+	return filepath.Join(home, ".config", "claude-agent")
+}
+
+// DefaultFile is the config file read when --config isn't given, ~/.config/claude-agent/config.yaml
+func DefaultFile() string {
+	if Dir() == "" {
+		return ""
 	}
+	return filepath.Join(Dir(), "config.yaml")
+}
+
+// LoadFile reads a YAML config file. A missing file is only an error if required.
+func (c *Config) LoadFile(filename string, required bool) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	err = yaml.Unmarshal(data, c)
+	if err != nil {
+		return fmt.Errorf("failed to parse config file '%s': %v", filename, err)
+	}
+	if len(c.ToolDirs) == 0 {
+		c.ToolDirs = []string{"tools"}
+	}
+	return nil
+}
+
+// SelectProfile picks the profile to load, taking precedence over CLAUDE_PROFILE and the file's default
+func (c *Config) SelectProfile(name string) {
+	c.selected = name
+}
 
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+// SelectProvider picks the provider, taking precedence over CLAUDE_PROVIDER, the profile and the file
+func (c *Config) SelectProvider(name string) {
+	c.selectedProvider = name
+}
+
+func (c *Config) Load() {
+	dotEnvErr := godotenv.Load()
+	if err := c.applyProfile(); err != nil {
+		utils.Fatal("could not load profile", "error", err)
+	}
+
+	envString(&c.Provider, "CLAUDE_PROVIDER")
+	envString(&c.AWSRegion, "AWS_REGION")
+	envString(&c.VertexProject, "ANTHROPIC_VERTEX_PROJECT_ID")
+	envString(&c.VertexRegion, "CLOUD_ML_REGION")
+	if c.selectedProvider != "" {
+		c.Provider = c.selectedProvider
+	}
+	if err := c.CheckProvider(); err != nil {
+		utils.Fatal("invalid provider", "error", err)
+	}
+
+	// Bedrock and Vertex authenticate with cloud credentials instead of an API key
+	var apiKey string
+	if c.Provider == "anthropic" && !c.offline {
+		key, err := KeyringAPIKey()
+		if err != nil {
+			slog.Debug("no system keyring, using ANTHROPIC_API_KEY", "error", err)
+		}
+		apiKey = key
+	}
 	if apiKey == "" {
-		log.Fatal("FATAL: could not find ANTHROPIC_API_KEY")
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	// a .env is only needed for the API key, which may be in the keyring instead
+	if dotEnvErr != nil {
+		if c.requireDotEnv && !c.offline && apiKey == "" && len(c.AnthropicKeys) == 0 {
+			utils.Fatal("could not load .env", "error", dotEnvErr)
+		} else {
+			slog.Info("could not load .env, continuing...")
+		}
+	}
+	if apiKey == "" && c.Provider == "anthropic" && !c.offline && len(c.AnthropicKeys) == 0 {
+		utils.Fatal("could not find ANTHROPIC_API_KEY, set it or store it with `super-claude auth login`")
 	}
 
 	c.AnthropicApiKey = apiKey
+	c.SlackAppToken = os.Getenv("SLACK_APP_TOKEN")
+	c.SlackBotToken = os.Getenv("SLACK_BOT_TOKEN")
+	c.VoyageAPIKey = os.Getenv("VOYAGE_API_KEY")
+	envString(&c.AnthropicBaseURL, "ANTHROPIC_BASE_URL")
+	envString(&c.AnthropicVersion, "ANTHROPIC_VERSION")
+	envString(&c.KeyRotation, "KEY_ROTATION")
+	envString(&c.SlackAPIURL, "SLACK_API_URL")
+	envString(&c.Model, "CLAUDE_MODEL")
+	envString(&c.SubAgentModel, "SUB_AGENT_MODEL")
+	envString(&c.Persona, "CLAUDE_PERSONA")
+	envString(&c.SystemPrompt, "SYSTEM_PROMPT")
+	envString(&c.SystemPromptFile, "SYSTEM_PROMPT_FILE")
+	envString(&c.MCPConfigFile, "MCP_CONFIG")
+	envString(&c.Workspace, "WORKSPACE")
+	envString(&c.DatabaseURL, "QUERY_DATABASE_URL")
+	envString(&c.ToolCacheDir, "TOOL_CACHE_DIR")
+	envString(&c.ConnectTimeout, "CONNECT_TIMEOUT")
+	envString(&c.RequestTimeout, "REQUEST_TIMEOUT")
+	envString(&c.TurnDeadline, "TURN_DEADLINE")
+	envString(&c.LogLevel, "LOG_LEVEL")
+	envString(&c.LogFile, "LOG_FILE")
+	envString(&c.AuditLog, "AUDIT_LOG")
+	envString(&c.UsageLog, "USAGE_LOG")
+	envString(&c.DocsIndex, "DOCS_INDEX")
+	envString(&c.EmbeddingModel, "EMBEDDING_MODEL")
+	envString(&c.VoyageBaseURL, "VOYAGE_BASE_URL")
+	envString(&c.HistoryDir, "HISTORY_DIR")
+	envString(&c.RecoveryDir, "RECOVERY_DIR")
+	envString(&c.OTLPEndpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	envString(&c.MetricsAddr, "METRICS_ADDR")
+	envString(&c.SessionStore, "SESSION_STORE")
+	envString(&c.StorageKey, "STORAGE_KEY")
+	if c.StorageKey == "" {
+		key, err := KeyringStorageKey()
+		if err != nil {
+			slog.Debug("no system keyring, saving sessions unencrypted unless STORAGE_KEY is set", "error", err)
+		}
+		c.StorageKey = key
+	}
+	if models := os.Getenv("FALLBACK_MODELS"); models != "" {
+		c.FallbackModels = strings.Split(models, ",")
+	}
+	if betas := os.Getenv("ANTHROPIC_BETAS"); betas != "" {
+		c.AnthropicBetas = strings.Split(betas, ",")
+	}
+	if domains := os.Getenv("FETCH_DOMAINS"); domains != "" {
+		c.FetchDomains = strings.Split(domains, ",")
+	}
+	if dirs := os.Getenv("TOOL_DIRS"); dirs != "" {
+		c.ToolDirs = strings.Split(dirs, string(os.PathListSeparator))
+	}
+	if v := os.Getenv("COMPACT_AT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utils.Fatal("invalid COMPACT_AT", "error", err)
+		}
+		c.CompactAt = n
+	}
+	if v := os.Getenv("MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utils.Fatal("invalid MAX_TOKENS", "error", err)
+		}
+		c.MaxTokens = n
+	}
+	if v := os.Getenv("MAX_TURNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utils.Fatal("invalid MAX_TURNS", "error", err)
+		}
+		c.MaxTurns = n
+	}
+	if v := os.Getenv("MAX_TOOL_CALLS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utils.Fatal("invalid MAX_TOOL_CALLS", "error", err)
+		}
+		c.MaxToolCalls = n
+	}
+	if v := os.Getenv("THINKING_BUDGET"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			utils.Fatal("invalid THINKING_BUDGET", "error", err)
+		}
+		c.ThinkingBudget = n
+	}
+
+	// Endpoints set in the environment win over the config file
+	for name, url := range c.Endpoints {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, url)
+		}
+	}
+
+	if c.SystemPromptFile != "" && os.Getenv("SYSTEM_PROMPT") == "" {
+		if err := c.LoadSystemPrompt(c.SystemPromptFile); err != nil {
+			utils.Fatal("could not load system prompt", "error", err)
+		}
+	}
+}
+
+// CheckProvider defaults the provider to anthropic and checks it has the settings it needs
+func (c *Config) CheckProvider() error {
+	switch c.Provider {
+	case "", "anthropic":
+		c.Provider = "anthropic"
+	case "bedrock":
+		if c.AWSRegion == "" && os.Getenv("AWS_DEFAULT_REGION") == "" {
+			return fmt.Errorf("the bedrock provider needs aws_region or AWS_REGION")
+		}
+	case "vertex":
+		if c.VertexProject == "" || c.VertexRegion == "" {
+			return fmt.Errorf("the vertex provider needs vertex_project and vertex_region, or ANTHROPIC_VERTEX_PROJECT_ID and CLOUD_ML_REGION")
+		}
+	default:
+		return fmt.Errorf("unknown provider '%s', expected anthropic, bedrock or vertex", c.Provider)
+	}
+	return nil
+}
+
+// applyProfile overlays the selected profile, if any, on the values from the config file
+func (c *Config) applyProfile() error {
+	name := c.selected
+	if name == "" {
+		name = os.Getenv("CLAUDE_PROFILE")
+	}
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		return nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile '%s', the config file defines: %s", name, strings.Join(names, ", "))
+	}
+	c.Profile = name
+
+	overlay := func(value *string, v string) {
+		if v != "" {
+			*value = v
+		}
+	}
+	overlay(&c.AnthropicBaseURL, p.AnthropicBaseURL)
+	overlay(&c.Provider, p.Provider)
+	overlay(&c.AWSRegion, p.AWSRegion)
+	overlay(&c.VertexProject, p.VertexProject)
+	overlay(&c.VertexRegion, p.VertexRegion)
+	overlay(&c.Model, p.Model)
+	overlay(&c.MCPConfigFile, p.MCPConfigFile)
+	if p.MaxTokens > 0 {
+		c.MaxTokens = p.MaxTokens
+	}
+	if p.SystemPrompt != "" || p.SystemPromptFile != "" {
+		c.SystemPrompt, c.SystemPromptFile = p.SystemPrompt, p.SystemPromptFile
+	}
+	if c.Endpoints == nil {
+		c.Endpoints = map[string]string{}
+	}
+	for k, v := range p.Endpoints {
+		c.Endpoints[k] = v
+		os.Setenv(k, v)
+	}
+	if c.Vars == nil {
+		c.Vars = map[string]string{}
+	}
+	for k, v := range p.Vars {
+		c.Vars[k] = v
+	}
+	for k, v := range p.Env {
+		os.Setenv(k, os.ExpandEnv(v))
+	}
+	slog.Info("using config profile", "profile", name)
+	return nil
+}
+
+// envString overrides a config value with an env var, if it is set
+func envString(value *string, name string) {
+	if v := os.Getenv(name); v != "" {
+		*value = v
+	}
+}
+
+// LoadSystemPrompt reads the system prompt from a file, taking precedence over SYSTEM_PROMPT
+func (c *Config) LoadSystemPrompt(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read system prompt file: %v", err)
+	}
+	c.SystemPrompt = string(data)
+	return nil
+}
+
+// PersonaPrompt is a persona's system prompt, read from its file if it has one and rendered like the main prompt
+func (c *Config) PersonaPrompt(name string) (string, error) {
+	p := c.Personas[name]
+	prompt := p.SystemPrompt
+	if p.SystemPromptFile != "" {
+		data, err := os.ReadFile(p.SystemPromptFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read system prompt file of persona %s: %v", name, err)
+		}
+		prompt = string(data)
+	}
+	return c.Render(name+" persona system prompt", prompt)
 }
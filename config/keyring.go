@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// # KEYRING
+// Keeping the API key in the system keyring instead of a plaintext .env, with `super-claude auth login`
+//   - The macOS Keychain, Windows Credential Manager, or the Secret Service (GNOME Keyring, KWallet) on Linux
+//   - A key in the keyring is used before ANTHROPIC_API_KEY, which remains the fallback, e.g. for CI
+//   - Without a keyring, such as in a container with no D-Bus session, only the env is used
+//   - The key that encrypts saved sessions is kept alongside it, made with `super-claude auth storage-key`
+const (
+	keyringService    = "claude-agent"
+	keyringUser       = "anthropic-api-key"
+	keyringStorageKey = "storage-key"
+)
+
+// StoreAPIKey saves the API key in the system keyring, replacing any already there
+func StoreAPIKey(key string) error {
+	if err := keyring.Set(keyringService, keyringUser, key); err != nil {
+		return fmt.Errorf("failed to store the API key in the system keyring: %v", err)
+	}
+	return nil
+}
+
+// DeleteAPIKey removes the API key from the system keyring
+func DeleteAPIKey() error {
+	err := keyring.Delete(keyringService, keyringUser)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("there is no API key in the system keyring")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete the API key from the system keyring: %v", err)
+	}
+	return nil
+}
+
+// KeyringAPIKey returns the API key in the system keyring, or "" if there is none
+func KeyringAPIKey() (string, error) {
+	key, err := keyring.Get(keyringService, keyringUser)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the system keyring: %v", err)
+	}
+	return key, nil
+}
+
+// StoreStorageKey saves the key that encrypts saved sessions in the system keyring
+func StoreStorageKey(key string) error {
+	if err := keyring.Set(keyringService, keyringStorageKey, key); err != nil {
+		return fmt.Errorf("failed to store the storage key in the system keyring: %v", err)
+	}
+	return nil
+}
+
+// KeyringStorageKey returns the storage key in the system keyring, or "" if there is none
+func KeyringStorageKey() (string, error) {
+	key, err := keyring.Get(keyringService, keyringStorageKey)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the system keyring: %v", err)
+	}
+	return key, nil
+}
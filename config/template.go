@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// # TEMPLATES
+// The system prompt and tool descriptions are Go text/templates, so one prompt file works for every profile
+//   - {{.Env}} is the profile name, or "default"; {{.Model}}, {{.Workspace}} and {{.Date}} are what they say
+//   - {{.Endpoints.GO_POSTAL_URL}} is the value of an endpoint, {{env "NAME"}} any env var
+//   - Anything under vars, in the file or the profile, is a variable too, e.g. {{.BackendURL}}
+//   - An unknown variable is an error rather than an empty string, to catch typos
+
+// TemplateData is the variables available to templates
+func (c *Config) TemplateData() map[string]any {
+	data := map[string]any{}
+	for k, v := range c.Vars {
+		data[k] = v
+	}
+	env := c.Profile
+	if env == "" {
+		env = "default"
+	}
+	endpoints := map[string]string{}
+	for name := range c.Endpoints {
+		endpoints[name] = os.Getenv(name) // the env wins over the file, as for endpoint tools
+	}
+	data["Env"] = env
+	data["Model"] = c.Model
+	data["Workspace"] = c.Workspace
+	data["Date"] = time.Now().Format("2006-01-02")
+	data["Endpoints"] = endpoints
+	return data
+}
+
+// Render executes text as a template with TemplateData, text without any {{ is returned as it is
+func (c *Config) Render(name, text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{"env": os.Getenv}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, c.TemplateData()); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %v", name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,92 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// # TELEMETRY
+// Exporting the agent's OpenTelemetry traces and metrics over OTLP/HTTP, e.g. to Tempo and Mimir via an OTel collector,
+// and serving the metrics at /metrics for Prometheus to scrape
+// The anthropic and agent packages record to the global providers, which do nothing until Setup replaces them
+const serviceName = "claude-agent"
+
+// registry holds the metrics served by MetricsHandler, along with the Go runtime's and the process's
+var registry = prometheus.NewRegistry()
+
+// Setup exports to the OTLP/HTTP endpoint, a base URL such as http://otel-collector:4318, with extra headers
+// such as auth, and with scrape set also keeps the metrics for MetricsHandler; either may be left out.
+// The service name defaults to claude-agent, and OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES
+// are honoured. The returned function flushes and stops the exporters.
+func Setup(ctx context.Context, endpoint string, headers map[string]string, scrape bool) (func(context.Context) error, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create telemetry resource: %v", err)
+	}
+	base := strings.TrimRight(endpoint, "/")
+	metricOptions := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	var shutdowns []func(context.Context) error
+
+	if endpoint != "" {
+		traceExporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(base+"/v1/traces"), otlptracehttp.WithHeaders(headers))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+		}
+		tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+		otel.SetTracerProvider(tracerProvider)
+		shutdowns = append(shutdowns, tracerProvider.Shutdown)
+
+		metricExporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(base+"/v1/metrics"), otlpmetrichttp.WithHeaders(headers))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metric exporter: %v", err)
+		}
+		metricOptions = append(metricOptions, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+	}
+	if scrape {
+		reader, err := otelprom.New(otelprom.WithRegisterer(registry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Prometheus exporter: %v", err)
+		}
+		registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		metricOptions = append(metricOptions, sdkmetric.WithReader(reader))
+	}
+
+	meterProvider := sdkmetric.NewMeterProvider(metricOptions...)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	shutdowns = append(shutdowns, meterProvider.Shutdown)
+
+	return func(ctx context.Context) error {
+		var errs []error
+		for _, shutdown := range shutdowns {
+			errs = append(errs, shutdown(ctx))
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format, once Setup has been asked to keep them
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -3,61 +3,67 @@ package main
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 
 	"github.com/hunterjsb/super-claude/anthropic"
 )
 
+// main is unused when loaded as a plugin, but lets `go build ./...` succeed
+func main() {}
+
 func USED_PHONE_PRICE(params map[string]any) anthropic.Content {
 	baseUrl, err := url.Parse("http://localhost:5000/api/iphone-used/")
 	if err != nil {
-		return newToolResult("ERROR Something has seriously gone wrong")
+		return newToolError("Something has seriously gone wrong")
 	}
 
 	model, ok := params["phone_model"].(string)
 	if !ok {
-		return newToolResult("ERROR must provide phone model")
+		return newToolError("must provide phone model")
 	}
 	urlWithModel := baseUrl.JoinPath(model)
 
 	q := urlWithModel.Query()
 	storage, ok := params["storage"].(string)
 	if !ok {
-		fmt.Println("Storage Not Provided")
+		slog.Debug("used_phone_price: storage not provided")
 	}
 	unlocked, ok := params["unlocked"].(string)
 	if !ok {
-		fmt.Println("Is_Unlocked Not Provided")
+		slog.Debug("used_phone_price: unlocked not provided")
 	}
 	q.Set("storage", storage)
 	q.Add("unlocked", unlocked)
 
 	resp, err := http.Get(urlWithModel.String())
 	if err != nil {
-		errMsg := "ERROR on http.Get: " + err.Error()
-		return newToolResult(errMsg)
+		return newToolError("on http.Get: " + err.Error())
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			errMsg := fmt.Sprintf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err)
-			return newToolResult(errMsg)
+			return newToolError(fmt.Sprintf("API request failed with status code: %d, failed to read response body: %v", resp.StatusCode, err))
 		}
-		errMsg := fmt.Sprintf("API request failed with status code: %d, response body: %s", resp.StatusCode, string(body))
-		return newToolResult(errMsg)
+		return newToolError(fmt.Sprintf("API request failed with status code: %d, response body: %s", resp.StatusCode, string(body)))
 	}
 
 	// Decode the JSON response
 	responseContent, err := (io.ReadAll(resp.Body))
 	if err != nil {
-		return newToolResult(fmt.Sprintf("failed to decode response: %v", err))
+		return newToolError(fmt.Sprintf("failed to decode response: %v", err))
 	}
 	return newToolResult(string(responseContent))
 }
 
 func newToolResult(s string) anthropic.Content {
 	return anthropic.Content{Type: anthropic.ToolResult, Content: s}
-}
\ No newline at end of file
+}
+
+// newToolError marks the result as an error, so Claude knows the call failed
+func newToolError(s string) anthropic.Content {
+	return anthropic.Content{Type: anthropic.ToolResult, Content: "ERROR " + s, IsError: true}
+}
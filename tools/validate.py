@@ -21,11 +21,6 @@ def validate_tools_folder():
             print(f"\033[91mError: Missing or incorrectly named JSON file in folder '{folder_name}' ❌\033[0m")
             continue
 
-        # Check if Go file exists and has the correct name
-        if not os.path.isfile(go_file):
-            print(f"\033[91mError: Missing or incorrectly named Go file in folder '{folder_name}' ❌\033[0m")
-            continue
-
         # Check if JSON file has the correct top-level key "name"
         try:
             with open(json_file, "r") as file:
@@ -37,6 +32,19 @@ def validate_tools_folder():
             print(f"\033[91mError: Invalid JSON format in file '{json_file}' ❌\033[0m")
             continue
 
+        # HTTP endpoint tools need a url and no Go file
+        if "endpoint" in data:
+            if not isinstance(data["endpoint"], dict) or not data["endpoint"].get("url"):
+                print(f"\033[91mError: JSON file in folder '{folder_name}' has an endpoint without a 'url' ❌\033[0m")
+                continue
+            print(f"\033[92mFolder '{folder_name}' passed validation ✅\033[0m")
+            continue
+
+        # Check if Go file exists and has the correct name
+        if not os.path.isfile(go_file):
+            print(f"\033[91mError: Missing or incorrectly named Go file in folder '{folder_name}' ❌\033[0m")
+            continue
+
         # Check if Go file has the correct function name
         try:
             with open(go_file, "r") as file:
@@ -2,9 +2,38 @@ package utils
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
 )
 
+// # OUTPUT STREAMS
+// Stdout is kept for Claude's replies and what commands were asked to show, so the output can be piped
+//   - Diagnostics, i.e. errors, warnings, prompts, tool calls and token counts, go to stderr with Eprintln and Eprintf
+//   - Quiet mode drops the diagnostics altogether, and stdout loses its colors when it isn't a terminal
+var (
+	quiet       bool
+	stdoutColor = true
+)
+
+// SetQuiet suppresses everything printed with Eprintln and Eprintf, but not Promptln
+func SetQuiet(enabled bool) {
+	quiet = enabled
+}
+
+// SetStdoutColor turns colors on stdout on or off, e.g. off when it is piped
+func SetStdoutColor(enabled bool) {
+	stdoutColor = enabled
+}
+
+// Diagnostics is where diagnostics are written, discarding them in quiet mode
+func Diagnostics() io.Writer {
+	if quiet {
+		return io.Discard
+	}
+	return os.Stderr
+}
+
 var colorMap = map[string]string{
 	"black":   "#000000",
 	"red":     "#FF0000",
@@ -62,42 +91,71 @@ var colorMap = map[string]string{
 
 func PrintAllColors() {
 	for colorName, hexCode := range colorMap {
-		cprintlnh(hexCode, colorName)
+		cprintlnh(os.Stdout, hexCode, colorName)
 	}
 }
 
 func Cprintln(color string, a ...interface{}) {
+	if !stdoutColor {
+		color = ""
+	}
+	fprintln(os.Stdout, color, a...)
+}
+
+// Eprintln is Cprintln for diagnostics
+func Eprintln(color string, a ...interface{}) {
+	fprintln(Diagnostics(), color, a...)
+}
+
+// Promptln is Eprintln for what a question at the prompt is about, which quiet mode never hides
+func Promptln(color string, a ...interface{}) {
+	fprintln(os.Stderr, color, a...)
+}
+
+func fprintln(w io.Writer, color string, a ...interface{}) {
 	color = strings.ToLower(color)
 	if hexCode, ok := colorMap[color]; ok {
-		cprintlnh(hexCode, a...)
+		cprintlnh(w, hexCode, a...)
 	} else {
-		fmt.Println(a...)
+		fmt.Fprintln(w, a...)
 	}
 }
 
-func cprintlnh(hexColor string, a ...interface{}) {
+func cprintlnh(w io.Writer, hexColor string, a ...interface{}) {
 	colorCode := "\033[38;2;" + hexToRGB(hexColor) + "m"
 	resetCode := "\033[0m"
-	fmt.Print(colorCode)
-	fmt.Println(a...)
-	fmt.Print(resetCode)
+	fmt.Fprint(w, colorCode)
+	fmt.Fprintln(w, a...)
+	fmt.Fprint(w, resetCode)
 }
 
 func Cprintf(color string, format string, a ...interface{}) {
+	if !stdoutColor {
+		color = ""
+	}
+	fprintf(os.Stdout, color, format, a...)
+}
+
+// Eprintf is Cprintf for diagnostics
+func Eprintf(color string, format string, a ...interface{}) {
+	fprintf(Diagnostics(), color, format, a...)
+}
+
+func fprintf(w io.Writer, color string, format string, a ...interface{}) {
 	color = strings.ToLower(color)
 	if hexCode, ok := colorMap[color]; ok {
-		cprintfh(hexCode, format, a...)
+		cprintfh(w, hexCode, format, a...)
 	} else {
-		fmt.Printf(format, a...)
+		fmt.Fprintf(w, format, a...)
 	}
 }
 
-func cprintfh(hexColor string, format string, a ...interface{}) {
+func cprintfh(w io.Writer, hexColor string, format string, a ...interface{}) {
 	colorCode := "\033[38;2;" + hexToRGB(hexColor) + "m"
 	resetCode := "\033[0m"
-	fmt.Print(colorCode)
-	fmt.Printf(format, a...)
-	fmt.Print(resetCode)
+	fmt.Fprint(w, colorCode)
+	fmt.Fprintf(w, format, a...)
+	fmt.Fprint(w, resetCode)
 }
 
 func Csprintf(color string, format string, a ...interface{}) string {
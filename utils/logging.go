@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// # LOGGING
+// Leveled diagnostics via log/slog, kept separate from the colorized conversation output
+// Logs go to stderr as text, or to a file as JSON when one is given
+
+// SetupLogger installs the default slog logger at the given level ("debug", "info", "warn", "error")
+func SetupLogger(level string, filename string) error {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(strings.ToLower(level)))
+	if err != nil {
+		return fmt.Errorf("invalid log level '%s': %v", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	if filename != "" {
+		file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		handler = slog.NewJSONHandler(file, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+var fatalHooks []func()
+
+// AtFatal runs f when Fatal is called, before it exits, e.g. to save work that would be lost
+func AtFatal(f func()) {
+	fatalHooks = append(fatalHooks, f)
+}
+
+// Fatal logs at error level and exits, replacing log.Fatal
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	for _, f := range fatalHooks {
+		f()
+	}
+	os.Exit(1)
+}
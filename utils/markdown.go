@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// # MARKDOWN
+// Rendering the Markdown in Claude's replies for a terminal, with ANSI styles over a base color
+//   - Headings, bold, italics, inline code, links, lists, quotes and rules are styled
+//   - Fenced code blocks are indented in their own color, with nothing inside them styled
+//   - Anything else, such as tables, is printed as it is
+const (
+	markdownCodeColor = "pastel_orange"
+	markdownRuleWidth = 40
+
+	ansiReset                   = "\033[0m"
+	ansiBold, ansiBoldOff       = "\033[1m", "\033[22m"
+	ansiItalic, ansiItalicOff   = "\033[3m", "\033[23m"
+	ansiUnderline, ansiUnderOff = "\033[4m", "\033[24m"
+)
+
+var (
+	mdHeading    = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	mdRule       = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	mdBullet     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdQuote      = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	mdCodeSpan   = regexp.MustCompile("`[^`]+`")
+	mdBold       = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	mdItalicStar = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*]*?\S)?)\*`)
+	mdItalicLine = regexp.MustCompile(`(^|\s)_(\S(?:[^_]*?\S)?)_($|[\s.,;:!?)])`)
+	mdLink       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// colorCode is the escape sequence that switches to a color of the color map, or "" for unknown colors
+func colorCode(color string) string {
+	hexCode, ok := colorMap[strings.ToLower(color)]
+	if !ok {
+		return ""
+	}
+	return "\033[38;2;" + hexToRGB(hexCode) + "m"
+}
+
+// RenderMarkdown styles Markdown text for the terminal, in the given base color
+func RenderMarkdown(color string, text string) string {
+	base := colorCode(color)
+	code := colorCode(markdownCodeColor)
+	var b strings.Builder
+	b.WriteString(base)
+	inFence := false
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+			if lang := strings.Trim(trimmed, "`~ "); inFence && lang != "" {
+				b.WriteString(ansiItalic + "  " + lang + ansiItalicOff)
+			}
+			continue
+		}
+		if inFence {
+			b.WriteString(code + "    " + line + base)
+			continue
+		}
+
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			b.WriteString(ansiBold + ansiUnderline + renderInline(m[1], base, code) + ansiUnderOff + ansiBoldOff)
+		} else if mdRule.MatchString(line) {
+			b.WriteString(strings.Repeat("─", markdownRuleWidth))
+		} else if m := mdQuote.FindStringSubmatch(line); m != nil {
+			b.WriteString("│ " + ansiItalic + renderInline(m[1], base, code) + ansiItalicOff)
+		} else if m := mdBullet.FindStringSubmatch(line); m != nil {
+			b.WriteString(m[1] + "• " + renderInline(m[2], base, code))
+		} else {
+			b.WriteString(renderInline(line, base, code))
+		}
+	}
+	b.WriteString(ansiReset)
+	return b.String()
+}
+
+// CprintMarkdown prints Markdown text styled for the terminal, like Cprintln
+func CprintMarkdown(color string, text string) {
+	fmt.Println(RenderMarkdown(color, text))
+}
+
+// renderInline styles the spans of one line, leaving the inside of `code` alone
+func renderInline(line string, base, code string) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range mdCodeSpan.FindAllStringIndex(line, -1) {
+		b.WriteString(styleSpans(line[last:span[0]]))
+		b.WriteString(code + line[span[0]+1:span[1]-1] + base)
+		last = span[1]
+	}
+	b.WriteString(styleSpans(line[last:]))
+	return b.String()
+}
+
+func styleSpans(s string) string {
+	s = mdLink.ReplaceAllString(s, ansiUnderline+"$1"+ansiUnderOff+" ($2)")
+	s = mdBold.ReplaceAllString(s, ansiBold+"$1$2"+ansiBoldOff)
+	s = mdItalicStar.ReplaceAllString(s, "$1"+ansiItalic+"$2"+ansiItalicOff)
+	return mdItalicLine.ReplaceAllString(s, "$1"+ansiItalic+"$2"+ansiItalicOff+"$3")
+}